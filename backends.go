@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+type backendsCmd struct {
+	List struct{} `cmd:"" help:"List the configured backends, their types and default models"`
+}
+
+// maskedSuffixLen is the number of trailing characters of API keys printed by
+// the backends list command, for keys long enough that they reveal nothing.
+const maskedSuffixLen = 4
+
+// backendListing describes a configured backend, as printed by the backends
+// list command.
+type backendListing struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	DefaultModel string `json:"default_model,omitempty"`
+	Default      bool   `json:"default"`
+	URL          string `json:"url,omitempty"`
+	APIKey       string `json:"api_key,omitempty"`
+}
+
+// listBackends prints the backends of the loaded configuration, after
+// environment variables and secret references are resolved, sorted by name:
+// their type, default model, URL and API key, and whether they are the
+// default backend. API keys are masked, as are passwords in URLs. Printed as
+// JSON with the --json flag.
+func listBackends(aiac *libaiac.Aiac, cli flags) error {
+	names := make([]string, 0, len(aiac.Conf.Backends))
+	for name := range aiac.Conf.Backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	listings := make([]backendListing, 0, len(names))
+	for _, name := range names {
+		backendConf := aiac.Conf.Backends[name]
+
+		backendType := backendConf.Type
+		if backendType == "" {
+			backendType = libaiac.BackendOpenAI
+		}
+
+		listings = append(listings, backendListing{
+			Name:         name,
+			Type:         string(backendType),
+			DefaultModel: backendConf.DefaultModel,
+			Default:      name == aiac.Conf.DefaultBackend,
+			URL:          maskURL(backendConf.URL),
+			APIKey:       maskSecret(backendConf.APIKey),
+		})
+	}
+
+	if cli.JSON {
+		return printJSON(listings)
+	}
+
+	if len(listings) == 0 {
+		fmt.Fprintln(os.Stderr, "No backends configured")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint: gomnd
+	fmt.Fprintln(w, "NAME\tTYPE\tDEFAULT MODEL\tURL\tAPI KEY")
+
+	for _, listing := range listings {
+		name := listing.Name
+		if listing.Default {
+			name += " (default)"
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\n",
+			name,
+			listing.Type,
+			orDash(listing.DefaultModel),
+			orDash(listing.URL),
+			orDash(listing.APIKey),
+		)
+	}
+
+	return w.Flush()
+}
+
+// maskSecret masks a secret for printing, keeping only its last few
+// characters if it is long enough for them not to weaken it.
+func maskSecret(secret string) string {
+	switch {
+	case secret == "":
+		return ""
+	case len(secret) < 4*maskedSuffixLen:
+		return "****"
+	default:
+		return "****" + secret[len(secret)-maskedSuffixLen:]
+	}
+}
+
+// maskURL masks the password of a URL, if it has one.
+func maskURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+
+	return parsed.Redacted()
+}
+
+// orDash returns s, or a dash if it is empty, for columns of tables.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
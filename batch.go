@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+type batchCmd struct {
+	Input       string `help:"JSONL or CSV file of prompts, with name and prompt fields" type:"existingfile" required:""` //nolint: lll
+	Concurrency int    `help:"Number of prompts to generate code for at the same time" default:"1"`
+}
+
+var (
+	errBatchOutputDir   = errors.New("batch mode requires --output-dir")
+	errBatchConcurrency = errors.New("--concurrency must be at least 1")
+	errBatchFailed      = errors.New("some prompts failed")
+	errBatchCSVHeader   = errors.New("header must include name and prompt columns")
+)
+
+// batchItem is a single prompt of a batch.
+type batchItem struct {
+	// Name is the name of the file the generated code is saved to, without
+	// an extension.
+	Name string `json:"name"`
+
+	// Prompt is what to generate, like the prompt of the get command.
+	Prompt string `json:"prompt"`
+}
+
+// runBatch generates code for every prompt of the batch input file, saving the
+// code generated for each to a file named after it in the output directory,
+// with an extension based on the kind of code (e.g. vpc.tf). Prompts are
+// sent concurrently, up to the selected concurrency, subject to the rate
+// limits of the backend. Failures are reported as they happen, without
+// stopping the batch, and a summary is printed at the end. errBatchFailed is
+// returned if any prompt failed.
+func runBatch(ctx context.Context, aiac *libaiac.Aiac, cli flags) error {
+	if cli.OutputDir == "" {
+		return errBatchOutputDir
+	}
+
+	if cli.Batch.Concurrency < 1 {
+		return errBatchConcurrency
+	}
+
+	items, err := readBatch(cli.Batch.Input)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(cli.OutputDir, 0o755) //nolint: gomnd
+	if err != nil {
+		return fmt.Errorf("failed creating output directory: %w", err)
+	}
+
+	// Prompts of a batch are independent of each other, and of sessions.
+	// Existing files are not confirmed at a prompt, as code is saved
+	// concurrently.
+	cli.Session = ""
+	cli.Quiet = true
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed int
+	)
+
+	queue := make(chan batchItem)
+
+	for i := 0; i < cli.Batch.Concurrency; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for item := range queue {
+				path, warnings, err := generateBatchItem(ctx, aiac, cli, item)
+
+				mu.Lock()
+
+				for _, warning := range warnings {
+					fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", item.Name, warning)
+				}
+
+				if err != nil {
+					failed++
+					fmt.Fprintf(os.Stderr, "Failed generating %s: %s\n", item.Name, describeError(err))
+				} else {
+					fmt.Fprintf(os.Stderr, "Code for %s saved successfully to %s\n", item.Name, path)
+				}
+
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// Prompts are started in order, and those that did not start before an
+	// interruption are skipped
+	skipped := 0
+
+feed:
+	for i, item := range items {
+		select {
+		case queue <- item:
+		case <-ctx.Done():
+			skipped = len(items) - i
+			break feed
+		}
+	}
+
+	close(queue)
+
+	wg.Wait()
+
+	fmt.Fprintf(
+		os.Stderr,
+		"Batch complete: %d succeeded, %d failed\n",
+		len(items)-failed-skipped, failed,
+	)
+
+	switch {
+	case ctx.Err() != nil:
+		return errInterrupted
+	case failed > 0:
+		return fmt.Errorf("%w (%d of %d)", errBatchFailed, failed, len(items))
+	}
+
+	return nil
+}
+
+// generateBatchItem generates code for a single prompt of a batch, in a new
+// conversation, and saves it to the output directory. Returns the path of the
+// file saved, and the warnings of the response.
+func generateBatchItem(
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	cli flags,
+	item batchItem,
+) (path string, warnings []string, err error) {
+	kind := libaiac.DetectKind(item.Prompt)
+
+	sess, err := loadSession(aiac, cli, kind)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The same prompt the get command sends for a prompt on the command line,
+	// with the same presets
+	prompt, err := buildPrompt(aiac, sess, kind, item.Prompt, false)
+	if err != nil {
+		return "", nil, err
+	}
+
+	prompt, err = aiac.ApplyPresets(prompt, cli.Preset...)
+	if err != nil {
+		return "", nil, err
+	}
+
+	chat, err := aiac.Chat(ctx, sess.Backend, sess.Model, sess.Messages...)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed starting chat: %w", err)
+	}
+
+	chat.SetParameters(sess.Parameters)
+
+	res, err := chat.Send(ctx, prompt)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed generating code: %w", err)
+	}
+
+	res, err = libaiac.PostProcess(ctx, res, cli.PostProcess...)
+	if err != nil {
+		return "", res.Warnings, err
+	}
+
+	findings, err := checkGuardrails(aiac, res.Code)
+	for _, finding := range findings {
+		res.Warnings = append(res.Warnings, "guardrails: "+finding.String())
+	}
+	if err != nil {
+		return "", res.Warnings, err
+	}
+
+	// Kinds of code whose files have no extension keep their filename as one
+	// (e.g. web.Dockerfile)
+	filename, _ := libaiac.DetectFilename(item.Prompt, res.Language)
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		ext = "." + filename
+	}
+
+	path = filepath.Join(cli.OutputDir, item.Name+ext)
+
+	code := addHeader(aiac, res, item.Prompt, types.File{Name: path, Language: res.Language, Code: res.Code})
+
+	err = writeFile(cli, path, code)
+	if err != nil {
+		return "", res.Warnings, fmt.Errorf("failed saving code to %s: %w", path, err)
+	}
+
+	recordHistory(aiac, &historyRecord{prompt: item.Prompt, res: res, path: path})
+
+	return path, res.Warnings, nil
+}
+
+// readBatch reads the prompts of a batch from a file. Files with a .csv
+// extension are read as CSV files whose header includes "name" and "prompt"
+// columns, and other files as JSON Lines files, with a JSON object with
+// "name" and "prompt" keys per line. Names must be unique, and usable as file
+// names.
+func readBatch(path string) (items []batchItem, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening batch input: %w", err)
+	}
+	defer f.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		items, err = readBatchCSV(f)
+	} else {
+		items, err = readBatchJSONL(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid batch input %s: %w", path, err)
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("invalid batch input %s: no prompts found", path)
+	}
+
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		switch {
+		case item.Name == "" || item.Prompt == "":
+			return nil, fmt.Errorf("invalid batch input %s: item %d: name and prompt are required", path, i+1) //nolint: lll
+		case filepath.Base(item.Name) != item.Name || strings.HasPrefix(item.Name, "."):
+			return nil, fmt.Errorf("invalid batch input %s: item %d: invalid name %q", path, i+1, item.Name) //nolint: lll
+		case seen[item.Name]:
+			return nil, fmt.Errorf("invalid batch input %s: item %d: duplicate name %q", path, i+1, item.Name) //nolint: lll
+		}
+
+		seen[item.Name] = true
+	}
+
+	return items, nil
+}
+
+// readBatchJSONL reads batch items from JSON Lines input. Empty lines are
+// ignored.
+func readBatchJSONL(r io.Reader) (items []batchItem, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024) //nolint: gomnd
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var item batchItem
+
+		err := json.Unmarshal([]byte(text), &item)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		items = append(items, item)
+	}
+
+	return items, scanner.Err()
+}
+
+// readBatchCSV reads batch items from CSV input with a header row. Columns
+// other than "name" and "prompt" are ignored.
+func readBatchCSV(r io.Reader) (items []batchItem, err error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	nameCol, promptCol := -1, -1
+	for i, column := range records[0] {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "name":
+			nameCol = i
+		case "prompt":
+			promptCol = i
+		}
+	}
+
+	if nameCol < 0 || promptCol < 0 {
+		return nil, errBatchCSVHeader
+	}
+
+	for _, record := range records[1:] {
+		items = append(items, batchItem{
+			Name:   strings.TrimSpace(record[nameCol]),
+			Prompt: strings.TrimSpace(record[promptCol]),
+		})
+	}
+
+	return items, nil
+}
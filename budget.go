@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+type budgetCmd struct {
+	Show  struct{} `cmd:"" help:"Show the spend of the current month against the budget"`
+	Reset struct{} `cmd:"" help:"Remove the spend recorded in the ledger"`
+}
+
+// budgetStatus is the spend of the current month, printed by the budget show
+// command with the --json flag.
+type budgetStatus struct {
+	Month   string  `json:"month"`
+	Spent   float64 `json:"spent"`
+	Monthly float64 `json:"monthly,omitempty"`
+	MaxCost float64 `json:"max_cost,omitempty"`
+	Ledger  string  `json:"ledger"`
+}
+
+// runBudgetCmd runs the budget subcommand selected on the command line. The
+// ledger is inspected and reset even if no monthly budget is configured, as
+// it is stored in the same place regardless of the configuration.
+func runBudgetCmd(command string, aiac *libaiac.Aiac, cli flags) error {
+	ledger := aiac.Ledger
+	if ledger == nil {
+		ledger = libaiac.NewLedger()
+	}
+
+	if command == "budget reset" {
+		err := ledger.Reset()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, "Spend ledger reset (%s)\n", ledger.Path())
+		return nil
+	}
+
+	spent, err := ledger.Spent()
+	if err != nil {
+		return err
+	}
+
+	budget := aiac.Conf.Budget
+	status := budgetStatus{
+		Month:   time.Now().Format("2006-01"),
+		Spent:   spent,
+		Monthly: budget.Monthly,
+		MaxCost: budget.MaxCost,
+		Ledger:  ledger.Path(),
+	}
+
+	if cli.JSON {
+		return printJSON(status)
+	}
+
+	month := time.Now().Format("January 2006")
+	if budget.Monthly > 0 {
+		fmt.Printf(
+			"Spent $%.4f of the monthly budget of $%.4f in %s ($%.4f left)\n",
+			spent, budget.Monthly, month, max(budget.Monthly-spent, 0),
+		)
+	} else {
+		fmt.Printf("Spent $%.4f in %s, with no monthly budget\n", spent, month)
+	}
+
+	if budget.MaxCost > 0 {
+		fmt.Printf("Maximum cost per request: $%.4f\n", budget.MaxCost)
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/mattn/go-isatty"
+)
+
+// stderrColors is whether ANSI colors are written to standard error (see
+// setupColors).
+var stderrColors = true
+
+// setupColors decides whether output is colored. Colors are disabled with the
+// --no-color flag, if the NO_COLOR environment variable is set to any value
+// (see https://no-color.org), or if the terminal is dumb. Otherwise, they are
+// only written to standard output and standard error while they are
+// terminals, so that redirected output never includes escape sequences.
+func setupColors(cli flags) {
+	disabled := cli.NoColor || os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb"
+
+	color.NoColor = disabled || !isTerminal(os.Stdout)
+	stderrColors = !disabled && isTerminal(os.Stderr)
+}
+
+// isTerminal returns whether the provided file is a terminal.
+func isTerminal(f *os.File) bool {
+	return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+}
+
+// stderrColor returns a color for messages written to standard error, which is
+// disabled unless colors are written to it (see setupColors). Colors created
+// with color.New follow standard output instead.
+func stderrColor(attrs ...color.Attribute) *color.Color {
+	c := color.New(attrs...)
+	if stderrColors {
+		c.EnableColor()
+	} else {
+		c.DisableColor()
+	}
+
+	return c
+}
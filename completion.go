@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alecthomas/kong"
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+type completionCmd struct {
+	Shell string `arg:"" help:"Shell to print the completion script of (bash, zsh, fish or powershell)" enum:"bash,zsh,fish,powershell"` //nolint: lll
+}
+
+// completeCmd is the hidden command that completion scripts run to complete
+// command lines. Its first argument is the word being completed, prefixed
+// with "=" so that it is never empty (some shells drop empty arguments),
+// followed by the words that precede it, without the program name. It prints
+// the candidates one per line, or one of the directives completeFiles and
+// completeDirs if the shell should complete paths instead.
+const completeCmd = "__complete"
+
+const (
+	completeFiles = ":files"
+	completeDirs  = ":dirs"
+)
+
+// completionTimeout is the maximum amount of time listing models for
+// completion may take, as the shell waits for it.
+const completionTimeout = 3 * time.Second
+
+// printCompletion prints the completion script of a shell.
+func printCompletion(shell string) error {
+	script, ok := completionScripts[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q", shell)
+	}
+
+	fmt.Print(script)
+
+	return nil
+}
+
+// runComplete prints the completions of a partial command line, as requested
+// by completion scripts (see completeCmd). Errors are not printed, as they
+// would garble the shell's output; completions that cannot be computed are
+// simply omitted.
+func runComplete(app *kong.Application, args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	cur := strings.TrimPrefix(args[0], "=")
+	for _, candidate := range complete(app, args[1:], cur) {
+		fmt.Println(candidate)
+	}
+}
+
+// complete returns the completions of cur, the word being completed, given
+// the words that precede it on the command line.
+func complete(app *kong.Application, words []string, cur string) []string {
+	node := app.Node
+	positional := 0
+
+	var pending *kong.Flag
+	for _, word := range words {
+		switch {
+		case pending != nil:
+			pending = nil
+		case word == "--":
+			positional++
+		case strings.HasPrefix(word, "--"):
+			if !strings.Contains(word, "=") {
+				pending = valueFlag(findFlag(node, strings.TrimPrefix(word, "--"), 0))
+			}
+		case strings.HasPrefix(word, "-") && len(word) == 2:
+			pending = valueFlag(findFlag(node, "", rune(word[1])))
+		case strings.HasPrefix(word, "-"):
+			// Short flags with values attached, or combined boolean flags
+		default:
+			if child := findChild(node, word); child != nil && positional == 0 {
+				node = child
+				continue
+			}
+
+			positional++
+		}
+	}
+
+	if pending != nil {
+		return filterPrefix(completeValue(pending.Value, words), cur)
+	}
+
+	if name, val, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(name, "--") {
+		flag := valueFlag(findFlag(node, strings.TrimPrefix(name, "--"), 0))
+		if flag == nil {
+			return nil
+		}
+
+		candidates := completeValue(flag.Value, words)
+		if len(candidates) == 1 && strings.HasPrefix(candidates[0], ":") {
+			return candidates
+		}
+
+		for i := range candidates {
+			candidates[i] = name + "=" + candidates[i]
+		}
+
+		return filterPrefix(candidates, name+"="+val)
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		return filterPrefix(flagNames(node), cur)
+	}
+
+	var candidates []string
+	if positional == 0 {
+		for _, child := range node.Children {
+			if !child.Hidden {
+				candidates = append(candidates, child.Name)
+			}
+		}
+	}
+
+	if positional < len(node.Positional) {
+		values := completeValue(node.Positional[positional], words)
+		if len(candidates) == 0 {
+			return filterPrefix(values, cur)
+		}
+
+		// Directives cannot be combined with commands
+		if len(values) != 1 || !strings.HasPrefix(values[0], ":") {
+			candidates = append(candidates, values...)
+		}
+	}
+
+	return filterPrefix(candidates, cur)
+}
+
+// findFlag returns the flag of node, or of its parents, with the provided
+// long name or short name, or nil if there is none.
+func findFlag(node *kong.Node, name string, short rune) *kong.Flag {
+	for ; node != nil; node = node.Parent {
+		for _, flag := range node.Flags {
+			if (name != "" && flag.Name == name) || (short != 0 && flag.Short == short) {
+				return flag
+			}
+		}
+	}
+
+	return nil
+}
+
+// valueFlag returns flag if it takes a value, or nil otherwise.
+func valueFlag(flag *kong.Flag) *kong.Flag {
+	if flag == nil || flag.IsBool() || flag.IsCounter() {
+		return nil
+	}
+
+	return flag
+}
+
+// findChild returns the subcommand of node with the provided name or alias,
+// or nil if there is none.
+func findChild(node *kong.Node, name string) *kong.Node {
+	for _, child := range node.Children {
+		if child.Type != kong.CommandNode {
+			continue
+		}
+
+		if child.Name == name {
+			return child
+		}
+
+		for _, alias := range child.Aliases {
+			if alias == name {
+				return child
+			}
+		}
+	}
+
+	return nil
+}
+
+// flagNames returns the long names of the visible flags of node and its
+// parents, including the help flag.
+func flagNames(node *kong.Node) (names []string) {
+	for ; node != nil; node = node.Parent {
+		for _, flag := range node.Flags {
+			if !flag.Hidden {
+				names = append(names, "--"+flag.Name)
+			}
+		}
+	}
+
+	return names
+}
+
+// completeValue returns the completions of the value of a flag or positional
+// argument: the values of enums, the names of backends, models and profiles,
+// or a directive to complete paths.
+func completeValue(val *kong.Value, words []string) []string {
+	if val.Enum != "" {
+		// Empty values of optional enums are not worth completing
+		var enum []string
+		for _, value := range val.EnumSlice() {
+			if value != "" {
+				enum = append(enum, value)
+			}
+		}
+
+		return enum
+	}
+
+	switch val.Name {
+	case "backend", "fallback", "race":
+		return completeBackends(wordFlags(words, "config", 'c'))
+	case "profile":
+		return completeProfiles(wordFlags(words, "config", 'c'))
+	case "model":
+		return completeModels(
+			wordFlags(words, "config", 'c'),
+			lastWordFlag(words, "backend", 'b'),
+		)
+	}
+
+	if val.Tag == nil {
+		return nil
+	}
+
+	switch val.Tag.Type {
+	case "path", "existingfile":
+		return []string{completeFiles}
+	case "existingdir":
+		return []string{completeDirs}
+	}
+
+	return nil
+}
+
+// completionConfig is the part of the configuration needed to complete
+// backend, model and profile names, decoded directly from configuration files
+// if the configuration cannot be loaded (e.g. because an API key is missing).
+type completionConfig struct {
+	DefaultBackend string `toml:"default_backend"`
+	Backends       map[string]struct {
+		Type         libaiac.BackendType `toml:"type"`
+		DefaultModel string              `toml:"default_model"`
+	} `toml:"backends"`
+	Profiles map[string]struct{} `toml:"profiles"`
+}
+
+// readCompletionConfig decodes the configuration files at the provided paths,
+// or the default ones, without validating them or resolving secrets. Files
+// that cannot be decoded are skipped.
+func readCompletionConfig(paths []string) (conf completionConfig) {
+	if len(paths) == 0 {
+		paths = libaiac.DefaultConfigPaths()
+	}
+
+	for _, path := range paths {
+		var layer completionConfig
+		if _, err := toml.DecodeFile(path, &layer); err != nil {
+			continue
+		}
+
+		if layer.DefaultBackend != "" {
+			conf.DefaultBackend = layer.DefaultBackend
+		}
+
+		for name := range layer.Profiles {
+			if conf.Profiles == nil {
+				conf.Profiles = make(map[string]struct{}, len(layer.Profiles))
+			}
+
+			conf.Profiles[name] = struct{}{}
+		}
+
+		if conf.Backends == nil {
+			conf.Backends = layer.Backends
+			continue
+		}
+
+		for name, backend := range layer.Backends {
+			conf.Backends[name] = backend
+		}
+	}
+
+	return conf
+}
+
+// completeProfiles returns the names of the profiles of the configuration,
+// sorted.
+func completeProfiles(paths []string) []string {
+	var names []string
+	for name := range readCompletionConfig(paths).Profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// completeBackends returns the names of the backends of the configuration,
+// sorted.
+func completeBackends(paths []string) []string {
+	var names []string
+
+	if aiac, err := libaiac.New(paths...); err == nil {
+		for name := range aiac.Conf.Backends {
+			names = append(names, name)
+		}
+	} else {
+		for name := range readCompletionConfig(paths).Backends {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// completeModels returns the names of the models of a backend, or of the
+// default backend if backendName is empty, as listed by the backend (see
+// Aiac.ListModels). If they cannot be listed, the models whose context window
+// or price aiac knows for the backend's type are returned instead.
+func completeModels(paths []string, backendName string) []string {
+	var names []string
+
+	if aiac, err := libaiac.New(paths...); err == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+		defer cancel()
+
+		models, err := aiac.ListModels(ctx, backendName)
+		if err == nil && len(models) > 0 {
+			for _, model := range models {
+				names = append(names, model.ID)
+			}
+
+			sort.Strings(names)
+
+			return names
+		}
+	}
+
+	conf := readCompletionConfig(paths)
+	if backendName == "" {
+		backendName = conf.DefaultBackend
+	}
+
+	backend, ok := conf.Backends[backendName]
+	if !ok {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	if backend.DefaultModel != "" {
+		known[backend.DefaultModel] = true
+	}
+
+	if model := libaiac.DefaultModels[backend.Type]; model != "" {
+		known[model] = true
+	}
+
+	for model := range libaiac.DefaultContextWindows[backend.Type] {
+		known[model] = true
+	}
+
+	for model := range libaiac.DefaultPricing[backend.Type] {
+		known[model] = true
+	}
+
+	for model := range known {
+		// Prefixes matching families of models are not model names
+		if !strings.HasSuffix(model, "-") && !strings.HasSuffix(model, ".") {
+			names = append(names, model)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// wordFlags returns the values of a flag on the command line, in order.
+func wordFlags(words []string, name string, short rune) (vals []string) {
+	for i, word := range words {
+		switch {
+		case word == "--"+name || word == "-"+string(short):
+			if i+1 < len(words) {
+				vals = append(vals, words[i+1])
+			}
+		case strings.HasPrefix(word, "--"+name+"="):
+			vals = append(vals, strings.TrimPrefix(word, "--"+name+"="))
+		}
+	}
+
+	return vals
+}
+
+// lastWordFlag returns the last value of a flag on the command line, or an
+// empty string if it is not set.
+func lastWordFlag(words []string, name string, short rune) string {
+	vals := wordFlags(words, name, short)
+	if len(vals) == 0 {
+		return ""
+	}
+
+	return vals[len(vals)-1]
+}
+
+// filterPrefix returns the candidates that start with prefix.
+func filterPrefix(candidates []string, prefix string) (filtered []string) {
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) || strings.HasPrefix(candidate, ":") {
+			filtered = append(filtered, candidate)
+		}
+	}
+
+	return filtered
+}
+
+// completeMain handles the hidden completeCmd command, which must run before
+// the command line is parsed, as partial command lines are generally invalid.
+// It returns false if the command line is not a completion request.
+func completeMain(app *kong.Application) bool {
+	if len(os.Args) < 2 || os.Args[1] != completeCmd { //nolint: gomnd
+		return false
+	}
+
+	runComplete(app, os.Args[2:])
+
+	return true
+}
+
+// completionScripts are the completion scripts of every supported shell. They
+// run "aiac __complete" (see completeCmd) to compute completions, so backend
+// and model names are completed from the configuration.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for aiac
+# Load with: source <(aiac completion bash)
+
+_aiac() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local out
+    out=$(aiac __complete "=$cur" "${COMP_WORDS[@]:1:COMP_CWORD-1}" 2>/dev/null)
+
+    case "$out" in
+    :files) COMPREPLY=($(compgen -f -- "$cur")) ;;
+    :dirs) COMPREPLY=($(compgen -d -- "$cur")) ;;
+    *)
+        local IFS=$'\n'
+        COMPREPLY=($(compgen -W "$out" -- "$cur"))
+        ;;
+    esac
+}
+
+complete -o filenames -F _aiac aiac
+`,
+	"zsh": `#compdef aiac
+# zsh completion for aiac
+# Load with: source <(aiac completion zsh)
+
+_aiac() {
+    local out
+    out=$(aiac __complete "=${words[CURRENT]}" "${(@)words[2,CURRENT-1]}" 2>/dev/null)
+
+    case $out in
+    :files) _files ;;
+    :dirs) _files -/ ;;
+    *)
+        local -a candidates
+        candidates=("${(@f)out}")
+        compadd -- $candidates
+        ;;
+    esac
+}
+
+if [ "$funcstack[1]" = "_aiac" ]; then
+    _aiac "$@"
+else
+    compdef _aiac aiac
+fi
+`,
+	"fish": `# fish completion for aiac
+# Load with: aiac completion fish | source
+
+function __aiac_complete
+    set -l words (commandline -opc)
+    set -l out (aiac __complete "="(commandline -ct) $words[2..-1] 2>/dev/null)
+
+    switch "$out"
+        case :files
+            __fish_complete_path (commandline -ct)
+        case :dirs
+            __fish_complete_directories (commandline -ct)
+        case '*'
+            printf '%s\n' $out
+    end
+end
+
+complete -c aiac -f -a '(__aiac_complete)'
+`,
+	"powershell": `# PowerShell completion for aiac
+# Load with: aiac completion powershell | Out-String | Invoke-Expression
+
+Register-ArgumentCompleter -Native -CommandName aiac -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+
+    # The words before the one being completed, without the program name
+    $words = @($commandAst.CommandElements |
+        Where-Object { $_.Extent.EndOffset -lt $cursorPosition } |
+        Select-Object -Skip 1 |
+        ForEach-Object { $_.ToString() })
+
+    $out = @(& aiac __complete "=$wordToComplete" @words 2>$null)
+
+    # Paths are completed by PowerShell when no results are returned
+    if ($out.Count -eq 1 -and $out[0].StartsWith(':')) {
+        return
+    }
+
+    $out | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`,
+}
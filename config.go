@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+type configCmd struct {
+	Example  struct{} `cmd:"" help:"Print a commented example configuration file"`
+	Validate struct {
+		Path string `arg:"" optional:"" help:"Configuration file to validate (defaults to the files aiac loads)" type:"path"` //nolint: lll
+	} `cmd:"" help:"Validate the configuration"`
+	Path struct{} `cmd:"" help:"Print the paths of the configuration files aiac loads"`
+}
+
+// runConfigCmd runs the config subcommand selected on the command line. These
+// commands run before the configuration is loaded, so that they work even if
+// it is invalid or missing.
+func runConfigCmd(command string, cli flags) error {
+	switch command {
+	case "config example":
+		fmt.Print(exampleConfig)
+		return nil
+	case "config path":
+		return printConfigPaths(cli)
+	default:
+		return validateConfig(cli)
+	}
+}
+
+// configOptions returns the options of loading the configuration selected on
+// the command line, with the --profile and --no-config flags.
+func configOptions(cli flags) libaiac.ConfigOptions {
+	return libaiac.ConfigOptions{Profile: cli.Profile, NoConfig: cli.NoConfig}
+}
+
+// configPaths returns the paths of the configuration files to load: the ones
+// provided with the --config flag, or the default ones otherwise.
+func configPaths(cli flags) []string {
+	if len(cli.Config) > 0 {
+		return cli.Config
+	}
+
+	return configOptions(cli).DefaultConfigPaths()
+}
+
+// printConfigPaths prints the paths of the configuration files that aiac
+// loads, in the order in which they are merged. If there are none, but the
+// configuration is read from environment variables, or files are disabled,
+// that is printed instead.
+func printConfigPaths(cli flags) error {
+	paths := configPaths(cli)
+	if len(paths) == 0 && (envConfigured() || configOptions(cli).NoFiles()) {
+		fmt.Println(envSource())
+		return nil
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf(
+			"no configuration file found, create one at %s",
+			libaiac.UserConfigPath(),
+		)
+	}
+
+	for _, path := range paths {
+		fmt.Println(path)
+	}
+
+	return nil
+}
+
+// validateConfig loads and validates the configuration file provided, or the
+// files aiac loads by default (or the environment variables, if there are
+// none or files are disabled), printing OK if it is valid.
+func validateConfig(cli flags) error {
+	paths := configPaths(cli)
+	if cli.ConfigCmd.Validate.Path != "" {
+		paths = []string{cli.ConfigCmd.Validate.Path}
+	}
+
+	if len(paths) == 0 && (envConfigured() || configOptions(cli).NoFiles()) {
+		_, err := configOptions(cli).LoadConfig("")
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(os.Stdout, "%s %s\n", color.GreenString("OK"), envSource())
+		return nil
+	}
+
+	if len(paths) == 0 {
+		return fmt.Errorf(
+			"no configuration file found, create one at %s",
+			libaiac.UserConfigPath(),
+		)
+	}
+
+	conf, err := configOptions(cli).LoadConfigs(paths...)
+	if err != nil {
+		return err
+	}
+
+	err = checkConfigWarnings(cli, conf.Warnings)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		os.Stdout,
+		"%s %s\n",
+		color.GreenString("OK"),
+		strings.Join(paths, ", "),
+	)
+
+	return nil
+}
+
+// checkConfigWarnings prints the warnings found while loading the
+// configuration to standard error, or returns them as an error wrapping
+// errConfigWarnings if the --strict-config flag is provided.
+func checkConfigWarnings(cli flags, warnings []string) error {
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	if cli.StrictConf {
+		return fmt.Errorf("%w: %s", errConfigWarnings, strings.Join(warnings, "; "))
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	return nil
+}
+
+// envConfigSource describes the source of the configuration when it is read
+// from environment variables.
+const envConfigSource = "environment variables (AIAC_*)"
+
+// noConfigSource describes the source of the configuration when files are
+// disabled, and no environment variables configure aiac.
+const noConfigSource = "none (configuration files are disabled)"
+
+// envSource describes the source of the configuration when no configuration
+// files are loaded.
+func envSource() string {
+	if !envConfigured() {
+		return noConfigSource
+	}
+
+	return envConfigSource
+}
+
+// envConfigured returns whether any of the environment variables from which
+// the configuration is synthesized, in the absence of configuration files,
+// are set.
+func envConfigured() bool {
+	_, err := libaiac.ConfigFromEnv()
+	return !errors.Is(err, fs.ErrNotExist)
+}
+
+// exampleConfig is the example configuration printed by the config example
+// command. It documents every setting, and must remain loadable.
+const exampleConfig = `# Example aiac configuration file. By default, aiac loads and merges
+# /etc/xdg/aiac/aiac.toml, ~/.config/aiac/aiac.toml and ./aiac.toml (run
+# "aiac config path" to see which files are loaded on this machine).
+#
+# String settings may reference environment variables, e.g. "$OPENAI_API_KEY"
+# or "${OPENAI_API_KEY:?must be set}". API keys may also reference the system
+# keyring (see "aiac secret set"), or HashiCorp Vault secrets, e.g.
+# "vault:secret/data/aiac#openai_key" (with VAULT_ADDR and VAULT_TOKEN set).
+
+# The backend to use when one is not selected with --backend.
+default_backend = "openai"
+
+# Backends to fall back to, in order, when the selected one fails due to rate
+# limiting, server errors or timeouts.
+fallback = ["local"]
+
+# Record prompts, the models that answered them and where their code was
+# saved, in ~/.local/share/aiac/history.jsonl (see "aiac history list").
+history = false
+
+# Each backend has a name (used with --backend) and a type. Every setting a
+# backend accepts is shown below; most are optional.
+[backends.openai]
+# The type of the backend: "openai", "azure_openai", "anthropic", "gemini",
+# "mistral", "openrouter", "groq", "deepseek", "xai", "perplexity",
+# "together", "fireworks", "cohere", "huggingface", "openai_compatible",
+# "bedrock" or "ollama". Defaults to "openai".
+type = "openai"
+
+# The API key to authenticate with. Required by most providers.
+api_key = "$OPENAI_API_KEY"
+
+# A custom URL for the provider's API, e.g. for OpenAI-compatible servers or
+# corporate gateways. Each type has a sensible default.
+# url = "https://api.openai.com/v1"
+
+# The header to send the API key in, for OpenAI and OpenAI-compatible types.
+# With "Authorization" (the default), the key is sent as a bearer token.
+# auth_header = "Authorization"
+
+# The API version. Required for Azure OpenAI, and sent as the
+# anthropic-version header for Anthropic.
+# api_version = "2024-06-01"
+
+# The model to use when one is not selected with --model. For Azure OpenAI,
+# this is the name of a deployment.
+default_model = "gpt-4o"
+
+# Models to use for kinds of code, detected from the prompt, when one is not
+# selected with --model. Take precedence over default_model.
+# model_by_kind = { terraform = "gpt-4o", dockerfile = "gpt-4o-mini" }
+
+# A system prompt instructing the model how to behave.
+# system_prompt = "You are a Terraform expert. Always pin provider versions."
+
+# Default generation parameters, used unless overridden with --temperature,
+# --top-p, --max-tokens, --stop, --format (format = "json" to generate JSON),
+# --seed or --reasoning (reasoning_effort = "low", "medium" or "high"). Other
+# keys are passed to the provider as-is.
+# parameters = { temperature = 0.2, top_p = 0.9, max_tokens = 2048, stop = ["Explanation:"] }
+
+# Extra HTTP headers to send with every request (not supported by Bedrock).
+# extra_headers = { X-Team = "platform" }
+
+# Query parameters to add to the URL of every request (not supported by
+# Bedrock).
+# query_params = { api-version = "2024-06-01" }
+
+# Extra fields to add to the body of every chat request, for provider-specific
+# options (for OpenAI-compatible types only).
+# extra_body = { user = "ci" }
+
+# The maximum duration of a single request, including streaming. "0" means no
+# timeout.
+timeout = "2m"
+
+# How many times to retry requests that fail due to transient errors, and the
+# base delay of the exponential backoff between retries.
+max_retries = 2
+retry_backoff = "1s"
+
+# Client-side rate limits that pace requests to stay under the provider's
+# quotas. Not set by default.
+# rate_limit = { requests_per_minute = 60, tokens_per_minute = 200000 }
+
+# A proxy to send requests through. HTTP_PROXY, HTTPS_PROXY and NO_PROXY are
+# honored when not set.
+# proxy = "http://proxy.corp:8080"
+
+# A file with PEM-encoded CA certificates to trust, in addition to the
+# system's, and whether to skip TLS certificate verification entirely
+# (insecure, for testing only).
+# ca_cert_file = "/etc/ssl/private-ca.pem"
+# insecure_skip_verify = false
+
+# Settings used by Amazon Bedrock backends only. Without aws_profile, the
+# standard AWS credential chain is used (environment variables, AWS_PROFILE,
+# web identity tokens, container and instance roles). Without aws_region,
+# AWS_REGION or AWS_DEFAULT_REGION is used. A role to assume via STS may be
+# provided with aws_role_arn, and its session name with aws_session_name.
+[backends.bedrock]
+type = "bedrock"
+aws_profile = "default"
+aws_region = "us-east-1"
+# aws_role_arn = "arn:aws:iam::123456789012:role/bedrock-invoker"
+# aws_session_name = "aiac"
+default_model = "anthropic.claude-3-5-sonnet-20240620-v1:0"
+
+# Settings used by Gemini backends only. With gcp_project, Vertex AI is used
+# instead of the Generative Language API, and api_key must not be set.
+[backends.vertex]
+type = "gemini"
+gcp_project = "my-project"
+gcp_location = "us-central1"
+default_model = "gemini-1.5-pro"
+
+# Any server implementing the OpenAI API, such as vLLM or LM Studio. The URL
+# is required, and nothing else is assumed about the server.
+[backends.vllm]
+type = "openai_compatible"
+url = "http://localhost:8000/v1"
+default_model = "Qwen/Qwen2.5-Coder-7B-Instruct"
+
+# Ollama and OpenAI-compatible servers may also be reached over a Unix
+# socket, e.g. "unix:///var/run/ollama.sock".
+[backends.local]
+type = "ollama"
+url = "http://localhost:11434/api"
+default_model = "mistral:latest"
+keep_alive = "30m"
+
+# The on-disk response cache, also enabled with --cache.
+[cache]
+enabled = false
+ttl = "24h"
+# dir = "/tmp/aiac-cache"
+
+# Limits on the estimated cost of requests, in US dollars. Prompts exceeding
+# them are refused unless --force is provided. The spend of every month is
+# tracked in the XDG data directory, and cleared with "aiac budget reset".
+[budget]
+# max_cost = 0.50
+# monthly = 20.0
+
+# The connections shared by all backends. The defaults suit most uses; raise
+# the limits of idle connections for large batches, or force HTTP/1.1 for
+# proxies that mishandle HTTP/2.
+[http]
+# max_idle_conns = 100
+# max_idle_conns_per_host = 16
+# idle_conn_timeout = "90s"
+# http1_only = false
+
+# Headers: comments at the start of saved code recording how it was
+# generated, also enabled with --header. The template is a Go template with
+# the .Timestamp, .Version, .Backend, .Model, .Prompt and .File fields.
+[output]
+header = false
+# header_template = "Generated by aiac {{.Version}} on {{.Timestamp}}"
+
+# Scanning of generated code for insecure patterns and secrets, also enabled
+# with --guardrails. With strict, code with findings is not saved.
+[guardrails]
+enabled = false
+strict = false
+# checks = ["open-ingress", "insecure-tls", "hardcoded-secret"]
+
+[guardrails.rules]
+no-latest-tag = { pattern = 'image:\s*\S+:latest', message = "image uses the latest tag" }
+
+# Profiles, selected with --profile or the AIAC_PROFILE environment variable,
+# override the default backend and the parameters of every backend.
+[profiles.offline]
+default_backend = "local"
+
+[profiles.offline.parameters]
+temperature = 0.1
+
+# Prices of models in US dollars per 1,000 tokens, by backend type and model
+# name, used to estimate costs with --show-usage. Overrides the built-in
+# prices.
+[pricing.openai]
+"gpt-4o" = { input = 0.0025, output = 0.01 }
+
+# Context windows of models in tokens, by backend type and model name. Prompts
+# that do not fit are refused unless --force is provided. Overrides the
+# built-in context windows.
+[context_windows.ollama]
+"mistral" = 32768
+
+# Instructions appended to prompts with --preset, adding to or overriding the
+# built-in presets (aws-tagged, encrypted, least-privilege, pinned-versions
+# and variables).
+[presets]
+team-naming = "Prefix the names of all resources with the team name"
+
+# The prompts requesting code of a kind (e.g. terraform, k8s or dockerfile),
+# replacing the built-in "Generate sample code for a ..." prompt. Templates
+# are Go templates with the .Request, .Kind and .Explain fields, and system
+# prompts take precedence over those of backends.
+[prompts.terraform]
+# system = "You write Terraform for ACME, following its module conventions."
+template = "Generate sample code for a {{.Request}}{{if .Explain}}. Include explanations.{{end}}"
+
+# Prompt templates, rendered with --template and --var. Templates can also be
+# stored as files (e.g. ~/.config/aiac/templates/tf-module.tmpl).
+[templates]
+tf-module = "Generate a Terraform module for {{.resource}} in {{.region}}"
+
+[templates.k8s-deployment]
+prompt = "Generate a Kubernetes deployment for {{.image}} with {{.replicas}} replicas"
+defaults = { replicas = "3" }
+`
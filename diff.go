@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// writeDiff prints a unified diff of the changes that writing the code to the
+// file selected with the --diff flag would make, and writes it only if the
+// changes are confirmed at a prompt, or with the --yes flag. Files that do
+// not exist are diffed as if they were empty, so all of the code is shown as
+// added. Nothing is written when there are no changes, or when they cannot be
+// confirmed because aiac is not running interactively. Returns whether the
+// file was written.
+func writeDiff(cli flags, code string) (written bool, err error) {
+	existing, err := os.ReadFile(cli.Diff)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	from := cli.Diff
+	if err != nil {
+		from = os.DevNull
+	}
+
+	// The code is written followed by a newline (see writeFile)
+	content := code + "\n"
+	if cli.WriteMode == writeModeAppend {
+		content = string(existing) + content
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(string(existing)),
+		B:        splitLines(content),
+		FromFile: from,
+		ToFile:   cli.Diff,
+		Context:  3, //nolint: gomnd
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if diff == "" {
+		fmt.Fprintf(os.Stderr, "No changes to %s\n", cli.Diff)
+		return false, nil
+	}
+
+	printDiff(diff)
+
+	if !cli.Yes && !confirmChanges(cli) {
+		fmt.Fprintf(os.Stderr, "Changes not written to %s (use --yes to write them)\n", cli.Diff)
+		return false, nil
+	}
+
+	err = writeFile(cli, cli.Diff, code)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// splitLines splits text into lines for diffing, each ending with a newline,
+// including the last line, so that a missing final newline does not make it
+// differ.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+
+	return lines
+}
+
+// printDiff prints a unified diff to standard output, coloring removed lines
+// red, added lines green and the headers of hunks cyan.
+func printDiff(diff string) {
+	for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "---"), strings.HasPrefix(line, "+++"):
+			color.New(color.Bold).Println(line)
+		case strings.HasPrefix(line, "@@"):
+			color.New(color.FgCyan).Println(line)
+		case strings.HasPrefix(line, "-"):
+			color.New(color.FgRed).Println(line)
+		case strings.HasPrefix(line, "+"):
+			color.New(color.FgGreen).Println(line)
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// confirmChanges asks whether the changes shown by writeDiff should be
+// written, in interactive mode when standard input is a terminal. Returns
+// false without asking otherwise.
+func confirmChanges(cli flags) bool {
+	if cli.Quiet || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+
+	input := promptui.Prompt{
+		Label:     fmt.Sprintf("Write these changes to %s", cli.Diff),
+		IsConfirm: true,
+	}
+
+	_, err := input.Run()
+
+	return err == nil
+}
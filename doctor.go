@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+var errDoctorFailed = errors.New("checks failed")
+
+// doctorCheck is the result of checking a backend with the doctor command.
+type doctorCheck struct {
+	detail string
+	err    error
+}
+
+// runDoctor loads and validates the configuration, then checks that every
+// backend can be reached, printing OK or FAIL for each of them, and a
+// summary. Backends are checked concurrently by listing their models, which
+// costs nothing, or for backends that cannot list models, by generating a
+// single token. An error wrapping errDoctorFailed is returned if any check
+// fails. Errors are printed with secrets redacted.
+func runDoctor(ctx context.Context, cli flags) error {
+	aiac, err := libaiac.NewContextWithOptions(ctx, configOptions(cli), cli.Config...)
+	if err != nil {
+		fmt.Printf("%s configuration: %s\n", color.RedString("FAIL"), err)
+		printErrorHint(err)
+		return fmt.Errorf("%w: the configuration could not be loaded", errDoctorFailed)
+	}
+
+	err = checkConfigWarnings(cli, aiac.Conf.Warnings)
+	if err != nil {
+		fmt.Printf("%s configuration: %s\n", color.RedString("FAIL"), err)
+		return fmt.Errorf("%w: the configuration has warnings", errDoctorFailed)
+	}
+
+	source := strings.Join(configPaths(cli), ", ")
+	if source == "" {
+		source = envSource()
+	}
+
+	fmt.Printf("%s configuration: %s\n", color.GreenString("OK"), source)
+
+	aiac.Logger = newLogger(cli)
+
+	// Responses must come from the backend being checked, and not from the
+	// cache or a fallback backend
+	aiac.Cache = nil
+	aiac.Conf.Fallback = nil
+
+	names := make([]string, 0, len(aiac.Conf.Backends))
+	for name, backendConf := range aiac.Conf.Backends {
+		if cli.Timeout != nil {
+			backendConf.Timeout = cli.Timeout
+			aiac.Conf.Backends[name] = backendConf
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	checks := make([]doctorCheck, len(names))
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			checks[i] = checkBackend(ctx, aiac, name)
+		}(i, name)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for i, name := range names {
+		backend := fmt.Sprintf("backend %s (%s)", name, aiac.Conf.Backends[name].Type)
+		if checks[i].err != nil {
+			failed++
+			fmt.Printf("%s %s: %s\n", color.RedString("FAIL"), backend, describeError(checks[i].err))
+			continue
+		}
+
+		fmt.Printf("%s %s: %s\n", color.GreenString("OK"), backend, checks[i].detail)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d of %d backends failed", errDoctorFailed, failed, len(names))
+	}
+
+	fmt.Printf("All %d backends OK\n", len(names))
+
+	return nil
+}
+
+// checkBackend checks that a backend can be reached by listing its models, or
+// if it does not support listing them, by generating a single token with its
+// default model.
+func checkBackend(ctx context.Context, aiac *libaiac.Aiac, name string) doctorCheck {
+	models, err := aiac.ListModels(ctx, name)
+	switch {
+	case err == nil:
+		return doctorCheck{detail: fmt.Sprintf("listed %d models", len(models))}
+	case errors.Is(err, types.ErrNoResults):
+		return doctorCheck{detail: "reachable, but listed no models"}
+	}
+
+	if !errors.Is(err, types.ErrUnsupported) {
+		return doctorCheck{err: err}
+	}
+
+	chat, err := aiac.Chat(ctx, name, "")
+	if err != nil {
+		return doctorCheck{err: err}
+	}
+
+	maxTokens := 1
+	chat.SetParameters(types.Parameters{MaxTokens: &maxTokens})
+
+	_, err = chat.Send(ctx, "Reply with OK.")
+
+	// Responses without code are irrelevant, the backend responded
+	if err != nil && !errors.Is(err, types.ErrEmptyResponse) {
+		return doctorCheck{err: err}
+	}
+
+	return doctorCheck{detail: "generated a test response"}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+type historyCmd struct {
+	List struct{} `cmd:"" help:"List the prompts recorded in the history"`
+	Show struct {
+		ID int `arg:"" help:"ID of the history entry to show"`
+	} `cmd:"" help:"Show an entry of the history"`
+}
+
+// maxListedPrompt is the maximum number of characters of a prompt printed by
+// the history list command.
+const maxListedPrompt = 60
+
+// runHistoryCmd runs the history subcommand selected on the command line.
+// These commands run before the configuration is loaded, as the history is
+// stored in the same place regardless of it, and can be inspected even if
+// recording it is disabled. Entries are printed as JSON with the --json flag.
+func runHistoryCmd(command string, cli flags) error {
+	history := libaiac.NewHistory()
+
+	if command == "history list" {
+		entries, err := history.List()
+		if err != nil {
+			return err
+		}
+
+		if cli.JSON {
+			if entries == nil {
+				entries = []libaiac.HistoryEntry{}
+			}
+			return printJSON(entries)
+		}
+
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "History is empty (%s)\n", history.Path())
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint: gomnd
+		for _, entry := range entries {
+			fmt.Fprintf(
+				w,
+				"%d\t%s\t%s\t%s\t%s\n",
+				entry.ID,
+				entry.Time.Local().Format(time.DateTime),
+				entry.Backend,
+				entry.Model,
+				summarize(entry.Prompt, maxListedPrompt),
+			)
+		}
+
+		return w.Flush()
+	}
+
+	entry, err := history.Get(cli.History.Show.ID)
+	if err != nil {
+		return err
+	}
+
+	if cli.JSON {
+		return printJSON(entry)
+	}
+
+	bold := color.New(color.Bold)
+
+	fmt.Fprintf(os.Stdout, "%s %d\n", bold.Sprint("ID:     "), entry.ID)
+	fmt.Fprintf(os.Stdout, "%s %s\n", bold.Sprint("Time:   "), entry.Time.Local().Format(time.RFC1123))
+	fmt.Fprintf(os.Stdout, "%s %s\n", bold.Sprint("Backend:"), entry.Backend)
+	fmt.Fprintf(os.Stdout, "%s %s\n", bold.Sprint("Model:  "), entry.Model)
+
+	if entry.OutputPath != "" {
+		fmt.Fprintf(os.Stdout, "%s %s\n", bold.Sprint("Output: "), entry.OutputPath)
+	}
+
+	fmt.Fprintf(os.Stdout, "\n%s\n", entry.Prompt)
+
+	if entry.Excerpt != "" {
+		fmt.Fprintf(os.Stdout, "\n%s\n", entry.Excerpt)
+	}
+
+	return nil
+}
+
+// summarize returns the first line of s, shortened to at most size
+// characters with an ellipsis if longer.
+func summarize(s string, size int) string {
+	line, _, more := strings.Cut(strings.TrimSpace(s), "\n")
+
+	runes := []rune(line)
+	if len(runes) > size {
+		return string(runes[:size-1]) + "…"
+	}
+
+	if more {
+		return line + " …"
+	}
+
+	return line
+}
+
+// historyRecord is a response to be recorded in the history, along with the
+// prompt it answered, as written by the user, and the path its code was saved
+// to, if any.
+type historyRecord struct {
+	prompt string
+	res    types.Response
+	path   string
+}
+
+// recordHistory records a response in the history, if enabled. Failures are
+// reported, but do not fail generating code. Nothing is recorded if rec is
+// nil.
+func recordHistory(aiac *libaiac.Aiac, rec *historyRecord) {
+	if aiac.History == nil || rec == nil {
+		return
+	}
+
+	_, err := aiac.History.Add(rec.prompt, rec.res, rec.path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed recording history: %s\n", err)
+	}
+}
@@ -0,0 +1,184 @@
+package anthropic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/sse"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/ido50/requests"
+)
+
+const (
+	// DefaultAPIURL is the default URL for the Anthropic API
+	DefaultAPIURL = "https://api.anthropic.com/v1"
+
+	// DefaultAPIVersion is the value of the anthropic-version header sent with
+	// every request, unless a different version is provided.
+	DefaultAPIVersion = "2023-06-01"
+
+	// DefaultMaxTokens is the maximum number of tokens to generate in a
+	// response. The Anthropic API requires this value to be set.
+	DefaultMaxTokens = 4096
+)
+
+// Anthropic is a structure used to continuously generate IaC code via
+// Anthropic's Claude models
+type Anthropic struct {
+	*requests.HTTPClient
+	httpClient *http.Client
+	url        string
+	apiKey     string
+	headers    map[string]string
+}
+
+// Options is a struct containing all the parameters accepted by the New
+// constructor.
+type Options struct {
+	// APIKey is the Anthropic API key to use for requests. Required.
+	APIKey string
+
+	// URL is the Anthropic API URL to use. Optional, defaults to DefaultAPIURL.
+	URL string
+
+	// APIVersion is the value of the anthropic-version header. Optional,
+	// defaults to DefaultAPIVersion.
+	APIVersion string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// New creates a new instance of the Anthropic struct, with the provided input
+// options. The Anthropic API is not yet contacted at this point. An error is
+// returned if an API key is not provided.
+func New(opts *Options) (*Anthropic, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: anthropic backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = DefaultAPIURL
+	}
+
+	if opts.APIVersion == "" {
+		opts.APIVersion = DefaultAPIVersion
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{}
+	}
+
+	backend := &Anthropic{
+		httpClient: opts.HTTPClient,
+		url:        strings.TrimSuffix(opts.URL, "/"),
+		apiKey:     opts.APIKey,
+		headers: map[string]string{
+			"x-api-key":         opts.APIKey,
+			"anthropic-version": opts.APIVersion,
+		},
+	}
+
+	for header, value := range opts.ExtraHeaders {
+		backend.headers[header] = value
+	}
+
+	backend.HTTPClient = requests.NewClient(backend.url).
+		Accept("application/json").
+		Timeout(types.NoTimeout).
+		ErrorHandler(handleError).
+		CustomHTTPClient(backend.httpClient)
+
+	for header, value := range backend.headers {
+		backend.HTTPClient.Header(header, value)
+	}
+
+	return backend, nil
+}
+
+// handleError parses error responses from the Anthropic API.
+func handleError(httpStatus int, _ string, body io.Reader) error {
+	var res struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	err := json.NewDecoder(body).Decode(&res)
+	if err != nil || res.Error.Message == "" {
+		return &types.APIError{Provider: "Anthropic", StatusCode: httpStatus}
+	}
+
+	return &types.APIError{
+		Provider:   "Anthropic",
+		StatusCode: httpStatus,
+		Code:       res.Error.Type,
+		Message:    res.Error.Message,
+	}
+}
+
+// stream sends a POST request with the provided JSON body to the provided API
+// path, and reads the server-sent events stream returned in response. The
+// payload of every "data" line in the stream is passed to the provided
+// function. Streaming stops when the stream ends, the context is canceled, or
+// the function returns an error.
+func (backend *Anthropic) stream(
+	ctx context.Context,
+	path string,
+	body interface{},
+	extraHeaders map[string]string,
+	fn func([]byte) error,
+) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed encoding request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		backend.url+path,
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	for key, val := range backend.headers {
+		req.Header.Set(key, val)
+	}
+
+	for key, val := range extraHeaders {
+		req.Header.Set(key, val)
+	}
+
+	res, err := backend.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return handleError(res.StatusCode, res.Header.Get("Content-Type"), res.Body)
+	}
+
+	return sse.Read(res.Body, fn)
+}
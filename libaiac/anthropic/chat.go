@@ -0,0 +1,317 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Conversation is a struct used to converse with an Anthropic chat model. It
+// maintains all messages sent/received in order to maintain context.
+type Conversation struct {
+	backend      *Anthropic
+	model        string
+	messages     []types.Message
+	extraHeaders map[string]string
+	params       types.Parameters
+
+	// images are attached to the next prompt (see AttachImages)
+	images []types.Image
+}
+
+// streamEvent represents a single event in the stream returned by the
+// messages API. Only the fields aiac cares about are included.
+type streamEvent struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage struct {
+			InputTokens int64 `json:"input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		Thinking   string `json:"thinking"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage struct {
+		OutputTokens int64 `json:"output_tokens"`
+	} `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Chat initiates a conversation with an Anthropic chat model. A conversation
+// maintains context, allowing to send further instructions to modify the output
+// from previous requests. The name of the model to use must be provided. Users
+// can also supply zero or more "previous messages" that may have been exchanged
+// in the past. This practically allows "loading" previous conversations and
+// continuing them.
+func (backend *Anthropic) Chat(model string, msgs ...types.Message) types.Conversation {
+	conv := &Conversation{
+		backend: backend,
+		model:   model,
+	}
+
+	if len(msgs) > 0 {
+		conv.messages = msgs
+	}
+
+	return conv
+}
+
+// Send sends the provided message to the API and returns a Response object.
+// To maintain context, all previous messages (whether from you to the API or
+// vice-versa) are sent as well, allowing you to ask the API to modify the
+// code it already generated. The response is streamed from the API and
+// assembled before being returned.
+func (conv *Conversation) Send(ctx context.Context, prompt string) (
+	res types.Response,
+	err error,
+) {
+	return conv.Stream(ctx, prompt, io.Discard)
+}
+
+// Stream is the same as Send, but writes the generated text to w as it is
+// streamed from the API.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	var output, thinking strings.Builder
+	var inputTokens, outputTokens int64
+
+	body, warnings := conv.requestBody()
+	res.Warnings = warnings
+
+	err = conv.backend.stream(ctx, "/messages", body, conv.extraHeaders, func(data []byte) error {
+		var event streamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed parsing stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "message_start":
+			inputTokens = event.Message.Usage.InputTokens
+		case "content_block_delta":
+			switch event.Delta.Type {
+			case "text_delta":
+				output.WriteString(event.Delta.Text)
+				if _, err := io.WriteString(w, event.Delta.Text); err != nil {
+					return err
+				}
+			case "thinking_delta":
+				thinking.WriteString(event.Delta.Thinking)
+			}
+		case "message_delta":
+			res.StopReason = event.Delta.StopReason
+			outputTokens = event.Usage.OutputTokens
+		case "error":
+			return &types.APIError{
+				Provider: "Anthropic",
+				Code:     event.Error.Type,
+				Message:  event.Error.Message,
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if output.Len() == 0 {
+		return res, types.ErrNoResults
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.Reasoning = strings.TrimSpace(thinking.String())
+	res.APIKeyUsed = conv.backend.apiKey
+	res.TokensUsed = inputTokens + outputTokens
+	res.InputTokens = inputTokens
+	res.OutputTokens = outputTokens
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
+// Messages returns all the messages that have been exchanged between the user
+// and the assistant up to this point.
+func (conv *Conversation) Messages() []types.Message {
+	return conv.messages
+}
+
+// AttachImages attaches images to the next prompt sent in the conversation.
+func (conv *Conversation) AttachImages(images ...types.Image) error {
+	conv.images = append(conv.images, images...)
+	return nil
+}
+
+// userMessage returns the message of a prompt, with the images attached to
+// the conversation, which are then detached from it.
+func (conv *Conversation) userMessage(prompt string) types.Message {
+	msg := types.Message{Role: "user", Content: prompt, Images: conv.images}
+	conv.images = nil
+
+	return msg
+}
+
+// AddHeader adds an extra HTTP header that will be added to every HTTP
+// request issued as part of this conversation. Any headers added will be in
+// addition to any extra headers defined for the backend itself, and will
+// take precedence over them.
+func (conv *Conversation) AddHeader(key, val string) {
+	if conv.extraHeaders == nil {
+		conv.extraHeaders = make(map[string]string)
+	}
+	conv.extraHeaders[key] = val
+}
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// requestBody builds the body of a messages request, translating the
+// conversation's generation parameters to their Anthropic equivalents. Recent
+// Claude models reject requests that set both temperature and top_p, so if
+// both are set, top_p is dropped and a warning is returned. If only top_p is
+// set, the default temperature is not sent. A reasoning effort enables
+// extended thinking with a budget of tokens (see types.ReasoningBudget), which
+// are part of max_tokens, so max_tokens is raised by the budget if it does not
+// exceed it. Temperatures are not accepted with extended thinking, so they are
+// dropped, with a warning if one was set. Extra parameters are included,
+// unless overridden.
+func (conv *Conversation) requestBody() (
+	body map[string]interface{},
+	warnings []string,
+) {
+	// The system prompt is a top-level parameter rather than a message
+	system, msgs := types.SplitSystem(conv.messages)
+
+	body = make(map[string]interface{})
+	for key, val := range conv.params.Extra {
+		body[key] = val
+	}
+
+	body["model"] = conv.model
+	body["messages"] = requestMessages(msgs)
+	body["stream"] = true
+
+	if system != "" {
+		body["system"] = system
+	}
+
+	maxTokens := DefaultMaxTokens
+	if conv.params.MaxTokens != nil {
+		maxTokens = *conv.params.MaxTokens
+	}
+
+	body["max_tokens"] = maxTokens
+
+	if len(conv.params.Stop) > 0 {
+		body["stop_sequences"] = conv.params.Stop
+	}
+
+	if budget := types.ReasoningBudget(conv.params.ReasoningEffort); budget > 0 {
+		body["thinking"] = map[string]interface{}{
+			"type":          "enabled",
+			"budget_tokens": budget,
+		}
+
+		if maxTokens <= budget {
+			body["max_tokens"] = budget + maxTokens
+		}
+
+		if conv.params.TopP != nil {
+			body["top_p"] = *conv.params.TopP
+		}
+
+		if conv.params.Temperature != nil {
+			warnings = append(warnings, "Anthropic models do not accept a temperature "+
+				"with extended thinking, ignoring temperature")
+		}
+
+		return body, warnings
+	}
+
+	switch {
+	case conv.params.TopP != nil && conv.params.Temperature != nil:
+		body["temperature"] = *conv.params.Temperature
+		warnings = append(warnings, "Anthropic models do not accept both temperature and top_p, ignoring top_p")
+	case conv.params.TopP != nil:
+		body["top_p"] = *conv.params.TopP
+	default:
+		body["temperature"] = conv.params.TemperatureOrDefault()
+	}
+
+	return body, warnings
+}
+
+// requestMessage is a message of a messages request. The content of messages
+// with images is a list of content blocks rather than text.
+type requestMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// contentBlock is a block of the content of a message, either text or an
+// image encoded as base64.
+type contentBlock struct {
+	Type   string       `json:"type"`
+	Text   string       `json:"text,omitempty"`
+	Source *imageSource `json:"source,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// requestMessages converts the conversation's messages into those of a
+// messages request. Images precede the text of the messages that have them,
+// as Anthropic recommends.
+func requestMessages(msgs []types.Message) []requestMessage {
+	converted := make([]requestMessage, len(msgs))
+	for i, msg := range msgs {
+		converted[i] = requestMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.Images) == 0 {
+			continue
+		}
+
+		blocks := make([]contentBlock, 0, len(msg.Images)+1)
+		for _, img := range msg.Images {
+			blocks = append(blocks, contentBlock{
+				Type: "image",
+				Source: &imageSource{
+					Type:      "base64",
+					MediaType: img.MediaType,
+					Data:      img.Base64(),
+				},
+			})
+		}
+
+		converted[i].Content = append(blocks, contentBlock{Type: "text", Text: msg.Content})
+	}
+
+	return converted
+}
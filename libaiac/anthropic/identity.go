@@ -0,0 +1,31 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Identity returns the ID of the organization the API key belongs to, as
+// reported by the Anthropic API in the headers of its responses. An error
+// wrapping types.ErrUnsupported is returned if it is not reported, e.g. by a
+// gateway in front of the API.
+func (backend *Anthropic) Identity(ctx context.Context) (identity types.Identity, err error) {
+	err = backend.NewRequest("GET", "/models").
+		QueryParam("limit", "1").
+		HeaderInto("anthropic-organization-id", &identity.Organization).
+		RunContext(ctx)
+	if err != nil {
+		return identity, fmt.Errorf("failed getting identity: %w", err)
+	}
+
+	if identity.Organization == "" {
+		return identity, fmt.Errorf(
+			"%w: the provider does not report the organization of API keys",
+			types.ErrUnsupported,
+		)
+	}
+
+	return identity, nil
+}
@@ -0,0 +1,63 @@
+package anthropic
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// ListModels returns a list of all the models supported by this backend.
+func (backend *Anthropic) ListModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	var answer struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+		} `json:"data"`
+		HasMore bool   `json:"has_more"`
+		LastID  string `json:"last_id"`
+	}
+
+	for {
+		req := backend.NewRequest("GET", "/models").
+			QueryParam("limit", "1000").
+			Into(&answer)
+		if answer.LastID != "" {
+			req.QueryParam("after_id", answer.LastID)
+		}
+
+		answer.HasMore = false
+		answer.Data = nil
+
+		err = req.RunContext(ctx)
+		if err != nil {
+			return models, fmt.Errorf("failed listing models: %w", err)
+		}
+
+		for i := range answer.Data {
+			models = append(models, types.Model{
+				ID:    answer.Data[i].ID,
+				Name:  answer.Data[i].DisplayName,
+				Owner: "Anthropic",
+			})
+		}
+
+		if !answer.HasMore {
+			break
+		}
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
@@ -4,12 +4,15 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrock"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
 // Bedrock is the struct that implements libaiac's Backend interface.
 type Bedrock struct {
 	runtime *bedrockruntime.Client
 	service *bedrock.Client
+	sts     *sts.Client
+	region  string
 }
 
 const (
@@ -17,16 +20,24 @@ const (
 	// specify one
 	DefaultAWSRegion = "us-east-1"
 
-	// DefaultAWSProfile is the default AWS profile to use if the backend does
-	// not specify one
+	// DefaultAWSProfile is the AWS profile the AWS SDK uses if neither the
+	// backend nor the AWS_PROFILE environment variable specify one
 	DefaultAWSProfile = "default"
+
+	// DefaultAWSSessionName is the session name to use when assuming a role,
+	// if the backend does not specify one
+	DefaultAWSSessionName = "aiac"
 )
 
 // New constructs a new Bedrock object. It receives a standard aws.Config
-// object.
+// object. Models are identified by their model IDs or ARNs, or by the IDs or
+// ARNs of inference profiles (e.g. "us.anthropic.claude-3-5-sonnet-...") for
+// cross-region inference, all of which the Converse API accepts.
 func New(cfg aws.Config) *Bedrock {
 	return &Bedrock{
 		runtime: bedrockruntime.NewFromConfig(cfg),
 		service: bedrock.NewFromConfig(cfg),
+		sts:     sts.NewFromConfig(cfg),
+		region:  cfg.Region,
 	}
 }
@@ -3,9 +3,12 @@ package bedrock
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
 	bedrocktypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
 )
@@ -15,7 +18,13 @@ import (
 type Conversation struct {
 	backend  *Bedrock
 	model    string
+	family   family
+	system   string
 	messages []bedrocktypes.Message
+	params   types.Parameters
+
+	// images are attached to the next prompt (see AttachImages)
+	images []types.Image
 }
 
 // Chat initiates a conversation with a Bedrock chat model. A conversation
@@ -28,8 +37,12 @@ func (backend *Bedrock) Chat(model string, msgs ...types.Message) types.Conversa
 	conv := &Conversation{
 		backend: backend,
 		model:   model,
+		family:  familyOf(model),
 	}
 
+	// The system prompt is sent separately from the conversation's messages
+	conv.system, msgs = types.SplitSystem(msgs)
+
 	if len(msgs) > 0 {
 		conv.messages = make([]bedrocktypes.Message, len(msgs))
 		for i := range msgs {
@@ -39,10 +52,8 @@ func (backend *Bedrock) Chat(model string, msgs ...types.Message) types.Conversa
 			}
 
 			conv.messages[i] = bedrocktypes.Message{
-				Role: role,
-				Content: []bedrocktypes.ContentBlock{
-					&bedrocktypes.ContentBlockMemberText{Value: msgs[i].Content},
-				},
+				Role:    role,
+				Content: contentBlocks(msgs[i].Content, msgs[i].Images),
 			}
 		}
 	}
@@ -58,23 +69,19 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 	res types.Response,
 	err error,
 ) {
-	conv.messages = append(conv.messages, bedrocktypes.Message{
-		Role: bedrocktypes.ConversationRoleUser,
-		Content: []bedrocktypes.ContentBlock{
-			&bedrocktypes.ContentBlockMemberText{Value: prompt},
-		},
-	})
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
 
 	input := bedrockruntime.ConverseInput{
-		ModelId:  aws.String(conv.model),
-		Messages: conv.messages,
-		InferenceConfig: &bedrocktypes.InferenceConfiguration{
-			Temperature: aws.Float32(0.2),
-		},
+		ModelId: aws.String(conv.model),
 	}
 
+	input.System, input.Messages = conv.family.prepare(conv.system, conv.messages)
+	input.InferenceConfig, res.Warnings = conv.family.inferenceConfig(conv.params)
+	input.AdditionalModelRequestFields = conv.additionalFields()
+
 	output, err := conv.backend.runtime.Converse(ctx, &input)
 	if err != nil {
+		err = apiError(conv.backend.modelError(ctx, conv.model, err))
 		return res, fmt.Errorf("failed sending prompt: %w", err)
 	}
 
@@ -96,6 +103,8 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 
 	res.FullOutput = outputTxt.Value
 	res.TokensUsed = int64(*output.Usage.TotalTokens)
+	res.InputTokens = int64(aws.ToInt32(output.Usage.InputTokens))
+	res.OutputTokens = int64(aws.ToInt32(output.Usage.OutputTokens))
 	res.StopReason = string(output.StopReason)
 
 	conv.messages = append(conv.messages, outputMsg)
@@ -107,19 +116,162 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 	return res, nil
 }
 
+// Stream is the same as Send, but streams the response from the backend via
+// the ConverseStream API, writing the generated text to w as it arrives.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	input := bedrockruntime.ConverseStreamInput{
+		ModelId: aws.String(conv.model),
+	}
+
+	input.System, input.Messages = conv.family.prepare(conv.system, conv.messages)
+	input.InferenceConfig, res.Warnings = conv.family.inferenceConfig(conv.params)
+	input.AdditionalModelRequestFields = conv.additionalFields()
+
+	output, err := conv.backend.runtime.ConverseStream(ctx, &input)
+	if err != nil {
+		err = apiError(conv.backend.modelError(ctx, conv.model, err))
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	stream := output.GetStream()
+	defer stream.Close()
+
+	var text strings.Builder
+
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *bedrocktypes.ConverseStreamOutputMemberContentBlockDelta:
+			delta, ok := e.Value.Delta.(*bedrocktypes.ContentBlockDeltaMemberText)
+			if !ok {
+				continue
+			}
+
+			text.WriteString(delta.Value)
+			if _, err := io.WriteString(w, delta.Value); err != nil {
+				return res, err
+			}
+		case *bedrocktypes.ConverseStreamOutputMemberMessageStop:
+			res.StopReason = string(e.Value.StopReason)
+		case *bedrocktypes.ConverseStreamOutputMemberMetadata:
+			if e.Value.Usage != nil && e.Value.Usage.TotalTokens != nil {
+				res.TokensUsed = int64(*e.Value.Usage.TotalTokens)
+				res.InputTokens = int64(aws.ToInt32(e.Value.Usage.InputTokens))
+				res.OutputTokens = int64(aws.ToInt32(e.Value.Usage.OutputTokens))
+			}
+		}
+	}
+
+	if err := stream.Err(); err != nil {
+		return res, fmt.Errorf("failed reading response stream: %w", apiError(err))
+	}
+
+	if text.Len() == 0 {
+		return res, fmt.Errorf("Bedrock didn't return any message")
+	}
+
+	conv.messages = append(conv.messages, bedrocktypes.Message{
+		Role: bedrocktypes.ConversationRoleAssistant,
+		Content: []bedrocktypes.ContentBlock{
+			&bedrocktypes.ContentBlockMemberText{Value: text.String()},
+		},
+	})
+
+	res.FullOutput = text.String()
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
 // Messages returns all the messages that have been exchanged between the user
 // and the assistant up to this point.
 func (conv *Conversation) Messages() []types.Message {
-	msgs := make([]types.Message, len(conv.messages))
-	for i, m := range conv.messages {
+	msgs := make([]types.Message, 0, len(conv.messages)+1)
+	if conv.system != "" {
+		msgs = append(msgs, types.Message{Role: "system", Content: conv.system})
+	}
+
+	for _, m := range conv.messages {
 		content, _ := m.Content[0].(*bedrocktypes.ContentBlockMemberText)
-		msgs[i] = types.Message{
+		msg := types.Message{
 			Role:    string(m.Role),
 			Content: content.Value,
 		}
+
+		for _, block := range m.Content[1:] {
+			if image, ok := block.(*bedrocktypes.ContentBlockMemberImage); ok {
+				data, _ := image.Value.Source.(*bedrocktypes.ImageSourceMemberBytes)
+				msg.Images = append(msg.Images, types.Image{
+					MediaType: "image/" + string(image.Value.Format),
+					Data:      data.Value,
+				})
+			}
+		}
+
+		msgs = append(msgs, msg)
 	}
 	return msgs
 }
 
+// AttachImages attaches images to the next prompt sent in the conversation.
+func (conv *Conversation) AttachImages(images ...types.Image) error {
+	conv.images = append(conv.images, images...)
+	return nil
+}
+
+// userMessage returns the message of a prompt, with the images attached to
+// the conversation, which are then detached from it.
+func (conv *Conversation) userMessage(prompt string) bedrocktypes.Message {
+	msg := bedrocktypes.Message{
+		Role:    bedrocktypes.ConversationRoleUser,
+		Content: contentBlocks(prompt, conv.images),
+	}
+	conv.images = nil
+
+	return msg
+}
+
+// contentBlocks returns the content blocks of a message with the provided
+// text, followed by the provided images. The text always comes first, as it is
+// where families include the system prompt (see noSystemFamily).
+func contentBlocks(text string, images []types.Image) []bedrocktypes.ContentBlock {
+	blocks := []bedrocktypes.ContentBlock{&bedrocktypes.ContentBlockMemberText{Value: text}}
+	for _, img := range images {
+		blocks = append(blocks, &bedrocktypes.ContentBlockMemberImage{
+			Value: bedrocktypes.ImageBlock{
+				Format: bedrocktypes.ImageFormat(strings.TrimPrefix(img.MediaType, "image/")),
+				Source: &bedrocktypes.ImageSourceMemberBytes{Value: img.Data},
+			},
+		})
+	}
+
+	return blocks
+}
+
 // AddHeader is a noop for the bedrock implementation
 func (conv *Conversation) AddHeader(_ string, _ string) {}
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// additionalFields returns the conversation's extra parameters as additional
+// model request fields, which the Converse API passes to the model as native
+// inference parameters (e.g. "top_k"). Returns nil if there are none.
+func (conv *Conversation) additionalFields() document.Interface {
+	if len(conv.params.Extra) == 0 {
+		return nil
+	}
+
+	return document.NewLazyDocument(conv.params.Extra)
+}
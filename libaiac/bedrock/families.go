@@ -0,0 +1,184 @@
+package bedrock
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	bedrocktypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// family adapts requests to the conventions of a family of models. The
+// Converse API translates requests and responses, including streamed deltas
+// and token usage, to and from the native schema of every model, but leaves
+// some differences between families to the caller, such as which generation
+// parameters can be combined and whether system prompts are supported.
+// Supporting a new family only requires implementing this interface and
+// adding it to families.
+type family interface {
+	// inferenceConfig translates generation parameters to an inference
+	// configuration the family accepts. Parameters that have to be dropped
+	// are reported as warnings.
+	inferenceConfig(params types.Parameters) (
+		config *bedrocktypes.InferenceConfiguration,
+		warnings []string,
+	)
+
+	// prepare returns the system prompt and messages of a conversation the
+	// way the family expects them. A nil list of system content blocks means
+	// that the system prompt, if any, is included in the messages.
+	prepare(system string, msgs []bedrocktypes.Message) (
+		[]bedrocktypes.SystemContentBlock,
+		[]bedrocktypes.Message,
+	)
+}
+
+// families maps prefixes of model IDs, without region prefixes of inference
+// profiles, to the families of the models. The longest matching prefix wins,
+// and models that match none belong to converseFamily.
+var families = map[string]family{
+	"anthropic.":                    anthropicFamily{},
+	"amazon.titan-text":             noSystemFamily{},
+	"mistral.mistral-7b-instruct":   noSystemFamily{},
+	"mistral.mixtral-8x7b-instruct": noSystemFamily{},
+	"cohere.command-text":           noSystemFamily{},
+	"cohere.command-light-text":     noSystemFamily{},
+}
+
+// profileRegions are the region prefixes of the IDs of cross-region inference
+// profiles (e.g. "us." in "us.anthropic.claude-3-5-sonnet-20240620-v1:0").
+var profileRegions = []string{"us.", "us-gov.", "eu.", "apac.", "ca.", "jp.", "au.", "global."}
+
+// familyOf detects the family of a model from its ID, the ID of an inference
+// profile, or the ARN of either.
+func familyOf(model string) family {
+	// ARNs of models and inference profiles end with their IDs
+	if strings.HasPrefix(model, "arn:") {
+		model = model[strings.LastIndex(model, "/")+1:]
+	}
+
+	for _, prefix := range profileRegions {
+		if strings.HasPrefix(model, prefix) {
+			model = strings.TrimPrefix(model, prefix)
+			break
+		}
+	}
+
+	var found family = converseFamily{}
+
+	longest := 0
+	for prefix, f := range families {
+		if strings.HasPrefix(model, prefix) && len(prefix) > longest {
+			found, longest = f, len(prefix)
+		}
+	}
+
+	return found
+}
+
+// converseFamily is the family of models that accept everything the Converse
+// API supports, which is most models.
+type converseFamily struct{}
+
+// inferenceConfig implements the family interface. The Converse API maps the
+// configuration onto each model's native inference parameters.
+func (converseFamily) inferenceConfig(params types.Parameters) (
+	config *bedrocktypes.InferenceConfiguration,
+	warnings []string,
+) {
+	config = &bedrocktypes.InferenceConfiguration{
+		Temperature: aws.Float32(float32(params.TemperatureOrDefault())),
+	}
+
+	if params.TopP != nil {
+		config.TopP = aws.Float32(float32(*params.TopP))
+	}
+
+	if params.MaxTokens != nil {
+		config.MaxTokens = aws.Int32(int32(*params.MaxTokens))
+	}
+
+	if len(params.Stop) > 0 {
+		config.StopSequences = params.Stop
+	}
+
+	return config, nil
+}
+
+// prepare implements the family interface. The system prompt is sent as a
+// system content block.
+func (converseFamily) prepare(system string, msgs []bedrocktypes.Message) (
+	[]bedrocktypes.SystemContentBlock,
+	[]bedrocktypes.Message,
+) {
+	if system == "" {
+		return nil, msgs
+	}
+
+	return []bedrocktypes.SystemContentBlock{
+		&bedrocktypes.SystemContentBlockMemberText{Value: system},
+	}, msgs
+}
+
+// anthropicFamily is the family of Anthropic's Claude models.
+type anthropicFamily struct {
+	converseFamily
+}
+
+// inferenceConfig implements the family interface. Anthropic models reject
+// requests that set both temperature and top_p, so in that case top_p is
+// dropped and a warning is returned.
+func (f anthropicFamily) inferenceConfig(params types.Parameters) (
+	config *bedrocktypes.InferenceConfiguration,
+	warnings []string,
+) {
+	if params.TopP != nil && params.Temperature != nil {
+		params.TopP = nil
+		warnings = append(warnings, "Anthropic models do not accept both temperature and top_p, ignoring top_p")
+	}
+
+	config, _ = f.converseFamily.inferenceConfig(params)
+
+	return config, warnings
+}
+
+// noSystemFamily is the family of models that do not support system prompts,
+// such as Amazon Titan Text models, and older Mistral and Cohere models.
+type noSystemFamily struct {
+	converseFamily
+}
+
+// prepare implements the family interface. The system prompt is prepended to
+// the first user message, without modifying the provided messages.
+func (noSystemFamily) prepare(system string, msgs []bedrocktypes.Message) (
+	[]bedrocktypes.SystemContentBlock,
+	[]bedrocktypes.Message,
+) {
+	if system == "" {
+		return nil, msgs
+	}
+
+	for i, msg := range msgs {
+		if msg.Role != bedrocktypes.ConversationRoleUser {
+			continue
+		}
+
+		text, rest := system, msg.Content
+		if content, ok := rest[0].(*bedrocktypes.ContentBlockMemberText); ok {
+			text, rest = text+"\n\n"+content.Value, rest[1:]
+		}
+
+		prepared := append([]bedrocktypes.Message(nil), msgs...)
+		prepared[i] = bedrocktypes.Message{
+			Role: msg.Role,
+			Content: append(
+				[]bedrocktypes.ContentBlock{&bedrocktypes.ContentBlockMemberText{Value: text}},
+				rest...,
+			),
+		}
+
+		return nil, prepared
+	}
+
+	return nil, msgs
+}
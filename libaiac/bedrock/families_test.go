@@ -0,0 +1,305 @@
+package bedrock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// bucket is the code generated in the recorded responses in testdata.
+const bucket = "resource \"aws_s3_bucket\" \"main\" {\n  bucket = \"encrypted\"\n}"
+
+// familyTests are conversations with a model of each family, whose requests
+// and recorded responses are in the testdata directory named by dir.
+var familyTests = []struct {
+	dir    string
+	model  string
+	family family
+	params types.Parameters
+
+	wantWarnings     []string
+	wantCode         string
+	wantStopReason   string
+	wantInputTokens  int64
+	wantOutputTokens int64
+}{
+	{
+		dir:    "anthropic",
+		model:  "us.anthropic.claude-3-5-sonnet-20240620-v1:0",
+		family: anthropicFamily{},
+		params: types.Parameters{
+			Temperature: ptr(0.5),
+			TopP:        ptr(0.75),
+			MaxTokens:   ptr(1024),
+			Stop:        []string{"</code>"},
+		},
+		wantWarnings: []string{
+			"Anthropic models do not accept both temperature and top_p, ignoring top_p",
+		},
+		wantCode:         bucket,
+		wantStopReason:   "end_turn",
+		wantInputTokens:  31,
+		wantOutputTokens: 42,
+	},
+	{
+		dir:    "titan",
+		model:  "amazon.titan-text-premier-v1:0",
+		family: noSystemFamily{},
+		params: types.Parameters{
+			Temperature: ptr(0.5),
+			TopP:        ptr(0.75),
+			MaxTokens:   ptr(512),
+		},
+		wantCode:         bucket,
+		wantStopReason:   "max_tokens",
+		wantInputTokens:  19,
+		wantOutputTokens: 512,
+	},
+}
+
+const (
+	system = "You are an expert in infrastructure as code."
+	prompt = "generate terraform for an encrypted s3 bucket"
+)
+
+func TestFamilySend(t *testing.T) {
+	for _, tt := range familyTests {
+		t.Run(tt.dir, func(t *testing.T) {
+			var body []byte
+			backend := testBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/converse") {
+					t.Errorf("expected a Converse request, got %s", r.URL.Path)
+				}
+
+				body, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(readTestdata(t, tt.dir, "response.json")) //nolint: errcheck
+			})
+
+			conv := backend.Chat(tt.model, types.Message{Role: "system", Content: system})
+			conv.SetParameters(tt.params)
+
+			res, err := conv.Send(context.Background(), prompt)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			assertJSON(t, readTestdata(t, tt.dir, "request.json"), body)
+
+			var recorded struct {
+				Output struct {
+					Message struct {
+						Content []struct {
+							Text string `json:"text"`
+						} `json:"content"`
+					} `json:"message"`
+				} `json:"output"`
+			}
+			err = json.Unmarshal(readTestdata(t, tt.dir, "response.json"), &recorded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := recorded.Output.Message.Content[0].Text; res.FullOutput != want {
+				t.Errorf("expected output %q, got %q", want, res.FullOutput)
+			}
+
+			assertResponse(t, res, tt.wantWarnings, tt.wantCode, tt.wantStopReason,
+				tt.wantInputTokens, tt.wantOutputTokens)
+		})
+	}
+}
+
+func TestFamilyStream(t *testing.T) {
+	for _, tt := range familyTests {
+		t.Run(tt.dir, func(t *testing.T) {
+			var chunks []struct {
+				Event   string          `json:"event"`
+				Payload json.RawMessage `json:"payload"`
+			}
+			err := json.Unmarshal(readTestdata(t, tt.dir, "stream.json"), &chunks)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var body []byte
+			backend := testBackend(t, func(w http.ResponseWriter, r *http.Request) {
+				if !strings.HasSuffix(r.URL.Path, "/converse-stream") {
+					t.Errorf("expected a ConverseStream request, got %s", r.URL.Path)
+				}
+
+				body, _ = io.ReadAll(r.Body)
+				w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+
+				encoder := eventstream.NewEncoder()
+				for _, chunk := range chunks {
+					var headers eventstream.Headers
+					headers.Set(":message-type", eventstream.StringValue("event"))
+					headers.Set(":event-type", eventstream.StringValue(chunk.Event))
+					headers.Set(":content-type", eventstream.StringValue("application/json"))
+
+					err := encoder.Encode(w, eventstream.Message{Headers: headers, Payload: chunk.Payload})
+					if err != nil {
+						t.Errorf("failed encoding %s event: %s", chunk.Event, err)
+					}
+				}
+			})
+
+			conv := backend.Chat(tt.model, types.Message{Role: "system", Content: system})
+			conv.SetParameters(tt.params)
+
+			var streamed bytes.Buffer
+			res, err := conv.Stream(context.Background(), prompt, &streamed)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			// Streamed requests have the same body as others
+			assertJSON(t, readTestdata(t, tt.dir, "request.json"), body)
+
+			var want strings.Builder
+			for _, chunk := range chunks {
+				var payload struct {
+					Delta struct {
+						Text string `json:"text"`
+					} `json:"delta"`
+				}
+				if json.Unmarshal(chunk.Payload, &payload) == nil {
+					want.WriteString(payload.Delta.Text)
+				}
+			}
+
+			if streamed.String() != want.String() {
+				t.Errorf("expected deltas %q to be written, got %q", want.String(), streamed.String())
+			}
+
+			if res.FullOutput != want.String() {
+				t.Errorf("expected output %q, got %q", want.String(), res.FullOutput)
+			}
+
+			assertResponse(t, res, tt.wantWarnings, tt.wantCode, tt.wantStopReason,
+				tt.wantInputTokens, tt.wantOutputTokens)
+		})
+	}
+}
+
+func TestFamilyOf(t *testing.T) {
+	for _, tt := range familyTests {
+		if got := familyOf(tt.model); got != tt.family {
+			t.Errorf("expected family %T for %s, got %T", tt.family, tt.model, got)
+		}
+	}
+
+	tests := map[string]family{
+		"anthropic.claude-3-haiku-20240307-v1:0":                                   anthropicFamily{},
+		"arn:aws:bedrock:us-east-1::foundation-model/amazon.titan-text-express-v1": noSystemFamily{},
+		"mistral.mistral-large-2402-v1:0":                                          converseFamily{},
+		"mistral.mistral-7b-instruct-v0:2":                                         noSystemFamily{},
+		"meta.llama3-70b-instruct-v1:0":                                            converseFamily{},
+	}
+
+	for model, want := range tests {
+		if got := familyOf(model); got != want {
+			t.Errorf("expected family %T for %s, got %T", want, model, got)
+		}
+	}
+}
+
+// testBackend returns a Bedrock backend sending its requests to a test server
+// with the provided handler.
+func testBackend(t *testing.T, handler http.HandlerFunc) *Bedrock {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return New(aws.Config{
+		Region:       DefaultAWSRegion,
+		Credentials:  credentials.NewStaticCredentialsProvider("AKIDTEST", "secret", ""),
+		BaseEndpoint: aws.String(srv.URL),
+	})
+}
+
+// readTestdata returns the content of a file in the testdata directory of a
+// family.
+func readTestdata(t *testing.T, dir, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// assertJSON fails the test if the provided JSON documents are not
+// equivalent.
+func assertJSON(t *testing.T, want, got []byte) {
+	t.Helper()
+
+	var wantDoc, gotDoc any
+	if err := json.Unmarshal(want, &wantDoc); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := json.Unmarshal(got, &gotDoc); err != nil {
+		t.Fatalf("invalid request body %q: %s", got, err)
+	}
+
+	if !reflect.DeepEqual(wantDoc, gotDoc) {
+		t.Errorf("expected request body %s, got %s", want, got)
+	}
+}
+
+// assertResponse fails the test if the response was not parsed as expected.
+func assertResponse(
+	t *testing.T,
+	res types.Response,
+	wantWarnings []string,
+	wantCode, wantStopReason string,
+	wantInputTokens, wantOutputTokens int64,
+) {
+	t.Helper()
+
+	if !reflect.DeepEqual(res.Warnings, wantWarnings) {
+		t.Errorf("expected warnings %q, got %q", wantWarnings, res.Warnings)
+	}
+
+	if res.Code != wantCode {
+		t.Errorf("expected code %q, got %q", wantCode, res.Code)
+	}
+
+	if res.StopReason != wantStopReason {
+		t.Errorf("expected stop reason %q, got %q", wantStopReason, res.StopReason)
+	}
+
+	if res.InputTokens != wantInputTokens || res.OutputTokens != wantOutputTokens {
+		t.Errorf(
+			"expected %d input and %d output tokens, got %d and %d",
+			wantInputTokens, wantOutputTokens, res.InputTokens, res.OutputTokens,
+		)
+	}
+
+	if want := wantInputTokens + wantOutputTokens; res.TokensUsed != want {
+		t.Errorf("expected %d tokens used, got %d", want, res.TokensUsed)
+	}
+}
+
+// ptr returns a pointer to the provided value.
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,25 @@
+package bedrock
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Identity returns the AWS principal and account of the backend's
+// credentials, as reported by STS's GetCallerIdentity, which requires no
+// permissions.
+func (backend *Bedrock) Identity(ctx context.Context) (identity types.Identity, err error) {
+	output, err := backend.sts.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return identity, fmt.Errorf("failed getting caller identity: %w", err)
+	}
+
+	return types.Identity{
+		User:         aws.ToString(output.Arn),
+		Organization: aws.ToString(output.Account),
+	}, nil
+}
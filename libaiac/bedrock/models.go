@@ -2,28 +2,162 @@ package bedrock
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sort"
+	"strings"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrock"
-	"github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	bedrocktypes "github.com/aws/aws-sdk-go-v2/service/bedrock/types"
+	runtimetypes "github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
 )
 
-// ListModels returns a list of all the models supported by this backend.
-func (backend *Bedrock) ListModels(ctx context.Context) (models []string, err error) {
+// maxListedModels is the maximum number of valid models included in the error
+// returned when a model is not available
+const maxListedModels = 20
+
+// ListModels returns a list of all the models supported by this backend: the
+// foundation models that generate text, and the active inference profiles
+// available in the backend's region. Inference profiles are not included if
+// they cannot be listed (e.g. due to missing permissions).
+func (backend *Bedrock) ListModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
 	output, err := backend.service.ListFoundationModels(ctx, &bedrock.ListFoundationModelsInput{
-		ByOutputModality: types.ModelModalityText,
+		ByOutputModality: bedrocktypes.ModelModalityText,
 	})
 	if err != nil {
 		return models, fmt.Errorf("failed listing base models: %w", err)
 	}
 
-	models = make([]string, len(output.ModelSummaries))
-	for i := range output.ModelSummaries {
-		models[i] = *output.ModelSummaries[i].ModelId
+	models = make([]types.Model, len(output.ModelSummaries))
+	for i, summary := range output.ModelSummaries {
+		models[i] = types.Model{
+			ID:    aws.ToString(summary.ModelId),
+			Name:  aws.ToString(summary.ModelName),
+			Owner: aws.ToString(summary.ProviderName),
+		}
+	}
+
+	profiles, err := backend.listInferenceProfiles(ctx)
+	if err == nil {
+		models = append(models, profiles...)
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
 	}
 
-	sort.Strings(models)
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
+
+// listInferenceProfiles returns the active inference profiles available in the
+// backend's region.
+func (backend *Bedrock) listInferenceProfiles(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	paginator := bedrock.NewListInferenceProfilesPaginator(
+		backend.service,
+		&bedrock.ListInferenceProfilesInput{},
+	)
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return models, fmt.Errorf("failed listing inference profiles: %w", err)
+		}
+
+		for _, summary := range output.InferenceProfileSummaries {
+			if summary.Status != bedrocktypes.InferenceProfileStatusActive {
+				continue
+			}
+
+			models = append(models, types.Model{
+				ID:    aws.ToString(summary.InferenceProfileId),
+				Name:  aws.ToString(summary.InferenceProfileName),
+				Owner: "Inference profile",
+			})
+		}
+	}
 
 	return models, nil
 }
+
+// modelError checks whether a request failed with err because the model of
+// the conversation does not exist in the backend's region, or is not
+// available to the account. If so, an error wrapping types.ErrModelNotFound
+// that lists some of the available models is returned. Otherwise, err is
+// returned as-is.
+func (backend *Bedrock) modelError(ctx context.Context, model string, err error) error {
+	var validationErr *runtimetypes.ValidationException
+	var notFoundErr *runtimetypes.ResourceNotFoundException
+	if !errors.As(err, &validationErr) && !errors.As(err, &notFoundErr) {
+		return err
+	}
+
+	models, listErr := backend.ListModels(ctx)
+	if listErr != nil {
+		return err
+	}
+
+	// ARNs of models and inference profiles end with their IDs
+	id := model
+	if strings.HasPrefix(id, "arn:") {
+		id = id[strings.LastIndex(id, "/")+1:]
+	}
+
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		if m.ID == id {
+			// The model exists, the request is invalid for another reason
+			return err
+		}
+
+		ids = append(ids, m.ID)
+	}
+
+	more := ""
+	if len(ids) > maxListedModels {
+		more = fmt.Sprintf(" and %d more (see the models command)", len(ids)-maxListedModels)
+		ids = ids[:maxListedModels]
+	}
+
+	return fmt.Errorf(
+		"%w: %s is not available in region %s; available models and inference profiles include %s%s",
+		types.ErrModelNotFound, model, backend.region, strings.Join(ids, ", "), more,
+	)
+}
+
+// apiError returns errors returned by the Bedrock API as types.APIError,
+// carrying the exception's code (e.g. "ThrottlingException"), message and
+// the response's status code, and wrapping the original error. Other errors,
+// such as network errors, are returned as-is.
+func apiError(err error) error {
+	var sdkErr smithy.APIError
+	if !errors.As(err, &sdkErr) {
+		return err
+	}
+
+	apiErr := &types.APIError{
+		Provider: "Amazon Bedrock",
+		Code:     sdkErr.ErrorCode(),
+		Message:  sdkErr.ErrorMessage(),
+		Err:      err,
+	}
+
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		apiErr.StatusCode = statusErr.HTTPStatusCode()
+	}
+
+	return apiErr
+}
@@ -0,0 +1,191 @@
+package libaiac
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultOutputEstimate is the number of tokens responses are assumed to be
+// made of when estimating the cost of prompts that do not set a maximum
+// number of tokens to generate (see Aiac.EstimateCost).
+const DefaultOutputEstimate = 1000
+
+// ledgerMonth is the format of the months the spend ledger is keyed by.
+const ledgerMonth = "2006-01"
+
+// BudgetConfig holds the limits on the cost of requests, in US dollars.
+// Costs are estimated before prompts are sent (see Aiac.EstimateCost), and
+// prompts that exceed a limit are refused with an error wrapping
+// types.ErrBudgetExceeded, unless budget checks are disabled (see
+// Aiac.SkipBudgetCheck). Limits of zero are not enforced.
+type BudgetConfig struct {
+	// MaxCost is the maximum estimated cost of a single request.
+	MaxCost float64 `toml:"max_cost"`
+
+	// Monthly is the maximum total cost of the requests of a calendar
+	// month, tracked in the spend ledger (see Ledger).
+	Monthly float64 `toml:"monthly"`
+}
+
+// validate verifies that the limits of the budget are not negative.
+func (budget BudgetConfig) validate() (errs []error) {
+	if budget.MaxCost < 0 {
+		errs = append(errs, fmt.Errorf("budget.max_cost must not be negative, got %g", budget.MaxCost))
+	}
+
+	if budget.Monthly < 0 {
+		errs = append(errs, fmt.Errorf("budget.monthly must not be negative, got %g", budget.Monthly))
+	}
+
+	return errs
+}
+
+// Ledger is an on-disk record of the estimated cost of the responses
+// generated in every calendar month, for enforcing monthly budgets (see
+// BudgetConfig.Monthly). Only responses of models with a known price are
+// recorded. It is stored as a JSON object mapping months (e.g. "2024-06") to
+// their total cost in US dollars.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLedger creates a spend ledger stored in the XDG data directory. On
+// Unix-like operating systems, this will be ~/.local/share/aiac/spend.json.
+func NewLedger() *Ledger {
+	return &Ledger{path: filepath.Join(xdg.DataHome, "aiac", "spend.json")}
+}
+
+// Path returns the path of the file the ledger is stored in.
+func (ledger *Ledger) Path() string {
+	return ledger.path
+}
+
+// Spent returns the total cost recorded in the ledger for the current
+// month.
+func (ledger *Ledger) Spent() (float64, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	months, err := ledger.read()
+	if err != nil {
+		return 0, err
+	}
+
+	return months[time.Now().Format(ledgerMonth)], nil
+}
+
+// Add records the cost of a response in the ledger, for the current month.
+func (ledger *Ledger) Add(cost float64) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	months, err := ledger.read()
+	if err != nil {
+		return err
+	}
+
+	if months == nil {
+		months = make(map[string]float64, 1)
+	}
+
+	months[time.Now().Format(ledgerMonth)] += cost
+
+	return ledger.write(months)
+}
+
+// Reset removes every cost recorded in the ledger.
+func (ledger *Ledger) Reset() error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	err := os.Remove(ledger.path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed removing spend ledger: %w", err)
+	}
+
+	return nil
+}
+
+// read reads the costs recorded in the ledger, by month.
+func (ledger *Ledger) read() (months map[string]float64, err error) {
+	data, err := os.ReadFile(ledger.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed reading spend ledger: %w", err)
+	}
+
+	err = json.Unmarshal(data, &months)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing spend ledger %s: %w", ledger.path, err)
+	}
+
+	return months, nil
+}
+
+// write replaces the ledger file with the provided costs, atomically, so that
+// the ledger is never left partially written.
+func (ledger *Ledger) write(months map[string]float64) error {
+	data, err := json.MarshalIndent(months, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding spend ledger: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(ledger.path), 0o700) //nolint: gomnd
+	if err != nil {
+		return fmt.Errorf("failed creating spend ledger directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(ledger.path), ".spend-*")
+	if err != nil {
+		return fmt.Errorf("failed creating spend ledger: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing spend ledger: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), ledger.path)
+	if err != nil {
+		return fmt.Errorf("failed saving spend ledger: %w", err)
+	}
+
+	return nil
+}
+
+// EstimateCost estimates the cost of sending a prompt to a model of a
+// backend, in US dollars, from the estimate of its tokens (see
+// EstimateTokens). Responses are assumed to be as long as the maximum number
+// of tokens to generate, or DefaultOutputEstimate tokens if it is not set, so
+// the actual cost is usually lower. Returns false if the price of the model
+// is unknown (see Aiac.Cost).
+func (aiac *Aiac) EstimateCost(backendName, model string, est TokenEstimate) (cost float64, ok bool) {
+	output := est.MaxTokens
+	if output == 0 {
+		output = DefaultOutputEstimate
+	}
+
+	return aiac.Cost(types.Response{
+		Backend:      backendName,
+		Model:        model,
+		InputTokens:  est.Prompt,
+		OutputTokens: output,
+	})
+}
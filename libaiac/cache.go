@@ -0,0 +1,177 @@
+package libaiac
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultCacheTTL is the amount of time cached responses are kept for when a
+// TTL is not configured.
+const DefaultCacheTTL = 24 * time.Hour
+
+// Cache is an on-disk cache of responses. Responses are keyed by a hash of
+// the backend, model, generation parameters, previous messages and the
+// normalized prompt, so an identical request can be answered without
+// contacting the provider.
+type Cache struct {
+	dir string
+	ttl time.Duration
+}
+
+type cacheEntry struct {
+	CreatedAt time.Time      `json:"created_at"`
+	Response  types.Response `json:"response"`
+}
+
+// NewCache creates a cache from the provided configuration. If a directory is
+// not configured, responses are stored in the XDG cache directory. On
+// Unix-like operating systems, this will be ~/.cache/aiac.
+func NewCache(conf CacheConfig) *Cache {
+	cache := &Cache{dir: conf.Dir, ttl: conf.TTL}
+	if cache.dir == "" {
+		cache.dir = filepath.Join(xdg.CacheHome, "aiac")
+	}
+	if cache.ttl <= 0 {
+		cache.ttl = DefaultCacheTTL
+	}
+
+	return cache
+}
+
+// Dir returns the directory in which responses are stored.
+func (cache *Cache) Dir() string {
+	return cache.dir
+}
+
+// Clear removes all cached responses.
+func (cache *Cache) Clear() error {
+	entries, err := os.ReadDir(cache.dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed reading cache directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(cache.dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed clearing cache: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// get returns the response cached for the provided key, if it exists and
+// hasn't expired.
+func (cache *Cache) get(key string) (res types.Response, ok bool) {
+	data, err := os.ReadFile(cache.path(key))
+	if err != nil {
+		return res, false
+	}
+
+	var entry cacheEntry
+
+	err = json.Unmarshal(data, &entry)
+	if err != nil || time.Since(entry.CreatedAt) > cache.ttl {
+		return res, false
+	}
+
+	entry.Response.Cached = true
+
+	return entry.Response, true
+}
+
+// put stores a response under the provided key. The API key used for the
+// request is never stored.
+func (cache *Cache) put(key string, res types.Response) error {
+	res.APIKeyUsed = ""
+	res.Warnings = nil
+
+	data, err := json.Marshal(cacheEntry{
+		CreatedAt: time.Now(),
+		Response:  res,
+	})
+	if err != nil {
+		return fmt.Errorf("failed encoding response: %w", err)
+	}
+
+	err = os.MkdirAll(cache.dir, 0o700) //nolint: gomnd
+	if err != nil {
+		return fmt.Errorf("failed creating cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(cache.dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed creating cache entry: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing cache entry: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), cache.path(key))
+	if err != nil {
+		return fmt.Errorf("failed saving cache entry: %w", err)
+	}
+
+	return nil
+}
+
+func (cache *Cache) path(key string) string {
+	return filepath.Join(cache.dir, key+".json")
+}
+
+// cacheKey returns the key under which the response to the provided prompt,
+// with the provided images attached, is cached. Leading and trailing
+// whitespace in the prompt is ignored, but not whitespace within it, as the
+// prompt may include files (e.g. context files) where it is significant.
+func cacheKey(
+	backendName, model string,
+	params types.Parameters,
+	msgs []types.Message,
+	prompt string,
+	images []types.Image,
+) string {
+	data, _ := json.Marshal(struct {
+		Backend    string           `json:"backend"`
+		Model      string           `json:"model"`
+		Parameters types.Parameters `json:"parameters"`
+		Messages   []types.Message  `json:"messages"`
+		Prompt     string           `json:"prompt"`
+		Images     []types.Image    `json:"images,omitempty"`
+	}{
+		Backend:    backendName,
+		Model:      model,
+		Parameters: params,
+		Messages:   msgs,
+		Prompt:     strings.TrimSpace(prompt),
+		Images:     images,
+	})
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,24 @@
+package libaiac
+
+import (
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestCacheKeyWhitespace(t *testing.T) {
+	key := func(prompt string) string {
+		return cacheKey("test", "model", types.Parameters{}, nil, prompt, nil)
+	}
+
+	prompt := "kubernetes manifest based on:\n\nspec:\n  replicas: 2\n"
+
+	if key(prompt) != key("  "+prompt+"\n\n") {
+		t.Error("expected leading and trailing whitespace to be ignored")
+	}
+
+	// Indentation is significant in context files, such as YAML
+	if key(prompt) == key("kubernetes manifest based on:\n\nspec:\nreplicas: 2\n") {
+		t.Error("expected whitespace within the prompt to change the key")
+	}
+}
@@ -0,0 +1,262 @@
+package libaiac
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Client provides a minimal, stable API for embedding aiac's code generation
+// in other programs: backends are selected from a configuration by name (see
+// Client.Backend), and generate responses to single requests (see
+// Backend.Complete). It is implemented on top of Aiac, which remains
+// available for features the client does not expose (see Client.Aiac).
+type Client struct {
+	aiac *Aiac
+}
+
+// NewClient creates a client from a populated configuration, such as one
+// returned by LoadConfig or built in code. The configuration is validated
+// first (see Config.Validate), and an error is returned if it is invalid.
+// Environment variable references in the configuration are not replaced, as
+// they are by LoadConfig.
+func NewClient(conf Config) (*Client, error) {
+	err := conf.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return &Client{aiac: NewFromConf(conf)}, nil
+}
+
+// Aiac returns the Aiac object the client is implemented with, to configure
+// features the client does not expose, such as logging, middleware or the
+// response cache. These must be configured before the first request is sent.
+func (client *Client) Aiac() *Aiac {
+	return client.aiac
+}
+
+// Backend returns the backend with the provided name, or the default backend
+// of the configuration if name is an empty string. If no backend has that
+// name, but exactly one backend has it as its type, that backend is returned
+// (see Aiac.ResolveBackend). An error wrapping types.ErrNoSuchBackend is
+// returned if there is no such backend, types.ErrAmbiguousBackend if several
+// backends have that type, or types.ErrNoDefaultBackend if name is empty and
+// there is no default backend. The backend's implementation is only created
+// when the first request is sent, so errors in its settings are returned
+// then.
+func (client *Client) Backend(name string) (*Backend, error) {
+	name, err := client.aiac.ResolveBackend(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Backend{aiac: client.aiac, name: name}, nil
+}
+
+// Backend is a backend of a Client, which generates responses to completion
+// requests. It is safe for concurrent use.
+type Backend struct {
+	aiac *Aiac
+	name string
+}
+
+// CompletionRequest is a request to generate a response with a Backend. Only
+// the prompt is required by every backend. The model is required by backends
+// of types that have no default model (Azure OpenAI, where it is the name of
+// the deployment, Amazon Bedrock and Ollama), unless their configuration
+// sets one (see BackendConfig.ResolveModel). Other fields are optional.
+type CompletionRequest struct {
+	// Prompt is the prompt to generate a response to.
+	Prompt string
+
+	// Model is the model to generate the response with. If empty, the
+	// backend's default model is used.
+	Model string
+
+	// Kind is the kind of code requested (e.g. "terraform"), selecting the
+	// model the backend configures for it, if Model is empty (see
+	// BackendConfig.ModelByKind). It can be detected from the prompt with
+	// DetectKind.
+	Kind string
+
+	// System is the system prompt. If empty, the backend's configured system
+	// prompt is used, if any, unless Messages include one.
+	System string
+
+	// Messages are previous messages of the conversation the prompt is part
+	// of, oldest first, such as CompletionResponse.Messages of a previous
+	// response to send a follow-up prompt.
+	Messages []types.Message
+
+	// Parameters are the generation parameters. Those not set fall back to
+	// the backend's configured parameters, and to the provider's defaults.
+	Parameters types.Parameters
+}
+
+// CompletionResponse is a response generated by a Backend.
+type CompletionResponse struct {
+	// Output is the complete output of the model, generally Markdown text
+	// including the code and explanations.
+	Output string
+
+	// Code is the code extracted from the output, or the complete output if
+	// it does not include a code block.
+	Code string
+
+	// Language is the language hint of the extracted code block (e.g.
+	// "hcl"), if any.
+	Language string
+
+	// Reasoning is the reasoning that preceded the output, for reasoning
+	// models that return it separately.
+	Reasoning string
+
+	// Backend is the name of the backend that generated the response, which
+	// may be a fallback backend (see Config.Fallback).
+	Backend string
+
+	// Model is the model that generated the response.
+	Model string
+
+	// Provider is the upstream provider that served the request, for
+	// backends that route requests (e.g. OpenRouter).
+	Provider string
+
+	// StopReason is the reason the model stopped generating, as reported by
+	// the provider (e.g. "stop" or "length").
+	StopReason string
+
+	// Citations are the URLs of the sources of web-grounded responses, if
+	// returned by the provider.
+	Citations []string
+
+	// Seed is the seed the response was generated with, if one was set (see
+	// types.Parameters.Seed) and the provider supports it.
+	Seed *int64
+
+	// SystemFingerprint identifies the configuration of the provider's
+	// servers that generated the response, if returned by the provider.
+	SystemFingerprint string
+
+	// Usage is the token usage of the request.
+	Usage Usage
+
+	// Cost is the estimated cost of the request in US dollars, if the price
+	// of the model is known (see Aiac.Cost) and the response was not cached.
+	Cost *float64
+
+	// Warnings holds non-fatal issues encountered while preparing the
+	// request, such as unsupported parameters that were ignored.
+	Warnings []string
+
+	// Cached is true if the response was loaded from the response cache.
+	Cached bool
+
+	// Messages are the messages of the conversation, including the prompt
+	// and the response, to be sent with a follow-up prompt.
+	Messages []types.Message
+}
+
+// Name returns the name of the backend.
+func (backend *Backend) Name() string {
+	return backend.name
+}
+
+// ListModels returns a list of all the models supported by the backend, as
+// with Aiac.ListModels.
+func (backend *Backend) ListModels(ctx context.Context) ([]types.Model, error) {
+	return backend.aiac.ListModels(ctx, backend.name)
+}
+
+// Complete generates a response to a completion request. Requests are sent
+// with the backend's timeout, retries and fallbacks, as with conversations
+// started with Aiac.Chat. An error wrapping types.ErrEmptyPrompt is returned
+// if the request has no prompt, and one wrapping types.ErrNoDefaultModel if
+// it has no model and the backend has no default.
+func (backend *Backend) Complete(ctx context.Context, req CompletionRequest) (
+	CompletionResponse,
+	error,
+) {
+	return backend.complete(ctx, req, nil)
+}
+
+// Stream is the same as Complete, but streams the response, writing
+// generated text to w as it arrives. The returned response contains the
+// complete output, as with Complete.
+func (backend *Backend) Stream(ctx context.Context, req CompletionRequest, w io.Writer) (
+	CompletionResponse,
+	error,
+) {
+	return backend.complete(ctx, req, w)
+}
+
+// complete generates a response to a completion request in a new
+// conversation, streaming it to w if not nil.
+func (backend *Backend) complete(ctx context.Context, req CompletionRequest, w io.Writer) (
+	comp CompletionResponse,
+	err error,
+) {
+	if req.Prompt == "" {
+		return comp, types.ErrEmptyPrompt
+	}
+
+	msgs := req.Messages
+	if req.System != "" {
+		msgs = types.WithSystem(msgs, req.System)
+	}
+
+	model := backend.aiac.Conf.Backends[backend.name].ResolveModelForKind(req.Model, req.Kind)
+
+	chat, err := backend.aiac.Chat(ctx, backend.name, model, msgs...)
+	if err != nil {
+		return comp, err
+	}
+
+	chat.SetParameters(req.Parameters)
+
+	var res types.Response
+	if w != nil {
+		res, err = chat.Stream(ctx, req.Prompt, w)
+	} else {
+		res, err = chat.Send(ctx, req.Prompt)
+	}
+	if err != nil {
+		return comp, err
+	}
+
+	comp = CompletionResponse{
+		Output:     res.FullOutput,
+		Code:       res.Code,
+		Language:   res.Language,
+		Reasoning:  res.Reasoning,
+		Backend:    res.Backend,
+		Model:      res.Model,
+		Provider:   res.Provider,
+		StopReason: res.StopReason,
+		Citations:  res.Citations,
+		Seed:       res.Seed,
+		Usage: Usage{
+			InputTokens:  res.InputTokens,
+			OutputTokens: res.OutputTokens,
+			TotalTokens:  res.TokensUsed,
+			Estimated:    res.TokensEstimated,
+
+			ReasoningTokens: res.ReasoningTokens,
+		},
+		Warnings: res.Warnings,
+		Cached:   res.Cached,
+		Messages: chat.Messages(),
+
+		SystemFingerprint: res.SystemFingerprint,
+	}
+
+	// Cached responses cost nothing
+	if cost, ok := backend.aiac.Cost(res); ok && !res.Cached {
+		comp.Cost = &cost
+	}
+
+	return comp, nil
+}
@@ -0,0 +1,221 @@
+package cohere
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Conversation is a struct used to converse with a Cohere chat model. It
+// maintains all messages sent/received in order to maintain context.
+type Conversation struct {
+	backend      *Cohere
+	model        string
+	messages     []types.Message
+	extraHeaders map[string]string
+	params       types.Parameters
+}
+
+// billedUnits is the number of tokens Cohere bills a request for.
+type billedUnits struct {
+	InputTokens  float64 `json:"input_tokens"`
+	OutputTokens float64 `json:"output_tokens"`
+}
+
+// streamEvent represents a single event in the stream returned by the v2
+// chat API. Only the fields aiac cares about are included.
+type streamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Message struct {
+			Content struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"message"`
+		FinishReason string `json:"finish_reason"`
+		Usage        struct {
+			BilledUnits *billedUnits `json:"billed_units"`
+		} `json:"usage"`
+		Error string `json:"error"`
+	} `json:"delta"`
+
+	// Older versions of the API, and some compatible gateways, report usage
+	// in a "meta" object
+	Meta struct {
+		BilledUnits *billedUnits `json:"billed_units"`
+	} `json:"meta"`
+}
+
+// Chat initiates a conversation with a Cohere chat model. A conversation
+// maintains context, allowing to send further instructions to modify the output
+// from previous requests. The name of the model to use must be provided. Users
+// can also supply zero or more "previous messages" that may have been exchanged
+// in the past. This practically allows "loading" previous conversations and
+// continuing them.
+func (backend *Cohere) Chat(model string, msgs ...types.Message) types.Conversation {
+	conv := &Conversation{
+		backend: backend,
+		model:   model,
+	}
+
+	if len(msgs) > 0 {
+		conv.messages = msgs
+	}
+
+	return conv
+}
+
+// Send sends the provided message to the API and returns a Response object.
+// To maintain context, all previous messages (whether from you to the API or
+// vice-versa) are sent as well, allowing you to ask the API to modify the
+// code it already generated. The response is streamed from the API and
+// assembled before being returned.
+func (conv *Conversation) Send(ctx context.Context, prompt string) (
+	res types.Response,
+	err error,
+) {
+	return conv.Stream(ctx, prompt, io.Discard)
+}
+
+// Stream is the same as Send, but writes the generated text to w as it is
+// streamed from the API.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "user",
+		Content: prompt,
+	})
+
+	var output strings.Builder
+	var usage *billedUnits
+
+	err = conv.backend.stream(ctx, "/v2/chat", conv.requestBody(), conv.extraHeaders, func(data []byte) error {
+		var event streamEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return fmt.Errorf("failed parsing stream event: %w", err)
+		}
+
+		switch event.Type {
+		case "content-delta":
+			text := event.Delta.Message.Content.Text
+			output.WriteString(text)
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+		case "message-end":
+			res.StopReason = event.Delta.FinishReason
+			if usage = event.Delta.Usage.BilledUnits; usage == nil {
+				usage = event.Meta.BilledUnits
+			}
+			if event.Delta.Error != "" {
+				return &types.APIError{Provider: "Cohere", Message: event.Delta.Error}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if output.Len() == 0 {
+		return res, types.ErrNoResults
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.APIKeyUsed = conv.backend.apiKey
+
+	if usage != nil {
+		res.InputTokens = int64(usage.InputTokens)
+		res.OutputTokens = int64(usage.OutputTokens)
+		res.TokensUsed = res.InputTokens + res.OutputTokens
+	}
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
+// Messages returns all the messages that have been exchanged between the user
+// and the assistant up to this point.
+func (conv *Conversation) Messages() []types.Message {
+	return conv.messages
+}
+
+// AddHeader adds an extra HTTP header that will be added to every HTTP
+// request issued as part of this conversation. Any headers added will be in
+// addition to any extra headers defined for the backend itself, and will
+// take precedence over them.
+func (conv *Conversation) AddHeader(key, val string) {
+	if conv.extraHeaders == nil {
+		conv.extraHeaders = make(map[string]string)
+	}
+	conv.extraHeaders[key] = val
+}
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// requestBody builds the body of a chat request, translating the
+// conversation's messages and generation parameters to their Cohere
+// equivalents. Cohere only knows the "system", "user" and "assistant" roles,
+// so messages of any other role are sent as the assistant's. Extra parameters
+// are included, unless overridden.
+func (conv *Conversation) requestBody() map[string]interface{} {
+	msgs := make([]types.Message, len(conv.messages))
+	for i, msg := range conv.messages {
+		msgs[i] = msg
+		if msg.Role != "system" && msg.Role != "user" {
+			msgs[i].Role = "assistant"
+		}
+	}
+
+	body := make(map[string]interface{})
+	for key, val := range conv.params.Extra {
+		body[key] = val
+	}
+
+	body["model"] = conv.model
+	body["messages"] = msgs
+	body["stream"] = true
+	body["temperature"] = conv.params.TemperatureOrDefault()
+
+	if conv.params.TopP != nil {
+		body["p"] = *conv.params.TopP
+	}
+
+	if conv.params.MaxTokens != nil {
+		body["max_tokens"] = *conv.params.MaxTokens
+	}
+
+	if len(conv.params.Stop) > 0 {
+		body["stop_sequences"] = conv.params.Stop
+	}
+
+	if conv.params.Format == types.FormatJSON {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	if conv.params.Seed != nil {
+		body["seed"] = *conv.params.Seed
+	}
+
+	return body
+}
@@ -0,0 +1,163 @@
+package cohere
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/sse"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/ido50/requests"
+)
+
+// DefaultAPIURL is the default URL for the Cohere API. Paths include the
+// version of the API they belong to, as chat and model listing are served by
+// different versions.
+const DefaultAPIURL = "https://api.cohere.com"
+
+// Cohere is a structure used to continuously generate IaC code via Cohere's
+// Command models
+type Cohere struct {
+	*requests.HTTPClient
+	httpClient *http.Client
+	url        string
+	apiKey     string
+	headers    map[string]string
+}
+
+// Options is a struct containing all the parameters accepted by the New
+// constructor.
+type Options struct {
+	// APIKey is the Cohere API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Cohere API URL to use. Optional, defaults to DefaultAPIURL.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// New creates a new instance of the Cohere struct, with the provided input
+// options. The Cohere API is not yet contacted at this point. An error is
+// returned if an API key is not provided.
+func New(opts *Options) (*Cohere, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: cohere backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = DefaultAPIURL
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{}
+	}
+
+	backend := &Cohere{
+		httpClient: opts.HTTPClient,
+		url:        strings.TrimSuffix(opts.URL, "/"),
+		apiKey:     opts.APIKey,
+		headers: map[string]string{
+			"Authorization": fmt.Sprintf("Bearer %s", opts.APIKey),
+		},
+	}
+
+	for header, value := range opts.ExtraHeaders {
+		backend.headers[header] = value
+	}
+
+	backend.HTTPClient = requests.NewClient(backend.url).
+		Accept("application/json").
+		Timeout(types.NoTimeout).
+		ErrorHandler(handleError).
+		CustomHTTPClient(backend.httpClient)
+
+	for header, value := range backend.headers {
+		backend.HTTPClient.Header(header, value)
+	}
+
+	return backend, nil
+}
+
+// handleError parses error responses from the Cohere API.
+func handleError(httpStatus int, _ string, body io.Reader) error {
+	var res struct {
+		Message string `json:"message"`
+	}
+
+	err := json.NewDecoder(body).Decode(&res)
+	if err != nil || res.Message == "" {
+		return &types.APIError{Provider: "Cohere", StatusCode: httpStatus}
+	}
+
+	return &types.APIError{
+		Provider:   "Cohere",
+		StatusCode: httpStatus,
+		Message:    res.Message,
+	}
+}
+
+// stream sends a POST request with the provided JSON body to the provided API
+// path, and reads the server-sent events stream returned in response. The
+// payload of every "data" line in the stream is passed to the provided
+// function. Streaming stops when the stream ends, the context is canceled, or
+// the function returns an error.
+func (backend *Cohere) stream(
+	ctx context.Context,
+	path string,
+	body interface{},
+	extraHeaders map[string]string,
+	fn func([]byte) error,
+) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed encoding request body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		backend.url+path,
+		bytes.NewReader(payload),
+	)
+	if err != nil {
+		return fmt.Errorf("failed creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+
+	for key, val := range backend.headers {
+		req.Header.Set(key, val)
+	}
+
+	for key, val := range extraHeaders {
+		req.Header.Set(key, val)
+	}
+
+	res, err := backend.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusMultipleChoices {
+		return handleError(res.StatusCode, res.Header.Get("Content-Type"), res.Body)
+	}
+
+	return sse.Read(res.Body, fn)
+}
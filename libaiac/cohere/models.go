@@ -0,0 +1,64 @@
+package cohere
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// ListModels returns a list of all the models supported by this backend that
+// can be used with the chat API.
+func (backend *Cohere) ListModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	var answer struct {
+		Models []struct {
+			Name          string `json:"name"`
+			ContextLength int    `json:"context_length"`
+		} `json:"models"`
+		NextPageToken string `json:"next_page_token"`
+	}
+
+	for {
+		req := backend.NewRequest("GET", "/v1/models").
+			QueryParam("endpoint", "chat").
+			QueryParam("page_size", "1000").
+			Into(&answer)
+		if answer.NextPageToken != "" {
+			req.QueryParam("page_token", answer.NextPageToken)
+		}
+
+		answer.NextPageToken = ""
+		answer.Models = nil
+
+		err = req.RunContext(ctx)
+		if err != nil {
+			return models, fmt.Errorf("failed listing models: %w", err)
+		}
+
+		for i := range answer.Models {
+			models = append(models, types.Model{
+				ID:            answer.Models[i].Name,
+				Owner:         "Cohere",
+				ContextWindow: answer.Models[i].ContextLength,
+			})
+		}
+
+		if answer.NextPageToken == "" {
+			break
+		}
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
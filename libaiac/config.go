@@ -1,11 +1,18 @@
 package libaiac
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/BurntSushi/toml"
-	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
 )
 
 // BackendType is a const type used for identifying backends, a.k.a LLM providers.
@@ -15,11 +22,62 @@ const (
 	// BackendOpenAI represents the OpenAI LLM provider.
 	BackendOpenAI BackendType = "openai"
 
+	// BackendAzureOpenAI represents the Azure OpenAI LLM provider.
+	BackendAzureOpenAI BackendType = "azure_openai"
+
 	// BackendBedrock represents the Amazon Bedrock LLM provider.
 	BackendBedrock BackendType = "bedrock"
 
 	// BackendOllama represents the Ollama LLM provider.
 	BackendOllama BackendType = "ollama"
+
+	// BackendAnthropic represents the Anthropic LLM provider.
+	BackendAnthropic BackendType = "anthropic"
+
+	// BackendMistral represents Mistral's La Plateforme LLM provider.
+	BackendMistral BackendType = "mistral"
+
+	// BackendOpenRouter represents the OpenRouter LLM router, which provides
+	// access to models of many providers.
+	BackendOpenRouter BackendType = "openrouter"
+
+	// BackendGroq represents the Groq LLM provider.
+	BackendGroq BackendType = "groq"
+
+	// BackendDeepSeek represents the DeepSeek LLM provider.
+	BackendDeepSeek BackendType = "deepseek"
+
+	// BackendXAI represents the xAI LLM provider, which serves the Grok
+	// models.
+	BackendXAI BackendType = "xai"
+
+	// BackendPerplexity represents the Perplexity LLM provider, whose models
+	// search the web to ground their responses.
+	BackendPerplexity BackendType = "perplexity"
+
+	// BackendTogether represents the Together AI LLM provider, which serves
+	// open models such as Llama, Qwen and DeepSeek.
+	BackendTogether BackendType = "together"
+
+	// BackendFireworks represents the Fireworks AI LLM provider, whose
+	// serverless deployments are addressed by IDs under
+	// "accounts/fireworks/models/".
+	BackendFireworks BackendType = "fireworks"
+
+	// BackendCohere represents the Cohere LLM provider.
+	BackendCohere BackendType = "cohere"
+
+	// BackendOpenAICompatible represents any server implementing the OpenAI
+	// chat completions API, such as LocalAI, vLLM or LM Studio.
+	BackendOpenAICompatible BackendType = "openai_compatible"
+
+	// BackendGemini represents the Google Gemini LLM provider, either via the
+	// Generative Language API or via Vertex AI.
+	BackendGemini BackendType = "gemini"
+
+	// BackendHuggingFace represents the Hugging Face Inference API, or a
+	// dedicated Hugging Face Inference Endpoint.
+	BackendHuggingFace BackendType = "huggingface"
 )
 
 // Config holds the configuration for aiac.
@@ -31,6 +89,158 @@ type Config struct {
 	// DefaultBackend is the name of the default backend to use when one is
 	// not specifically selected.
 	DefaultBackend string `toml:"default_backend"`
+
+	// Fallback is an ordered list of backend names to try when the selected
+	// backend fails due to a transient error, such as rate limiting, a server
+	// error or a timeout. Backends in the list are tried in order, skipping
+	// the selected backend itself, until one succeeds.
+	Fallback []string `toml:"fallback"`
+
+	// Cache configures the on-disk response cache.
+	Cache CacheConfig `toml:"cache"`
+
+	// History determines whether prompts are recorded in the on-disk
+	// history (see History).
+	History bool `toml:"history"`
+
+	// Pricing allows setting or overriding the prices of models, by backend
+	// type and model name, for the purpose of estimating the cost of
+	// responses (see DefaultPricing).
+	Pricing map[BackendType]map[string]Price `toml:"pricing"`
+
+	// ContextWindows allows setting or overriding the context windows of
+	// models, in tokens, by backend type and model name, for the purpose of
+	// refusing prompts that do not fit in them (see DefaultContextWindows).
+	ContextWindows map[BackendType]map[string]int `toml:"context_windows"`
+
+	// Templates are named prompt templates (see Aiac.RenderTemplate).
+	Templates map[string]Template `toml:"templates"`
+
+	// Presets are named instructions appended to prompts, by name, adding to
+	// or overriding BuiltinPresets (see Aiac.ApplyPresets).
+	Presets map[string]string `toml:"presets"`
+
+	// Prompts override the prompts requesting code of a kind, by the name
+	// of the kind or any of its keywords (see PromptConfig and
+	// Aiac.BuildPrompt).
+	Prompts map[string]PromptConfig `toml:"prompts"`
+
+	// Guardrails configures the checks generated code is scanned with for
+	// insecure patterns and secrets (see Guardrails).
+	Guardrails GuardrailsConfig `toml:"guardrails"`
+
+	// Budget configures the limits on the estimated cost of requests (see
+	// BudgetConfig).
+	Budget BudgetConfig `toml:"budget"`
+
+	// HTTP configures the connections shared by the backends (see
+	// HTTPConfig).
+	HTTP HTTPConfig `toml:"http"`
+
+	// Output configures the files generated code is saved to (see
+	// OutputConfig).
+	Output OutputConfig `toml:"output"`
+
+	// Profiles are named sets of overrides of the default backend and of
+	// the parameters of every backend, such as for work and personal use, of
+	// which one can be selected with the EnvProfile environment variable
+	// (see Config.WithProfile).
+	Profiles map[string]ProfileConfig `toml:"profiles"`
+
+	// Profile is the name of the profile applied to the configuration, if
+	// any.
+	Profile string `toml:"-"`
+
+	// Warnings are non-fatal problems found while loading configuration
+	// files, such as keys that are not known settings (often misspelled
+	// ones, which would otherwise be silently ignored).
+	Warnings []string `toml:"-"`
+}
+
+// CacheConfig holds configuration for the on-disk response cache.
+type CacheConfig struct {
+	// Enabled determines whether responses are cached. The cache can also be
+	// enabled from the command line.
+	Enabled bool `toml:"enabled"`
+
+	// TTL is the amount of time cached responses are valid for. Defaults to
+	// DefaultCacheTTL.
+	TTL time.Duration `toml:"ttl"`
+
+	// Dir is the directory in which responses are stored. Defaults to "aiac"
+	// under the XDG cache directory.
+	Dir string `toml:"dir"`
+}
+
+// HTTPConfig holds the settings of the pool of connections shared by all
+// backends loaded from the configuration (see transport.Pool). Backends that
+// connect the same way, with the same proxy and TLS settings, reuse each
+// other's idle connections. Zero values are replaced by the defaults of the
+// transport package (see transport.PoolOptions), which suit most uses, so
+// only large batches or unusual networks should need to change them.
+type HTTPConfig struct {
+	// MaxIdleConns is the maximum number of idle connections kept open
+	// across all hosts.
+	MaxIdleConns int `toml:"max_idle_conns"`
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept
+	// open to each host.
+	MaxIdleConnsPerHost int `toml:"max_idle_conns_per_host"`
+
+	// IdleConnTimeout is the amount of time idle connections are kept open
+	// for. In the configuration file, this is a duration string such as
+	// "90s".
+	IdleConnTimeout time.Duration `toml:"idle_conn_timeout"`
+
+	// HTTP1Only forces HTTP/1.1, for proxies that mishandle HTTP/2. HTTP/2
+	// is otherwise used with providers that support it.
+	HTTP1Only bool `toml:"http1_only"`
+}
+
+// validate verifies that the settings are not negative.
+func (httpConf HTTPConfig) validate() (errs []error) {
+	if httpConf.MaxIdleConns < 0 {
+		errs = append(errs, fmt.Errorf("http.max_idle_conns must not be negative, got %d", httpConf.MaxIdleConns))
+	}
+
+	if httpConf.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, fmt.Errorf(
+			"http.max_idle_conns_per_host must not be negative, got %d",
+			httpConf.MaxIdleConnsPerHost,
+		))
+	}
+
+	if httpConf.IdleConnTimeout < 0 {
+		errs = append(errs, fmt.Errorf(
+			"http.idle_conn_timeout must not be negative, got %s",
+			httpConf.IdleConnTimeout,
+		))
+	}
+
+	return errs
+}
+
+// poolOptions returns the options of the pool of connections shared by the
+// backends.
+func (httpConf HTTPConfig) poolOptions() transport.PoolOptions {
+	return transport.PoolOptions{
+		MaxIdleConns:        httpConf.MaxIdleConns,
+		MaxIdleConnsPerHost: httpConf.MaxIdleConnsPerHost,
+		IdleConnTimeout:     httpConf.IdleConnTimeout,
+		HTTP1Only:           httpConf.HTTP1Only,
+	}
+}
+
+// ProfileConfig holds the overrides of a configuration profile (see
+// Config.Profiles).
+type ProfileConfig struct {
+	// DefaultBackend is the name of the default backend to use when the
+	// profile is applied, overriding Config.DefaultBackend.
+	DefaultBackend string `toml:"default_backend"`
+
+	// Parameters are the default generation parameters when the profile is
+	// applied, overriding those of every backend, parameter by parameter.
+	Parameters types.Parameters `toml:"parameters"`
 }
 
 // BackendConfig holds backend-specific configuration.
@@ -39,93 +249,1085 @@ type BackendConfig struct {
 	Type BackendType `toml:"type"`
 
 	// AWSProfile is used by Amazon Bedrock. It is the name of the AWS profile
-	// in the credentials file to use.
+	// in the credentials file to use. When not set, credentials are found via
+	// the AWS SDK's default credential chain (environment variables, the
+	// AWS_PROFILE or default profile, web identity tokens such as IRSA, and
+	// container or instance roles).
 	AWSProfile string `toml:"aws_profile"`
 
 	// AWSRegion is used by Amazon Bedrock. It is the name of the region where
-	// the models to use are hosted.
+	// the models to use are hosted. When not set, the AWS_REGION and
+	// AWS_DEFAULT_REGION environment variables and the profile's region are
+	// used, falling back to bedrock.DefaultAWSRegion.
 	AWSRegion string `toml:"aws_region"`
 
+	// AWSRoleARN is used by Amazon Bedrock. It is the ARN of an IAM role to
+	// assume via STS, using the credentials found as described above.
+	AWSRoleARN string `toml:"aws_role_arn"`
+
+	// AWSSessionName is used by Amazon Bedrock. It is the session name to use
+	// when assuming AWSRoleARN. Defaults to bedrock.DefaultAWSSessionName.
+	AWSSessionName string `toml:"aws_session_name"`
+
+	// KeepAlive is used by Ollama. It is how long models stay loaded in the
+	// server's memory after a request, as a duration string such as "30m", or
+	// a number of seconds. Negative values keep models loaded indefinitely.
+	KeepAlive string `toml:"keep_alive"`
+
+	// GCPProject is used by Gemini. It is the name of the Google Cloud project
+	// to use with Vertex AI. When not set, Gemini backends use the public
+	// Generative Language API with an API key instead.
+	GCPProject string `toml:"gcp_project"`
+
+	// GCPLocation is used by Gemini. It is the Google Cloud location where
+	// the Vertex AI models to use are hosted.
+	GCPLocation string `toml:"gcp_location"`
+
 	// APIKey is an API key used for authentication. It is used by backends such
-	// as OpenAI.
+	// as OpenAI, Anthropic and Gemini.
 	APIKey string `toml:"api_key"`
 
-	// APIVersion allows setting a specific API version to use. It is accepted
-	// by the OpenAI backend.
+	// AuthHeader is used by the OpenAI and OpenAI-compatible backends. It is
+	// the header in which the API key is sent, defaulting to "Authorization".
+	// With "Authorization" or "Proxy-Authorization", the key is sent as a
+	// bearer token, otherwise it is sent as-is.
+	AuthHeader string `toml:"auth_header"`
+
+	// APIVersion allows setting a specific API version to use. It is accepted by
+	// the OpenAI backend, required by the Azure OpenAI backend, and used as the
+	// anthropic-version header by the Anthropic backend.
 	APIVersion string `toml:"api_version"`
 
 	// URL allows setting a custom URL for a backend's API. It is accepted by
-	// backends such as OpenAI and Ollama.
+	// backends such as OpenAI, Anthropic, Gemini and Ollama. Ollama and
+	// OpenAI-compatible backends also accept the URL of a Unix domain socket
+	// their server listens on, such as "unix:///var/run/ollama.sock". The
+	// path of the API on the server defaults to "/api" for Ollama and "/v1"
+	// for OpenAI-compatible backends, and may be set with the "path" query
+	// parameter (e.g. "unix:///run/llm.sock?path=/openai/v1").
 	URL string `toml:"url"`
 
 	// DefaultModel is the name of the model to use by default when a specific
-	// one is not selected.
+	// one is not selected. With Azure OpenAI, this is the name of a deployment.
 	DefaultModel string `toml:"default_model"`
 
+	// ModelByKind maps kinds of code to the models used to generate them
+	// when a specific model is not selected, taking precedence over
+	// DefaultModel, e.g. to generate Terraform with a stronger model than
+	// Dockerfiles. Kinds are referenced by name (e.g. "terraform",
+	// "dockerfile" or "k8s") or by any keyword that requests them in
+	// prompts (e.g. "kubernetes"), and are detected from prompts (see
+	// DetectKind).
+	ModelByKind map[string]string `toml:"model_by_kind"`
+
+	// SystemPrompt is a system prompt used for conversations with the backend
+	// that do not already include one, e.g. to instruct models to follow a
+	// certain coding style.
+	SystemPrompt string `toml:"system_prompt"`
+
+	// Parameters are the default generation parameters for conversations with
+	// the backend, e.g. "[backends.openai.parameters]" with "temperature" and
+	// "max_tokens" keys. Parameters set for a conversation (e.g. via CLI
+	// flags) take precedence. Unknown keys are passed through to the provider
+	// (see types.Parameters.Extra).
+	Parameters types.Parameters `toml:"parameters"`
+
 	// ExtraHeaders allows setting extra HTTP headers whenever aiac sends
 	// requests to the backend. Bedrock backends do not support this setting.
 	ExtraHeaders map[string]string `toml:"extra_headers"`
+
+	// QueryParams allows adding query parameters to the URL of every request
+	// aiac sends to the backend, such as those required by some gateways
+	// (e.g. "api-version"). They replace parameters of the same name already
+	// in the URL. Values may reference environment variables. Bedrock
+	// backends do not support this setting.
+	QueryParams map[string]string `toml:"query_params"`
+
+	// ExtraBody allows setting extra fields in the body of every chat request
+	// sent to the backend, for provider-specific options that aiac does not
+	// support directly (e.g. Mistral's "safe_prompt"). It is accepted by
+	// backends of types that implement the OpenAI API, such as OpenAI,
+	// Mistral, OpenRouter, Groq, DeepSeek, xAI, Perplexity, Together AI,
+	// Fireworks AI and OpenAI-compatible servers.
+	ExtraBody map[string]interface{} `toml:"extra_body"`
+
+	// Timeout is the maximum amount of time a single request to the backend
+	// may take, including reading streamed responses. In the configuration
+	// file, this is a duration string such as "30s" or "2m". If not set,
+	// DefaultTimeout is used. A value of "0" means no timeout.
+	Timeout *time.Duration `toml:"timeout"`
+
+	// StreamIdleTimeout is the maximum amount of time a streamed response
+	// from the backend may go without receiving any data before it is
+	// aborted with an error wrapping transport.ErrStreamIdle (see
+	// Aiac.ResumeStreams to request it again). In the configuration file,
+	// this is a duration string such as "90s". If not set,
+	// DefaultStreamIdleTimeout is used. A value of "0" disables the watchdog.
+	StreamIdleTimeout *time.Duration `toml:"stream_idle_timeout"`
+
+	// MaxRetries is the maximum number of times a request that failed due to
+	// a transient error (a network error, or a status of 429, 500, 502, 503,
+	// 504 or 529) is retried. Defaults to zero, meaning no retries.
+	MaxRetries int `toml:"max_retries"`
+
+	// RetryBackoff is the base delay for exponential backoff between retries,
+	// as a duration string such as "500ms". Defaults to one second. Retry-After
+	// headers returned by the backend take precedence.
+	RetryBackoff time.Duration `toml:"retry_backoff"`
+
+	// RateLimit paces requests to the backend to stay under the provider's
+	// quotas, e.g. "rate_limit = { requests_per_minute = 50 }". Requests are
+	// not limited by default.
+	RateLimit RateLimitConfig `toml:"rate_limit"`
+
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy to send the
+	// backend's requests through, e.g. "http://proxy.corp:8080" or
+	// "socks5://proxy.corp:1080". If not set, the standard HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables are honored.
+	Proxy string `toml:"proxy"`
+
+	// CACertFile is the path to a file containing PEM-encoded CA certificates
+	// to trust when connecting to the backend, in addition to the system's
+	// certificate pool. This is useful for endpoints that use certificates
+	// signed by a private CA.
+	CACertFile string `toml:"ca_cert_file"`
+
+	// InsecureSkipVerify disables verification of the backend's TLS
+	// certificate. This is insecure, and a warning is included with every
+	// response when enabled.
+	InsecureSkipVerify bool `toml:"insecure_skip_verify"`
+}
+
+// RateLimitConfig holds the client-side rate limits of a backend. Limits apply
+// to all requests aiac sends to the backend, including retries, within a
+// single process.
+type RateLimitConfig struct {
+	// RequestsPerMinute is the maximum number of requests per minute.
+	RequestsPerMinute int `toml:"requests_per_minute"`
+
+	// TokensPerMinute is the maximum number of prompt tokens per minute,
+	// estimated from the size of requests.
+	TokensPerMinute int `toml:"tokens_per_minute"`
+}
+
+// DefaultModels holds the models used by backends of each type that do not
+// configure a default model (see BackendConfig.ResolveModel). Backend types
+// whose models depend on the account or server, such as Azure OpenAI
+// deployments and Ollama, have no default model.
+var DefaultModels = map[BackendType]string{
+	BackendOpenAI:      "gpt-4o",
+	BackendAnthropic:   "claude-3-5-sonnet-latest",
+	BackendGemini:      "gemini-1.5-pro",
+	BackendMistral:     "mistral-large-latest",
+	BackendGroq:        "llama-3.3-70b-versatile",
+	BackendDeepSeek:    "deepseek-chat",
+	BackendXAI:         "grok-2",
+	BackendPerplexity:  "llama-3.1-sonar-large-128k-online",
+	BackendTogether:    "meta-llama/Llama-3.3-70B-Instruct-Turbo",
+	BackendFireworks:   "accounts/fireworks/models/llama-v3p3-70b-instruct",
+	BackendCohere:      "command-r-plus",
+	BackendOpenRouter:  "openai/gpt-4o",
+	BackendHuggingFace: "meta-llama/Meta-Llama-3-8B-Instruct",
+}
+
+// StopSequenceLimits holds the maximum number of stop sequences accepted by
+// the providers of each backend type (see types.Parameters.Stop). Only the
+// first stop sequences are sent to providers with a limit, and a warning is
+// added to responses. Backend types not listed have no limit, or one that
+// depends on the model.
+var StopSequenceLimits = map[BackendType]int{
+	BackendOpenAI:      4,
+	BackendAzureOpenAI: 4,
+	BackendGroq:        4,
+	BackendHuggingFace: 4,
+	BackendDeepSeek:    16,
+	BackendGemini:      5,
+	BackendCohere:      5,
+}
+
+// NativeJSON holds the backend types whose providers can constrain responses
+// to valid JSON (see types.Parameters.Format). Prompts sent to other backend
+// types only ask for JSON, and their responses are validated the same way.
+var NativeJSON = map[BackendType]bool{
+	BackendOpenAI:      true,
+	BackendAzureOpenAI: true,
+	BackendMistral:     true,
+	BackendGroq:        true,
+	BackendDeepSeek:    true,
+	BackendXAI:         true,
+	BackendTogether:    true,
+	BackendFireworks:   true,
+	BackendOpenRouter:  true,
+	BackendCohere:      true,
+	BackendGemini:      true,
+	BackendOllama:      true,
+}
+
+// nativeJSON returns whether the backend's provider can constrain responses
+// to valid JSON (see NativeJSON).
+func (backendConf BackendConfig) nativeJSON() bool {
+	if backendConf.Type == "" {
+		return NativeJSON[BackendOpenAI]
+	}
+
+	return NativeJSON[backendConf.Type]
+}
+
+// NativeSchema holds the backend types whose providers can constrain
+// responses to JSON conforming to a JSON Schema (see
+// types.Parameters.Schema). Prompts sent to other backend types include the
+// schema instead, and their responses are validated the same way.
+var NativeSchema = map[BackendType]bool{
+	BackendOllama: true,
+}
+
+// nativeSchema returns whether the backend's provider can constrain responses
+// to a JSON Schema (see NativeSchema).
+func (backendConf BackendConfig) nativeSchema() bool {
+	return NativeSchema[backendConf.Type]
+}
+
+// SeedSupport holds the backend types whose providers accept a seed for
+// reproducible generations (see types.Parameters.Seed). Seeds are not sent to
+// other backend types, and a warning is added to their responses.
+var SeedSupport = map[BackendType]bool{
+	BackendOpenAI:           true,
+	BackendAzureOpenAI:      true,
+	BackendMistral:          true,
+	BackendGroq:             true,
+	BackendXAI:              true,
+	BackendTogether:         true,
+	BackendFireworks:        true,
+	BackendOpenRouter:       true,
+	BackendHuggingFace:      true,
+	BackendOpenAICompatible: true,
+	BackendCohere:           true,
+	BackendGemini:           true,
+	BackendOllama:           true,
+}
+
+// seedSupport returns whether the backend's provider accepts a seed (see
+// SeedSupport).
+func (backendConf BackendConfig) seedSupport() bool {
+	if backendConf.Type == "" {
+		return SeedSupport[BackendOpenAI]
+	}
+
+	return SeedSupport[backendConf.Type]
+}
+
+// ReasoningModels holds the backend types whose providers accept a reasoning
+// effort (see types.Parameters.ReasoningEffort), with the prefixes of the
+// names of the models that support it. Types with no prefixes accept it for
+// every model, as the provider (or server) determines which models support
+// it, e.g. because models are addressed by deployment names. Reasoning
+// efforts are not sent to other models, with a debug message logged.
+var ReasoningModels = map[BackendType][]string{
+	BackendOpenAI:           {"o1", "o3", "o4", "gpt-5"},
+	BackendXAI:              {"grok-3-mini"},
+	BackendGroq:             {"openai/gpt-oss", "qwen/qwen3"},
+	BackendAnthropic:        {"claude-3-7-sonnet", "claude-sonnet-4", "claude-opus-4"},
+	BackendGemini:           {"gemini-2.5"},
+	BackendAzureOpenAI:      nil,
+	BackendOpenRouter:       nil,
+	BackendOpenAICompatible: nil,
+	BackendOllama:           {"gpt-oss", "qwen3", "deepseek-r1", "magistral"},
+}
+
+// reasoningSupport returns whether the backend's provider accepts a reasoning
+// effort for the provided model (see ReasoningModels).
+func (backendConf BackendConfig) reasoningSupport(model string) bool {
+	backendType := backendConf.Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	prefixes, ok := ReasoningModels[backendType]
+	if !ok {
+		return false
+	}
+
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	model = strings.ToLower(model)
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(model, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// stopLimit returns the maximum number of stop sequences accepted by the
+// backend, or zero if there is none (see StopSequenceLimits).
+func (backendConf BackendConfig) stopLimit() int {
+	if backendConf.Type == "" {
+		return StopSequenceLimits[BackendOpenAI]
+	}
+
+	return StopSequenceLimits[backendConf.Type]
+}
+
+// ResolveModel returns the model to use with the backend. In order of
+// precedence, this is the provided model, the backend's default model, and the
+// default model of the backend's type (see DefaultModels). Returns an empty
+// string if none are set. Use ResolveModelForKind to also consider the
+// backend's models by kind of code.
+func (backendConf BackendConfig) ResolveModel(model string) string {
+	switch {
+	case model != "":
+		return model
+	case backendConf.DefaultModel != "":
+		return backendConf.DefaultModel
+	default:
+		return DefaultModels[backendConf.Type]
+	}
+}
+
+// ResolveModelForKind is the same as ResolveModel, but if model is empty and
+// the backend has a model for the provided kind of code (see ModelByKind and
+// DetectKind), that model is returned instead of the default model.
+func (backendConf BackendConfig) ResolveModelForKind(model, kind string) string {
+	if model == "" && kind != "" {
+		for _, alias := range kindAliases(kind) {
+			if kindModel := backendConf.ModelByKind[alias]; kindModel != "" {
+				return kindModel
+			}
+		}
+	}
+
+	return backendConf.ResolveModel(model)
+}
+
+// DefaultTimeout is the request timeout used for backends that do not
+// configure one.
+const DefaultTimeout = 120 * time.Second
+
+// timeout returns the request timeout for the backend.
+func (backendConf BackendConfig) timeout() time.Duration {
+	if backendConf.Timeout == nil {
+		return DefaultTimeout
+	}
+
+	return *backendConf.Timeout
+}
+
+// DefaultStreamIdleTimeout is the stream idle timeout used for backends that
+// do not configure one. It is generous, as reasoning models may think for a
+// while before streaming anything, without the provider sending keep-alives.
+const DefaultStreamIdleTimeout = 90 * time.Second
+
+// streamIdleTimeout returns the stream idle timeout for the backend.
+func (backendConf BackendConfig) streamIdleTimeout() time.Duration {
+	if backendConf.StreamIdleTimeout == nil {
+		return DefaultStreamIdleTimeout
+	}
+
+	return *backendConf.StreamIdleTimeout
+}
+
+// unixSocketPaths are the default paths of the APIs of the backend types
+// that can be served over a Unix domain socket (see BackendConfig.URL).
+var unixSocketPaths = map[BackendType]string{
+	BackendOllama:           "/api",
+	BackendOpenAICompatible: "/v1",
+}
+
+// isUnixSocketURL returns whether the URL of the backend is the URL of a
+// Unix domain socket.
+func (backendConf BackendConfig) isUnixSocketURL() bool {
+	return strings.HasPrefix(backendConf.URL, "unix:")
+}
+
+// unixSocket returns the path of the Unix domain socket the backend is
+// served over, and the URL of its API to send requests to through the socket.
+// Both are empty if the backend's URL is not the URL of a socket.
+func (backendConf BackendConfig) unixSocket() (socket, apiURL string) {
+	if !backendConf.isUnixSocketURL() {
+		return "", ""
+	}
+
+	// Malformed URLs are reported by validate
+	socketURL, err := url.Parse(backendConf.URL)
+	if err != nil || socketURL.Path == "" {
+		return "", ""
+	}
+
+	path := socketURL.Query().Get("path")
+	if path == "" {
+		path = unixSocketPaths[backendConf.Type]
+	}
+
+	// The host is irrelevant, as connections are always made to the socket
+	return socketURL.Path, "http://localhost/" + strings.TrimPrefix(path, "/")
+}
+
+// transportOptions returns the options for the HTTP client used by the
+// backend.
+func (backendConf BackendConfig) transportOptions() transport.Options {
+	socket, _ := backendConf.unixSocket()
+
+	return transport.Options{
+		Retry: transport.RetryOptions{
+			MaxRetries: backendConf.MaxRetries,
+			Backoff:    backendConf.RetryBackoff,
+		},
+		RateLimit: transport.RateLimitOptions{
+			RequestsPerMinute: backendConf.RateLimit.RequestsPerMinute,
+			TokensPerMinute:   backendConf.RateLimit.TokensPerMinute,
+		},
+		Proxy:              backendConf.Proxy,
+		CACertFile:         backendConf.CACertFile,
+		UnixSocket:         socket,
+		InsecureSkipVerify: backendConf.InsecureSkipVerify,
+		StreamIdleTimeout:  backendConf.streamIdleTimeout(),
+		QueryParams:        backendConf.QueryParams,
+		Secrets:            []string{backendConf.APIKey},
+	}
+}
+
+// withSystemPrompt adds the backend's system prompt to the provided messages,
+// unless they already include one.
+func (backendConf BackendConfig) withSystemPrompt(msgs []types.Message) []types.Message {
+	if backendConf.SystemPrompt == "" {
+		return msgs
+	}
+
+	if system, _ := types.SplitSystem(msgs); system != "" {
+		return msgs
+	}
+
+	return types.WithSystem(msgs, backendConf.SystemPrompt)
+}
+
+// EnvConfig is the environment variable holding the path of the
+// configuration file to load when a path is not explicitly provided, in place
+// of the default paths (see DefaultConfigPaths).
+const EnvConfig = "AIAC_CONFIG"
+
+// EnvProfile is the environment variable holding the name of the profile
+// applied to configurations when they are loaded (see Config.Profiles).
+const EnvProfile = "AIAC_PROFILE"
+
+// EnvNoConfig is the environment variable that, when set to a true value
+// (e.g. "1" or "true"), disables loading configuration files, so that the
+// configuration only comes from environment variables (see NoConfig).
+const EnvNoConfig = "AIAC_NO_CONFIG"
+
+// NoConfig returns whether loading configuration files is disabled by the
+// EnvNoConfig environment variable. Values that are not booleans (see
+// strconv.ParseBool) do not disable it.
+func NoConfig() bool {
+	disabled, err := strconv.ParseBool(os.Getenv(EnvNoConfig))
+	return err == nil && disabled
+}
+
+// ConfigOptions are options of loading configurations, for callers that
+// select them explicitly rather than through environment variables (e.g.
+// from command line flags). Options that are not set are taken from the
+// environment, so that the zero value loads configurations like LoadConfig.
+type ConfigOptions struct {
+	// Profile is the name of the profile to apply to the loaded
+	// configuration (see Config.WithProfile), in place of the one named by
+	// the EnvProfile environment variable.
+	Profile string
+
+	// NoConfig disables loading configuration files, like the EnvNoConfig
+	// environment variable (see NoConfig).
+	NoConfig bool
+}
+
+// NoFiles returns whether loading configuration files is disabled, by the
+// options or by the EnvNoConfig environment variable.
+func (opts ConfigOptions) NoFiles() bool {
+	return opts.NoConfig || NoConfig()
+}
+
+// profile returns the name of the profile to apply, from the options or else
+// from the EnvProfile environment variable.
+func (opts ConfigOptions) profile() string {
+	if opts.Profile != "" {
+		return opts.Profile
+	}
+
+	return os.Getenv(EnvProfile)
 }
 
 // LoadConfig loads an aiac configuration file from the provided path, which
-// must be a TOML file. If path is an empty string, the default path will be
-// checked based on the XDG specification. On Unix-like operating systems, this
-// will be ~/.config/aiac/aiac.toml.
+// must be a TOML file. If path is an empty string, the file at the path held
+// by the EnvConfig environment variable is loaded, if it is set, and must
+// exist. Otherwise, the default paths will be checked and merged (see
+// DefaultConfigPaths and LoadConfigs). On Unix-like operating systems, this
+// will be /etc/xdg/aiac/aiac.toml, ~/.config/aiac/aiac.toml and ./aiac.toml.
+// If none of them exist, the configuration is synthesized from environment
+// variables (see ConfigFromEnv). The profile named by the EnvProfile
+// environment variable, if set, is applied to the loaded configuration (see
+// Config.WithProfile).
+//
+// If loading configuration files is disabled (see NoConfig), no file is
+// loaded, not even the one at path, and the configuration is synthesized from
+// environment variables. If none are set, an empty configuration is returned,
+// which is valid, but has no backends.
 func LoadConfig(path string) (conf Config, err error) {
-	if path == "" {
-		path, err = xdg.ConfigFile("aiac/aiac.toml")
+	return ConfigOptions{}.LoadConfig(path)
+}
+
+// LoadConfig is the same as the LoadConfig function, applying the options.
+func (opts ConfigOptions) LoadConfig(path string) (conf Config, err error) {
+	if opts.NoFiles() {
+		conf, err = ConfigFromEnv()
+		if errors.Is(err, fs.ErrNotExist) {
+			conf, err = Config{}, nil
+		}
 		if err != nil {
-			return conf, fmt.Errorf("failed getting default config path: %w", err)
+			return conf, err
 		}
+
+		return conf.WithProfile(opts.profile())
 	}
 
-	_, err = toml.DecodeFile(path, &conf)
-	if err != nil {
-		return conf, fmt.Errorf("failed loading configuration: %w", err)
+	if path != "" {
+		return opts.LoadConfigs(path)
 	}
 
-	// If any of the config values are env vars, replace them
-	conf = replaceEnvVars(conf)
+	if path := os.Getenv(EnvConfig); path != "" {
+		if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+			return conf, fmt.Errorf(
+				"failed loading configuration: %s (set by %s) does not exist: %w",
+				path, EnvConfig, fs.ErrNotExist,
+			)
+		}
+
+		return opts.LoadConfigs(path)
+	}
+
+	paths := opts.DefaultConfigPaths()
+	if len(paths) == 0 {
+		conf, err = ConfigFromEnv()
+		if errors.Is(err, fs.ErrNotExist) {
+			return conf, fmt.Errorf(
+				"failed loading configuration: no configuration file found in %s, "+
+					"and no configuration environment variables (such as %s) are set: %w",
+				UserConfigPath(), EnvBackendType, fs.ErrNotExist,
+			)
+		}
+		if err != nil {
+			return conf, err
+		}
+
+		return conf.WithProfile(opts.profile())
+	}
+
+	return opts.LoadConfigs(paths...)
+}
+
+// Validate verifies the configuration is coherent: every backend must be of
+// a known type and include the settings required by that type, and the
+// default backend and fallback backends, if set, must exist, as must the
+// default backends of profiles. Guardrails must only reference built-in
+// checks that exist, and rules with valid patterns, neither budgets nor HTTP
+// connection settings may be negative, and the header template and prompts
+// of kinds of code must render. All problems found are returned together as a
+// single error (see errors.Join). Settings that have defaults (e.g. the AWS
+// region for Bedrock, or the URL for Ollama) are not required.
+func (conf Config) Validate() error {
+	var errs []error
+
+	if conf.DefaultBackend != "" {
+		if _, ok := conf.Backends[conf.DefaultBackend]; !ok {
+			errs = append(errs, fmt.Errorf(
+				"default_backend %q: %w",
+				conf.DefaultBackend, types.ErrNoSuchBackend,
+			))
+		}
+	}
+
+	for _, name := range conf.Fallback {
+		if _, ok := conf.Backends[name]; !ok {
+			errs = append(errs, fmt.Errorf(
+				"fallback %q: %w",
+				name, types.ErrNoSuchBackend,
+			))
+		}
+	}
+
+	// Iterate over backends in a stable order so errors are reported
+	// consistently
+	names := make([]string, 0, len(conf.Backends))
+	for name := range conf.Backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		errs = append(errs, conf.Backends[name].validate(name)...)
+	}
+
+	profiles := make([]string, 0, len(conf.Profiles))
+	for name := range conf.Profiles {
+		profiles = append(profiles, name)
+	}
+
+	sort.Strings(profiles)
+
+	for _, name := range profiles {
+		backend := conf.Profiles[name].DefaultBackend
+		if backend == "" {
+			continue
+		}
+
+		if _, ok := conf.Backends[backend]; !ok {
+			errs = append(errs, fmt.Errorf(
+				"profile %q: default_backend %q: %w",
+				name, backend, types.ErrNoSuchBackend,
+			))
+		}
+	}
+
+	// Guardrails are validated even if disabled, as they may be enabled from
+	// the command line
+	if _, err := NewGuardrails(conf.Guardrails); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, conf.Budget.validate()...)
+	errs = append(errs, conf.HTTP.validate()...)
+	errs = append(errs, conf.Output.validate()...)
+
+	kinds := make([]string, 0, len(conf.Prompts))
+	for kind := range conf.Prompts {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		errs = append(errs, conf.Prompts[kind].validate(kind)...)
+	}
+
+	return errors.Join(errs...)
+}
+
+// WithProfile returns a copy of the configuration with the overrides of the
+// named profile applied: the profile's default backend, if set, replaces the
+// configuration's, and its parameters override those of every backend. If
+// name is an empty string, the configuration is returned unchanged. An error
+// wrapping types.ErrNoSuchProfile is returned if the profile does not exist.
+func (conf Config) WithProfile(name string) (Config, error) {
+	if name == "" {
+		return conf, nil
+	}
+
+	profile, ok := conf.Profiles[name]
+	if !ok {
+		return conf, fmt.Errorf("profile %q: %w", name, types.ErrNoSuchProfile)
+	}
+
+	if profile.DefaultBackend != "" {
+		conf.DefaultBackend = profile.DefaultBackend
+	}
+
+	// The map of backends is copied, so that the original configuration is
+	// not modified
+	backends := make(map[string]BackendConfig, len(conf.Backends))
+	for backendName, backendConf := range conf.Backends {
+		backendConf.Parameters = backendConf.Parameters.Override(profile.Parameters)
+		backends[backendName] = backendConf
+	}
+
+	conf.Backends = backends
+	conf.Profile = name
 
 	return conf, nil
 }
 
+// validate verifies a single backend configuration, returning all problems
+// found.
+func (backendConf BackendConfig) validate(name string) (errs []error) {
+	missing := func(field string) {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: %s is required for backends of type %q",
+			types.ErrInvalidBackendConfig, name, field, backendConf.Type,
+		))
+	}
+
+	switch backendConf.Type {
+	case BackendOpenAI, "":
+		// The api_key is only required when using the official OpenAI API,
+		// as other OpenAI-compatible servers may not require authentication
+		if backendConf.URL == "" && backendConf.APIKey == "" {
+			missing("api_key")
+		}
+	case BackendOpenAICompatible:
+		if backendConf.URL == "" {
+			missing("url")
+		}
+	case BackendHuggingFace:
+		// Dedicated Inference Endpoints may be public
+		if backendConf.URL == "" && backendConf.APIKey == "" {
+			missing("api_key")
+		}
+	case BackendAzureOpenAI:
+		if backendConf.URL == "" {
+			missing("url")
+		}
+		if backendConf.APIVersion == "" {
+			missing("api_version")
+		}
+	case BackendAnthropic, BackendMistral, BackendOpenRouter, BackendGroq,
+		BackendDeepSeek, BackendXAI, BackendPerplexity, BackendTogether,
+		BackendFireworks, BackendCohere:
+		if backendConf.APIKey == "" {
+			missing("api_key")
+		}
+	case BackendGemini:
+		if backendConf.APIKey == "" && backendConf.GCPProject == "" {
+			missing("api_key or gcp_project")
+		} else if backendConf.APIKey != "" && backendConf.GCPProject != "" {
+			errs = append(errs, fmt.Errorf(
+				"%w: backend %s: api_key and gcp_project are mutually exclusive",
+				types.ErrInvalidBackendConfig, name,
+			))
+		}
+	case BackendBedrock:
+		// All settings have defaults, but a session name is meaningless
+		// without a role to assume
+		if backendConf.AWSSessionName != "" && backendConf.AWSRoleARN == "" {
+			errs = append(errs, fmt.Errorf(
+				"%w: backend %s: aws_session_name requires aws_role_arn",
+				types.ErrInvalidBackendConfig, name,
+			))
+		}
+	case BackendOllama:
+		// All settings have defaults
+		if backendConf.KeepAlive != "" && !validKeepAlive(backendConf.KeepAlive) {
+			errs = append(errs, fmt.Errorf(
+				"%w: backend %s: keep_alive must be a duration or a number of seconds",
+				types.ErrInvalidBackendConfig, name,
+			))
+		}
+	default:
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: %q",
+			types.ErrUnknownBackendType, name, backendConf.Type,
+		))
+	}
+
+	if backendConf.isUnixSocketURL() {
+		errs = append(errs, backendConf.validateUnixSocketURL(name)...)
+	}
+
+	if backendConf.StreamIdleTimeout != nil && *backendConf.StreamIdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: stream_idle_timeout must not be negative, got %s",
+			types.ErrInvalidBackendConfig, name, *backendConf.StreamIdleTimeout,
+		))
+	}
+
+	if format := backendConf.Parameters.Format; format != "" && format != types.FormatJSON {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: unsupported parameters.format %q, expected %q",
+			types.ErrInvalidBackendConfig, name, format, types.FormatJSON,
+		))
+	}
+
+	if effort := backendConf.Parameters.ReasoningEffort; effort != "" && types.ReasoningBudget(effort) == 0 {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: unsupported parameters.reasoning_effort %q, expected one of %s",
+			types.ErrInvalidBackendConfig, name, effort, strings.Join(types.ReasoningEfforts, ", "),
+		))
+	}
+
+	// Iterate over kinds in a stable order so errors are reported
+	// consistently
+	kinds := make([]string, 0, len(backendConf.ModelByKind))
+	for kind := range backendConf.ModelByKind {
+		kinds = append(kinds, kind)
+	}
+
+	sort.Strings(kinds)
+
+	for _, kind := range kinds {
+		switch {
+		case !knownKind(kind):
+			errs = append(errs, fmt.Errorf(
+				"%w: backend %s: model_by_kind: unknown kind of code %q",
+				types.ErrInvalidBackendConfig, name, kind,
+			))
+		case backendConf.ModelByKind[kind] == "":
+			errs = append(errs, fmt.Errorf(
+				"%w: backend %s: model_by_kind: model for %q is empty",
+				types.ErrInvalidBackendConfig, name, kind,
+			))
+		}
+	}
+
+	// Requests to Bedrock are signed before they reach the transport, so
+	// their URLs cannot be modified
+	if backendConf.Type == BackendBedrock && len(backendConf.QueryParams) > 0 {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: query_params is not supported for backends of type %q",
+			types.ErrInvalidBackendConfig, name, backendConf.Type,
+		))
+	}
+
+	return errs
+}
+
+// validateUnixSocketURL verifies the URL of a backend served over a Unix
+// domain socket. Whether the socket exists is only verified when the
+// backend's HTTP client is created, as it may be created after the
+// configuration is loaded.
+func (backendConf BackendConfig) validateUnixSocketURL(name string) (errs []error) {
+	if _, ok := unixSocketPaths[backendConf.Type]; !ok {
+		return []error{fmt.Errorf(
+			"%w: backend %s: Unix socket URLs are only supported by backends of type %q and %q",
+			types.ErrInvalidBackendConfig, name, BackendOllama, BackendOpenAICompatible,
+		)}
+	}
+
+	socketURL, err := url.Parse(backendConf.URL)
+	if err != nil || socketURL.Host != "" || !strings.HasPrefix(socketURL.Path, "/") {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: url %q must be the absolute path of a socket, e.g. %q",
+			types.ErrInvalidBackendConfig, name, backendConf.URL, "unix:///var/run/ollama.sock",
+		))
+	}
+
+	if backendConf.Proxy != "" {
+		errs = append(errs, fmt.Errorf(
+			"%w: backend %s: proxy cannot be used with a Unix socket URL",
+			types.ErrInvalidBackendConfig, name,
+		))
+	}
+
+	return errs
+}
+
+// validKeepAlive returns whether a keep_alive setting is either a duration
+// string or an integer number of seconds.
+func validKeepAlive(value string) bool {
+	if _, err := strconv.Atoi(value); err == nil {
+		return true
+	}
+
+	_, err := time.ParseDuration(value)
+
+	return err == nil
+}
+
 // replaceEnvVars replaces any environment variables in the config with their
-// actual values.
-func replaceEnvVars(conf Config) Config {
+// actual values. An error is returned if a required variable is not set.
+func replaceEnvVars(conf Config) (Config, error) {
 	for backendName, backendConfig := range conf.Backends {
-		if backendConfig.APIKey != "" {
-			backendConfig.APIKey = replaceEnvVar(backendConfig.APIKey)
+		fields := []struct {
+			name  string
+			value *string
+		}{
+			{"api_key", &backendConfig.APIKey},
+			{"aws_profile", &backendConfig.AWSProfile},
+			{"aws_region", &backendConfig.AWSRegion},
+			{"aws_role_arn", &backendConfig.AWSRoleARN},
+			{"aws_session_name", &backendConfig.AWSSessionName},
+			{"gcp_project", &backendConfig.GCPProject},
+			{"gcp_location", &backendConfig.GCPLocation},
+			{"keep_alive", &backendConfig.KeepAlive},
+			{"url", &backendConfig.URL},
+			{"default_model", &backendConfig.DefaultModel},
+			{"api_version", &backendConfig.APIVersion},
+			{"auth_header", &backendConfig.AuthHeader},
+			{"proxy", &backendConfig.Proxy},
+			{"ca_cert_file", &backendConfig.CACertFile},
+		}
+
+		for _, field := range fields {
+			if *field.value == "" {
+				continue
+			}
+
+			value, err := replaceEnvVar(*field.value)
+			if err != nil {
+				return conf, fmt.Errorf(
+					"backend %s, field %s: %w",
+					backendName, field.name, err,
+				)
+			}
+
+			*field.value = value
 		}
 
-		if backendConfig.AWSProfile != "" {
-			backendConfig.AWSProfile = replaceEnvVar(backendConfig.AWSProfile)
+		if len(backendConfig.QueryParams) > 0 {
+			params := make(map[string]string, len(backendConfig.QueryParams))
+			for key, value := range backendConfig.QueryParams {
+				value, err := replaceEnvVar(value)
+				if err != nil {
+					return conf, fmt.Errorf(
+						"backend %s, field query_params.%s: %w",
+						backendName, key, err,
+					)
+				}
+
+				params[key] = value
+			}
+
+			backendConfig.QueryParams = params
 		}
 
-		if backendConfig.AWSRegion != "" {
-			backendConfig.AWSRegion = replaceEnvVar(backendConfig.AWSRegion)
+		if len(backendConfig.ModelByKind) > 0 {
+			models := make(map[string]string, len(backendConfig.ModelByKind))
+			for kind, model := range backendConfig.ModelByKind {
+				model, err := replaceEnvVar(model)
+				if err != nil {
+					return conf, fmt.Errorf(
+						"backend %s, field model_by_kind.%s: %w",
+						backendName, kind, err,
+					)
+				}
+
+				models[kind] = model
+			}
+
+			backendConfig.ModelByKind = models
 		}
 
-		if backendConfig.URL != "" {
-			backendConfig.URL = replaceEnvVar(backendConfig.URL)
+		conf.Backends[backendName] = backendConfig
+	}
+
+	return conf, nil
+}
+
+// replaceEnvVar replaces environment variables in a string with their actual
+// values. Both the $VAR and ${VAR} forms are supported, as well as the
+// following shell-style forms:
+//
+//   - ${VAR:-default}: use "default" if VAR is unset or empty. The default
+//     value may itself contain variable references.
+//   - ${VAR:?message}: return an error if VAR is unset or empty. The message
+//     is optional.
+//
+// A literal dollar sign can be provided by escaping it as $$ or \$.
+func replaceEnvVar(s string) (string, error) {
+	var out strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '$' {
+			out.WriteByte('$')
+			i++
+			continue
 		}
 
-		if backendConfig.DefaultModel != "" {
-			backendConfig.DefaultModel = replaceEnvVar(backendConfig.DefaultModel)
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
 		}
 
-		if backendConfig.APIVersion != "" {
-			backendConfig.APIVersion = replaceEnvVar(backendConfig.APIVersion)
+		switch next := s[i+1]; {
+		case next == '$':
+			out.WriteByte('$')
+			i++
+		case next == '{':
+			end := closingBrace(s, i+1)
+			if end == -1 {
+				return "", fmt.Errorf("%w: unterminated variable reference in %q", types.ErrInvalidEnvVar, s)
+			}
+
+			value, err := expandBraced(s[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+
+			out.WriteString(value)
+			i = end
+		case isVarNameChar(next, true):
+			end := i + 1
+			for end < len(s) && isVarNameChar(s[end], false) {
+				end++
+			}
+
+			out.WriteString(os.Getenv(s[i+1 : end]))
+			i = end - 1
+		default:
+			out.WriteByte('$')
 		}
+	}
 
-		conf.Backends[backendName] = backendConfig
+	return out.String(), nil
+}
+
+// expandBraced expands the contents of a ${...} variable reference, i.e.
+// everything between the braces.
+func expandBraced(expr string) (string, error) {
+	nameEnd := 0
+	for nameEnd < len(expr) && isVarNameChar(expr[nameEnd], nameEnd == 0) {
+		nameEnd++
+	}
+
+	name, modifier := expr[:nameEnd], expr[nameEnd:]
+	if name == "" {
+		return "", fmt.Errorf("%w: bad variable reference ${%s}", types.ErrInvalidEnvVar, expr)
+	}
+
+	value := os.Getenv(name)
+
+	switch {
+	case modifier == "":
+		return value, nil
+	case strings.HasPrefix(modifier, ":-"):
+		if value != "" {
+			return value, nil
+		}
+
+		return replaceEnvVar(modifier[2:])
+	case strings.HasPrefix(modifier, ":?"):
+		if value != "" {
+			return value, nil
+		}
+
+		msg, err := replaceEnvVar(modifier[2:])
+		if err != nil {
+			return "", err
+		}
+
+		if msg == "" {
+			return "", fmt.Errorf("%w: %s", types.ErrRequiredEnvVar, name)
+		}
+
+		return "", fmt.Errorf("%w: %s: %s", types.ErrRequiredEnvVar, name, msg)
+	default:
+		return "", fmt.Errorf("%w: bad variable reference ${%s}", types.ErrInvalidEnvVar, expr)
+	}
+}
+
+// closingBrace returns the index of the brace closing the opening brace at
+// index start of s, taking nested braces into account. Returns -1 if the
+// brace is never closed.
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch s[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
 	}
 
-	return conf
+	return -1
 }
 
-// replaceEnvVar replaces an environment variable in a string with its actual
-// value.
-func replaceEnvVar(s string) string {
-	return os.ExpandEnv(s)
+// isVarNameChar returns whether c can be part of an environment variable
+// name. If first is true, c is checked as the first character of the name,
+// which cannot be a digit.
+func isVarNameChar(c byte, first bool) bool {
+	return c == '_' ||
+		(c >= 'a' && c <= 'z') ||
+		(c >= 'A' && c <= 'Z') ||
+		(!first && c >= '0' && c <= '9')
 }
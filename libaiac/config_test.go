@@ -0,0 +1,268 @@
+package libaiac
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestReplaceEnvVar(t *testing.T) {
+	t.Setenv("AIAC_TEST_SET", "set")
+	t.Setenv("AIAC_TEST_OTHER", "other")
+	t.Setenv("AIAC_TEST_EMPTY", "")
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		// wantErr is the error expansion must fail with, if any, and
+		// wantMsg a string its message must contain
+		wantErr error
+		wantMsg string
+	}{
+		{name: "no references", input: "plain text", want: "plain text"},
+		{name: "bare form", input: "a-$AIAC_TEST_SET-b", want: "a-set-b"},
+		{name: "braced form", input: "a${AIAC_TEST_SET}b", want: "asetb"},
+		{name: "unset without default", input: "a${AIAC_TEST_UNSET}b", want: "ab"},
+		{name: "unset bare form", input: "a$AIAC_TEST_UNSET", want: "a"},
+		{name: "unset with default", input: "${AIAC_TEST_UNSET:-fallback}", want: "fallback"},
+		{name: "empty with default", input: "${AIAC_TEST_EMPTY:-fallback}", want: "fallback"},
+		{name: "set with default", input: "${AIAC_TEST_SET:-fallback}", want: "set"},
+		{name: "empty default", input: "${AIAC_TEST_UNSET:-}", want: ""},
+		{name: "nested default", input: "${AIAC_TEST_UNSET:-${AIAC_TEST_OTHER}}", want: "other"},
+		{
+			name:  "doubly nested default",
+			input: "${AIAC_TEST_UNSET:-${AIAC_TEST_EMPTY:-${AIAC_TEST_OTHER}-x}}",
+			want:  "other-x",
+		},
+		{name: "nested default not used", input: "${AIAC_TEST_SET:-${AIAC_TEST_OTHER}}", want: "set"},
+		{name: "required and set", input: "${AIAC_TEST_SET:?must be set}", want: "set"},
+		{
+			name:    "required with message",
+			input:   "${AIAC_TEST_UNSET:?must be set}",
+			wantErr: types.ErrRequiredEnvVar,
+			wantMsg: "AIAC_TEST_UNSET: must be set",
+		},
+		{
+			name:    "required without message",
+			input:   "${AIAC_TEST_EMPTY:?}",
+			wantErr: types.ErrRequiredEnvVar,
+			wantMsg: "AIAC_TEST_EMPTY",
+		},
+		{name: "dollar escape", input: "cost: $$5", want: "cost: $5"},
+		{name: "backslash escape", input: `cost: \$5`, want: "cost: $5"},
+		{name: "escaped reference", input: "$${AIAC_TEST_SET}", want: "${AIAC_TEST_SET}"},
+		{name: "backslash escaped reference", input: `\$AIAC_TEST_SET`, want: "$AIAC_TEST_SET"},
+		{name: "other backslashes", input: `a\b\`, want: `a\b\`},
+		{name: "trailing dollar", input: "5$", want: "5$"},
+		{name: "dollar before non-name", input: "$5 and $-", want: "$5 and $-"},
+		{
+			name:    "unterminated reference",
+			input:   "${AIAC_TEST_SET",
+			wantErr: types.ErrInvalidEnvVar,
+			wantMsg: "unterminated",
+		},
+		{
+			name:    "unterminated nested reference",
+			input:   "${AIAC_TEST_UNSET:-${AIAC_TEST_SET}",
+			wantErr: types.ErrInvalidEnvVar,
+			wantMsg: "unterminated",
+		},
+		{name: "empty name", input: "${}", wantErr: types.ErrInvalidEnvVar},
+		{name: "unknown modifier", input: "${AIAC_TEST_SET:+x}", wantErr: types.ErrInvalidEnvVar},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replaceEnvVar(tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				if !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Errorf("expected error to contain %q, got %q", tt.wantMsg, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadConfigRequiredEnvVar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aiac.toml")
+
+	err := os.WriteFile(path, []byte(`
+default_backend = "test"
+
+[backends.test]
+type = "openai"
+api_key = "${AIAC_TEST_KEY:?must be set}"
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("AIAC_TEST_KEY", "")
+
+	_, err = LoadConfig(path)
+	if !errors.Is(err, types.ErrRequiredEnvVar) {
+		t.Fatalf("expected error %q, got %v", types.ErrRequiredEnvVar, err)
+	}
+
+	if !strings.Contains(err.Error(), "AIAC_TEST_KEY") {
+		t.Errorf("expected error to name AIAC_TEST_KEY, got %q", err)
+	}
+
+	t.Setenv("AIAC_TEST_KEY", "sk-test")
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := conf.Backends["test"].APIKey; got != "sk-test" {
+		t.Errorf("expected API key %q, got %q", "sk-test", got)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+
+	// configFile writes a configuration file whose default backend is named
+	// after it, returning its path
+	configFile := func(path, backend string) string {
+		t.Helper()
+
+		err := os.MkdirAll(filepath.Dir(path), 0o700)
+		if err == nil {
+			err = os.WriteFile(path, []byte(
+				"default_backend = \""+backend+"\"\n\n[backends."+backend+"]\ntype = \"ollama\"\n",
+			), 0o600)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return path
+	}
+
+	explicit := configFile(filepath.Join(dir, "explicit.toml"), "explicit")
+	env := configFile(filepath.Join(dir, "env.toml"), "env")
+	missing := filepath.Join(dir, "missing.toml")
+
+	// xdgHome has a user configuration file, and emptyHome does not
+	xdgHome := filepath.Join(dir, "xdg")
+	configFile(filepath.Join(xdgHome, "aiac", "aiac.toml"), "xdg")
+	emptyHome := filepath.Join(dir, "empty")
+
+	tests := []struct {
+		name    string
+		path    string
+		envVar  string
+		xdgHome string
+		// backendType, if set, is set in the EnvBackendType environment
+		// variable
+		backendType string
+
+		wantBackend string
+		wantErr     error
+		wantMsg     string
+	}{
+		{
+			name:        "explicit path over environment variable",
+			path:        explicit,
+			envVar:      env,
+			xdgHome:     xdgHome,
+			wantBackend: "explicit",
+		},
+		{
+			name:        "environment variable over XDG path",
+			envVar:      env,
+			xdgHome:     xdgHome,
+			wantBackend: "env",
+		},
+		{
+			name:    "missing file in environment variable",
+			envVar:  missing,
+			xdgHome: xdgHome,
+			wantErr: fs.ErrNotExist,
+			wantMsg: missing + " (set by " + EnvConfig + ") does not exist",
+		},
+		{
+			// Configuration files are loaded even if configuration
+			// environment variables are set
+			name:        "XDG path",
+			xdgHome:     xdgHome,
+			backendType: "openai",
+			wantBackend: "xdg",
+		},
+		{
+			name:        "configuration from environment",
+			xdgHome:     emptyHome,
+			backendType: "ollama",
+			wantBackend: "ollama",
+		},
+		{
+			name:    "no configuration",
+			xdgHome: emptyHome,
+			wantErr: fs.ErrNotExist,
+			wantMsg: "no configuration file found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The XDG paths are read from the environment once, so they are
+			// read again once it is set, and after it is restored (cleanup
+			// functions run in reverse order)
+			t.Cleanup(xdg.Reload)
+
+			for _, name := range append([]string{EnvProfile, EnvNoConfig}, ConfigEnvVars...) {
+				t.Setenv(name, "")
+			}
+
+			t.Setenv(EnvConfig, tt.envVar)
+			t.Setenv(EnvBackendType, tt.backendType)
+			t.Setenv("XDG_CONFIG_HOME", tt.xdgHome)
+			t.Setenv("XDG_CONFIG_DIRS", filepath.Join(dir, "system"))
+			xdg.Reload()
+
+			conf, err := LoadConfig(tt.path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				if !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Errorf("expected error to contain %q, got %q", tt.wantMsg, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if conf.DefaultBackend != tt.wantBackend {
+				t.Errorf("expected default backend %q, got %q", tt.wantBackend, conf.DefaultBackend)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package libaiac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultContextLimit is the default maximum total size of context files, in
+// bytes (see ReadContextFiles).
+const DefaultContextLimit = 64 * 1024
+
+// binarySniffLen is the number of leading bytes of a file that are checked for
+// NUL bytes to detect binary files, like Git does.
+const binarySniffLen = 8000
+
+// ContextFile is an existing file provided to the model as context, so that the
+// code it generates is compatible with it.
+type ContextFile struct {
+	// Path is the path of the file, as provided.
+	Path string
+
+	// Content is the content of the file.
+	Content string
+
+	// Modified is when the file was last modified, by which the least
+	// relevant files are found (see FitContextFiles).
+	Modified time.Time
+}
+
+// ReadContextFiles reads files to provide to the model as context, from the
+// provided paths and the files matching the provided glob patterns (see
+// filepath.Match), in that order. Files are only read once. Binary files,
+// files larger than the limit, and files that would bring the total size of
+// the context files over the limit are skipped, and reported as warnings. A
+// limit of zero or less means no limit. An error is returned if a path cannot
+// be read or a pattern is malformed.
+func ReadContextFiles(paths, patterns []string, limit int) (
+	files []ContextFile,
+	warnings []string,
+	err error,
+) {
+	// Matches are appended without modifying the caller's slice
+	paths = paths[:len(paths):len(paths)]
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid context glob %q: %w", pattern, err)
+		}
+
+		if len(matches) == 0 {
+			warnings = append(warnings, fmt.Sprintf("context glob %q matches no files", pattern))
+		}
+
+		for _, match := range matches {
+			// Directories matched by patterns are not context
+			if info, err := os.Stat(match); err == nil && !info.IsDir() {
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	seen := make(map[string]bool, len(paths))
+	total := 0
+
+	for _, path := range paths {
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = filepath.Clean(path)
+		}
+
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading context file: %w", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed reading context file: %w", err)
+		}
+
+		switch {
+		case isBinary(data):
+			warnings = append(warnings, fmt.Sprintf("skipping binary context file %s", path))
+			continue
+		case limit > 0 && len(data) > limit:
+			warnings = append(warnings, fmt.Sprintf(
+				"skipping context file %s, its size (%d bytes) exceeds the limit of %d bytes",
+				path, len(data), limit,
+			))
+			continue
+		case limit > 0 && total+len(data) > limit:
+			warnings = append(warnings, fmt.Sprintf(
+				"skipping context file %s, the total size of context files would exceed the limit of %d bytes",
+				path, limit,
+			))
+			continue
+		}
+
+		total += len(data)
+		files = append(files, ContextFile{
+			Path:     path,
+			Content:  string(data),
+			Modified: info.ModTime(),
+		})
+	}
+
+	return files, warnings, nil
+}
+
+// ContextPrompt returns a prompt presenting the provided files to the model as
+// existing code, each in a fenced code block labeled with its path, and
+// asking it to keep the code it generates compatible with them. The prompt is
+// meant to precede the request. Returns an empty string if there are no
+// files.
+func ContextPrompt(files []ContextFile) string {
+	if len(files) == 0 {
+		return ""
+	}
+
+	var prompt bytes.Buffer
+
+	prompt.WriteString(
+		"The following files already exist in the project. Make sure the " +
+			"code you generate is compatible with them, following their " +
+			"naming conventions and provider versions, and do not repeat " +
+			"their contents unless asked to.",
+	)
+
+	for _, file := range files {
+		fmt.Fprintf(
+			&prompt,
+			"\n\nExisting file %s:\n\n%s",
+			file.Path, codeBlock(fileLanguage(file.Path), file.Content),
+		)
+	}
+
+	return prompt.String()
+}
+
+// FitContextFiles drops context files until the prompt presenting them (see
+// ContextPrompt), preceding prompt, fits in the context window of a model of
+// a backend, together with the message history and the maximum number of
+// tokens to generate (see EstimateTokens and ContextWindow). The least
+// relevant files are dropped first: those modified least recently, and of
+// those modified at the same time, those provided last. Files are dropped
+// whole, as partial files could mislead the model. Returns the files that are
+// kept and those that are dropped, in their original order, and the estimate
+// of the prompt with all files. All files are kept if the context window is
+// unknown, and none if the prompt does not fit even without them.
+func (aiac *Aiac) FitContextFiles(
+	ctx context.Context,
+	backendName, model string,
+	history []types.Message,
+	files []ContextFile,
+	prompt string,
+	params types.Parameters,
+) (kept, dropped []ContextFile, est TokenEstimate) {
+	estimate := func(files []ContextFile) TokenEstimate {
+		return aiac.EstimateTokens(
+			backendName, model, history,
+			strings.TrimSpace(ContextPrompt(files)+"\n\n"+prompt), params,
+		)
+	}
+
+	if len(files) == 0 || aiac.ContextWindow(ctx, backendName, model) == 0 {
+		return files, nil, estimate(files)
+	}
+
+	est = estimate(files)
+	if !est.Exceeds() {
+		return files, nil, est
+	}
+
+	// Indexes of the files, from the least relevant to the most relevant
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = len(files) - 1 - i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return files[order[i]].Modified.Before(files[order[j]].Modified)
+	})
+
+	drop := make(map[int]bool, len(files))
+	for _, i := range order {
+		drop[i] = true
+
+		kept = kept[:0]
+		for j, file := range files {
+			if !drop[j] {
+				kept = append(kept, file)
+			}
+		}
+
+		if !estimate(kept).Exceeds() {
+			break
+		}
+	}
+
+	for i, file := range files {
+		if drop[i] {
+			dropped = append(dropped, file)
+		}
+	}
+
+	return kept, dropped, est
+}
+
+// isBinary returns whether the provided data seems to be the content of a
+// binary file rather than text.
+func isBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > binarySniffLen {
+		sniff = sniff[:binarySniffLen]
+	}
+
+	return bytes.IndexByte(sniff, 0) >= 0 || !utf8.Valid(data)
+}
@@ -0,0 +1,1218 @@
+package libaiac
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// conversation wraps a backend's Conversation implementation with behavior
+// that applies to all backends regardless of their type, such as request
+// timeouts, response caching and falling back to other backends.
+type conversation struct {
+	types.Conversation
+
+	aiac        *Aiac
+	backend     types.Backend
+	backendName string
+	model       string
+	timeout     time.Duration
+	cache       *Cache
+
+	// fallbacks are the names of the backends to try, in order, when the
+	// current backend fails due to a transient error
+	fallbacks []string
+
+	// defaults are the default generation parameters of the current backend,
+	// which params override
+	defaults types.Parameters
+
+	// stopLimit is the maximum number of stop sequences the current backend
+	// accepts, or zero if there is none
+	stopLimit int
+
+	// nativeJSON is true if the current backend's provider can constrain
+	// responses to JSON
+	nativeJSON bool
+
+	// nativeSchema is true if the current backend's provider can constrain
+	// responses to a JSON Schema
+	nativeSchema bool
+
+	// seed is true if the current backend's provider accepts a seed
+	seed bool
+
+	// reasoning is true if the current backend's provider accepts a
+	// reasoning effort for the current model
+	reasoning bool
+
+	// headers and params are recorded so that the wrapped conversation can be
+	// recreated with the same settings
+	headers [][2]string
+	params  types.Parameters
+
+	// images are the images to attach to the next prompt (see AttachImages)
+	images []types.Image
+}
+
+// Send sends a message to the model and returns the response, just like the
+// wrapped Conversation, but enforces the backend's request timeout, and
+// refuses prompts that do not fit in the model's context window (see
+// checkContextWindow). If the response cache is enabled, a cached response is
+// returned when available. Responses without code are sent again, or fail
+// with types.ErrEmptyResponse (see sendNonEmpty). If responses are
+// constrained to JSON, the prompt asks for JSON, and responses whose code is
+// not valid JSON fail with types.ErrInvalidJSON (see checkFormat).
+func (conv *conversation) Send(ctx context.Context, prompt string) (
+	res types.Response,
+	err error,
+) {
+	return conv.send(ctx, conv.formatPrompt(prompt), nil)
+}
+
+// Stream is the same as Send, but streams the response from the model,
+// writing generated text to w as it arrives. A cached response is written to
+// w in its entirety, and streamed responses are cached once complete.
+func (conv *conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	return conv.send(ctx, conv.formatPrompt(prompt), w)
+}
+
+// SendCandidates sends a message to the model and returns n alternative
+// responses to it. If the wrapped conversation can generate multiple responses
+// in a single request (see types.CandidateGenerator), it is used. Otherwise,
+// the prompt is sent n times, each time with the same history, and the token
+// usage of every response is reported separately. Either way, only the first
+// response is kept in the conversation's history. Responses are not cached,
+// except for the first when sent sequentially, if it is kept. Candidates
+// without code are discarded (see nonEmpty), as are those whose code is not
+// valid JSON if responses are constrained to JSON (see validFormat), whether
+// they are generated in a single request or sequentially.
+func (conv *conversation) SendCandidates(ctx context.Context, prompt string, n int) (
+	results []types.Response,
+	err error,
+) {
+	if n <= 1 {
+		res, err := conv.Send(ctx, prompt)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Response{res}, nil
+	}
+
+	prompt = conv.formatPrompt(prompt)
+
+	if generator, ok := conv.Conversation.(types.CandidateGenerator); ok {
+		conv.logReasoning(ctx)
+
+		results, err = conv.sendCandidates(ctx, generator, prompt, n)
+		if err == nil {
+			return conv.validFormat(nonEmpty(results))
+		}
+		if !errors.Is(err, types.ErrUnsupported) {
+			return results, err
+		}
+	}
+
+	images := conv.images
+
+	// The first candidate is sent like the others, so that it is discarded
+	// rather than failing the whole request if it is empty or not in the
+	// required format
+	first, err := conv.sendCached(ctx, prompt, nil, func() (types.Response, error) {
+		res, err := conv.sendWithFallback(ctx, prompt, nil)
+		if err != nil {
+			return res, err
+		}
+
+		conv.images = nil
+		res.Code, res.Language = extractCode(res.FullOutput)
+
+		return res, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	results = append(results, first)
+
+	// The history now ends with the prompt and the first response, which
+	// are removed for the remaining candidates and restored after them
+	after := append([]types.Message(nil), conv.Messages()...)
+	before := after[:len(after)-2]
+
+	for len(results) < n {
+		conv.reset(before)
+
+		// Every candidate is a request of its own
+		if _, err := conv.checkBudget(prompt, 1); err != nil {
+			conv.reset(after)
+			return results, err
+		}
+
+		conv.images = images
+
+		res, err := conv.sendWithFallback(ctx, prompt, nil)
+		conv.images = nil
+		if err != nil {
+			conv.reset(after)
+			return results, err
+		}
+
+		res.Code, res.Language = extractCode(res.FullOutput)
+		results = append(results, res)
+	}
+
+	conv.reset(after)
+
+	return conv.validFormat(nonEmpty(results))
+}
+
+// nonEmpty removes candidates without code (see emptyCode) from results,
+// with a warning added to the first remaining one. An error wrapping
+// types.ErrEmptyResponse is returned if none remain. Candidates are not sent
+// again, regardless of Aiac.RetryOnEmpty.
+func nonEmpty(results []types.Response) ([]types.Response, error) {
+	kept := make([]types.Response, 0, len(results))
+	for _, res := range results {
+		if !emptyCode(res) {
+			kept = append(kept, res)
+		}
+	}
+
+	switch {
+	case len(kept) == 0:
+		return nil, fmt.Errorf(
+			"backend %s, model %s: %w",
+			results[0].Backend, results[0].Model, types.ErrEmptyResponse,
+		)
+	case len(kept) < len(results):
+		kept[0].Warnings = append(kept[0].Warnings, fmt.Sprintf(
+			"%d of %d candidates contained no code and were discarded",
+			len(results)-len(kept), len(results),
+		))
+	}
+
+	return kept, nil
+}
+
+// sendCandidates generates n responses to the prompt in a single request using
+// the provided generator, enforcing the backend's request timeout.
+func (conv *conversation) sendCandidates(
+	ctx context.Context,
+	generator types.CandidateGenerator,
+	prompt string,
+	n int,
+) (results []types.Response, err error) {
+	warning, err := conv.checkContextWindow(prompt)
+	if err != nil {
+		return nil, err
+	}
+
+	budgetWarning, err := conv.checkBudget(prompt, n)
+	if err != nil {
+		return nil, err
+	}
+
+	history := append([]types.Message(nil), conv.Messages()...)
+
+	ctx, cancel := withTimeout(ctx, conv.timeout)
+	defer cancel()
+
+	ctx, span := conv.startGeneration(ctx, false, n)
+	defer func() {
+		// Backends that cannot generate candidates at once did not fail
+		if errors.Is(err, types.ErrUnsupported) {
+			span.End()
+			return
+		}
+
+		endSpan(span, err)
+	}()
+
+	logger := conv.aiac.log().With("backend", conv.backendName, "model", conv.model)
+
+	start := time.Now()
+
+	err = conv.attachImages()
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = generator.SendCandidates(ctx, prompt, n)
+	if errors.Is(err, types.ErrUnsupported) {
+		logger.DebugContext(ctx, "sending prompt once per candidate", "error", err)
+		return nil, err
+	}
+
+	if err != nil {
+		err = conv.aiac.redactError(timeoutError(ctx, conv.backendName, err))
+		logger.InfoContext(
+			ctx, "prompt failed",
+			"duration", time.Since(start),
+			"error", err,
+		)
+		return nil, err
+	}
+
+	recordUsage(span, results[0])
+
+	for i := range results {
+		results[i].Code, results[i].Language = extractCode(results[i].FullOutput)
+		results[i].Backend = conv.backendName
+		results[i].Model = conv.model
+		results[i].Seed = conv.parameters().Seed
+	}
+
+	estimateUsage(&results[0], history, prompt)
+
+	conv.images = nil
+
+	for _, warning := range []string{warning, budgetWarning, conv.recordSpend(results[0])} {
+		if warning != "" {
+			results[0].Warnings = append(results[0].Warnings, warning)
+		}
+	}
+
+	if warning := conv.stopWarning(); warning != "" {
+		results[0].Warnings = append(results[0].Warnings, warning)
+	}
+
+	if warning := conv.seedWarning(); warning != "" {
+		results[0].Warnings = append(results[0].Warnings, warning)
+	}
+
+	logger.InfoContext(
+		ctx, "received responses",
+		"duration", time.Since(start),
+		"candidates", len(results),
+		"input_tokens", results[0].InputTokens,
+		"output_tokens", results[0].OutputTokens,
+	)
+
+	return results, nil
+}
+
+// send implements both Send and Stream. The response is streamed if w is not
+// nil. Responses without code fail with an error wrapping
+// types.ErrEmptyResponse (see sendNonEmpty), and those whose code is not in
+// the required format with the error of checkFormat.
+func (conv *conversation) send(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	return conv.sendCached(ctx, prompt, w, func() (types.Response, error) {
+		res, err := conv.sendNonEmpty(ctx, prompt, w)
+		if err != nil {
+			return res, err
+		}
+
+		// The images are now part of the conversation's history
+		conv.images = nil
+
+		return res, conv.checkFormat(res)
+	})
+}
+
+// sendCached sends the prompt with the provided function, unless its response
+// is cached, after checking the context window and the cost budget. Responses
+// are only cached if they contain code in the required format, so that
+// unusable responses are not replayed. The response is streamed if w is not
+// nil, which must be the writer the function streams to.
+func (conv *conversation) sendCached(
+	ctx context.Context,
+	prompt string,
+	w io.Writer,
+	sendFn func() (types.Response, error),
+) (res types.Response, err error) {
+	// The history and images the response is cached for, as they are part
+	// of the conversation's history once it is sent
+	var history []types.Message
+	var images []types.Image
+
+	if conv.cache != nil {
+		history = append([]types.Message(nil), conv.Messages()...)
+		images = conv.images
+
+		key := cacheKey(
+			conv.backendName, conv.model, conv.parameters(),
+			history, prompt, images,
+		)
+
+		if res, ok := conv.cache.get(key); ok {
+			conv.aiac.log().DebugContext(ctx, "using cached response", "key", key)
+
+			conv.replay(prompt, res)
+			conv.images = nil
+			res.Code, res.Language = extractCode(res.FullOutput)
+
+			if w != nil {
+				_, err = io.WriteString(w, res.FullOutput)
+			}
+
+			return res, err
+		}
+	}
+
+	warning, err := conv.checkContextWindow(prompt)
+	if err != nil {
+		return res, err
+	}
+
+	budgetWarning, err := conv.checkBudget(prompt, 1)
+	if err != nil {
+		return res, err
+	}
+
+	conv.logReasoning(ctx)
+
+	res, err = sendFn()
+	if err != nil {
+		return res, err
+	}
+
+	for _, warning := range []string{warning, budgetWarning} {
+		if warning != "" {
+			res.Warnings = append(res.Warnings, warning)
+		}
+	}
+
+	if warning := conv.stopWarning(); warning != "" {
+		res.Warnings = append(res.Warnings, warning)
+	}
+
+	if conv.cache != nil && !emptyCode(res) && conv.checkFormat(res) == nil {
+		// A fallback backend may have responded, so the response is cached
+		// under the key of the backend and model it came from
+		key := cacheKey(
+			conv.backendName, conv.model, conv.parameters(),
+			history, prompt, images,
+		)
+
+		err = conv.cache.put(key, res)
+		if err != nil {
+			res.Warnings = append(
+				res.Warnings,
+				fmt.Sprintf("failed caching response: %s", err),
+			)
+		}
+	}
+
+	return res, nil
+}
+
+// sendNonEmpty sends the prompt with sendWithFallback and extracts the code
+// from the response. If the response contains no code (see emptyCode), it is
+// removed from the conversation's history, and the prompt is sent again up to
+// Aiac.RetryOnEmpty times; an error wrapping types.ErrEmptyResponse is
+// returned if every response is empty. Streamed empty responses have already
+// been written to w when retried.
+func (conv *conversation) sendNonEmpty(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	history := append([]types.Message(nil), conv.Messages()...)
+
+	for attempt := 0; ; attempt++ {
+		res, err = conv.sendWithFallback(ctx, prompt, w)
+		if err != nil {
+			return res, err
+		}
+
+		res.Code, res.Language = extractCode(res.FullOutput)
+		if !emptyCode(res) {
+			return res, nil
+		}
+
+		conv.reset(history)
+
+		if attempt >= conv.aiac.RetryOnEmpty {
+			return res, fmt.Errorf(
+				"backend %s, model %s: %w",
+				res.Backend, res.Model, types.ErrEmptyResponse,
+			)
+		}
+
+		conv.aiac.log().InfoContext(
+			ctx, "retrying empty response",
+			"backend", res.Backend,
+			"model", res.Model,
+			"attempt", attempt+1,
+			"stop_reason", res.StopReason,
+		)
+	}
+}
+
+// emptyCode returns whether a response contains no code, such as when the
+// model's output was filtered or truncated: either its output is blank, or
+// all of its code blocks are. Responses without code blocks are not empty if
+// they contain any text, as it is taken as the code (see extractCode).
+func emptyCode(res types.Response) bool {
+	if strings.TrimSpace(res.Code) == "" {
+		return true
+	}
+
+	_, _, ok := types.ExtractCodeBlock(res.FullOutput)
+
+	return !ok && len(types.FindCodeBlocks(res.FullOutput)) > 0
+}
+
+// checkContextWindow estimates the size of the prompt, with the conversation's
+// history and the maximum number of tokens to generate, and returns an error
+// wrapping types.ErrContextWindowExceeded if it does not fit in the model's
+// context window. If it fits but leaves little room for the response, a
+// warning is returned instead. Nothing is checked in dry-run mode, or if
+// checks are disabled (see Aiac.SkipContextCheck).
+func (conv *conversation) checkContextWindow(prompt string) (warning string, err error) {
+	if conv.aiac.SkipContextCheck || conv.aiac.DryRun {
+		return "", nil
+	}
+
+	est := conv.aiac.EstimateTokens(
+		conv.backendName, conv.model, conv.Messages(), prompt, conv.parameters(),
+	)
+
+	switch {
+	case est.Exceeds():
+		return "", fmt.Errorf(
+			"%w: %s (model %s)",
+			types.ErrContextWindowExceeded, est, conv.model,
+		)
+	case est.Tight():
+		return fmt.Sprintf(
+			"the prompt leaves little room for the response: %s (model %s)",
+			est, conv.model,
+		), nil
+	}
+
+	return "", nil
+}
+
+// checkBudget estimates the cost of sending the prompt, with the
+// conversation's history, to generate n responses (see Aiac.EstimateCost),
+// and returns an error wrapping types.ErrBudgetExceeded if it exceeds the
+// maximum cost of a request, or would bring the current month's spend
+// recorded in the ledger over the monthly budget (see BudgetConfig). If a
+// limit is set but the price of the model is unknown, a warning is returned
+// instead. Nothing is checked in dry-run mode, or if checks are disabled (see
+// Aiac.SkipBudgetCheck).
+func (conv *conversation) checkBudget(prompt string, n int) (warning string, err error) {
+	budget := conv.aiac.Conf.Budget
+	monthly := budget.Monthly > 0 && conv.aiac.Ledger != nil
+
+	if conv.aiac.SkipBudgetCheck || conv.aiac.DryRun || (budget.MaxCost == 0 && !monthly) {
+		return "", nil
+	}
+
+	est := conv.aiac.EstimateTokens(
+		conv.backendName, conv.model, conv.Messages(), prompt, conv.parameters(),
+	)
+
+	cost, ok := conv.aiac.EstimateCost(conv.backendName, conv.model, est)
+	if !ok {
+		return fmt.Sprintf(
+			"the price of model %s is unknown, so its cost is not limited by the budget",
+			conv.model,
+		), nil
+	}
+
+	cost *= float64(n)
+
+	if budget.MaxCost > 0 && cost > budget.MaxCost {
+		return "", fmt.Errorf(
+			"%w: the estimated cost of $%.4f exceeds the maximum cost of "+
+				"$%.4f per request (model %s)",
+			types.ErrBudgetExceeded, cost, budget.MaxCost, conv.model,
+		)
+	}
+
+	if !monthly {
+		return "", nil
+	}
+
+	spent, err := conv.aiac.Ledger.Spent()
+	if err != nil {
+		return "", err
+	}
+
+	if spent+cost > budget.Monthly {
+		return "", fmt.Errorf(
+			"%w: the estimated cost of $%.4f would exceed the monthly budget "+
+				"of $%.4f, of which $%.4f is spent (model %s)",
+			types.ErrBudgetExceeded, cost, budget.Monthly, spent, conv.model,
+		)
+	}
+
+	return "", nil
+}
+
+// recordSpend records the cost of a response in the spend ledger, if there is
+// one (see Aiac.Ledger), returning a warning if it cannot be recorded.
+// Responses replayed from recordings cost nothing.
+func (conv *conversation) recordSpend(res types.Response) (warning string) {
+	if conv.aiac.Ledger == nil || conv.aiac.ReplayDir != "" {
+		return ""
+	}
+
+	cost, ok := conv.aiac.Cost(res)
+	if !ok || cost == 0 {
+		return ""
+	}
+
+	err := conv.aiac.Ledger.Add(cost)
+	if err != nil {
+		return fmt.Sprintf("failed recording the cost of the response: %s", err)
+	}
+
+	return ""
+}
+
+// MaxStreamResumes is the maximum number of times a prompt is sent again to
+// the same backend when its streamed response stalls, if resuming streams is
+// enabled (see Aiac.ResumeStreams).
+const MaxStreamResumes = 3
+
+// sendWithFallback sends the prompt to the current backend. If it fails due to
+// a transient error (see fallbackable), the fallback backends are tried in
+// order. Once a fallback backend succeeds, the conversation continues with it,
+// and the backends following it in the list remain as fallbacks. If all
+// backends fail, the conversation is left unchanged. Streamed responses only
+// fall back if nothing was written to w yet. Streamed responses that stalled
+// are first requested again from the same backend, from scratch, if resuming
+// streams is enabled (see Aiac.ResumeStreams). If the model is missing from
+// the server and pulling models is enabled (see Aiac.PullModels), it is
+// pulled and the prompt is sent again.
+func (conv *conversation) sendWithFallback(
+	ctx context.Context,
+	prompt string,
+	w io.Writer,
+) (res types.Response, err error) {
+	// Keep the current state so it can be restored if all backends fail
+	orig := *conv
+	history := append([]types.Message(nil), conv.Messages()...)
+
+	var cw *countingWriter
+	if w != nil {
+		cw = &countingWriter{w: w}
+		w = cw
+	}
+
+	var errs []error
+	var warnings []string
+	var pulled bool
+	var resumes int
+
+	for {
+		res, err = conv.sendOnce(ctx, prompt, w)
+		if err == nil {
+			break
+		}
+
+		if conv.aiac.ResumeStreams && resumes < MaxStreamResumes &&
+			errors.Is(err, transport.ErrStreamIdle) && ctx.Err() == nil {
+			resumes++
+
+			conv.aiac.log().WarnContext(
+				ctx, "stream stalled, sending the prompt again",
+				"backend", conv.backendName,
+				"model", conv.model,
+				"attempt", resumes,
+				"error", err,
+			)
+
+			warnings = append(warnings, fmt.Sprintf(
+				"the response of backend %s stalled, and was requested again",
+				conv.backendName,
+			))
+
+			conv.reset(history)
+			if restarter, ok := w.(types.StreamRestarter); ok {
+				restarter.RestartStream()
+			}
+			continue
+		}
+
+		if puller, ok := conv.backend.(types.ModelPuller); ok && !pulled &&
+			conv.aiac.PullModels && errors.Is(err, types.ErrModelNotFound) {
+			pulled = true
+
+			err = conv.pull(ctx, puller)
+			if err == nil {
+				conv.reset(history)
+				continue
+			}
+		}
+
+		errs = append(errs, fmt.Errorf("backend %s: %w", conv.backendName, err))
+
+		if len(conv.fallbacks) == 0 ||
+			ctx.Err() != nil ||
+			!fallbackable(err) ||
+			(cw != nil && cw.n > 0) {
+			break
+		}
+
+		failed := conv.backendName
+		if !conv.fallBack(ctx, history, &errs) {
+			break
+		}
+
+		conv.aiac.log().WarnContext(
+			ctx, "falling back to another backend",
+			"backend", failed,
+			"fallback", conv.backendName,
+			"error", err,
+		)
+
+		warnings = append(warnings, fmt.Sprintf(
+			"backend %s failed (%s), falling back to %s",
+			failed, err, conv.backendName,
+		))
+	}
+
+	switch {
+	case err == nil:
+		res.Backend = conv.backendName
+		res.Model = conv.model
+		res.Seed = conv.parameters().Seed
+		estimateUsage(&res, history, prompt)
+		res.Warnings = append(res.Warnings, warnings...)
+
+		if warning := conv.recordSpend(res); warning != "" {
+			res.Warnings = append(res.Warnings, warning)
+		}
+
+		if warning := conv.seedWarning(); warning != "" {
+			res.Warnings = append(res.Warnings, warning)
+		}
+
+		if conv.aiac.Conf.Backends[conv.backendName].InsecureSkipVerify {
+			res.Warnings = append(res.Warnings, fmt.Sprintf(
+				"TLS certificate verification is disabled for backend %s, "+
+					"connections to it are not secure",
+				conv.backendName,
+			))
+		}
+		return res, nil
+	case len(errs) == 1:
+		// No fallback was attempted
+		return res, err
+	default:
+		*conv = orig
+		return res, fmt.Errorf("all backends failed: %w", errors.Join(errs...))
+	}
+}
+
+// sendOnce sends the prompt to the current backend, enforcing its request
+// timeout. The response is streamed if w is not nil.
+func (conv *conversation) sendOnce(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	ctx, cancel := withTimeout(ctx, conv.timeout)
+	defer cancel()
+
+	ctx, span := conv.startGeneration(ctx, w != nil, 1)
+	defer func() { endSpan(span, err) }()
+
+	logger := conv.aiac.log().With("backend", conv.backendName, "model", conv.model)
+	logger.InfoContext(ctx, "sending prompt", "stream", w != nil)
+
+	start := time.Now()
+
+	err = conv.attachImages()
+	if err != nil {
+		return res, err
+	}
+
+	if w != nil {
+		res, err = conv.Conversation.Stream(ctx, prompt, w)
+	} else {
+		res, err = conv.Conversation.Send(ctx, prompt)
+	}
+	if err != nil {
+		err = conv.aiac.redactError(timeoutError(ctx, conv.backendName, err))
+		logger.InfoContext(
+			ctx, "prompt failed",
+			"duration", time.Since(start),
+			"error", err,
+		)
+		return res, err
+	}
+
+	logger.InfoContext(
+		ctx, "received response",
+		"duration", time.Since(start),
+		"input_tokens", res.InputTokens,
+		"output_tokens", res.OutputTokens,
+		"stop_reason", res.StopReason,
+	)
+
+	recordUsage(span, res)
+
+	return res, nil
+}
+
+// pull pulls the conversation's model to the server of the current backend,
+// writing progress to Aiac.PullProgress.
+func (conv *conversation) pull(ctx context.Context, puller types.ModelPuller) error {
+	w := conv.aiac.PullProgress
+	if w == nil {
+		w = io.Discard
+	}
+
+	logger := conv.aiac.log().With("backend", conv.backendName, "model", conv.model)
+	logger.InfoContext(ctx, "pulling missing model")
+
+	start := time.Now()
+
+	err := puller.PullModel(ctx, conv.model, w)
+	if err != nil {
+		return conv.aiac.redactError(err)
+	}
+
+	logger.InfoContext(ctx, "pulled model", "duration", time.Since(start))
+
+	return nil
+}
+
+// fallBack switches the conversation to the next fallback backend that can be
+// loaded, removing it and any backends before it from the list, and recreates
+// the wrapped conversation with the provided message history. Fallback
+// backends always use their default model (see BackendConfig.ResolveModel).
+// Errors loading backends are appended to errs. Returns false if no backend
+// could be loaded.
+func (conv *conversation) fallBack(
+	ctx context.Context,
+	history []types.Message,
+	errs *[]error,
+) bool {
+	for len(conv.fallbacks) > 0 {
+		name := conv.fallbacks[0]
+		conv.fallbacks = conv.fallbacks[1:]
+
+		backend, backendConf, err := conv.aiac.loadBackend(ctx, name)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf(
+				"backend %s: failed loading backend: %w",
+				name, conv.aiac.redactError(err),
+			))
+			continue
+		}
+
+		model := backendConf.ResolveModel("")
+		if model == "" {
+			*errs = append(*errs, fmt.Errorf(
+				"backend %s: %w",
+				name, types.ErrNoDefaultModel,
+			))
+			continue
+		}
+
+		// Images in the history are sent to the fallback backend too
+		images := append(historyImages(history), conv.images...)
+		if len(images) > 0 {
+			if err := backendConf.checkImages(model, images); err != nil {
+				*errs = append(*errs, fmt.Errorf("backend %s: %w", name, err))
+				continue
+			}
+		}
+
+		conv.backend = backend
+		conv.backendName = backendConf.name
+		conv.model = model
+		conv.timeout = backendConf.timeout()
+		conv.defaults = backendConf.Parameters
+		conv.stopLimit = backendConf.stopLimit()
+		conv.nativeJSON = backendConf.nativeJSON()
+		conv.nativeSchema = backendConf.nativeSchema()
+		conv.seed = backendConf.seedSupport()
+		conv.reasoning = backendConf.reasoningSupport(model)
+		conv.reset(backendConf.withSystemPrompt(history))
+
+		return true
+	}
+
+	return false
+}
+
+// AttachImages attaches images to the next prompt sent in the conversation,
+// if the backend's provider accepts images for its model (see VisionModels)
+// of their size (see ImageSizeLimits). Images are attached to the wrapped
+// conversation whenever the prompt is sent to it, so they are sent again when
+// it is retried or falls back to other backends, which are skipped if they do
+// not accept them. Once the prompt is answered, they are kept in the
+// conversation's history.
+func (conv *conversation) AttachImages(images ...types.Image) error {
+	backendConf := namedBackendConfig{
+		BackendConfig: conv.aiac.Conf.Backends[conv.backendName],
+		name:          conv.backendName,
+	}
+
+	if _, ok := conv.Conversation.(types.ImageAttacher); !ok {
+		return fmt.Errorf(
+			"%w: backend %s does not accept images",
+			types.ErrUnsupported, conv.backendName,
+		)
+	}
+
+	err := backendConf.checkImages(conv.model, append(conv.images, images...))
+	if err != nil {
+		return err
+	}
+
+	conv.images = append(conv.images, images...)
+
+	return nil
+}
+
+// attachImages attaches the images of the next prompt, if any, to the wrapped
+// conversation.
+func (conv *conversation) attachImages() error {
+	if len(conv.images) == 0 {
+		return nil
+	}
+
+	attacher, ok := conv.Conversation.(types.ImageAttacher)
+	if !ok {
+		return fmt.Errorf(
+			"%w: backend %s does not accept images",
+			types.ErrUnsupported, conv.backendName,
+		)
+	}
+
+	return attacher.AttachImages(conv.images...)
+}
+
+// historyImages returns the images attached to the provided messages.
+func historyImages(msgs []types.Message) (images []types.Image) {
+	for _, msg := range msgs {
+		images = append(images, msg.Images...)
+	}
+
+	return images
+}
+
+// AddHeader adds a header to the wrapped conversation, recording it in case
+// the conversation needs to be recreated.
+func (conv *conversation) AddHeader(key, val string) {
+	conv.headers = append(conv.headers, [2]string{key, val})
+	conv.Conversation.AddHeader(key, val)
+}
+
+// SetParameters sets the generation parameters of the wrapped conversation,
+// recording them in case the conversation needs to be recreated. Parameters
+// that are not set fall back to the backend's default parameters.
+func (conv *conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+	conv.Conversation.SetParameters(conv.parameters())
+}
+
+// parameters returns the generation parameters in effect: the backend's
+// default parameters, overridden by those set for the conversation. Stop
+// sequences beyond the backend's limit are dropped (see stopWarning), as are
+// the format and schema if the backend cannot constrain responses to them
+// (see formatPrompt), the seed if the backend does not accept one (see
+// seedWarning), and the reasoning effort if the model does not support it
+// (see logReasoning).
+func (conv *conversation) parameters() types.Parameters {
+	params := conv.defaults.Override(conv.params)
+	if conv.stopLimit > 0 && len(params.Stop) > conv.stopLimit {
+		params.Stop = params.Stop[:conv.stopLimit]
+	}
+
+	if !conv.nativeJSON {
+		params.Format = ""
+	}
+
+	if !conv.nativeSchema {
+		params.Schema = nil
+	}
+
+	if !conv.seed {
+		params.Seed = nil
+	}
+
+	if !conv.reasoning {
+		params.ReasoningEffort = ""
+	}
+
+	return params
+}
+
+// logReasoning logs a debug message if a reasoning effort was set, but the
+// current model does not support it. Unlike unsupported seeds, this is not
+// worth a warning, as the effort only applies to reasoning models, and
+// responses of other models are complete without it.
+func (conv *conversation) logReasoning(ctx context.Context) {
+	effort := conv.defaults.Override(conv.params).ReasoningEffort
+	if conv.reasoning || effort == "" {
+		return
+	}
+
+	conv.aiac.log().DebugContext(
+		ctx, "ignoring reasoning effort unsupported by the model",
+		"backend", conv.backendName,
+		"model", conv.model,
+		"reasoning_effort", effort,
+	)
+}
+
+// jsonInstruction is appended to prompts when responses are constrained to
+// JSON. Some providers require prompts to ask for JSON when constraining
+// responses to it, and others cannot constrain responses at all.
+const jsonInstruction = "Respond only with a single valid JSON value, without comments."
+
+// schemaInstruction is appended to prompts after jsonInstruction when
+// responses must conform to a JSON Schema, followed by the schema, which
+// grounds the model even when the provider enforces it.
+const schemaInstruction = "The JSON value must conform to the following JSON Schema:"
+
+// jsonFormat returns whether responses are constrained to JSON, which they
+// are if they must conform to a JSON Schema.
+func (conv *conversation) jsonFormat() bool {
+	params := conv.defaults.Override(conv.params)
+
+	return params.Format == types.FormatJSON || len(params.Schema) > 0
+}
+
+// formatPrompt returns the prompt to send, with jsonInstruction appended if
+// responses are constrained to JSON, and the schema if they must conform to
+// one.
+func (conv *conversation) formatPrompt(prompt string) string {
+	if !conv.jsonFormat() {
+		return prompt
+	}
+
+	prompt += "\n\n" + jsonInstruction
+
+	if schema := conv.defaults.Override(conv.params).Schema; len(schema) > 0 {
+		prompt += " " + schemaInstruction + "\n\n" + strings.TrimSpace(string(schema))
+	}
+
+	return prompt
+}
+
+// checkFormat returns an error wrapping types.ErrInvalidJSON if responses are
+// constrained to JSON, and the code of res is not valid JSON, or one wrapping
+// types.ErrSchemaViolation if it does not conform to the JSON Schema
+// responses must conform to.
+func (conv *conversation) checkFormat(res types.Response) error {
+	if !conv.jsonFormat() {
+		return nil
+	}
+
+	var val interface{}
+	if err := json.Unmarshal([]byte(res.Code), &val); err != nil {
+		return fmt.Errorf(
+			"backend %s, model %s: %w: %s",
+			res.Backend, res.Model, types.ErrInvalidJSON, err,
+		)
+	}
+
+	raw := conv.defaults.Override(conv.params).Schema
+	if len(raw) == 0 {
+		return nil
+	}
+
+	schema, err := ParseSchema(raw)
+	if err == nil {
+		err = schema.Validate([]byte(res.Code))
+	}
+
+	if err != nil {
+		return fmt.Errorf("backend %s, model %s: %w", res.Backend, res.Model, err)
+	}
+
+	return nil
+}
+
+// validFormat removes candidates whose code is not valid JSON, or does not
+// conform to the JSON Schema, from results, if responses are constrained to
+// JSON, with a warning added to the first remaining one, as nonEmpty does.
+// The error of nonEmpty is returned as-is, and that of checkFormat if no
+// candidates remain.
+func (conv *conversation) validFormat(results []types.Response, err error) (
+	[]types.Response,
+	error,
+) {
+	if err != nil || !conv.jsonFormat() {
+		return results, err
+	}
+
+	kept := make([]types.Response, 0, len(results))
+	for _, res := range results {
+		if err = conv.checkFormat(res); err == nil {
+			kept = append(kept, res)
+		}
+	}
+
+	switch {
+	case len(kept) == 0:
+		return nil, err
+	case len(kept) < len(results):
+		kept[0].Warnings = append(kept[0].Warnings, fmt.Sprintf(
+			"%d of %d candidates were not valid JSON and were discarded",
+			len(results)-len(kept), len(results),
+		))
+	}
+
+	return kept, nil
+}
+
+// stopWarning returns a warning if stop sequences beyond the current
+// backend's limit were dropped, or an empty string otherwise.
+func (conv *conversation) stopWarning() string {
+	stop := conv.defaults.Override(conv.params).Stop
+	if conv.stopLimit == 0 || len(stop) <= conv.stopLimit {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"backend %s accepts at most %d stop sequences, ignoring %q",
+		conv.backendName, conv.stopLimit, stop[conv.stopLimit:],
+	)
+}
+
+// seedWarning returns a warning if a seed was set, but the current backend's
+// provider does not accept one, or an empty string otherwise.
+func (conv *conversation) seedWarning() string {
+	if conv.seed || conv.defaults.Override(conv.params).Seed == nil {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"backend %s does not support seeds, responses are not reproducible",
+		conv.backendName,
+	)
+}
+
+// replay records a prompt and a response that were not exchanged with the
+// backend (e.g. a response loaded from cache) in the conversation's history.
+// Conversations do not allow modifying their history, so the wrapped
+// conversation is recreated with the new messages.
+func (conv *conversation) replay(prompt string, res types.Response) {
+	conv.reset(append(
+		conv.Messages(),
+		types.Message{Role: "user", Content: prompt, Images: conv.images},
+		types.Message{Role: "assistant", Content: res.FullOutput},
+	))
+}
+
+// reset recreates the wrapped conversation with the provided message history,
+// applying the recorded headers and parameters.
+func (conv *conversation) reset(msgs []types.Message) {
+	conv.Conversation = conv.backend.Chat(conv.model, msgs...)
+	for _, header := range conv.headers {
+		conv.Conversation.AddHeader(header[0], header[1])
+	}
+	conv.Conversation.SetParameters(conv.parameters())
+}
+
+// fallbackable returns whether a request that failed with err may succeed with
+// a different backend: transient errors such as rate limiting and server
+// errors, network errors, timeouts and stalled streams. Other errors, such as
+// invalid requests or authentication errors, are not, as they are likely
+// caused by the prompt or by the backend's configuration, and falling back
+// would hide them.
+func fallbackable(err error) bool {
+	if errors.Is(err, transport.ErrDryRun) || errors.Is(err, transport.ErrNoRecording) {
+		return false
+	}
+
+	if errors.Is(err, types.ErrTransient) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, transport.ErrStreamIdle) {
+		return true
+	}
+
+	// Errors from the AWS SDK carry the response's status code
+	var statusErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &statusErr) {
+		return types.TransientStatus(statusErr.HTTPStatusCode())
+	}
+
+	// Certificate verification failures are network errors too, but they
+	// are caused by the backend's configuration (see BackendConfig.CACertFile)
+	var certErr *tls.CertificateVerificationError
+	if errors.As(err, &certErr) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// countingWriter is an io.Writer that counts the number of bytes written
+// through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write implements the io.Writer interface.
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// RestartStream implements types.StreamRestarter, for the wrapped writer if
+// it implements it.
+func (cw *countingWriter) RestartStream() {
+	if restarter, ok := cw.w.(types.StreamRestarter); ok {
+		restarter.RestartStream()
+	}
+}
+
+// withTimeout returns a copy of the provided context that expires after the
+// provided timeout. A timeout of zero means no timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (
+	context.Context,
+	context.CancelFunc,
+) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// timeoutError checks whether the provided error was caused by the context's
+// deadline being exceeded, and if so, returns an error wrapping
+// context.DeadlineExceeded that names the backend that timed out. Different
+// backends report timeouts differently (if at all), hence the context itself
+// is checked rather than the error. Otherwise, the error is returned as-is.
+func timeoutError(ctx context.Context, backendName string, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf(
+			"backend %s timed out: %w",
+			backendName, context.DeadlineExceeded,
+		)
+	}
+
+	return err
+}
+
+// extractCode extracts the generated code and its language hint from the raw
+// output of a model (see types.ExtractCodeBlock). If the output contains no
+// code blocks, the entire output is returned as the code, without a language.
+func extractCode(raw string) (code, language string) {
+	code, language, ok := types.ExtractCodeBlock(raw)
+	if !ok {
+		return strings.TrimSpace(raw), ""
+	}
+
+	return code, language
+}
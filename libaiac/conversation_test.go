@@ -0,0 +1,155 @@
+package libaiac
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestSendCandidatesSequentially(t *testing.T) {
+	tests := []struct {
+		name string
+		// outputs are the outputs of consecutive responses
+		outputs []string
+		format  string
+		// wantCodes are the codes of the returned candidates, and wantErr
+		// the error sending must fail with, if any
+		wantCodes []string
+		wantErr   error
+	}{
+		{
+			name:      "empty first candidate",
+			outputs:   []string{"```hcl\n```", "```hcl\na = 1\n```", "```hcl\nb = 2\n```"},
+			wantCodes: []string{"a = 1", "b = 2"},
+		},
+		{
+			name:    "every candidate empty",
+			outputs: []string{"", "   ", "```hcl\n```"},
+			wantErr: types.ErrEmptyResponse,
+		},
+		{
+			name:      "invalid JSON first candidate",
+			outputs:   []string{"```json\n{\"a\":\n```", "```json\n{\"a\": 1}\n```", "```json\n[2]\n```"},
+			format:    types.FormatJSON,
+			wantCodes: []string{`{"a": 1}`, "[2]"},
+		},
+		{
+			name:    "every candidate invalid JSON",
+			outputs: []string{"{", "[", "nope"},
+			format:  types.FormatJSON,
+			wantErr: types.ErrInvalidJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &stubBackend{outputs: tt.outputs}
+
+			aiac := NewFromConf(Config{})
+			aiac.Backends = map[string]types.Backend{"stub": backend}
+
+			chat, err := aiac.Chat(context.Background(), "stub", "stub-model")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			chat.SetParameters(types.Parameters{Format: tt.format})
+
+			results, err := chat.(*conversation).SendCandidates(
+				context.Background(), "terraform for s3", len(tt.outputs),
+			)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if backend.sent != len(tt.outputs) {
+				t.Errorf("expected %d requests, got %d", len(tt.outputs), backend.sent)
+			}
+
+			codes := make([]string, 0, len(results))
+			for _, res := range results {
+				codes = append(codes, res.Code)
+			}
+
+			if len(codes) != len(tt.wantCodes) {
+				t.Fatalf("expected candidates %q, got %q", tt.wantCodes, codes)
+			}
+
+			for i := range codes {
+				if codes[i] != tt.wantCodes[i] {
+					t.Errorf("expected candidates %q, got %q", tt.wantCodes, codes)
+					break
+				}
+			}
+		})
+	}
+}
+
+// stubBackend is a backend whose conversations respond with consecutive
+// outputs, and which cannot generate several candidates in one request.
+type stubBackend struct {
+	outputs []string
+	sent    int
+}
+
+// ListModels implements types.Backend.
+func (b *stubBackend) ListModels(context.Context) ([]types.Model, error) {
+	return nil, types.ErrUnsupported
+}
+
+// Chat implements types.Backend.
+func (b *stubBackend) Chat(_ string, msgs ...types.Message) types.Conversation {
+	return &stubConversation{backend: b, msgs: msgs}
+}
+
+// stubConversation is a conversation of a stubBackend.
+type stubConversation struct {
+	backend *stubBackend
+	msgs    []types.Message
+}
+
+// Send implements types.Conversation.
+func (conv *stubConversation) Send(_ context.Context, prompt string) (types.Response, error) {
+	output := conv.backend.outputs[conv.backend.sent%len(conv.backend.outputs)]
+	conv.backend.sent++
+
+	conv.msgs = append(
+		conv.msgs,
+		types.Message{Role: "user", Content: prompt},
+		types.Message{Role: "assistant", Content: output},
+	)
+
+	return types.Response{FullOutput: output}, nil
+}
+
+// Stream implements types.Conversation.
+func (conv *stubConversation) Stream(ctx context.Context, prompt string, w io.Writer) (types.Response, error) {
+	res, err := conv.Send(ctx, prompt)
+	if err == nil {
+		_, err = io.WriteString(w, res.FullOutput)
+	}
+
+	return res, err
+}
+
+// Messages implements types.Conversation.
+func (conv *stubConversation) Messages() []types.Message {
+	return conv.msgs
+}
+
+// AddHeader implements types.Conversation.
+func (conv *stubConversation) AddHeader(string, string) {}
+
+// SetParameters implements types.Conversation.
+func (conv *stubConversation) SetParameters(types.Parameters) {}
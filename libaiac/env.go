@@ -0,0 +1,106 @@
+package libaiac
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// Environment variables from which a configuration is synthesized when no
+// configuration file exists (see ConfigFromEnv).
+const (
+	// EnvBackendType is the type of the backend, e.g. "openai". Defaults to
+	// "openai" if any of the other variables are set.
+	EnvBackendType = "AIAC_BACKEND_TYPE"
+
+	// EnvAPIKey is the API key of the backend (see BackendConfig.APIKey).
+	EnvAPIKey = "AIAC_API_KEY"
+
+	// EnvDefaultModel is the default model of the backend (see
+	// BackendConfig.DefaultModel).
+	EnvDefaultModel = "AIAC_DEFAULT_MODEL"
+
+	// EnvURL is the URL of the backend's API (see BackendConfig.URL).
+	EnvURL = "AIAC_URL"
+
+	// EnvAPIVersion is the API version to use (see BackendConfig.APIVersion).
+	EnvAPIVersion = "AIAC_API_VERSION"
+
+	// EnvAWSRegion is the AWS region of Bedrock backends (see
+	// BackendConfig.AWSRegion).
+	EnvAWSRegion = "AIAC_AWS_REGION"
+
+	// EnvGCPProject is the Google Cloud project of Gemini backends using
+	// Vertex AI (see BackendConfig.GCPProject).
+	EnvGCPProject = "AIAC_GCP_PROJECT"
+)
+
+// ConfigEnvVars lists the environment variables read by ConfigFromEnv.
+var ConfigEnvVars = []string{
+	EnvBackendType,
+	EnvAPIKey,
+	EnvDefaultModel,
+	EnvURL,
+	EnvAPIVersion,
+	EnvAWSRegion,
+	EnvGCPProject,
+}
+
+// ConfigFromEnv synthesizes a configuration with a single backend from the
+// environment variables in ConfigEnvVars, for environments where writing a
+// configuration file is inconvenient, such as CI pipelines and containers.
+// The backend is named after its type, and is the default backend. API keys
+// may reference the keyring, as in configuration files. The configuration is
+// validated. An error wrapping fs.ErrNotExist is returned if none of the
+// variables are set.
+func ConfigFromEnv() (conf Config, err error) {
+	set := false
+	for _, name := range ConfigEnvVars {
+		if os.Getenv(name) != "" {
+			set = true
+			break
+		}
+	}
+
+	if !set {
+		return conf, fmt.Errorf(
+			"none of the %s environment variables are set: %w",
+			strings.Join(ConfigEnvVars, ", "), fs.ErrNotExist,
+		)
+	}
+
+	backendType := BackendType(os.Getenv(EnvBackendType))
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	name := string(backendType)
+
+	conf = Config{
+		DefaultBackend: name,
+		Backends: map[string]BackendConfig{
+			name: {
+				Type:         backendType,
+				APIKey:       os.Getenv(EnvAPIKey),
+				DefaultModel: os.Getenv(EnvDefaultModel),
+				URL:          os.Getenv(EnvURL),
+				APIVersion:   os.Getenv(EnvAPIVersion),
+				AWSRegion:    os.Getenv(EnvAWSRegion),
+				GCPProject:   os.Getenv(EnvGCPProject),
+			},
+		},
+	}
+
+	conf, err = resolveSecrets(conf)
+	if err != nil {
+		return conf, fmt.Errorf("failed loading configuration: %w", err)
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return conf, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return conf, nil
+}
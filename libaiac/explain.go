@@ -0,0 +1,49 @@
+package libaiac
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// ExplanationHeading is the heading of the section of responses that explains
+// the generated code, as requested by ExplainPrompt.
+const ExplanationHeading = "## Explanation"
+
+// ExplainPrompt returns the prompt with instructions to respond with the code
+// in a single code block, followed by a section headed ExplanationHeading
+// explaining the key decisions made in it, so that the two can be separated
+// (see Explanation).
+func ExplainPrompt(prompt string) string {
+	return fmt.Sprintf(
+		"%s\n\nRespond with the complete code in a single code block. After "+
+			"it, add a section with the heading %q explaining the key "+
+			"decisions made in the code, such as the choice of resources, "+
+			"their settings and security considerations, as a short list. Do "+
+			"not include code blocks in the explanation.",
+		strings.TrimRight(prompt, "\n"),
+		ExplanationHeading,
+	)
+}
+
+// Explanation returns the explanation of the code in the output of a model,
+// for responses to prompts created by ExplainPrompt: the text of the section
+// headed ExplanationHeading, or if the model did not include one, all of the
+// text outside of code blocks (see types.Prose). Code blocks are never part of
+// the explanation, as they are extracted as the code (see
+// types.ExtractCodeBlock).
+func Explanation(output string) string {
+	prose := types.Prose(output)
+
+	lines := strings.Split(prose, "\n")
+	for i, line := range lines {
+		// The heading may also be formatted in bold rather than as a heading
+		isHeading := strings.HasPrefix(line, "#") || strings.HasPrefix(line, "**")
+		if isHeading && strings.EqualFold(strings.Trim(line, "#*: "), "explanation") {
+			return strings.TrimSpace(strings.Join(lines[i+1:], "\n"))
+		}
+	}
+
+	return prose
+}
@@ -0,0 +1,200 @@
+package libaiac
+
+import (
+	"strings"
+	"unicode"
+)
+
+// FallbackFilename is the filename used for generated code when its type
+// cannot be detected.
+const FallbackFilename = "output.txt"
+
+// codeKind describes a kind of generated code that may be requested in a
+// prompt.
+type codeKind struct {
+	// name identifies the kind in the configuration (see
+	// BackendConfig.ModelByKind).
+	name string
+
+	// filename is the default name of a file holding code of this kind.
+	filename string
+
+	// keywords are words or phrases in prompts that request this kind.
+	keywords []string
+
+	// languages are the code block language hints expected for this kind.
+	languages []string
+}
+
+// codeKinds are the known kinds of generated code. More specific kinds come
+// before the more general ones they overlap with (e.g. Docker Compose before
+// Dockerfile).
+var codeKinds = []codeKind{
+	{
+		"docker-compose", "docker-compose.yaml",
+		[]string{"docker compose", "docker-compose", "compose"}, []string{"yaml", "yml"},
+	},
+	{"dockerfile", "Dockerfile", []string{"dockerfile", "docker"}, []string{"dockerfile", "docker"}},
+	{
+		"github-actions", "workflow.yml",
+		[]string{"github actions", "github action", "github workflow"}, []string{"yaml", "yml"},
+	},
+	{"gitlab-ci", ".gitlab-ci.yml", []string{"gitlab ci", "gitlab"}, []string{"yaml", "yml"}},
+	{"jenkins", "Jenkinsfile", []string{"jenkinsfile", "jenkins"}, []string{"groovy", "jenkinsfile"}},
+	{"terraform", "main.tf", []string{"terraform", "opentofu", "tofu"}, []string{"hcl", "terraform", "tf"}},
+	{"bicep", "main.bicep", []string{"bicep"}, []string{"bicep"}},
+	{"cloudformation", "template.yaml", []string{"cloudformation", "cfn"}, []string{"yaml", "yml"}},
+	{"cloudformation", "template.json", []string{"cloudformation", "cfn", "arm template"}, []string{"json"}},
+	{"ansible", "playbook.yml", []string{"ansible", "playbook"}, []string{"yaml", "yml"}},
+	{"helm", "values.yaml", []string{"helm"}, []string{"yaml", "yml"}},
+	{"k8s", "manifest.yaml", []string{"kubernetes", "k8s", "kubectl", "kustomize"}, []string{"yaml", "yml"}},
+	{"makefile", "Makefile", []string{"makefile"}, []string{"makefile", "make"}},
+	{"nginx", "nginx.conf", []string{"nginx"}, []string{"nginx", "conf"}},
+	{"python", "main.py", []string{"python"}, []string{"python", "py"}},
+	{"typescript", "index.ts", []string{"typescript"}, []string{"typescript", "ts"}},
+	{"javascript", "index.js", []string{"javascript", "nodejs"}, []string{"javascript", "js"}},
+	{"go", "main.go", []string{"golang"}, []string{"go", "golang"}},
+	{"powershell", "script.ps1", []string{"powershell"}, []string{"powershell", "ps1", "pwsh"}},
+	{"bash", "script.sh", []string{"bash", "shell script"}, []string{"bash", "sh", "shell", "zsh"}},
+	{"sql", "query.sql", []string{"sql"}, []string{"sql", "postgresql", "mysql"}},
+}
+
+// languageFilenames are the default filenames for code whose kind can only be
+// detected from the language hint of its code block.
+var languageFilenames = map[string]string{
+	"hcl": "main.tf", "terraform": "main.tf", "tf": "main.tf",
+	"dockerfile": "Dockerfile", "docker": "Dockerfile",
+	"bicep":  "main.bicep",
+	"groovy": "Jenkinsfile", "jenkinsfile": "Jenkinsfile",
+	"makefile": "Makefile", "make": "Makefile",
+	"nginx":  "nginx.conf",
+	"python": "main.py", "py": "main.py",
+	"typescript": "index.ts", "ts": "index.ts",
+	"javascript": "index.js", "js": "index.js",
+	"go": "main.go", "golang": "main.go",
+	"powershell": "script.ps1", "ps1": "script.ps1", "pwsh": "script.ps1",
+	"bash": "script.sh", "sh": "script.sh", "shell": "script.sh", "zsh": "script.sh",
+	"sql": "query.sql", "postgresql": "query.sql", "mysql": "query.sql",
+	"yaml": "config.yaml", "yml": "config.yaml",
+	"json": "config.json",
+	"toml": "config.toml",
+}
+
+// DetectFilename suggests a filename for code generated in response to the
+// provided prompt. The kind of code requested in the prompt is detected by
+// keywords (e.g. "terraform" suggests "main.tf"), and the language hint of
+// the code block in the response (see types.ExtractCodeBlock) is used to
+// choose between kinds when the prompt mentions several of them, or on its
+// own when the prompt mentions none. If detection fails or is ambiguous,
+// FallbackFilename is returned with a false value.
+func DetectFilename(prompt, language string) (filename string, ok bool) {
+	words := " " + normalizeWords(prompt) + " "
+	language = strings.ToLower(language)
+
+	var requested []codeKind
+
+	for _, kind := range codeKinds {
+		for _, keyword := range kind.keywords {
+			if strings.Contains(words, " "+keyword+" ") {
+				requested = append(requested, kind)
+				break
+			}
+		}
+	}
+
+	if len(requested) == 1 {
+		return requested[0].filename, true
+	}
+
+	if len(requested) > 1 {
+		// The prompt mentions several kinds (e.g. "terraform for a docker
+		// host"), the first one matching the language wins, as kinds are
+		// ordered by specificity
+		for _, kind := range requested {
+			for _, lang := range kind.languages {
+				if lang == language {
+					return kind.filename, true
+				}
+			}
+		}
+
+		return FallbackFilename, false
+	}
+
+	if filename, ok := languageFilenames[language]; ok {
+		return filename, true
+	}
+
+	return FallbackFilename, false
+}
+
+// DetectKind returns the name of the kind of code requested in the provided
+// prompt (e.g. "terraform", "dockerfile" or "k8s"), detected by keywords as
+// with DetectFilename. If the prompt mentions several kinds, the one
+// mentioned first wins, so "terraform for a docker host" requests Terraform.
+// Returns an empty string if the prompt mentions none.
+func DetectKind(prompt string) string {
+	words := " " + normalizeWords(prompt) + " "
+
+	name, first := "", -1
+
+	for _, kind := range codeKinds {
+		for _, keyword := range kind.keywords {
+			// Kinds are ordered by specificity, so a kind only replaces
+			// another mentioned at the same position (e.g. "docker" in
+			// "docker compose") if it is mentioned earlier
+			i := strings.Index(words, " "+keyword+" ")
+			if i >= 0 && (first < 0 || i < first) {
+				name, first = kind.name, i
+			}
+		}
+	}
+
+	return name
+}
+
+// kindAliases returns the names a kind of code can be referenced by in the
+// configuration: its name, followed by its keywords (e.g. "k8s",
+// "kubernetes", "kubectl" and "kustomize").
+func kindAliases(name string) (aliases []string) {
+	for _, kind := range codeKinds {
+		if kind.name != name {
+			continue
+		}
+
+		if len(aliases) == 0 {
+			aliases = append(aliases, name)
+		}
+
+		aliases = append(aliases, kind.keywords...)
+	}
+
+	return aliases
+}
+
+// knownKind returns whether alias is the name or a keyword of a known kind of
+// code.
+func knownKind(alias string) bool {
+	for _, kind := range codeKinds {
+		if kind.name == alias {
+			return true
+		}
+
+		for _, keyword := range kind.keywords {
+			if keyword == alias {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// normalizeWords lowercases the provided string, and replaces any character
+// that isn't a letter, a digit or a hyphen with a space, so that words can be
+// matched regardless of punctuation.
+func normalizeWords(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	}), " ")
+}
@@ -0,0 +1,362 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/sse"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Conversation is a struct used to converse with a Gemini chat model. It
+// maintains all messages sent/received in order to maintain context.
+type Conversation struct {
+	backend      *Gemini
+	model        string
+	messages     []types.Message
+	extraHeaders map[string]string
+	params       types.Parameters
+
+	// images are attached to the next prompt (see AttachImages)
+	images []types.Image
+}
+
+type content struct {
+	Role  string `json:"role,omitempty"`
+	Parts []part `json:"parts"`
+}
+
+// part is a part of a message. Parts of responses that are the model's
+// thoughts, which are only returned if requested, have Thought set.
+type part struct {
+	Text       string      `json:"text,omitempty"`
+	Thought    bool        `json:"thought,omitempty"`
+	InlineData *inlineData `json:"inline_data,omitempty"`
+}
+
+// inlineData is the content of an image attached to a message, encoded as
+// base64.
+type inlineData struct {
+	MimeType string `json:"mime_type"`
+	Data     string `json:"data"`
+}
+
+type generateResponse struct {
+	Candidates []struct {
+		Content      content `json:"content"`
+		FinishReason string  `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int64 `json:"promptTokenCount"`
+		CandidatesTokenCount int64 `json:"candidatesTokenCount"`
+		TotalTokenCount      int64 `json:"totalTokenCount"`
+
+		// Thinking tokens are not included in the candidates' tokens
+		ThoughtsTokenCount int64 `json:"thoughtsTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// Chat initiates a conversation with a Gemini chat model. A conversation
+// maintains context, allowing to send further instructions to modify the output
+// from previous requests. The name of the model to use must be provided. Users
+// can also supply zero or more "previous messages" that may have been exchanged
+// in the past. This practically allows "loading" previous conversations and
+// continuing them.
+func (backend *Gemini) Chat(model string, msgs ...types.Message) types.Conversation {
+	conv := &Conversation{
+		backend: backend,
+		model:   model,
+	}
+
+	if len(msgs) > 0 {
+		conv.messages = msgs
+	}
+
+	return conv
+}
+
+// Send sends the provided message to the API and returns a Response object.
+// To maintain context, all previous messages (whether from you to the API or
+// vice-versa) are sent as well, allowing you to ask the API to modify the
+// code it already generated.
+func (conv *Conversation) Send(ctx context.Context, prompt string) (
+	res types.Response,
+	err error,
+) {
+	var answer generateResponse
+
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	req := conv.backend.
+		NewRequest("POST", fmt.Sprintf("/models/%s:generateContent", conv.model)).
+		JSONBody(conv.requestBody()).
+		Into(&answer)
+
+	for key, val := range conv.extraHeaders {
+		req.Header(key, val)
+	}
+
+	err = conv.backend.authorize(req)
+	if err != nil {
+		return res, err
+	}
+
+	err = req.RunContext(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if len(answer.Candidates) == 0 {
+		return res, types.ErrNoResults
+	}
+
+	var output, thoughts strings.Builder
+	for _, p := range answer.Candidates[0].Content.Parts {
+		if p.Thought {
+			thoughts.WriteString(p.Text)
+		} else {
+			output.WriteString(p.Text)
+		}
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.Reasoning = strings.TrimSpace(thoughts.String())
+	res.APIKeyUsed = conv.backend.apiKey
+	res.TokensUsed = answer.UsageMetadata.TotalTokenCount
+	res.InputTokens = answer.UsageMetadata.PromptTokenCount
+	res.OutputTokens = answer.UsageMetadata.CandidatesTokenCount + answer.UsageMetadata.ThoughtsTokenCount
+	res.ReasoningTokens = answer.UsageMetadata.ThoughtsTokenCount
+	res.StopReason = answer.Candidates[0].FinishReason
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
+// Stream is the same as Send, but streams the response from the API, writing
+// the generated text to w as it arrives.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	var output, thoughts strings.Builder
+
+	req := conv.backend.
+		NewRequest("POST", fmt.Sprintf("/models/%s:streamGenerateContent", conv.model)).
+		QueryParam("alt", "sse").
+		Header("Accept", "text/event-stream").
+		JSONBody(conv.requestBody()).
+		// The body handler is only called if a target is provided
+		Into(&output).
+		BodyHandler(func(_ int, _ string, body io.Reader, _ interface{}) error {
+			return sse.Read(body, func(data []byte) error {
+				var chunk generateResponse
+				if err := json.Unmarshal(data, &chunk); err != nil {
+					return fmt.Errorf("failed parsing stream chunk: %w", err)
+				}
+
+				if chunk.UsageMetadata.TotalTokenCount > 0 {
+					res.TokensUsed = chunk.UsageMetadata.TotalTokenCount
+					res.InputTokens = chunk.UsageMetadata.PromptTokenCount
+					res.OutputTokens = chunk.UsageMetadata.CandidatesTokenCount +
+						chunk.UsageMetadata.ThoughtsTokenCount
+					res.ReasoningTokens = chunk.UsageMetadata.ThoughtsTokenCount
+				}
+
+				if len(chunk.Candidates) == 0 {
+					return nil
+				}
+
+				if chunk.Candidates[0].FinishReason != "" {
+					res.StopReason = chunk.Candidates[0].FinishReason
+				}
+
+				for _, p := range chunk.Candidates[0].Content.Parts {
+					if p.Thought {
+						thoughts.WriteString(p.Text)
+						continue
+					}
+
+					output.WriteString(p.Text)
+					if _, err := io.WriteString(w, p.Text); err != nil {
+						return err
+					}
+				}
+
+				return nil
+			})
+		})
+
+	for key, val := range conv.extraHeaders {
+		req.Header(key, val)
+	}
+
+	err = conv.backend.authorize(req)
+	if err != nil {
+		return res, err
+	}
+
+	err = req.RunContext(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if output.Len() == 0 {
+		return res, types.ErrNoResults
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.Reasoning = strings.TrimSpace(thoughts.String())
+	res.APIKeyUsed = conv.backend.apiKey
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
+// Messages returns all the messages that have been exchanged between the user
+// and the assistant up to this point.
+func (conv *Conversation) Messages() []types.Message {
+	return conv.messages
+}
+
+// AttachImages attaches images to the next prompt sent in the conversation.
+func (conv *Conversation) AttachImages(images ...types.Image) error {
+	conv.images = append(conv.images, images...)
+	return nil
+}
+
+// userMessage returns the message of a prompt, with the images attached to
+// the conversation, which are then detached from it.
+func (conv *Conversation) userMessage(prompt string) types.Message {
+	msg := types.Message{Role: "user", Content: prompt, Images: conv.images}
+	conv.images = nil
+
+	return msg
+}
+
+// AddHeader adds an extra HTTP header that will be added to every HTTP
+// request issued as part of this conversation. Any headers added will be in
+// addition to any extra headers defined for the backend itself, and will
+// take precedence over them.
+func (conv *Conversation) AddHeader(key, val string) {
+	if conv.extraHeaders == nil {
+		conv.extraHeaders = make(map[string]string)
+	}
+	conv.extraHeaders[key] = val
+}
+
+// requestBody builds the body of a generation request. The system prompt, if
+// any, is sent as the system instruction, as Gemini does not accept system
+// messages in the conversation.
+func (conv *Conversation) requestBody() map[string]interface{} {
+	system, msgs := types.SplitSystem(conv.messages)
+
+	body := map[string]interface{}{
+		"contents":         toContents(msgs),
+		"generationConfig": conv.generationConfig(),
+	}
+
+	if system != "" {
+		body["systemInstruction"] = content{Parts: []part{{Text: system}}}
+	}
+
+	return body
+}
+
+// toContents converts aiac messages into Gemini's content format, with images
+// as inline data following the text. Gemini only recognizes the "user" and
+// "model" roles, so any role other than "user" is considered to be the model.
+func toContents(msgs []types.Message) []content {
+	contents := make([]content, len(msgs))
+	for i, msg := range msgs {
+		role := "model"
+		if msg.Role == "user" {
+			role = "user"
+		}
+
+		contents[i] = content{
+			Role:  role,
+			Parts: []part{{Text: msg.Content}},
+		}
+
+		for _, img := range msg.Images {
+			contents[i].Parts = append(contents[i].Parts, part{
+				InlineData: &inlineData{MimeType: img.MediaType, Data: img.Base64()},
+			})
+		}
+	}
+
+	return contents
+}
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// generationConfig builds the generation configuration for a request,
+// translating the conversation's generation parameters to their Gemini
+// equivalents. A reasoning effort is translated to a budget of thinking
+// tokens (see types.ReasoningBudget), with the model's thoughts included in
+// responses. Extra parameters are included in the configuration (e.g.
+// "topK"), unless overridden.
+func (conv *Conversation) generationConfig() map[string]interface{} {
+	config := make(map[string]interface{})
+	for key, val := range conv.params.Extra {
+		config[key] = val
+	}
+
+	config["temperature"] = conv.params.TemperatureOrDefault()
+
+	if conv.params.TopP != nil {
+		config["topP"] = *conv.params.TopP
+	}
+
+	if conv.params.MaxTokens != nil {
+		config["maxOutputTokens"] = *conv.params.MaxTokens
+	}
+
+	if len(conv.params.Stop) > 0 {
+		config["stopSequences"] = conv.params.Stop
+	}
+
+	if conv.params.Format == types.FormatJSON {
+		config["responseMimeType"] = "application/json"
+	}
+
+	if conv.params.Seed != nil {
+		config["seed"] = *conv.params.Seed
+	}
+
+	if budget := types.ReasoningBudget(conv.params.ReasoningEffort); budget > 0 {
+		config["thinkingConfig"] = map[string]interface{}{
+			"thinkingBudget":  budget,
+			"includeThoughts": true,
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,217 @@
+package gemini
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/ido50/requests"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// DefaultAPIURL is the default URL for the public Generative Language API
+	DefaultAPIURL = "https://generativelanguage.googleapis.com/v1beta"
+
+	// DefaultGCPLocation is the default location to use with Vertex AI if the
+	// backend does not specify one
+	DefaultGCPLocation = "us-central1"
+
+	// vertexScope is the OAuth2 scope requested when authenticating with
+	// Vertex AI
+	vertexScope = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// Gemini is a structure used to continuously generate IaC code via Google's
+// Gemini models, either through the public Generative Language API or
+// through Vertex AI.
+type Gemini struct {
+	*requests.HTTPClient
+	apiKey      string
+	tokenSource oauth2.TokenSource
+
+	// models is the client used for listing models. With the public API,
+	// this is the same as the main client, but Vertex AI lists publisher
+	// models under a different URL.
+	models *requests.HTTPClient
+}
+
+// Options is a struct containing all the parameters accepted by the New
+// constructor.
+type Options struct {
+	// APIKey is the API key to use with the public Generative Language API.
+	// Mutually exclusive with GCPProject.
+	APIKey string
+
+	// GCPProject is the Google Cloud project to use with Vertex AI. When set,
+	// authentication is performed using Application Default Credentials.
+	// Mutually exclusive with APIKey.
+	GCPProject string
+
+	// GCPLocation is the Google Cloud location to use with Vertex AI.
+	// Optional, defaults to DefaultGCPLocation.
+	GCPLocation string
+
+	// URL is the base URL of the API, under which model paths reside (e.g.
+	// "/models/gemini-1.5-pro:generateContent"). Optional, defaults to
+	// DefaultAPIURL for the public API, or to the publisher URL of the
+	// configured project and location for Vertex AI.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// New creates a new instance of the Gemini struct, with the provided input
+// options. The API is not yet contacted at this point, but when using Vertex
+// AI, Application Default Credentials are looked up. An error is returned if
+// neither an API key nor a project are provided, or if both are.
+func New(ctx context.Context, opts *Options) (*Gemini, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	switch {
+	case opts.APIKey != "" && opts.GCPProject != "":
+		return nil, fmt.Errorf(
+			"%w: gemini backends accept either an api_key (Generative Language API) "+
+				"or a gcp_project (Vertex AI), not both",
+			types.ErrInvalidBackendConfig,
+		)
+	case opts.APIKey == "" && opts.GCPProject == "":
+		return nil, fmt.Errorf(
+			"%w: gemini backends require either an api_key or a gcp_project",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	backend := &Gemini{apiKey: opts.APIKey}
+
+	var modelsURL string
+
+	if opts.GCPProject != "" {
+		if opts.GCPLocation == "" {
+			opts.GCPLocation = DefaultGCPLocation
+		}
+
+		if opts.URL == "" {
+			opts.URL = fmt.Sprintf(
+				"https://%s-aiplatform.googleapis.com/v1/projects/%s/locations/%s/publishers/google",
+				opts.GCPLocation, opts.GCPProject, opts.GCPLocation,
+			)
+
+			// Vertex AI only lists publisher models via the v1beta1 API,
+			// outside of the project's path
+			modelsURL = fmt.Sprintf(
+				"https://%s-aiplatform.googleapis.com/v1beta1/publishers/google",
+				opts.GCPLocation,
+			)
+		}
+
+		tokenSource, err := google.DefaultTokenSource(ctx, vertexScope)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading Google Cloud credentials: %w", err)
+		}
+
+		backend.tokenSource = tokenSource
+	} else if opts.URL == "" {
+		opts.URL = DefaultAPIURL
+	}
+
+	headers := make(map[string]string, len(opts.ExtraHeaders)+1)
+	if backend.apiKey != "" {
+		headers["x-goog-api-key"] = backend.apiKey
+	}
+
+	for header, value := range opts.ExtraHeaders {
+		headers[header] = value
+	}
+
+	backend.HTTPClient = newClient(opts.URL, headers, opts.HTTPClient)
+
+	backend.models = backend.HTTPClient
+	if modelsURL != "" {
+		backend.models = newClient(modelsURL, headers, opts.HTTPClient)
+	}
+
+	return backend, nil
+}
+
+func newClient(
+	url string,
+	headers map[string]string,
+	httpClient *http.Client,
+) *requests.HTTPClient {
+	cli := requests.NewClient(url).
+		Accept("application/json").
+		Timeout(types.NoTimeout).
+		ErrorHandler(func(
+			httpStatus int,
+			contentType string,
+			body io.Reader,
+		) error {
+			var res []struct {
+				Error struct {
+					Status  string `json:"status"`
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+
+			// Errors are sometimes returned as a single object, and
+			// sometimes as an array of objects
+			data, err := io.ReadAll(body)
+			if err == nil && len(data) > 0 && data[0] == '{' {
+				data = append(append([]byte{'['}, data...), ']')
+			}
+
+			if err != nil ||
+				json.Unmarshal(data, &res) != nil ||
+				len(res) == 0 ||
+				res[0].Error.Message == "" {
+				return &types.APIError{Provider: "Gemini", StatusCode: httpStatus}
+			}
+
+			return &types.APIError{
+				Provider:   "Gemini",
+				StatusCode: httpStatus,
+				Code:       res[0].Error.Status,
+				Message:    res[0].Error.Message,
+			}
+		})
+
+	for header, value := range headers {
+		cli.Header(header, value)
+	}
+
+	if httpClient != nil {
+		cli.CustomHTTPClient(httpClient)
+	}
+
+	return cli
+}
+
+// authorize adds authentication to the provided request, if necessary. API
+// key authentication is handled by the client itself, so this only applies
+// to Vertex AI, which requires an OAuth2 bearer token.
+func (backend *Gemini) authorize(req *requests.HTTPRequest) error {
+	if backend.tokenSource == nil {
+		return nil
+	}
+
+	token, err := backend.tokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("failed obtaining Google Cloud access token: %w", err)
+	}
+
+	req.Header("Authorization", fmt.Sprintf("Bearer %s", token.AccessToken))
+
+	return nil
+}
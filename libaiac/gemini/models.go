@@ -0,0 +1,97 @@
+package gemini
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// ListModels returns a list of all the models supported by this backend.
+// Only models supporting content generation are returned.
+func (backend *Gemini) ListModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	var answer struct {
+		Models []struct {
+			Name                       string   `json:"name"`
+			DisplayName                string   `json:"displayName"`
+			InputTokenLimit            int      `json:"inputTokenLimit"`
+			SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+		} `json:"models"`
+		PublisherModels []struct {
+			Name string `json:"name"`
+		} `json:"publisherModels"`
+		NextPageToken string `json:"nextPageToken"`
+	}
+
+	var pageToken string
+
+	for {
+		req := backend.models.NewRequest("GET", "/models").Into(&answer)
+		if pageToken != "" {
+			req.QueryParam("pageToken", pageToken)
+		}
+
+		answer.Models = nil
+		answer.PublisherModels = nil
+		answer.NextPageToken = ""
+
+		err = backend.authorize(req)
+		if err != nil {
+			return models, err
+		}
+
+		err = req.RunContext(ctx)
+		if err != nil {
+			return models, fmt.Errorf("failed listing models: %w", err)
+		}
+
+		for _, model := range answer.Models {
+			if supportsGeneration(model.SupportedGenerationMethods) {
+				models = append(models, types.Model{
+					ID:            strings.TrimPrefix(model.Name, "models/"),
+					Name:          model.DisplayName,
+					Owner:         "Google",
+					ContextWindow: model.InputTokenLimit,
+				})
+			}
+		}
+
+		for _, model := range answer.PublisherModels {
+			models = append(models, types.Model{
+				ID:    strings.TrimPrefix(model.Name, "publishers/google/models/"),
+				Owner: "Google",
+			})
+		}
+
+		if answer.NextPageToken == "" {
+			break
+		}
+
+		pageToken = answer.NextPageToken
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
+
+func supportsGeneration(methods []string) bool {
+	for _, method := range methods {
+		if method == "generateContent" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,214 @@
+package libaiac
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// GuardrailsConfig holds configuration for the checks generated code is
+// scanned with for insecure patterns and secrets (see Guardrails).
+type GuardrailsConfig struct {
+	// Enabled determines whether generated code is scanned. Scanning can also
+	// be enabled or disabled from the command line.
+	Enabled bool `toml:"enabled"`
+
+	// Strict determines whether code with findings is refused rather than
+	// saved.
+	Strict bool `toml:"strict"`
+
+	// Checks are the names of the built-in checks to run (see
+	// BuiltinGuardrails). Defaults to all of them; an empty list disables
+	// them, leaving only Rules.
+	Checks *[]string `toml:"checks"`
+
+	// Rules are additional checks, by name, matching lines of code with
+	// regular expressions.
+	Rules map[string]GuardrailRule `toml:"rules"`
+}
+
+// GuardrailRule is a check matching lines of generated code with a regular
+// expression.
+type GuardrailRule struct {
+	// Pattern is the regular expression (in the syntax of the regexp
+	// package) lines with findings match.
+	Pattern string `toml:"pattern"`
+
+	// Message describes the problem with matching lines. Defaults to the
+	// name of the rule.
+	Message string `toml:"message"`
+}
+
+// BuiltinGuardrails are the built-in checks of generated code, by name. They
+// are deliberately simple, and meant to catch common mistakes rather than
+// replace a security scanner.
+var BuiltinGuardrails = map[string]GuardrailRule{
+	"open-ingress": {
+		Pattern: `(0\.0\.0\.0/0|::/0)`,
+		Message: "network access open to the entire internet",
+	},
+	"insecure-tls": {
+		Pattern: `(?i)(insecure[_-]?skip[_-]?(tls[_-]?)?verify|skip[_-]?tls[_-]?verify)["']?\s*[:=]\s*["']?true`,
+		Message: "TLS certificate verification disabled",
+	},
+	"hardcoded-secret": {
+		Pattern: `(?i)\b[a-z_]*(password|passwd|secret|token|api_?key)\b["']?\s*[:=]\s*["'][^"'$%{}<>\s]{8,}["']`,
+		Message: "secret hardcoded in code",
+	},
+	"aws-access-key": {
+		Pattern: `\b(AKIA|ASIA)[0-9A-Z]{16}\b`,
+		Message: "AWS access key ID in code",
+	},
+	"private-key": {
+		Pattern: `-----BEGIN [A-Z ]*PRIVATE KEY-----`,
+		Message: "private key in code",
+	},
+	"public-bucket": {
+		Pattern: `(?i)\bacl["']?\s*[:=]\s*["'](public-read|public-read-write)["']`,
+		Message: "storage bucket readable by anyone",
+	},
+	"wildcard-iam": {
+		Pattern: `(?i)"?actions?"?\s*[:=]\s*\[?\s*"\*"`,
+		Message: "IAM policy allows every action",
+	},
+}
+
+// Guardrails scan generated code for insecure patterns and secrets, such as
+// security groups open to the internet or hardcoded credentials.
+type Guardrails struct {
+	// Strict is true if code with findings should be refused.
+	Strict bool
+
+	rules []guardrail
+}
+
+type guardrail struct {
+	name    string
+	message string
+	re      *regexp.Regexp
+}
+
+// Finding is a line of generated code that matched a guardrail check.
+type Finding struct {
+	// Rule is the name of the check that matched.
+	Rule string `json:"rule"`
+
+	// Message describes the problem.
+	Message string `json:"message"`
+
+	// Line is the number of the line that matched, starting at 1.
+	Line int `json:"line"`
+
+	// Text is the line that matched, without surrounding whitespace.
+	Text string `json:"text"`
+}
+
+// String returns a finding as a human-readable line.
+func (f Finding) String() string {
+	return fmt.Sprintf("line %d: [%s] %s: %s", f.Line, f.Rule, f.Message, f.Text)
+}
+
+// NewGuardrails creates guardrails from the provided configuration, whether
+// or not it is enabled. An error wrapping types.ErrInvalidGuardrail is
+// returned if it references checks that do not exist, or rules have invalid
+// patterns.
+func NewGuardrails(conf GuardrailsConfig) (*Guardrails, error) {
+	g := &Guardrails{Strict: conf.Strict}
+
+	var checks []string
+	if conf.Checks != nil {
+		checks = *conf.Checks
+	} else {
+		for name := range BuiltinGuardrails {
+			checks = append(checks, name)
+		}
+	}
+
+	var errs []error
+
+	for _, name := range checks {
+		rule, ok := BuiltinGuardrails[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf(
+				"guardrails check %q: %w (no such built-in check)",
+				name, types.ErrInvalidGuardrail,
+			))
+			continue
+		}
+
+		g.rules = append(g.rules, guardrail{
+			name:    name,
+			message: rule.Message,
+			re:      regexp.MustCompile(rule.Pattern),
+		})
+	}
+
+	// Iterate over rules in a stable order so errors are reported
+	// consistently
+	names := make([]string, 0, len(conf.Rules))
+	for name := range conf.Rules {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		rule := conf.Rules[name]
+		if rule.Pattern == "" {
+			errs = append(errs, fmt.Errorf(
+				"guardrails rule %q: %w: pattern is empty",
+				name, types.ErrInvalidGuardrail,
+			))
+			continue
+		}
+
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf(
+				"guardrails rule %q: %w: %s",
+				name, types.ErrInvalidGuardrail, err,
+			))
+			continue
+		}
+
+		message := rule.Message
+		if message == "" {
+			message = name
+		}
+
+		g.rules = append(g.rules, guardrail{name: name, message: message, re: re})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	sort.Slice(g.rules, func(i, j int) bool {
+		return g.rules[i].name < g.rules[j].name
+	})
+
+	return g, nil
+}
+
+// Scan scans code line by line, returning every finding, ordered by line and
+// rule. A line may have findings of multiple rules.
+func (g *Guardrails) Scan(code string) (findings []Finding) {
+	for i, line := range strings.Split(code, "\n") {
+		for _, rule := range g.rules {
+			if rule.re.MatchString(line) {
+				findings = append(findings, Finding{
+					Rule:    rule.name,
+					Message: rule.message,
+					Line:    i + 1,
+					Text:    strings.TrimSpace(line),
+				})
+			}
+		}
+	}
+
+	return findings
+}
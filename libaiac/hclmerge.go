@@ -0,0 +1,415 @@
+package libaiac
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Preferences resolving conflicts between generated and existing blocks when
+// merging HCL (see MergeHCL).
+const (
+	// MergePreferNone fails the merge when blocks conflict.
+	MergePreferNone = ""
+
+	// MergePreferGenerated replaces conflicting existing blocks with the
+	// generated ones.
+	MergePreferGenerated = "generated"
+
+	// MergePreferExisting keeps conflicting existing blocks, discarding the
+	// generated ones.
+	MergePreferExisting = "existing"
+)
+
+// MergeResult is the result of merging generated HCL into existing HCL.
+type MergeResult struct {
+	// Code is the merged code.
+	Code string
+
+	// Added are the addresses of the generated blocks and attributes that
+	// were appended, as they did not exist.
+	Added []string
+
+	// Replaced are the addresses of the existing blocks and attributes that
+	// conflicted with generated ones, and were replaced by them.
+	Replaced []string
+
+	// Kept are the addresses of the existing blocks and attributes that
+	// conflicted with generated ones, and were kept.
+	Kept []string
+
+	// Unchanged are the addresses of the generated blocks and attributes that
+	// were identical to existing ones, ignoring formatting.
+	Unchanged []string
+}
+
+// Changed returns whether merging changed the existing code.
+func (res MergeResult) Changed() bool {
+	return len(res.Added) > 0 || len(res.Replaced) > 0
+}
+
+// MergeHCL merges generated HCL (e.g. Terraform code) into existing HCL,
+// appending the generated blocks and top-level attributes that do not exist
+// yet, and leaving the rest of the existing code, including its comments and
+// formatting, as it is. Blocks are matched by their address: their type and
+// labels (e.g. "aws_s3_bucket.logs" for resources, "data.aws_ami.ubuntu",
+// "module.vpc" or "var.region"), plus their alias for providers. Local values
+// are matched one by one ("local.name"), and new ones are appended in a new
+// locals block. Blocks without labels that may repeat (e.g. "moved") are
+// appended unless an identical one exists.
+//
+// Generated blocks identical to existing ones, ignoring formatting, are left
+// as they are. Those that differ conflict, and are resolved according to
+// prefer: MergePreferGenerated replaces the contents of the existing block in
+// place, keeping the comments above it, and MergePreferExisting keeps it. With
+// MergePreferNone, an error wrapping types.ErrMergeConflict listing the
+// conflicting addresses is returned. An error wrapping types.ErrInvalidHCL is
+// returned if either code cannot be parsed.
+func MergeHCL(existing, generated, prefer string) (res MergeResult, err error) {
+	switch prefer {
+	case MergePreferNone, MergePreferGenerated, MergePreferExisting:
+	default:
+		return res, fmt.Errorf(
+			"unknown merge preference %q, expected %q or %q",
+			prefer, MergePreferGenerated, MergePreferExisting,
+		)
+	}
+
+	// Appended blocks must start on a line of their own
+	if existing != "" && !strings.HasSuffix(existing, "\n") {
+		existing += "\n"
+	}
+
+	dst, err := parseHCL(existing, "existing code")
+	if err != nil {
+		return res, err
+	}
+
+	src, err := parseHCL(generated, "generated code")
+	if err != nil {
+		return res, err
+	}
+
+	m := &hclMerge{dst: dst.Body(), prefer: prefer, res: &res}
+	m.index()
+
+	for _, name := range attributeOrder(generated) {
+		m.mergeAttribute(name, src.Body().GetAttribute(name))
+	}
+
+	var locals []*hclwrite.Block
+	for _, block := range src.Body().Blocks() {
+		if block.Type() == "locals" {
+			locals = append(locals, block)
+			continue
+		}
+
+		m.mergeBlock(block)
+	}
+
+	m.mergeLocals(locals, generated)
+
+	if len(m.conflicts) > 0 {
+		return MergeResult{}, fmt.Errorf(
+			"%w: %s",
+			types.ErrMergeConflict, strings.Join(m.conflicts, ", "),
+		)
+	}
+
+	res.Code = string(dst.Bytes())
+
+	return res, nil
+}
+
+// hclMerge holds the state of a merge of HCL (see MergeHCL).
+type hclMerge struct {
+	dst    *hclwrite.Body
+	prefer string
+	res    *MergeResult
+
+	// blocks are the existing blocks by address, and locals the existing
+	// locals blocks by the name of the local values they define
+	blocks map[string]*hclwrite.Block
+	locals map[string]*hclwrite.Block
+
+	// unlabeled are the existing blocks without a unique address
+	unlabeled []*hclwrite.Block
+
+	// appended is true once anything was appended to the existing code
+	appended bool
+
+	// conflicts are the addresses of unresolved conflicts
+	conflicts []string
+}
+
+// index indexes the existing blocks by address.
+func (m *hclMerge) index() {
+	m.blocks = make(map[string]*hclwrite.Block)
+	m.locals = make(map[string]*hclwrite.Block)
+
+	for _, block := range m.dst.Blocks() {
+		switch address, ok := blockAddress(block); {
+		case block.Type() == "locals":
+			for name := range block.Body().Attributes() {
+				m.locals[name] = block
+			}
+		case ok:
+			m.blocks[address] = block
+		default:
+			m.unlabeled = append(m.unlabeled, block)
+		}
+	}
+}
+
+// mergeBlock merges a generated block other than a locals block.
+func (m *hclMerge) mergeBlock(block *hclwrite.Block) {
+	address, ok := blockAddress(block)
+	if !ok {
+		for _, existing := range m.unlabeled {
+			if sameTokens(existing.Body().BuildTokens(nil), block.Body().BuildTokens(nil)) {
+				m.res.Unchanged = append(m.res.Unchanged, block.Type())
+				return
+			}
+		}
+
+		m.unlabeled = append(m.unlabeled, m.appendBlock(block))
+		m.res.Added = append(m.res.Added, block.Type())
+
+		return
+	}
+
+	existing, ok := m.blocks[address]
+	if !ok {
+		// Later generated blocks with the same address conflict with this
+		// one, rather than being appended again
+		m.blocks[address] = m.appendBlock(block)
+		m.res.Added = append(m.res.Added, address)
+
+		return
+	}
+
+	if !m.conflict(address, existing.Body().BuildTokens(nil), block.Body().BuildTokens(nil)) {
+		return
+	}
+
+	existing.Body().Clear()
+	existing.Body().AppendUnstructuredTokens(block.Body().BuildTokens(nil))
+}
+
+// mergeAttribute merges a generated top-level attribute, as found in
+// variable definitions files (e.g. terraform.tfvars).
+func (m *hclMerge) mergeAttribute(name string, attr *hclwrite.Attribute) {
+	existing := m.dst.GetAttribute(name)
+	if existing == nil {
+		m.append(attr.BuildTokens(nil))
+		m.res.Added = append(m.res.Added, name)
+
+		return
+	}
+
+	if m.conflict(name, existing.Expr().BuildTokens(nil), attr.Expr().BuildTokens(nil)) {
+		m.dst.SetAttributeRaw(name, attr.Expr().BuildTokens(nil))
+	}
+}
+
+// mergeLocals merges the local values of generated locals blocks. Values
+// that do not exist yet are appended in a single new locals block, in the
+// order they are generated in.
+func (m *hclMerge) mergeLocals(blocks []*hclwrite.Block, generated string) {
+	if len(blocks) == 0 {
+		return
+	}
+
+	// Attributes of blocks are unordered, so their order is taken from the
+	// source
+	attrs := make(map[string]*hclwrite.Attribute)
+	for _, block := range blocks {
+		for name, attr := range block.Body().Attributes() {
+			attrs[name] = attr
+		}
+	}
+
+	var added hclwrite.Tokens
+	for _, name := range localsOrder(generated) {
+		attr, ok := attrs[name]
+		if !ok {
+			continue
+		}
+
+		address := "local." + name
+
+		existing, ok := m.locals[name]
+		if !ok {
+			added = append(added, attr.BuildTokens(nil)...)
+			m.res.Added = append(m.res.Added, address)
+			continue
+		}
+
+		current := existing.Body().GetAttribute(name).Expr().BuildTokens(nil)
+		if m.conflict(address, current, attr.Expr().BuildTokens(nil)) {
+			existing.Body().SetAttributeRaw(name, attr.Expr().BuildTokens(nil))
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	block := hclwrite.NewBlock("locals", nil)
+	block.Body().AppendUnstructuredTokens(added)
+	m.append(block.BuildTokens(nil))
+}
+
+// conflict handles a generated block or attribute whose address exists,
+// whose existing and generated contents are provided. It returns whether the
+// existing contents must be replaced, according to the merge preference.
+func (m *hclMerge) conflict(address string, existing, generated hclwrite.Tokens) bool {
+	switch {
+	case sameTokens(existing, generated):
+		m.res.Unchanged = append(m.res.Unchanged, address)
+	case m.prefer == MergePreferGenerated:
+		m.res.Replaced = append(m.res.Replaced, address)
+		return true
+	case m.prefer == MergePreferExisting:
+		m.res.Kept = append(m.res.Kept, address)
+	default:
+		m.conflicts = append(m.conflicts, address)
+	}
+
+	return false
+}
+
+// append appends tokens to the existing code, separated from what precedes
+// them by an empty line.
+func (m *hclMerge) append(tokens hclwrite.Tokens) {
+	m.separate()
+	m.dst.AppendUnstructuredTokens(tokens)
+}
+
+// appendBlock appends a generated block to the existing code, separated from
+// what precedes it by an empty line, and returns it as appended.
+func (m *hclMerge) appendBlock(block *hclwrite.Block) *hclwrite.Block {
+	m.separate()
+	return m.dst.AppendBlock(block)
+}
+
+// separate appends an empty line to the existing code, unless it is empty.
+func (m *hclMerge) separate() {
+	if m.appended || len(m.dst.BuildTokens(nil)) > 0 {
+		m.dst.AppendNewline()
+	}
+
+	m.appended = true
+}
+
+// parseHCL parses HCL for merging, returning an error wrapping
+// types.ErrInvalidHCL if it is not valid.
+func parseHCL(code, what string) (*hclwrite.File, error) {
+	file, diags := hclwrite.ParseConfig([]byte(code), what, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("%w: %s", types.ErrInvalidHCL, diags.Error())
+	}
+
+	return file, nil
+}
+
+// blockAddressPrefixes map the types of blocks to the prefix of their
+// address, as used in references to them. Resources are referenced by their
+// labels alone.
+var blockAddressPrefixes = map[string]string{
+	"resource": "",
+	"variable": "var.",
+}
+
+// uniqueUnlabeledBlocks are the types of blocks without labels that may only
+// appear once, so that their type is their address.
+var uniqueUnlabeledBlocks = map[string]bool{
+	"terraform": true,
+}
+
+// blockAddress returns the address of a block (see MergeHCL), or false if it
+// has none, as its type may repeat without labels.
+func blockAddress(block *hclwrite.Block) (string, bool) {
+	labels := block.Labels()
+	if len(labels) == 0 {
+		return block.Type(), uniqueUnlabeledBlocks[block.Type()]
+	}
+
+	prefix, ok := blockAddressPrefixes[block.Type()]
+	if !ok {
+		prefix = block.Type() + "."
+	}
+
+	address := prefix + strings.Join(labels, ".")
+
+	// Providers may be configured several times, with different aliases
+	if block.Type() == "provider" {
+		if alias := block.Body().GetAttribute("alias"); alias != nil {
+			address += "." + strings.Trim(string(alias.Expr().BuildTokens(nil).Bytes()), "\" ")
+		}
+	}
+
+	return address, true
+}
+
+// sameTokens returns whether two sequences of tokens are the same code,
+// ignoring formatting.
+func sameTokens(a, b hclwrite.Tokens) bool {
+	return bytes.Equal(
+		bytes.TrimSpace(hclwrite.Format(a.Bytes())),
+		bytes.TrimSpace(hclwrite.Format(b.Bytes())),
+	)
+}
+
+// attributeOrder returns the names of the top-level attributes of valid HCL,
+// in the order they are defined in.
+func attributeOrder(code string) []string {
+	file, _ := hclsyntax.ParseConfig([]byte(code), "", hcl.InitialPos)
+
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil
+	}
+
+	return orderedAttributes(body.Attributes)
+}
+
+// localsOrder returns the names of the local values of the locals blocks of
+// valid HCL, in the order they are defined in.
+func localsOrder(code string) (names []string) {
+	file, _ := hclsyntax.ParseConfig([]byte(code), "", hcl.InitialPos)
+
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil
+	}
+
+	for _, block := range body.Blocks {
+		if block.Type == "locals" {
+			names = append(names, orderedAttributes(block.Body.Attributes)...)
+		}
+	}
+
+	return names
+}
+
+// orderedAttributes returns the names of attributes in the order they are
+// defined in.
+func orderedAttributes(attrs hclsyntax.Attributes) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return attrs[names[i]].SrcRange.Start.Byte < attrs[names[j]].SrcRange.Start.Byte
+	})
+
+	return names
+}
@@ -0,0 +1,299 @@
+package libaiac
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestMergeHCL(t *testing.T) {
+	const bucket = `# Bucket for access logs
+resource "aws_s3_bucket" "logs" {
+  bucket = "logs"
+}
+`
+
+	tests := []struct {
+		name      string
+		existing  string
+		generated string
+		prefer    string
+		// wantErr is the error merging must fail with, if any
+		wantErr error
+		want    string
+		// wantAdded, wantReplaced, wantKept and wantUnchanged are the
+		// expected addresses of the result
+		wantAdded     []string
+		wantReplaced  []string
+		wantKept      []string
+		wantUnchanged []string
+	}{
+		{
+			name:     "append",
+			existing: bucket,
+			generated: `resource "aws_iam_role" "r" {
+  name = "r"
+}
+`,
+			want: bucket + `
+resource "aws_iam_role" "r" {
+  name = "r"
+}
+`,
+			wantAdded: []string{"aws_iam_role.r"},
+		},
+		{
+			name:     "append to code without trailing newline",
+			existing: `variable "region" {}`,
+			generated: `variable "zone" {}
+`,
+			want: `variable "region" {}
+
+variable "zone" {}
+`,
+			wantAdded: []string{"var.zone"},
+		},
+		{
+			name:     "identical block",
+			existing: bucket,
+			generated: `resource "aws_s3_bucket" "logs" {
+    bucket    =    "logs"
+}
+`,
+			want:          bucket,
+			wantUnchanged: []string{"aws_s3_bucket.logs"},
+		},
+		{
+			name:     "conflict without preference",
+			existing: bucket,
+			generated: `resource "aws_s3_bucket" "logs" {
+  bucket = "other"
+}
+`,
+			wantErr: types.ErrMergeConflict,
+		},
+		{
+			name:     "prefer generated keeps comments",
+			existing: bucket,
+			generated: `resource "aws_s3_bucket" "logs" {
+  bucket = "other"
+}
+`,
+			prefer: MergePreferGenerated,
+			want: `# Bucket for access logs
+resource "aws_s3_bucket" "logs" {
+  bucket = "other"
+}
+`,
+			wantReplaced: []string{"aws_s3_bucket.logs"},
+		},
+		{
+			name:     "prefer existing",
+			existing: bucket,
+			generated: `resource "aws_s3_bucket" "logs" {
+  bucket = "other"
+}
+`,
+			prefer:   MergePreferExisting,
+			want:     bucket,
+			wantKept: []string{"aws_s3_bucket.logs"},
+		},
+		{
+			name: "duplicate generated blocks",
+			generated: `resource "aws_iam_role" "r" {
+  name = "r"
+}
+
+resource "aws_iam_role" "r" {
+  name = "r"
+}
+`,
+			want: `resource "aws_iam_role" "r" {
+  name = "r"
+}
+`,
+			wantAdded:     []string{"aws_iam_role.r"},
+			wantUnchanged: []string{"aws_iam_role.r"},
+		},
+		{
+			name: "conflicting generated blocks",
+			generated: `resource "aws_iam_role" "r" {
+  name = "r"
+}
+
+resource "aws_iam_role" "r" {
+  name = "other"
+}
+`,
+			wantErr: types.ErrMergeConflict,
+		},
+		{
+			name: "locals",
+			existing: `locals {
+  # The environment
+  env = "prod"
+}
+`,
+			generated: `locals {
+  env  = "prod"
+  name = "app"
+}
+`,
+			want: `locals {
+  # The environment
+  env = "prod"
+}
+
+locals {
+  name = "app"
+}
+`,
+			wantAdded:     []string{"local.name"},
+			wantUnchanged: []string{"local.env"},
+		},
+		{
+			name: "conflicting locals",
+			existing: `locals {
+  env = "prod"
+}
+`,
+			generated: `locals {
+  env = "dev"
+}
+`,
+			prefer: MergePreferGenerated,
+			want: `locals {
+  env = "dev"
+}
+`,
+			wantReplaced: []string{"local.env"},
+		},
+		{
+			name: "provider aliases",
+			existing: `provider "aws" {
+  region = "us-east-1"
+}
+`,
+			generated: `provider "aws" {
+  alias  = "west"
+  region = "us-west-2"
+}
+`,
+			want: `provider "aws" {
+  region = "us-east-1"
+}
+
+provider "aws" {
+  alias  = "west"
+  region = "us-west-2"
+}
+`,
+			wantAdded: []string{"provider.aws.west"},
+		},
+		{
+			name: "unlabeled blocks",
+			existing: `moved {
+  from = aws_s3_bucket.a
+  to   = aws_s3_bucket.b
+}
+`,
+			generated: `moved {
+  from = aws_s3_bucket.a
+  to   = aws_s3_bucket.b
+}
+
+moved {
+  from = aws_s3_bucket.c
+  to   = aws_s3_bucket.d
+}
+`,
+			want: `moved {
+  from = aws_s3_bucket.a
+  to   = aws_s3_bucket.b
+}
+
+moved {
+  from = aws_s3_bucket.c
+  to   = aws_s3_bucket.d
+}
+`,
+			wantAdded:     []string{"moved"},
+			wantUnchanged: []string{"moved"},
+		},
+		{
+			name: "unique unlabeled block",
+			existing: `terraform {
+  required_version = ">= 1.5"
+}
+`,
+			generated: `terraform {
+  required_version = ">= 1.6"
+}
+`,
+			prefer: MergePreferExisting,
+			want: `terraform {
+  required_version = ">= 1.5"
+}
+`,
+			wantKept: []string{"terraform"},
+		},
+		{
+			name:     "top-level attributes",
+			existing: "# Region to deploy to\nregion = \"us-east-1\"\n",
+			generated: `region = "us-west-2"
+zone   = "a"
+`,
+			prefer:       MergePreferGenerated,
+			want:         "# Region to deploy to\nregion = \"us-west-2\"\n\nzone = \"a\"\n",
+			wantAdded:    []string{"zone"},
+			wantReplaced: []string{"region"},
+		},
+		{
+			name:      "invalid generated code",
+			existing:  bucket,
+			generated: `resource "aws_s3_bucket" {`,
+			wantErr:   types.ErrInvalidHCL,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := MergeHCL(tt.existing, tt.generated, tt.prefer)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if res.Code != tt.want {
+				t.Errorf("expected code:\n%s\ngot:\n%s", tt.want, res.Code)
+			}
+
+			for _, addrs := range []struct {
+				what      string
+				want, got []string
+			}{
+				{"added", tt.wantAdded, res.Added},
+				{"replaced", tt.wantReplaced, res.Replaced},
+				{"kept", tt.wantKept, res.Kept},
+				{"unchanged", tt.wantUnchanged, res.Unchanged},
+			} {
+				if len(addrs.want) == 0 && len(addrs.got) == 0 {
+					continue
+				}
+
+				if !reflect.DeepEqual(addrs.want, addrs.got) {
+					t.Errorf("expected %s %v, got %v", addrs.what, addrs.want, addrs.got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package libaiac
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultHeaderTemplate is the template of the headers of saved files when
+// headers are enabled without one (see OutputConfig).
+const DefaultHeaderTemplate = `Generated by aiac {{.Version}} on {{.Timestamp}}
+Backend: {{.Backend}}, model: {{.Model}}
+Prompt: {{.Prompt}}`
+
+// headerPromptLen is the maximum number of characters of the prompt included
+// in headers (see HeaderData.Prompt).
+const headerPromptLen = 72
+
+// OutputConfig holds settings of the files generated code is saved to.
+type OutputConfig struct {
+	// Header enables headers: comments at the start of saved files of code
+	// recording how it was generated (see Aiac.AddHeader). Headers can also
+	// be enabled from the command line.
+	Header bool `toml:"header"`
+
+	// HeaderTemplate is the Go template (see text/template) headers are
+	// rendered from, with the fields of HeaderData. Defaults to
+	// DefaultHeaderTemplate.
+	HeaderTemplate string `toml:"header_template"`
+}
+
+// validate verifies that the header template can be rendered.
+func (output OutputConfig) validate() (errs []error) {
+	if _, err := output.template(); err != nil {
+		errs = append(errs, fmt.Errorf("output.header_template: %w", err))
+	}
+
+	return errs
+}
+
+// template parses the header template, and checks that it only references
+// fields of HeaderData by rendering it once.
+func (output OutputConfig) template() (*template.Template, error) {
+	text := output.HeaderTemplate
+	if text == "" {
+		text = DefaultHeaderTemplate
+	}
+
+	tmpl, err := template.New("header").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tmpl.Execute(&bytes.Buffer{}, HeaderData{})
+	if err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// HeaderData holds the fields header templates are rendered with.
+type HeaderData struct {
+	// Timestamp is the time the file was saved, in UTC, in RFC 3339 format.
+	Timestamp string
+
+	// Version is the version of aiac.
+	Version string
+
+	// Backend is the name of the backend that generated the code.
+	Backend string
+
+	// Model is the model that generated the code.
+	Model string
+
+	// Prompt is a summary of the prompt: its first line, shortened to 72
+	// characters.
+	Prompt string
+
+	// File is the name of the file, without its directory.
+	File string
+}
+
+// commentSyntax is how comments are written in a language: every line of a
+// comment starts with prefix and ends with suffix, for languages that only
+// have block comments (e.g. XML).
+type commentSyntax struct {
+	prefix string
+	suffix string
+}
+
+var (
+	hashComments  = commentSyntax{prefix: "#"}
+	slashComments = commentSyntax{prefix: "//"}
+	dashComments  = commentSyntax{prefix: "--"}
+	xmlComments   = commentSyntax{prefix: "<!--", suffix: "-->"}
+)
+
+// commentSyntaxes maps language hints of code blocks, file extensions (without
+// the dot) and names of files without extensions, in lowercase, to the syntax
+// of comments in their language. Languages without comments, such as JSON,
+// are not listed, so their files are saved without headers.
+var commentSyntaxes = map[string]commentSyntax{
+	"hcl": hashComments, "terraform": hashComments, "tf": hashComments, "tfvars": hashComments,
+	"yaml": hashComments, "yml": hashComments, "toml": hashComments,
+	"python": hashComments, "py": hashComments, "ruby": hashComments, "rb": hashComments,
+	"bash": hashComments, "sh": hashComments, "shell": hashComments, "zsh": hashComments,
+	"powershell": hashComments, "ps1": hashComments, "psm1": hashComments, "pwsh": hashComments,
+	"dockerfile": hashComments, "docker": hashComments, "containerfile": hashComments,
+	"makefile": hashComments, "make": hashComments, "mk": hashComments,
+	"nginx": hashComments, "conf": hashComments, "properties": hashComments,
+	"cue": slashComments, "json5": slashComments, "jsonc": slashComments, "bicep": slashComments,
+	"javascript": slashComments, "js": slashComments, "mjs": slashComments,
+	"typescript": slashComments, "ts": slashComments, "go": slashComments, "golang": slashComments,
+	"groovy": slashComments, "jenkinsfile": slashComments, "gradle": slashComments,
+	"java": slashComments, "kotlin": slashComments, "kt": slashComments, "rust": slashComments,
+	"rs": slashComments, "csharp": slashComments, "cs": slashComments,
+	"sql": dashComments, "postgresql": dashComments, "mysql": dashComments, "lua": dashComments,
+	"xml": xmlComments, "html": xmlComments, "markdown": xmlComments, "md": xmlComments,
+}
+
+// dockerDirective matches the parser directives of Dockerfiles, which are
+// only recognized in comments before any other line, so headers follow them.
+var dockerDirective = regexp.MustCompile(`(?i)^#\s*(syntax|escape|check)\s*=`)
+
+// AddHeader returns the code of a file, prepended with a header recording how
+// it was generated, if headers are enabled (see OutputConfig). The header is
+// rendered from the header template and written as comments in the language
+// of the file, detected from its name, or from the language hint of its code
+// block if its name is not recognized. Lines that must come first, such as
+// shebangs, XML declarations and the parser directives of Dockerfiles, stay
+// first, followed by the header. An error wrapping types.ErrNoCommentSyntax
+// is returned if the language does not support comments (e.g. JSON), or is
+// not known, in which case the file should be saved without a header.
+func (aiac *Aiac) AddHeader(file types.File, res types.Response, prompt string) (string, error) {
+	if !aiac.Conf.Output.Header {
+		return file.Code, nil
+	}
+
+	syntax, ok := fileCommentSyntax(file)
+	if !ok {
+		return file.Code, fmt.Errorf(
+			"%w: %s, saving it without a header",
+			types.ErrNoCommentSyntax, filepath.Base(file.Name),
+		)
+	}
+
+	tmpl, err := aiac.Conf.Output.template()
+	if err != nil {
+		return file.Code, fmt.Errorf("invalid header template: %w", err)
+	}
+
+	var rendered strings.Builder
+	err = tmpl.Execute(&rendered, HeaderData{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Version:   Version,
+		Backend:   res.Backend,
+		Model:     res.Model,
+		Prompt:    summarizePrompt(prompt),
+		File:      filepath.Base(file.Name),
+	})
+	if err != nil {
+		return file.Code, fmt.Errorf("failed rendering header: %w", err)
+	}
+
+	var header strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(rendered.String(), "\n"), "\n") {
+		header.WriteString(syntax.comment(line))
+		header.WriteByte('\n')
+	}
+
+	lines := strings.SplitAfter(file.Code, "\n")
+
+	first := 0
+	for first < len(lines) && leadingLine(syntax, first, lines[first]) {
+		first++
+	}
+
+	return strings.Join(lines[:first], "") + header.String() + strings.Join(lines[first:], ""), nil
+}
+
+// comment returns a line of text as a comment. Block comments cannot contain
+// double hyphens, which are replaced.
+func (syntax commentSyntax) comment(line string) string {
+	line = strings.TrimSpace(line)
+	if syntax.suffix != "" {
+		line = strings.ReplaceAll(line, "--", "- -")
+	}
+
+	if line == "" {
+		return syntax.prefix + syntax.suffix
+	}
+
+	if syntax.suffix == "" {
+		return syntax.prefix + " " + line
+	}
+
+	return syntax.prefix + " " + line + " " + syntax.suffix
+}
+
+// fileCommentSyntax returns the syntax of comments in the language of a file
+// (see commentSyntaxes). Returns false if the language does not support
+// comments, or is not known.
+func fileCommentSyntax(file types.File) (commentSyntax, bool) {
+	name := strings.ToLower(filepath.Base(file.Name))
+	if ext := filepath.Ext(name); ext != "" && ext != name {
+		name = ext[1:]
+	}
+
+	if syntax, ok := commentSyntaxes[name]; ok {
+		return syntax, true
+	}
+
+	// Files with a known extension whose language has no comments do not
+	// get one from the code block
+	if _, ok := languageFilenames[name]; ok {
+		return commentSyntax{}, false
+	}
+
+	syntax, ok := commentSyntaxes[strings.ToLower(file.Language)]
+
+	return syntax, ok
+}
+
+// leadingLine returns whether the line of code at index i must stay before
+// the header.
+func leadingLine(syntax commentSyntax, i int, line string) bool {
+	switch {
+	case i == 0 && strings.HasPrefix(line, "#!"):
+		return true
+	case i == 0 && strings.HasPrefix(line, "<?xml"):
+		return true
+	case syntax == hashComments && dockerDirective.MatchString(line):
+		return true
+	}
+
+	return false
+}
+
+// summarizePrompt returns the first non-empty line of a prompt, with its
+// whitespace collapsed, shortened to headerPromptLen characters.
+func summarizePrompt(prompt string) string {
+	var summary string
+	for _, line := range strings.Split(prompt, "\n") {
+		if summary = strings.Join(strings.Fields(line), " "); summary != "" {
+			break
+		}
+	}
+
+	if utf8.RuneCountInString(summary) <= headerPromptLen {
+		return summary
+	}
+
+	return string([]rune(summary)[:headerPromptLen-3]) + "..."
+}
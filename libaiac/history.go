@@ -0,0 +1,257 @@
+package libaiac
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+const (
+	// HistorySize is the maximum number of entries kept in the history. The
+	// oldest entries are removed as new ones are added.
+	HistorySize = 1000
+
+	// maxHistoryPrompt and maxHistoryExcerpt are the maximum sizes, in bytes,
+	// of the prompts and response excerpts stored in the history
+	maxHistoryPrompt  = 4096
+	maxHistoryExcerpt = 512
+)
+
+// HistoryEntry is a record of a prompt in the history (see History).
+type HistoryEntry struct {
+	// ID identifies the entry. IDs are assigned in increasing order, and
+	// are not reused when old entries are removed.
+	ID int `json:"id"`
+
+	// Time is the time the response was generated.
+	Time time.Time `json:"time"`
+
+	// Backend is the name of the backend that generated the response.
+	Backend string `json:"backend"`
+
+	// Model is the model that generated the response.
+	Model string `json:"model"`
+
+	// Prompt is the prompt, truncated if long.
+	Prompt string `json:"prompt"`
+
+	// Excerpt is the beginning of the generated code.
+	Excerpt string `json:"excerpt,omitempty"`
+
+	// OutputPath is the file or directory the code was saved to, if any.
+	OutputPath string `json:"output_path,omitempty"`
+}
+
+// History is an on-disk log of the prompts sent and where their responses
+// were saved, for recalling past requests. Full responses are not recorded,
+// to keep it small; only an excerpt of the code is. Entries are stored as
+// JSON Lines, and at most HistorySize entries are kept.
+type History struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewHistory creates a history stored in the XDG data directory. On
+// Unix-like operating systems, this will be
+// ~/.local/share/aiac/history.jsonl.
+func NewHistory() *History {
+	return &History{path: filepath.Join(xdg.DataHome, "aiac", "history.jsonl")}
+}
+
+// Path returns the path of the file the history is stored in.
+func (history *History) Path() string {
+	return history.path
+}
+
+// Add records an entry for a response to the provided prompt, returning the
+// entry recorded. The prompt is truncated if long, and only an excerpt of the
+// code is stored.
+func (history *History) Add(prompt string, res types.Response, outputPath string) (
+	entry HistoryEntry,
+	err error,
+) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	entries, err := history.read()
+	if err != nil {
+		return entry, err
+	}
+
+	entry = HistoryEntry{
+		ID:         1,
+		Time:       time.Now(),
+		Backend:    res.Backend,
+		Model:      res.Model,
+		Prompt:     truncate(prompt, maxHistoryPrompt),
+		Excerpt:    truncate(res.Code, maxHistoryExcerpt),
+		OutputPath: outputPath,
+	}
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+
+	err = os.MkdirAll(filepath.Dir(history.path), 0o700) //nolint: gomnd
+	if err != nil {
+		return entry, fmt.Errorf("failed creating history directory: %w", err)
+	}
+
+	if len(entries) < HistorySize {
+		return entry, history.append(entry)
+	}
+
+	return entry, history.write(append(entries[len(entries)-HistorySize+1:], entry))
+}
+
+// List returns the entries of the history, from oldest to newest.
+func (history *History) List() ([]HistoryEntry, error) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	return history.read()
+}
+
+// Get returns the entry of the history with the provided ID. An error
+// wrapping types.ErrNoSuchHistoryEntry is returned if there is none.
+func (history *History) Get(id int) (entry HistoryEntry, err error) {
+	entries, err := history.List()
+	if err != nil {
+		return entry, err
+	}
+
+	for _, e := range entries {
+		if e.ID == id {
+			return e, nil
+		}
+	}
+
+	return entry, fmt.Errorf("%w: %d", types.ErrNoSuchHistoryEntry, id)
+}
+
+// read reads the entries of the history. Lines that cannot be decoded, such
+// as one partially written, are skipped.
+func (history *History) read() (entries []HistoryEntry, err error) {
+	f, err := os.Open(history.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed opening history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024) //nolint: gomnd
+
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed reading history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// append appends an entry to the history file.
+func (history *History) append(entry HistoryEntry) error {
+	data, err := encodeHistory([]HistoryEntry{entry})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(
+		history.path,
+		os.O_WRONLY|os.O_CREATE|os.O_APPEND,
+		0o600, //nolint: gomnd
+	)
+	if err != nil {
+		return fmt.Errorf("failed opening history: %w", err)
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing history: %w", err)
+	}
+
+	return nil
+}
+
+// write replaces the history file with the provided entries.
+func (history *History) write(entries []HistoryEntry) error {
+	data, err := encodeHistory(entries)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(history.path), ".history-*")
+	if err != nil {
+		return fmt.Errorf("failed creating history: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing history: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), history.path)
+	if err != nil {
+		return fmt.Errorf("failed saving history: %w", err)
+	}
+
+	return nil
+}
+
+// encodeHistory encodes entries of the history as JSON Lines. Prompts often
+// include characters such as "<" and ">", which are not escaped.
+func encodeHistory(entries []HistoryEntry) ([]byte, error) {
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	for _, entry := range entries {
+		err := enc.Encode(entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed encoding history entry: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// truncate truncates s to at most size bytes, without splitting characters.
+func truncate(s string, size int) string {
+	if len(s) <= size {
+		return s
+	}
+
+	for size > 0 && !utf8.RuneStart(s[size]) {
+		size--
+	}
+
+	return s[:size]
+}
@@ -0,0 +1,157 @@
+package libaiac
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// ImageMediaTypes holds the formats of images that may be attached to
+// prompts (see LoadImage), which all vision-capable providers accept.
+var ImageMediaTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// DefaultImageSizeLimit is the maximum size of images attached to prompts
+// sent to backends of types that are not listed in ImageSizeLimits, in bytes.
+const DefaultImageSizeLimit = 20 << 20
+
+// ImageSizeLimits holds the maximum size of images, in bytes, accepted by the
+// providers of each backend type. Groq's limit applies to images encoded as
+// base64, so it is lower here by a quarter.
+var ImageSizeLimits = map[BackendType]int{
+	BackendAnthropic: 5 << 20,
+	BackendBedrock:   3840 << 10,
+	BackendGroq:      3 << 20,
+	BackendXAI:       10 << 20,
+}
+
+// VisionModels holds the backend types whose providers accept images in
+// prompts, with the prefixes of the names of the models that do, as with
+// ReasoningModels. Bedrock inference profile IDs are matched without their
+// region prefix. Types with no prefixes accept images for every model, as
+// the provider (or server) determines which models support them. Prompts with
+// images are refused for other models.
+var VisionModels = map[BackendType][]string{
+	BackendOpenAI:    {"gpt-4o", "gpt-4.1", "gpt-4-turbo", "gpt-5", "o1", "o3", "o4"},
+	BackendXAI:       {"grok-2-vision", "grok-vision", "grok-4"},
+	BackendGroq:      {"meta-llama/llama-4"},
+	BackendAnthropic: {"claude-3", "claude-sonnet-4", "claude-opus-4", "claude-haiku-4"},
+	BackendGemini:    {"gemini-1.5", "gemini-2"},
+	BackendBedrock: {
+		"anthropic.claude-3", "anthropic.claude-sonnet-4", "anthropic.claude-opus-4",
+		"amazon.nova-pro", "amazon.nova-lite", "meta.llama3-2-11b", "meta.llama3-2-90b",
+		"meta.llama4", "mistral.pixtral",
+	},
+	BackendOllama: {
+		"llava", "bakllava", "llama3.2-vision", "llama4", "gemma3", "qwen2.5vl",
+		"minicpm-v", "moondream", "granite3.2-vision", "mistral-small3",
+	},
+	BackendAzureOpenAI:      nil,
+	BackendOpenRouter:       nil,
+	BackendOpenAICompatible: nil,
+}
+
+// visionSupport returns whether the backend's provider accepts images in
+// prompts for the provided model (see VisionModels).
+func (backendConf BackendConfig) visionSupport(model string) bool {
+	backendType := backendConf.Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	prefixes, ok := VisionModels[backendType]
+	if !ok {
+		return false
+	}
+
+	if len(prefixes) == 0 {
+		return true
+	}
+
+	model = strings.TrimPrefix(strings.ToLower(model), "models/")
+
+	candidates := []string{model}
+	if _, rest, found := strings.Cut(model, "."); found {
+		candidates = append(candidates, rest)
+	}
+
+	for _, candidate := range candidates {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(candidate, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// imageSizeLimit returns the maximum size of images accepted by the backend's
+// provider, in bytes (see ImageSizeLimits).
+func (backendConf BackendConfig) imageSizeLimit() int {
+	if limit, ok := ImageSizeLimits[backendConf.Type]; ok {
+		return limit
+	}
+
+	return DefaultImageSizeLimit
+}
+
+// checkImages returns an error if the backend's provider does not accept
+// images for the provided model (wrapping types.ErrUnsupported), or if any of
+// the images exceeds its size limit (wrapping types.ErrInvalidImage).
+func (backendConf namedBackendConfig) checkImages(model string, images []types.Image) error {
+	if !backendConf.visionSupport(model) {
+		return fmt.Errorf(
+			"%w: backend %s does not accept images for model %s",
+			types.ErrUnsupported, backendConf.name, model,
+		)
+	}
+
+	limit := backendConf.imageSizeLimit()
+	for i, img := range images {
+		if len(img.Data) > limit {
+			return fmt.Errorf(
+				"%w: image %d is %s, backend %s accepts images of up to %s",
+				types.ErrInvalidImage, i+1, formatSize(len(img.Data)),
+				backendConf.name, formatSize(limit),
+			)
+		}
+	}
+
+	return nil
+}
+
+// LoadImage reads an image to attach to a prompt from the provided path. Its
+// format is detected from its content, and an error wrapping
+// types.ErrInvalidImage is returned if it is not one of ImageMediaTypes.
+// Whether its size is accepted depends on the backend it is sent to.
+func LoadImage(path string) (img types.Image, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return img, fmt.Errorf("failed reading image: %w", err)
+	}
+
+	mediaType := http.DetectContentType(data)
+	for _, supported := range ImageMediaTypes {
+		if mediaType == supported {
+			return types.Image{MediaType: mediaType, Data: data}, nil
+		}
+	}
+
+	return img, fmt.Errorf(
+		"%w: %s is not a PNG, JPEG, GIF or WebP image",
+		types.ErrInvalidImage, filepath.Base(path),
+	)
+}
+
+// formatSize formats a size in bytes for humans, in kilobytes or megabytes.
+func formatSize(size int) string {
+	if size < 1<<20 {
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	}
+
+	return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+}
@@ -3,17 +3,40 @@ package libaiac
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/gofireflyio/aiac/v5/libaiac/anthropic"
 	"github.com/gofireflyio/aiac/v5/libaiac/bedrock"
+	"github.com/gofireflyio/aiac/v5/libaiac/cohere"
+	"github.com/gofireflyio/aiac/v5/libaiac/gemini"
 	"github.com/gofireflyio/aiac/v5/libaiac/ollama"
 	"github.com/gofireflyio/aiac/v5/libaiac/openai"
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Version contains aiac's version string
 var Version = "development"
 
+// Commit contains the git commit aiac was built from, if set at build time
+// (e.g. with -ldflags "-X github.com/gofireflyio/aiac/v5/libaiac.Commit=...").
+var Commit = ""
+
+// BuildDate contains the date aiac was built, if set at build time like
+// Commit.
+var BuildDate = ""
+
 // Aiac provides the main interface for using libaiac.
 type Aiac struct {
 	// Conf holds the configuration for aiac.
@@ -21,45 +44,241 @@ type Aiac struct {
 
 	// Backends is a map from backend names to backend implementations.
 	Backends map[string]types.Backend
+
+	// Cache is the response cache. If nil, responses are not cached. It is
+	// created automatically when enabled in the configuration.
+	Cache *Cache
+
+	// History is the history prompts are recorded in. If nil, prompts are not
+	// recorded. It is created automatically when enabled in the
+	// configuration. libaiac does not record prompts itself; see
+	// History.Add.
+	History *History
+
+	// DryRun makes backends loaded from the configuration record requests
+	// rather than send them. Requests fail with a *transport.DryRunError
+	// holding the request that would have been sent, with secrets redacted.
+	// Responses are not loaded from the cache in this mode.
+	DryRun bool
+
+	// RecordDir, if not empty, makes backends loaded from the configuration
+	// record every HTTP request they send and the response it receives in
+	// the directory, with secrets redacted (see transport.Record).
+	RecordDir string
+
+	// ReplayDir, if not empty, makes backends loaded from the configuration
+	// serve responses from recordings made with RecordDir, rather than send
+	// requests (see transport.Options.ReplayDir).
+	ReplayDir string
+
+	// SkipContextCheck disables refusing prompts that do not fit in the
+	// context window of the model they are sent to (see EstimateTokens).
+	// Prompts are checked against the context window of the backend they
+	// are first sent to, not those of fallback backends.
+	SkipContextCheck bool
+
+	// Ledger is the spend ledger the cost of responses is recorded in, to
+	// enforce the monthly budget (see BudgetConfig.Monthly). If nil, costs
+	// are not recorded, and the monthly budget is not enforced. It is
+	// created automatically when a monthly budget is configured.
+	Ledger *Ledger
+
+	// SkipBudgetCheck disables refusing prompts whose estimated cost exceeds
+	// the budget (see BudgetConfig). Costs are still recorded in the
+	// ledger.
+	SkipBudgetCheck bool
+
+	// PullModels makes conversations with backends that can pull models (see
+	// types.ModelPuller), such as Ollama, pull the selected model and retry
+	// when it is missing from the server.
+	PullModels bool
+
+	// RetryOnEmpty is the number of times a prompt is sent again when the
+	// model returns a response without code (e.g. because its output was
+	// filtered or truncated). Once retries are exhausted, or if zero, such
+	// responses fail with an error wrapping types.ErrEmptyResponse.
+	RetryOnEmpty int
+
+	// ResumeStreams makes conversations request streamed responses that
+	// stalled (see BackendConfig.StreamIdleTimeout) again, up to
+	// MaxStreamResumes times. Providers cannot resume streams where they
+	// stopped, so responses are requested again from scratch; writers
+	// implementing types.StreamRestarter are told when they are.
+	ResumeStreams bool
+
+	// PullProgress is where the progress of pulling models is written, if not
+	// nil.
+	PullProgress io.Writer
+
+	// Logger, if not nil, is used to log what libaiac is doing: backends
+	// selected, prompts sent and responses received at info level, and the
+	// metadata of HTTP requests at debug level. Secrets are never logged.
+	Logger *slog.Logger
+
+	// Middlewares wrap the HTTP transport of every backend loaded from the
+	// configuration, to observe or modify the requests they send (see
+	// transport.Options.Middlewares for where they run). They must be set
+	// before the first conversation is started, as backends keep their HTTP
+	// clients.
+	Middlewares []transport.Middleware
+
+	// BackendMiddlewares are the same as Middlewares, for individual
+	// backends by name. They run after (inside) those in Middlewares.
+	BackendMiddlewares map[string][]transport.Middleware
+
+	// PromptBuilders are prompt builders registered by kind of code, which
+	// take precedence over the prompts in the configuration and the
+	// built-in ones (see RegisterPromptBuilder and BuildPrompt).
+	PromptBuilders map[string]PromptBuilder
+
+	// TracerProvider, if not nil, is the OpenTelemetry tracer provider used
+	// to record spans for selecting backends, generating responses and every
+	// HTTP request sent by backends loaded from the configuration. Otherwise,
+	// the global tracer provider is used (see otel.SetTracerProvider), which
+	// records nothing unless one is configured. Spans for loading the
+	// configuration are always recorded with the global tracer provider (see
+	// NewContext).
+	TracerProvider trace.TracerProvider
+
+	// httpClients holds the HTTP clients of backends loaded from the
+	// configuration, by backend name, so that all conversations with a
+	// backend share its connections and rate limits
+	httpClients   map[string]*http.Client
+	httpClientsMu sync.Mutex
+
+	// pool holds the base transports shared by the HTTP clients of
+	// backends, configured by Conf.HTTP
+	pool *transport.Pool
+
+	// listedWindows holds the context windows of models reported by
+	// backends when listing their models, by backend name and model (see
+	// ContextWindow)
+	listedWindows   map[string]map[string]int
+	listedWindowsMu sync.Mutex
 }
 
 // New constructs a new Aiac object with the path to a configuration file. If
-// a configuration file is not provided, the default path will be checked based
-// on the XDG specification. On Unix-like operating systems, this will be
-// ~/.config/aiac/aiac.toml.
-func New(configPath ...string) (*Aiac, error) {
-	path := ""
-	if len(configPath) > 0 {
-		path = configPath[0]
-	}
+// a configuration file is not provided, the default paths will be checked
+// based on the XDG specification and merged (see LoadConfig). On Unix-like
+// operating systems, this will be ~/.config/aiac/aiac.toml. If multiple paths
+// are provided, they are merged in order (see LoadConfigs). No files are
+// loaded if loading them is disabled (see NoConfig).
+func New(configPaths ...string) (*Aiac, error) {
+	return NewContext(context.Background(), configPaths...)
+}
 
-	conf, err := LoadConfig(path)
+// NewContext is the same as New, but records loading the configuration in an
+// OpenTelemetry span, as a child of the span in ctx, if any, using the global
+// tracer provider.
+func NewContext(ctx context.Context, configPaths ...string) (*Aiac, error) {
+	return NewContextWithOptions(ctx, ConfigOptions{}, configPaths...)
+}
+
+// NewContextWithOptions is the same as NewContext, but loads the
+// configuration with the provided options (see ConfigOptions).
+func NewContextWithOptions(
+	ctx context.Context,
+	opts ConfigOptions,
+	configPaths ...string,
+) (aiac *Aiac, err error) {
+	_, span := (*Aiac)(nil).startSpan(
+		ctx, "aiac.load_config",
+		attribute.StringSlice("aiac.config.paths", configPaths),
+	)
+	defer func() { endSpan(span, err) }()
+
+	var conf Config
+
+	if len(configPaths) > 1 && !opts.NoFiles() {
+		conf, err = opts.LoadConfigs(configPaths...)
+	} else {
+		path := ""
+		if len(configPaths) > 0 {
+			path = configPaths[0]
+		}
+
+		conf, err = opts.LoadConfig(path)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed loading configuration: %w", err)
 	}
 
-	return &Aiac{Conf: conf}, nil
+	span.SetAttributes(attribute.Int("aiac.config.backends", len(conf.Backends)))
+
+	return NewFromConf(conf), nil
 }
 
 // NewFromConf is the same as New, but receives a populated configuration object
 // rather than a file path.
 func NewFromConf(conf Config) *Aiac {
-	return &Aiac{Conf: conf}
+	aiac := &Aiac{Conf: conf}
+	if conf.Cache.Enabled {
+		aiac.Cache = NewCache(conf.Cache)
+	}
+	if conf.History {
+		aiac.History = NewHistory()
+	}
+	if conf.Budget.Monthly > 0 {
+		aiac.Ledger = NewLedger()
+	}
+
+	return aiac
 }
 
 // ListModels returns a list of all the models supported by the selected
 // backend, identified by its name. If backendName is an empty string, the
 // default backend defined in the configuration file will be used, if any.
 func (aiac *Aiac) ListModels(ctx context.Context, backendName string) (
-	models []string,
+	models []types.Model,
+	err error,
+) {
+	backend, backendConf, err := aiac.loadBackend(ctx, backendName)
+	if err != nil {
+		return models, fmt.Errorf("failed loading backend: %w", aiac.redactError(err))
+	}
+
+	ctx, cancel := withTimeout(ctx, backendConf.timeout())
+	defer cancel()
+
+	models, err = backend.ListModels(ctx)
+	if err != nil {
+		return models, aiac.redactError(timeoutError(ctx, backendConf.name, err))
+	}
+
+	return models, nil
+}
+
+// Identity returns who the selected backend, identified by its name, is
+// authenticated as with its provider (see types.IdentityProvider), e.g. to
+// confirm which account the backend's credentials belong to. If backendName
+// is an empty string, the default backend is used. An error wrapping
+// types.ErrUnsupported is returned if the provider does not report it.
+func (aiac *Aiac) Identity(ctx context.Context, backendName string) (
+	identity types.Identity,
 	err error,
 ) {
-	backend, _, err := aiac.loadBackend(ctx, backendName)
+	backend, backendConf, err := aiac.loadBackend(ctx, backendName)
+	if err != nil {
+		return identity, fmt.Errorf("failed loading backend: %w", aiac.redactError(err))
+	}
+
+	provider, ok := backend.(types.IdentityProvider)
+	if !ok {
+		return identity, fmt.Errorf(
+			"%w: backend %s does not report its identity",
+			types.ErrUnsupported, backendConf.name,
+		)
+	}
+
+	ctx, cancel := withTimeout(ctx, backendConf.timeout())
+	defer cancel()
+
+	identity, err = provider.Identity(ctx)
 	if err != nil {
-		return models, fmt.Errorf("failed loading backend: %w", err)
+		return identity, aiac.redactError(timeoutError(ctx, backendConf.name, err))
 	}
 
-	return backend.ListModels(ctx)
+	return identity, nil
 }
 
 // Chat initiates a chat conversation with the provided chat model of the
@@ -67,78 +286,461 @@ func (aiac *Aiac) ListModels(ctx context.Context, backendName string) (
 // sent and received. If backendName is an empty string, the default backend
 // defined in the configuration will be used, if any. If model is an empty
 // string, the default model defined in the backend configuration will be used,
-// if any. Users can also supply zero or more "previous messages" that may have
-// been exchanged in the past. This practically allows "loading" previous
-// conversations and continuing them.
+// or if not defined, the default model of the backend's type (see
+// BackendConfig.ResolveModel). If no model can be resolved, the returned error
+// wraps types.ErrNoDefaultModel and lists the models the backend supports, if
+// they can be listed. Users can also supply zero or more "previous messages"
+// that may have been exchanged in the past. This practically allows "loading"
+// previous conversations and continuing them. If the configuration includes
+// fallback backends, the conversation falls back to them when the selected
+// backend fails due to a transient error (see Config.Fallback). If the backend
+// has a system prompt configured and the messages do not include one, it is
+// added. The backend's default generation parameters apply to every parameter
+// not set with the conversation's SetParameters method.
 func (aiac *Aiac) Chat(
 	ctx context.Context,
 	backendName string,
 	model string,
 	msgs ...types.Message,
 ) (chat types.Conversation, err error) {
-	backend, defaultModel, err := aiac.loadBackend(ctx, backendName)
+	backend, backendConf, err := aiac.loadBackend(ctx, backendName)
 	if err != nil {
-		return chat, fmt.Errorf("failed loading backend: %w", err)
+		return chat, fmt.Errorf("failed loading backend: %w", aiac.redactError(err))
 	}
 
+	model = backendConf.ResolveModel(model)
 	if model == "" {
-		if defaultModel == "" {
-			return nil, types.ErrNoDefaultModel
+		return nil, noModelError(ctx, backend, backendConf)
+	}
+
+	msgs = backendConf.withSystemPrompt(msgs)
+
+	aiac.log().InfoContext(
+		ctx, "starting chat",
+		"backend", backendConf.name,
+		"model", model,
+		"messages", len(msgs),
+	)
+
+	cache := aiac.Cache
+	if aiac.DryRun {
+		cache = nil
+	}
+
+	conv := &conversation{
+		Conversation: backend.Chat(model, msgs...),
+		aiac:         aiac,
+		backend:      backend,
+		backendName:  backendConf.name,
+		model:        model,
+		timeout:      backendConf.timeout(),
+		cache:        cache,
+		fallbacks:    aiac.fallbacks(backendConf.name),
+		defaults:     backendConf.Parameters,
+		stopLimit:    backendConf.stopLimit(),
+		nativeJSON:   backendConf.nativeJSON(),
+		nativeSchema: backendConf.nativeSchema(),
+		seed:         backendConf.seedSupport(),
+		reasoning:    backendConf.reasoningSupport(model),
+	}
+
+	conv.Conversation.SetParameters(conv.defaults)
+
+	return conv, nil
+}
+
+// fallbacks returns the names of the fallback backends to use for the backend
+// with the provided name, in order, without duplicates and without the backend
+// itself.
+func (aiac *Aiac) fallbacks(name string) (names []string) {
+	seen := map[string]bool{name: true}
+	for _, fallback := range aiac.Conf.Fallback {
+		if !seen[fallback] {
+			names = append(names, fallback)
+			seen[fallback] = true
 		}
-		model = defaultModel
 	}
 
-	return backend.Chat(model, msgs...), nil
+	return names
 }
 
-func (aiac *Aiac) loadBackend(ctx context.Context, name string) (
+// noModelError returns the error for a backend with which no model can be
+// resolved, listing the models it supports so that one can be selected.
+// Models are not listed if they cannot be retrieved.
+func noModelError(
+	ctx context.Context,
 	backend types.Backend,
-	defaultModel string,
-	err error,
-) {
+	backendConf namedBackendConfig,
+) error {
+	err := fmt.Errorf("backend %s: %w", backendConf.name, types.ErrNoDefaultModel)
+
+	ctx, cancel := withTimeout(ctx, backendConf.timeout())
+	defer cancel()
+
+	models, listErr := backend.ListModels(ctx)
+	if listErr != nil || len(models) == 0 {
+		return err
+	}
+
+	ids := make([]string, len(models))
+	for i, model := range models {
+		ids[i] = model.ID
+	}
+
+	return fmt.Errorf("%w; available models: %s", err, strings.Join(ids, ", "))
+}
+
+// httpClient returns the HTTP client of a backend loaded from the
+// configuration, creating it the first time the backend is loaded. It is safe
+// for concurrent use.
+func (aiac *Aiac) httpClient(backendConf namedBackendConfig) (*http.Client, error) {
+	aiac.httpClientsMu.Lock()
+	defer aiac.httpClientsMu.Unlock()
+
+	if httpClient, ok := aiac.httpClients[backendConf.name]; ok {
+		return httpClient, nil
+	}
+
+	if aiac.pool == nil {
+		aiac.pool = transport.NewPool(aiac.Conf.HTTP.poolOptions())
+	}
+
+	transportOpts := backendConf.transportOptions()
+	transportOpts.Pool = aiac.pool
+	transportOpts.DryRun = aiac.DryRun
+	transportOpts.RecordDir = aiac.RecordDir
+	transportOpts.ReplayDir = aiac.ReplayDir
+	transportOpts.Logger = aiac.Logger
+	transportOpts.TracerProvider = aiac.tracerProvider()
+	transportOpts.Middlewares = append(
+		append([]transport.Middleware(nil), aiac.Middlewares...),
+		aiac.BackendMiddlewares[backendConf.name]...,
+	)
+
+	httpClient, err := transport.NewClient(transportOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if aiac.httpClients == nil {
+		aiac.httpClients = make(map[string]*http.Client)
+	}
+
+	aiac.httpClients[backendConf.name] = httpClient
+
+	return httpClient, nil
+}
+
+// ResolveBackend returns the name of the backend selected by name: the
+// backend with that name, if there is one, or else the only backend whose
+// type is name (e.g. "openai"), so that backends can be selected by the
+// provider they target. If name is empty, the default backend is returned.
+// An error wrapping types.ErrNoDefaultBackend is returned if name is empty
+// and there is no default backend, types.ErrAmbiguousBackend if multiple
+// backends have the type name, listing them, and types.ErrNoSuchBackend if
+// no backend matches.
+func (aiac *Aiac) ResolveBackend(name string) (string, error) {
 	if name == "" {
 		if aiac.Conf.DefaultBackend == "" {
-			return nil, defaultModel, types.ErrNoDefaultBackend
+			return "", types.ErrNoDefaultBackend
 		}
 		name = aiac.Conf.DefaultBackend
 	}
 
+	_, configured := aiac.Conf.Backends[name]
+	_, provided := aiac.Backends[name]
+	if configured || provided {
+		return name, nil
+	}
+
+	var candidates []string
+	for candidate, backendConf := range aiac.Conf.Backends {
+		if string(backendConf.Type) == name {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("backend %s: %w", name, types.ErrNoSuchBackend)
+	case 1:
+		return candidates[0], nil
+	default:
+		sort.Strings(candidates)
+
+		return "", fmt.Errorf(
+			"backend %s: %w (%s); select one by name",
+			name, types.ErrAmbiguousBackend, strings.Join(candidates, ", "),
+		)
+	}
+}
+
+// loadBackend loads the backend with the provided name, or the default
+// backend if the name is empty, resolving it by type if necessary (see
+// ResolveBackend). The backend's configuration is returned as
+// well, with its name populated.
+func (aiac *Aiac) loadBackend(ctx context.Context, name string) (
+	backend types.Backend,
+	backendConf namedBackendConfig,
+	err error,
+) {
+	ctx, span := aiac.startSpan(ctx, "aiac.load_backend")
+	defer func() {
+		span.SetAttributes(
+			attribute.String("aiac.backend", name),
+			attribute.String("aiac.backend.type", string(backendConf.Type)),
+		)
+		endSpan(span, aiac.redactError(err))
+	}()
+
+	name, err = aiac.ResolveBackend(name)
+	if err != nil {
+		return nil, backendConf, err
+	}
+
+	conf, ok := aiac.Conf.Backends[name]
+	if !ok {
+		// Backends may have been provided directly, without configuration
+		return aiac.Backends[name], namedBackendConfig{name: name}, nil
+	}
+
+	backendConf = namedBackendConfig{BackendConfig: conf, name: name}
+
 	// Check if we've already loaded it before
 	if backend, ok := aiac.Backends[name]; ok {
-		return backend, defaultModel, nil
+		return backend, backendConf, nil
 	}
 
-	// We haven't, check if it's in the configuration
-	backendConf, ok := aiac.Conf.Backends[name]
-	if !ok {
-		return backend, defaultModel, types.ErrNoSuchBackend
+	aiac.log().DebugContext(
+		ctx, "loading backend",
+		"backend", name,
+		"type", backendConf.Type,
+	)
+
+	httpClient, err := aiac.httpClient(backendConf)
+	if err != nil {
+		return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+	}
+
+	// Backends served over a Unix socket are sent requests through it by
+	// their HTTP client, at the HTTP URL of their API
+	apiURL := backendConf.URL
+	if _, socketAPIURL := backendConf.unixSocket(); socketAPIURL != "" {
+		apiURL = socketAPIURL
 	}
 
 	switch backendConf.Type {
 	case BackendBedrock:
-		if backendConf.AWSProfile == "" {
-			backendConf.AWSProfile = bedrock.DefaultAWSProfile
+		// Without a profile or region, the SDK finds them the standard way
+		loadOpts := []func(*config.LoadOptions) error{
+			config.WithHTTPClient(httpClient),
 		}
 
-		if backendConf.AWSRegion == "" {
-			backendConf.AWSRegion = bedrock.DefaultAWSRegion
+		if backendConf.AWSProfile != "" {
+			loadOpts = append(loadOpts, config.WithSharedConfigProfile(backendConf.AWSProfile))
 		}
 
-		cfg, err := config.LoadDefaultConfig(
-			ctx,
-			config.WithSharedConfigProfile(backendConf.AWSProfile),
-		)
+		if backendConf.AWSRegion != "" {
+			loadOpts = append(loadOpts, config.WithRegion(backendConf.AWSRegion))
+		}
+
+		// When retries are enabled they are handled by our HTTP client, so
+		// the SDK's own retryer is disabled to avoid compounding them
+		if backendConf.MaxRetries > 0 {
+			loadOpts = append(loadOpts, config.WithRetryer(func() aws.Retryer {
+				return aws.NopRetryer{}
+			}))
+		}
+
+		cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
 		if err != nil {
-			return nil, defaultModel, err
+			return nil, backendConf, err
 		}
 
-		cfg.Region = backendConf.AWSRegion
+		if cfg.Region == "" {
+			cfg.Region = bedrock.DefaultAWSRegion
+		}
+
+		if backendConf.AWSRoleARN != "" {
+			sessionName := backendConf.AWSSessionName
+			if sessionName == "" {
+				sessionName = bedrock.DefaultAWSSessionName
+			}
+
+			provider := stscreds.NewAssumeRoleProvider(
+				sts.NewFromConfig(cfg),
+				backendConf.AWSRoleARN,
+				func(opts *stscreds.AssumeRoleOptions) {
+					opts.RoleSessionName = sessionName
+				},
+			)
+
+			cfg.Credentials = aws.NewCredentialsCache(provider)
+		}
 
 		backend = bedrock.New(cfg)
+	case BackendAnthropic:
+		backend, err = anthropic.New(&anthropic.Options{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			APIVersion:   backendConf.APIVersion,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendCohere:
+		backend, err = cohere.New(&cohere.Options{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendGemini:
+		backend, err = gemini.New(ctx, &gemini.Options{
+			APIKey:       backendConf.APIKey,
+			GCPProject:   backendConf.GCPProject,
+			GCPLocation:  backendConf.GCPLocation,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendAzureOpenAI:
+		backend, err = openai.NewAzure(&openai.AzureOptions{
+			URL:          backendConf.URL,
+			APIKey:       backendConf.APIKey,
+			APIVersion:   backendConf.APIVersion,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendMistral:
+		backend, err = openai.NewMistral(&openai.MistralOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendOpenRouter:
+		backend, err = openai.NewOpenRouter(&openai.OpenRouterOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendGroq:
+		backend, err = openai.NewGroq(&openai.GroqOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendDeepSeek:
+		backend, err = openai.NewDeepSeek(&openai.DeepSeekOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendXAI:
+		backend, err = openai.NewXAI(&openai.XAIOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendPerplexity:
+		backend, err = openai.NewPerplexity(&openai.PerplexityOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendTogether:
+		backend, err = openai.NewTogether(&openai.TogetherOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendFireworks:
+		backend, err = openai.NewFireworks(&openai.FireworksOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendHuggingFace:
+		backend, err = openai.NewHuggingFace(&openai.HuggingFaceOptions{
+			APIKey:       backendConf.APIKey,
+			URL:          backendConf.URL,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
+	case BackendOpenAICompatible:
+		backend, err = openai.NewCompatible(&openai.CompatibleOptions{
+			URL:          apiURL,
+			APIKey:       backendConf.APIKey,
+			AuthHeader:   backendConf.AuthHeader,
+			APIVersion:   backendConf.APIVersion,
+			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
+		})
+		if err != nil {
+			return nil, backendConf, fmt.Errorf("backend %s: %w", name, err)
+		}
 	case BackendOllama:
 		backend = ollama.New(&ollama.Options{
-			URL:          backendConf.URL,
+			URL:          apiURL,
+			KeepAlive:    backendConf.KeepAlive,
 			ExtraHeaders: backendConf.ExtraHeaders,
+			HTTPClient:   httpClient,
 		})
 	default:
 		// default to openai
@@ -146,12 +748,35 @@ func (aiac *Aiac) loadBackend(ctx context.Context, name string) (
 			ApiKey:       backendConf.APIKey,
 			URL:          backendConf.URL,
 			APIVersion:   backendConf.APIVersion,
+			AuthHeader:   backendConf.AuthHeader,
 			ExtraHeaders: backendConf.ExtraHeaders,
+			ExtraBody:    backendConf.ExtraBody,
+			HTTPClient:   httpClient,
 		})
 		if err != nil {
-			return nil, defaultModel, err
+			return nil, backendConf, err
 		}
 	}
 
-	return backend, backendConf.DefaultModel, nil
+	return backend, backendConf, nil
+}
+
+// log returns the logger to use, which discards all messages if no logger was
+// provided.
+func (aiac *Aiac) log() *slog.Logger {
+	if aiac.Logger == nil {
+		return discardLogger
+	}
+
+	return aiac.Logger
+}
+
+// discardLogger is a logger that discards all messages.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// namedBackendConfig is a BackendConfig together with the name of the backend
+// in the configuration.
+type namedBackendConfig struct {
+	BackendConfig
+	name string
 }
@@ -0,0 +1,103 @@
+package libaiac
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestResolveBackend(t *testing.T) {
+	backends := map[string]BackendConfig{
+		"work":     {Type: BackendOpenAI},
+		"personal": {Type: BackendOpenAI},
+		"claude":   {Type: BackendAnthropic},
+		// A backend named after a type it does not have
+		"ollama": {Type: BackendOpenAICompatible},
+	}
+
+	tests := []struct {
+		name           string
+		defaultBackend string
+		input          string
+		want           string
+		wantErr        error
+		// wantListed are strings the error message must contain
+		wantListed []string
+	}{
+		{name: "exact name", input: "work", want: "work"},
+		{name: "exact name over type", input: "ollama", want: "ollama"},
+		{name: "unique type", input: "anthropic", want: "claude"},
+		{name: "unique type of backend named after another", input: "openai_compatible", want: "ollama"},
+		{name: "default backend", defaultBackend: "claude", want: "claude"},
+		{name: "default backend by type", defaultBackend: "anthropic", want: "claude"},
+		{name: "no default backend", wantErr: types.ErrNoDefaultBackend},
+		{
+			name:       "ambiguous type",
+			input:      "openai",
+			wantErr:    types.ErrAmbiguousBackend,
+			wantListed: []string{"(personal, work)"},
+		},
+		{
+			name:           "ambiguous default backend",
+			defaultBackend: "openai",
+			wantErr:        types.ErrAmbiguousBackend,
+			wantListed:     []string{"personal", "work"},
+		},
+		{
+			name:       "no such backend",
+			input:      "gemini",
+			wantErr:    types.ErrNoSuchBackend,
+			wantListed: []string{"gemini"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aiac := NewFromConf(Config{DefaultBackend: tt.defaultBackend, Backends: backends})
+
+			got, err := aiac.ResolveBackend(tt.input)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				for _, s := range tt.wantListed {
+					if !strings.Contains(err.Error(), s) {
+						t.Errorf("expected error to contain %q, got %q", s, err)
+					}
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected backend %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveProvidedBackend(t *testing.T) {
+	aiac := NewFromConf(Config{Backends: map[string]BackendConfig{
+		"work": {Type: BackendOpenAI},
+	}})
+
+	// Backends provided by the caller are selected by name, as they have no
+	// type
+	aiac.Backends = map[string]types.Backend{"custom": nil}
+
+	got, err := aiac.ResolveBackend("custom")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got != "custom" {
+		t.Errorf("expected backend %q, got %q", "custom", got)
+	}
+}
@@ -0,0 +1,429 @@
+package libaiac
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultConfigPaths returns the paths of the configuration files that are
+// loaded when a path is not explicitly provided, in the order in which they
+// are merged: the system configuration files (based on the XDG specification,
+// e.g. /etc/xdg/aiac/aiac.toml), the user's configuration file (e.g.
+// ~/.config/aiac/aiac.toml), and aiac.toml in the working directory. Paths
+// that do not exist are not returned. If the EnvConfig environment variable
+// is set, only its value is returned instead, whether the file exists or not.
+// No paths are returned if loading configuration files is disabled (see
+// NoConfig).
+func DefaultConfigPaths() (paths []string) {
+	return ConfigOptions{}.DefaultConfigPaths()
+}
+
+// DefaultConfigPaths is the same as the DefaultConfigPaths function, applying
+// the options.
+func (opts ConfigOptions) DefaultConfigPaths() (paths []string) {
+	if opts.NoFiles() {
+		return nil
+	}
+
+	if path := os.Getenv(EnvConfig); path != "" {
+		return []string{path}
+	}
+
+	var candidates []string
+
+	// xdg.ConfigDirs is ordered from most to least important, but the most
+	// important file must be merged last
+	for i := len(xdg.ConfigDirs) - 1; i >= 0; i-- {
+		candidates = append(
+			candidates,
+			filepath.Join(xdg.ConfigDirs[i], "aiac", "aiac.toml"),
+		)
+	}
+
+	candidates = append(candidates, UserConfigPath(), "aiac.toml")
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// UserConfigPath returns the path of the user's configuration file, based on
+// the XDG specification. On Unix-like operating systems, this is
+// ~/.config/aiac/aiac.toml. The file may not exist.
+func UserConfigPath() string {
+	return filepath.Join(xdg.ConfigHome, "aiac", "aiac.toml")
+}
+
+// LoadConfigs loads multiple aiac configuration files and merges them, in
+// order. Later files override earlier ones at the level of individual
+// settings: a backend defined in more than one file receives the settings of
+// all of them, with the last file setting a value winning. Maps such as
+// extra_headers are merged key by key. The default backend and the fallback
+// list are taken from the last file that sets them. A backend's type cannot
+// be changed by a later file. Keys that are not known settings do not cause
+// an error, so that files remain usable with older versions of aiac, but are
+// reported in the configuration's Warnings. Profiles are merged setting by
+// setting, like backends, and the profile named by the EnvProfile
+// environment variable, if set, is applied to the merged configuration (see
+// Config.WithProfile).
+func LoadConfigs(paths ...string) (conf Config, err error) {
+	return ConfigOptions{}.LoadConfigs(paths...)
+}
+
+// LoadConfigs is the same as the LoadConfigs function, applying the options.
+// Files are loaded even if loading configuration files is disabled, as they
+// are provided explicitly.
+func (opts ConfigOptions) LoadConfigs(paths ...string) (conf Config, err error) {
+	if len(paths) == 0 {
+		return conf, fmt.Errorf(
+			"failed loading configuration: no configuration files provided: %w",
+			fs.ErrNotExist,
+		)
+	}
+
+	// typeSources records which file defined the type of each backend, for
+	// the purpose of reporting conflicts
+	typeSources := make(map[string]string)
+
+	for _, path := range paths {
+		var layer Config
+
+		md, err := toml.DecodeFile(path, &layer)
+		if err != nil {
+			return conf, fmt.Errorf("failed loading configuration: %w", err)
+		}
+
+		err = conf.merge(layer, md, path, typeSources)
+		if err != nil {
+			return conf, fmt.Errorf("failed merging configuration: %w", err)
+		}
+
+		conf.Warnings = append(conf.Warnings, unknownKeys(md, path)...)
+	}
+
+	// If any of the config values are env vars, replace them
+	conf, err = replaceEnvVars(conf)
+	if err != nil {
+		return conf, fmt.Errorf("failed loading configuration: %w", err)
+	}
+
+	// If any of the API keys reference the keyring, resolve them
+	conf, err = resolveSecrets(conf)
+	if err != nil {
+		return conf, fmt.Errorf("failed loading configuration: %w", err)
+	}
+
+	conf, err = conf.WithProfile(opts.profile())
+	if err != nil {
+		return conf, err
+	}
+
+	err = conf.Validate()
+	if err != nil {
+		return conf, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return conf, nil
+}
+
+// unknownKeys returns a warning for every key of the file at path that was
+// not decoded into the configuration, according to md. Keys of tables that
+// are unknown themselves are not reported separately, and keys of values
+// that decode themselves (e.g. Template) are not reported at all, as they
+// reject unknown keys themselves or store them (e.g. types.Parameters).
+func unknownKeys(md toml.MetaData, path string) (warnings []string) {
+	unknown := make(map[string]bool)
+
+	for _, key := range md.Undecoded() {
+		if customDecoded(reflect.TypeOf(Config{}), key) {
+			continue
+		}
+
+		reported := unknown[key.String()]
+		for i := 1; i < len(key) && !reported; i++ {
+			reported = unknown[key[:i].String()]
+		}
+
+		unknown[key.String()] = true
+		if reported {
+			continue
+		}
+
+		if len(key) > 2 && key[0] == "backends" { //nolint: gomnd
+			warnings = append(warnings, fmt.Sprintf(
+				"%s: unknown key %q in backend %s",
+				path, strings.Join(key[2:], "."), key[1],
+			))
+			continue
+		}
+
+		warnings = append(warnings, fmt.Sprintf("%s: unknown key %q", path, key.String()))
+	}
+
+	return warnings
+}
+
+var unmarshalerType = reflect.TypeOf((*toml.Unmarshaler)(nil)).Elem()
+
+// customDecoded returns whether key, relative to a value of type t, is part
+// of a value decoded by its own implementation of toml.Unmarshaler.
+func customDecoded(t reflect.Type, key toml.Key) bool {
+	for _, part := range key {
+		for t.Kind() == reflect.Pointer {
+			t = t.Elem()
+		}
+
+		if reflect.PointerTo(t).Implements(unmarshalerType) {
+			return true
+		}
+
+		switch t.Kind() {
+		case reflect.Map:
+			t = t.Elem()
+		case reflect.Struct:
+			field, ok := tomlField(t, part)
+			if !ok {
+				return false
+			}
+
+			t = field.Type
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+// tomlField returns the field of struct type t that the TOML key name is
+// decoded into.
+func tomlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("toml"), ",")
+		if tag == name || (tag == "" && strings.EqualFold(field.Name, name)) {
+			return field, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+// merge merges a configuration loaded from the file at path into conf. Only
+// settings defined in the file (according to md) override existing settings.
+func (conf *Config) merge(
+	layer Config,
+	md toml.MetaData,
+	path string,
+	typeSources map[string]string,
+) error {
+	if md.IsDefined("default_backend") {
+		conf.DefaultBackend = layer.DefaultBackend
+	}
+
+	if md.IsDefined("fallback") {
+		conf.Fallback = layer.Fallback
+	}
+
+	if md.IsDefined("history") {
+		conf.History = layer.History
+	}
+
+	mergeDefined(
+		reflect.ValueOf(&conf.Cache).Elem(),
+		reflect.ValueOf(layer.Cache),
+		md, "cache",
+	)
+
+	mergeDefined(
+		reflect.ValueOf(&conf.Guardrails).Elem(),
+		reflect.ValueOf(layer.Guardrails),
+		md, "guardrails",
+	)
+
+	mergeDefined(
+		reflect.ValueOf(&conf.Budget).Elem(),
+		reflect.ValueOf(layer.Budget),
+		md, "budget",
+	)
+
+	mergeDefined(
+		reflect.ValueOf(&conf.HTTP).Elem(),
+		reflect.ValueOf(layer.HTTP),
+		md, "http",
+	)
+
+	mergeDefined(
+		reflect.ValueOf(&conf.Output).Elem(),
+		reflect.ValueOf(layer.Output),
+		md, "output",
+	)
+
+	for backendType, prices := range layer.Pricing {
+		if conf.Pricing == nil {
+			conf.Pricing = make(map[BackendType]map[string]Price)
+		}
+
+		if conf.Pricing[backendType] == nil {
+			conf.Pricing[backendType] = make(map[string]Price, len(prices))
+		}
+
+		for model, price := range prices {
+			conf.Pricing[backendType][model] = price
+		}
+	}
+
+	for backendType, windows := range layer.ContextWindows {
+		if conf.ContextWindows == nil {
+			conf.ContextWindows = make(map[BackendType]map[string]int)
+		}
+
+		if conf.ContextWindows[backendType] == nil {
+			conf.ContextWindows[backendType] = make(map[string]int, len(windows))
+		}
+
+		for model, window := range windows {
+			conf.ContextWindows[backendType][model] = window
+		}
+	}
+
+	for name, tmpl := range layer.Templates {
+		if conf.Templates == nil {
+			conf.Templates = make(map[string]Template, len(layer.Templates))
+		}
+
+		conf.Templates[name] = tmpl
+	}
+
+	for name, preset := range layer.Presets {
+		if conf.Presets == nil {
+			conf.Presets = make(map[string]string, len(layer.Presets))
+		}
+
+		conf.Presets[name] = preset
+	}
+
+	for kind, prompt := range layer.Prompts {
+		if conf.Prompts == nil {
+			conf.Prompts = make(map[string]PromptConfig, len(layer.Prompts))
+		}
+
+		conf.Prompts[kind] = prompt
+	}
+
+	for name, profile := range layer.Profiles {
+		if conf.Profiles == nil {
+			conf.Profiles = make(map[string]ProfileConfig, len(layer.Profiles))
+		}
+
+		existing := conf.Profiles[name]
+		params := existing.Parameters
+
+		mergeDefined(
+			reflect.ValueOf(&existing).Elem(),
+			reflect.ValueOf(profile),
+			md, "profiles", name,
+		)
+
+		// Parameters are merged parameter by parameter, as for backends
+		if md.IsDefined("profiles", name, "parameters") {
+			existing.Parameters = params.Override(profile.Parameters)
+		}
+
+		conf.Profiles[name] = existing
+	}
+
+	if len(layer.Backends) > 0 && conf.Backends == nil {
+		conf.Backends = make(map[string]BackendConfig, len(layer.Backends))
+	}
+
+	var errs []error
+
+	for name, backendConf := range layer.Backends {
+		existing := conf.Backends[name]
+
+		if md.IsDefined("backends", name, "type") {
+			source, ok := typeSources[name]
+			if ok && existing.Type != backendConf.Type {
+				errs = append(errs, fmt.Errorf(
+					"%w: backend %s has type %q in %s but type %q in %s; "+
+						"later files override earlier ones setting by "+
+						"setting, but cannot change a backend's type",
+					types.ErrConfigConflict,
+					name, existing.Type, source, backendConf.Type, path,
+				))
+				continue
+			}
+
+			typeSources[name] = path
+		}
+
+		params, rateLimit := existing.Parameters, existing.RateLimit
+
+		mergeDefined(
+			reflect.ValueOf(&existing).Elem(),
+			reflect.ValueOf(backendConf),
+			md, "backends", name,
+		)
+
+		// Parameters are merged parameter by parameter
+		if md.IsDefined("backends", name, "parameters") {
+			existing.Parameters = params.Override(backendConf.Parameters)
+		}
+
+		// Rate limits are merged limit by limit
+		existing.RateLimit = rateLimit
+		mergeDefined(
+			reflect.ValueOf(&existing.RateLimit).Elem(),
+			reflect.ValueOf(backendConf.RateLimit),
+			md, "backends", name, "rate_limit",
+		)
+
+		conf.Backends[name] = existing
+	}
+
+	return errors.Join(errs...)
+}
+
+// mergeDefined copies every field of the struct src whose TOML key (under the
+// provided key path) is defined according to md into the struct dst. Map
+// fields are merged key by key.
+func mergeDefined(dst, src reflect.Value, md toml.MetaData, keys ...string) {
+	typ := src.Type()
+
+	for i := 0; i < typ.NumField(); i++ {
+		key, _, _ := strings.Cut(typ.Field(i).Tag.Get("toml"), ",")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		if !md.IsDefined(append(keys[:len(keys):len(keys)], key)...) {
+			continue
+		}
+
+		srcField, dstField := src.Field(i), dst.Field(i)
+
+		if srcField.Kind() == reflect.Map && !dstField.IsNil() {
+			iter := srcField.MapRange()
+			for iter.Next() {
+				dstField.SetMapIndex(iter.Key(), iter.Value())
+			}
+			continue
+		}
+
+		dstField.Set(srcField)
+	}
+}
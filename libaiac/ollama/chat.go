@@ -2,7 +2,10 @@ package ollama
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
@@ -15,11 +18,25 @@ type Conversation struct {
 	model        string
 	messages     []types.Message
 	extraHeaders map[string]string
+	params       types.Parameters
+
+	// images are attached to the next prompt (see AttachImages)
+	images []types.Image
 }
 
 type chatResponse struct {
-	Message types.Message `json:"message"`
-	Done    bool          `json:"done"`
+	Message         chatMessage `json:"message"`
+	Done            bool        `json:"done"`
+	Error           string      `json:"error"`
+	PromptEvalCount int64       `json:"prompt_eval_count"`
+	EvalCount       int64       `json:"eval_count"`
+}
+
+// chatMessage is a message generated by the model. Thinking models return
+// their thinking in a separate field, if asked to think.
+type chatMessage struct {
+	types.Message
+	Thinking string `json:"thinking"`
 }
 
 // Chat initiates a conversation with an Ollama chat model. A conversation
@@ -51,20 +68,10 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 ) {
 	var answer chatResponse
 
-	conv.messages = append(conv.messages, types.Message{
-		Role:    "user",
-		Content: prompt,
-	})
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
 
 	req := conv.backend.NewRequest("POST", "/chat").
-		JSONBody(map[string]interface{}{
-			"model":    conv.model,
-			"messages": conv.messages,
-			"options": map[string]interface{}{
-				"temperature": 0.2,
-			},
-			"stream": false,
-		}).
+		JSONBody(conv.body(false)).
 		Into(&answer)
 
 	for key, val := range conv.extraHeaders {
@@ -76,9 +83,13 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 		return res, fmt.Errorf("failed sending prompt: %w", err)
 	}
 
-	conv.messages = append(conv.messages, answer.Message)
+	conv.messages = append(conv.messages, answer.Message.Message)
 
 	res.FullOutput = strings.TrimSpace(answer.Message.Content)
+	res.Reasoning = strings.TrimSpace(answer.Message.Thinking)
+	res.InputTokens = answer.PromptEvalCount
+	res.OutputTokens = answer.EvalCount
+	res.TokensUsed = answer.PromptEvalCount + answer.EvalCount
 	if answer.Done {
 		res.StopReason = "done"
 	} else {
@@ -93,12 +104,106 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 	return res, nil
 }
 
+// Stream is the same as Send, but streams the response from the API, writing
+// the generated text to w as it arrives. Ollama streams responses as a
+// sequence of JSON objects.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	var output, thinking strings.Builder
+	var done bool
+
+	req := conv.backend.NewRequest("POST", "/chat").
+		JSONBody(conv.body(true)).
+		// The body handler is only called if a target is provided
+		Into(&output).
+		BodyHandler(func(_ int, _ string, body io.Reader, _ interface{}) error {
+			decoder := json.NewDecoder(body)
+			for {
+				var chunk chatResponse
+
+				err := decoder.Decode(&chunk)
+				if errors.Is(err, io.EOF) {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("failed parsing stream chunk: %w", err)
+				}
+
+				if chunk.Error != "" {
+					return &types.APIError{Provider: "Ollama", Message: chunk.Error}
+				}
+
+				thinking.WriteString(chunk.Message.Thinking)
+
+				output.WriteString(chunk.Message.Content)
+				if _, err := io.WriteString(w, chunk.Message.Content); err != nil {
+					return err
+				}
+
+				if chunk.Done {
+					done = true
+					res.InputTokens = chunk.PromptEvalCount
+					res.OutputTokens = chunk.EvalCount
+					res.TokensUsed = chunk.PromptEvalCount + chunk.EvalCount
+					return nil
+				}
+			}
+		})
+
+	for key, val := range conv.extraHeaders {
+		req.Header(key, val)
+	}
+
+	err = req.RunContext(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.Reasoning = strings.TrimSpace(thinking.String())
+	if done {
+		res.StopReason = "done"
+	} else {
+		res.StopReason = "truncated"
+	}
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
 // Messages returns all the messages that have been exchanged between the user
 // and the assistant up to this point.
 func (conv *Conversation) Messages() []types.Message {
 	return conv.messages
 }
 
+// AttachImages attaches images to the next prompt sent in the conversation.
+func (conv *Conversation) AttachImages(images ...types.Image) error {
+	conv.images = append(conv.images, images...)
+	return nil
+}
+
+// userMessage returns the message of a prompt, with the images attached to
+// the conversation, which are then detached from it.
+func (conv *Conversation) userMessage(prompt string) types.Message {
+	msg := types.Message{Role: "user", Content: prompt, Images: conv.images}
+	conv.images = nil
+
+	return msg
+}
+
 // AddHeader adds an extra HTTP header that will be added to every HTTP
 // request issued as part of this conversation. Any headers added will be in
 // addition to any extra headers defined for the backend itself, and will
@@ -109,3 +214,94 @@ func (conv *Conversation) AddHeader(key, val string) {
 	}
 	conv.extraHeaders[key] = val
 }
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// body builds the body of a chat request with the conversation's messages.
+// A reasoning effort asks thinking models to think, which Ollama only accepts
+// as an effort for gpt-oss models, and as a boolean for others. A JSON Schema
+// is sent as the format, which Ollama constrains responses to.
+func (conv *Conversation) body(stream bool) map[string]interface{} {
+	body := map[string]interface{}{
+		"model":    conv.model,
+		"messages": requestMessages(conv.messages),
+		"options":  conv.options(),
+		"stream":   stream,
+	}
+
+	if conv.backend.keepAlive != nil {
+		body["keep_alive"] = conv.backend.keepAlive
+	}
+
+	if len(conv.params.Schema) > 0 {
+		body["format"] = conv.params.Schema
+	} else if conv.params.Format == types.FormatJSON {
+		body["format"] = types.FormatJSON
+	}
+
+	if conv.params.ReasoningEffort != "" {
+		if strings.HasPrefix(conv.model, "gpt-oss") {
+			body["think"] = conv.params.ReasoningEffort
+		} else {
+			body["think"] = true
+		}
+	}
+
+	return body
+}
+
+// options builds the model options for a chat request, translating the
+// conversation's generation parameters to their Ollama equivalents. Extra
+// parameters are included as options (e.g. "num_ctx"), unless overridden.
+func (conv *Conversation) options() map[string]interface{} {
+	opts := make(map[string]interface{})
+	for key, val := range conv.params.Extra {
+		opts[key] = val
+	}
+
+	opts["temperature"] = conv.params.TemperatureOrDefault()
+
+	if conv.params.TopP != nil {
+		opts["top_p"] = *conv.params.TopP
+	}
+
+	if conv.params.MaxTokens != nil {
+		opts["num_predict"] = *conv.params.MaxTokens
+	}
+
+	if len(conv.params.Stop) > 0 {
+		opts["stop"] = conv.params.Stop
+	}
+
+	if conv.params.Seed != nil {
+		opts["seed"] = *conv.params.Seed
+	}
+
+	return opts
+}
+
+// requestMessage is a message of a chat request. Ollama accepts the images of
+// messages as a list of base64-encoded images.
+type requestMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+// requestMessages converts the conversation's messages into those of a chat
+// request.
+func requestMessages(msgs []types.Message) []requestMessage {
+	converted := make([]requestMessage, len(msgs))
+	for i, msg := range msgs {
+		converted[i] = requestMessage{Role: msg.Role, Content: msg.Content}
+		for _, img := range msg.Images {
+			converted[i].Images = append(converted[i].Images, img.Base64())
+		}
+	}
+
+	return converted
+}
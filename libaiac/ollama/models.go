@@ -9,7 +9,10 @@ import (
 )
 
 // ListModels returns a list of all the models supported by this backend.
-func (backend *Ollama) ListModels(ctx context.Context) (models []string, err error) {
+func (backend *Ollama) ListModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
 	var answer struct {
 		Models []struct {
 			Name string `json:"name"`
@@ -21,19 +24,21 @@ func (backend *Ollama) ListModels(ctx context.Context) (models []string, err err
 		Into(&answer).
 		RunContext(ctx)
 	if err != nil {
-		return models, fmt.Errorf("failed sending prompt: %w", err)
+		return models, fmt.Errorf("failed listing models: %w", err)
 	}
 
 	if len(answer.Models) == 0 {
 		return models, types.ErrNoResults
 	}
 
-	models = make([]string, len(answer.Models))
+	models = make([]types.Model, len(answer.Models))
 	for i := range answer.Models {
-		models[i] = answer.Models[i].Name
+		models[i] = types.Model{ID: answer.Models[i].Name}
 	}
 
-	sort.Strings(models)
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
 
 	return models, nil
 }
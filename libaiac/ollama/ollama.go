@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
 	"github.com/ido50/requests"
@@ -16,6 +17,10 @@ const DefaultAPIURL = "http://localhost:11434/api"
 // Ollama is a structure used to continuously generate IaC code via Ollama
 type Ollama struct {
 	*requests.HTTPClient
+
+	// keepAlive is the value of the keep_alive parameter of chat requests, if
+	// set
+	keepAlive interface{}
 }
 
 // Options is a struct containing all the parameters accepted by the New
@@ -25,9 +30,18 @@ type Options struct {
 	// Defaults to DefaultAPIURL.
 	URL string
 
+	// KeepAlive is how long models stay loaded in memory after a request,
+	// as a duration string (e.g. "10m") or a number of seconds. Negative
+	// values keep models loaded indefinitely, and zero unloads them right
+	// away. Defaults to the server's default (5 minutes).
+	KeepAlive string
+
 	// ExtraHeaders are extra HTTP headers to send with every request to the
 	// provider.
 	ExtraHeaders map[string]string
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
 }
 
 // New creates a new instance of the Ollama struct, with the provided
@@ -43,8 +57,16 @@ func New(opts *Options) *Ollama {
 
 	cli := &Ollama{}
 
+	// Ollama accepts numbers of seconds as numbers, not strings
+	if seconds, err := strconv.Atoi(opts.KeepAlive); err == nil {
+		cli.keepAlive = seconds
+	} else if opts.KeepAlive != "" {
+		cli.keepAlive = opts.KeepAlive
+	}
+
 	cli.HTTPClient = requests.NewClient(opts.URL).
 		Accept("application/json").
+		Timeout(types.NoTimeout).
 		ErrorHandler(func(
 			httpStatus int,
 			contentType string,
@@ -56,23 +78,28 @@ func New(opts *Options) *Ollama {
 
 			err := json.NewDecoder(body).Decode(&res)
 			if err != nil {
-				return fmt.Errorf(
-					"%w %s",
-					types.ErrUnexpectedStatus,
-					http.StatusText(httpStatus),
-				)
+				return &types.APIError{Provider: "Ollama", StatusCode: httpStatus}
 			}
 
-			return fmt.Errorf(
-				"%w:  %s",
-				types.ErrRequestFailed,
-				res.Error,
-			)
+			// Models that were not pulled to the server are not found
+			if httpStatus == http.StatusNotFound {
+				return fmt.Errorf("%w: %s", types.ErrModelNotFound, res.Error)
+			}
+
+			return &types.APIError{
+				Provider:   "Ollama",
+				StatusCode: httpStatus,
+				Message:    res.Error,
+			}
 		})
 
 	for header, value := range opts.ExtraHeaders {
 		cli.HTTPClient.Header(header, value)
 	}
 
+	if opts.HTTPClient != nil {
+		cli.HTTPClient.CustomHTTPClient(opts.HTTPClient)
+	}
+
 	return cli
 }
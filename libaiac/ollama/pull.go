@@ -0,0 +1,79 @@
+package ollama
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+type pullResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// PullModel downloads a model to the Ollama server from the Ollama library,
+// writing human readable progress to w as it arrives. Models that were
+// already pulled are brought up to date.
+func (backend *Ollama) PullModel(ctx context.Context, model string, w io.Writer) error {
+	var status string
+
+	// The body handler is only called if a target is provided
+	var target struct{}
+
+	err := backend.NewRequest("POST", "/pull").
+		JSONBody(map[string]interface{}{
+			"model":  model,
+			"stream": true,
+		}).
+		Into(&target).
+		BodyHandler(func(_ int, _ string, body io.Reader, _ interface{}) error {
+			// The last status is left on its line
+			defer func() {
+				if status != "" {
+					fmt.Fprintln(w)
+				}
+			}()
+
+			decoder := json.NewDecoder(body)
+			for {
+				var chunk pullResponse
+
+				err := decoder.Decode(&chunk)
+				if errors.Is(err, io.EOF) {
+					return nil
+				} else if err != nil {
+					return fmt.Errorf("failed parsing pull progress: %w", err)
+				}
+
+				if chunk.Error != "" {
+					return &types.APIError{Provider: "Ollama", Message: chunk.Error}
+				}
+
+				// Every status is printed on a line of its own, updated with
+				// the progress of downloads
+				if chunk.Status != status && status != "" {
+					fmt.Fprintln(w)
+				}
+				status = chunk.Status
+
+				if chunk.Total > 0 {
+					fmt.Fprintf(w, "\r%s: %d%%", status, chunk.Completed*100/chunk.Total)
+				} else {
+					fmt.Fprintf(w, "\r%s", status)
+				}
+			}
+		}).
+		RunContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed pulling model %s: %w", model, err)
+	}
+
+	return nil
+}
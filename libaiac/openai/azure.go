@@ -0,0 +1,73 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// AzureOptions is a struct containing all the parameters accepted by the
+// NewAzure constructor.
+type AzureOptions struct {
+	// URL is the endpoint of the Azure OpenAI resource, e.g.
+	// "https://myresource.openai.azure.com". Required.
+	URL string
+
+	// APIKey is the Azure OpenAI API key, sent in the "api-key" header.
+	APIKey string
+
+	// APIVersion is the Azure OpenAI API version to use, sent as the
+	// "api-version" query parameter. Required.
+	APIVersion string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewAzure creates a new instance of the OpenAI struct that talks to an Azure
+// OpenAI resource. Azure OpenAI addresses models by deployment names rather
+// than model names, so the model provided when starting a chat is used as the
+// name of the deployment. An error is returned if the URL or API version are
+// not provided.
+func NewAzure(opts *AzureOptions) (*OpenAI, error) {
+	if opts == nil || opts.URL == "" {
+		return nil, fmt.Errorf(
+			"%w: azure_openai backends require a url",
+			types.ErrInvalidBackendConfig,
+		)
+	}
+
+	if opts.APIVersion == "" {
+		return nil, fmt.Errorf(
+			"%w: azure_openai backends require an api_version",
+			types.ErrInvalidBackendConfig,
+		)
+	}
+
+	backend, err := New(&Options{
+		URL:          strings.TrimSuffix(opts.URL, "/") + "/openai",
+		APIVersion:   opts.APIVersion,
+		ExtraHeaders: opts.ExtraHeaders,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Azure OpenAI",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.azure = true
+	backend.candidates = true
+
+	if opts.APIKey != "" {
+		backend.apiKey = opts.APIKey
+		backend.HTTPClient.Header("api-key", opts.APIKey)
+	}
+
+	return backend, nil
+}
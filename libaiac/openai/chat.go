@@ -2,9 +2,12 @@ package openai
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/gofireflyio/aiac/v5/libaiac/sse"
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
 )
 
@@ -16,17 +19,75 @@ type Conversation struct {
 	model        string
 	messages     []types.Message
 	extraHeaders map[string]string
+	params       types.Parameters
+
+	// images are attached to the next prompt (see AttachImages)
+	images []types.Image
 }
 
 type chatResponse struct {
 	Choices []struct {
-		Message      types.Message `json:"message"`
-		Index        int64         `json:"index"`
-		FinishReason string        `json:"finish_reason"`
+		Message      chatMessage `json:"message"`
+		Index        int64       `json:"index"`
+		FinishReason string      `json:"finish_reason"`
 	} `json:"choices"`
-	Usage struct {
-		TotalTokens int64 `json:"total_tokens"`
-	} `json:"usage"`
+	Usage             usage  `json:"usage"`
+	Provider          string `json:"provider"`
+	SystemFingerprint string `json:"system_fingerprint"`
+
+	// Perplexity returns the URLs of the sources of web-grounded responses
+	Citations []string `json:"citations"`
+}
+
+// chatMessage is a message generated by the model. Reasoning models of some
+// providers, such as DeepSeek, return their reasoning in a separate field,
+// which OpenRouter and Groq name "reasoning".
+type chatMessage struct {
+	types.Message
+	ReasoningContent string `json:"reasoning_content"`
+	Reasoning        string `json:"reasoning"`
+}
+
+// reasoning returns the reasoning of the message, in whichever field the
+// provider returned it.
+func (msg chatMessage) reasoning() string {
+	if msg.ReasoningContent != "" {
+		return msg.ReasoningContent
+	}
+
+	return msg.Reasoning
+}
+
+type usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+
+	// Reasoning tokens are included in the completion tokens
+	CompletionTokensDetails struct {
+		ReasoningTokens int64 `json:"reasoning_tokens"`
+	} `json:"completion_tokens_details"`
+}
+
+// streamChunk is a single chunk of a streamed chat completion.
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content          string `json:"content"`
+			ReasoningContent string `json:"reasoning_content"`
+			Reasoning        string `json:"reasoning"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage             *usage   `json:"usage"`
+	Provider          string   `json:"provider"`
+	SystemFingerprint string   `json:"system_fingerprint"`
+	Citations         []string `json:"citations"`
+
+	// Groq reports the usage of streamed responses in a separate field
+	XGroq struct {
+		Usage *usage `json:"usage"`
+	} `json:"x_groq"`
 }
 
 // Chat initiates a conversation with an OpenAI chat model. A conversation
@@ -58,23 +119,11 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 ) {
 	var answer chatResponse
 
-	conv.messages = append(conv.messages, types.Message{
-		Role:    "user",
-		Content: prompt,
-	})
-
-	var apiVersion string
-	if len(conv.backend.apiVersion) > 0 {
-		apiVersion = fmt.Sprintf("?api-version=%s", conv.backend.apiVersion)
-	}
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
 
 	req := conv.backend.
-		NewRequest("POST", fmt.Sprintf("/chat/completions%s", apiVersion)).
-		JSONBody(map[string]interface{}{
-			"model":       conv.model,
-			"messages":    conv.messages,
-			"temperature": 0.2,
-		}).
+		NewRequest("POST", conv.backend.chatPath(conv.model)).
+		JSONBody(conv.requestBody()).
 		Into(&answer)
 
 	for key, val := range conv.extraHeaders {
@@ -90,12 +139,193 @@ func (conv *Conversation) Send(ctx context.Context, prompt string) (
 		return res, types.ErrNoResults
 	}
 
-	conv.messages = append(conv.messages, answer.Choices[0].Message)
+	// The reasoning is not kept in the conversation, as providers do not
+	// expect it in subsequent requests
+	conv.messages = append(conv.messages, answer.Choices[0].Message.Message)
 
 	res.FullOutput = strings.TrimSpace(answer.Choices[0].Message.Content)
+	res.Reasoning = strings.TrimSpace(answer.Choices[0].Message.reasoning())
 	res.APIKeyUsed = conv.backend.apiKey
 	res.TokensUsed = answer.Usage.TotalTokens
+	res.InputTokens = answer.Usage.PromptTokens
+	res.OutputTokens = answer.Usage.CompletionTokens
+	res.ReasoningTokens = answer.Usage.CompletionTokensDetails.ReasoningTokens
 	res.StopReason = answer.Choices[0].FinishReason
+	res.Provider = answer.Provider
+	res.SystemFingerprint = answer.SystemFingerprint
+	res.Citations = answer.Citations
+
+	var ok bool
+	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+		res.Code = res.FullOutput
+	}
+
+	return res, nil
+}
+
+// SendCandidates is the same as Send, but generates n alternative responses
+// in a single request, via the "n" parameter. Only the first response is kept
+// in the conversation's history, and token usage is reported in it for the
+// request as a whole. It is only supported by the official OpenAI API and
+// Azure OpenAI, as many OpenAI-compatible providers reject the parameter; an
+// error wrapping types.ErrUnsupported is returned for other servers.
+func (conv *Conversation) SendCandidates(ctx context.Context, prompt string, n int) (
+	results []types.Response,
+	err error,
+) {
+	if !conv.backend.candidates {
+		return nil, fmt.Errorf(
+			"%w: generating multiple responses in a single request",
+			types.ErrUnsupported,
+		)
+	}
+
+	var answer chatResponse
+
+	msgs := append(conv.messages, conv.userMessage(prompt))
+
+	body := conv.requestBody()
+	body["messages"] = requestMessages(msgs)
+	body["n"] = n
+
+	req := conv.backend.
+		NewRequest("POST", conv.backend.chatPath(conv.model)).
+		JSONBody(body).
+		Into(&answer)
+
+	for key, val := range conv.extraHeaders {
+		req.Header(key, val)
+	}
+
+	err = req.RunContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if len(answer.Choices) == 0 {
+		return nil, types.ErrNoResults
+	}
+
+	for _, choice := range answer.Choices {
+		var res types.Response
+		res.FullOutput = strings.TrimSpace(choice.Message.Content)
+		res.Reasoning = strings.TrimSpace(choice.Message.reasoning())
+		res.APIKeyUsed = conv.backend.apiKey
+		res.StopReason = choice.FinishReason
+		res.Provider = answer.Provider
+		res.SystemFingerprint = answer.SystemFingerprint
+		res.Citations = answer.Citations
+
+		var ok bool
+		if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
+			res.Code = res.FullOutput
+		}
+
+		results = append(results, res)
+	}
+
+	results[0].TokensUsed = answer.Usage.TotalTokens
+	results[0].InputTokens = answer.Usage.PromptTokens
+	results[0].OutputTokens = answer.Usage.CompletionTokens
+	results[0].ReasoningTokens = answer.Usage.CompletionTokensDetails.ReasoningTokens
+
+	conv.messages = append(msgs, answer.Choices[0].Message.Message)
+
+	return results, nil
+}
+
+// Stream is the same as Send, but streams the response from the API, writing
+// the generated text to w as it arrives.
+func (conv *Conversation) Stream(ctx context.Context, prompt string, w io.Writer) (
+	res types.Response,
+	err error,
+) {
+	conv.messages = append(conv.messages, conv.userMessage(prompt))
+
+	body := conv.requestBody()
+	body["stream"] = true
+	if conv.backend.streamUsage {
+		body["stream_options"] = map[string]interface{}{"include_usage": true}
+	}
+
+	var output, reasoning strings.Builder
+
+	req := conv.backend.
+		NewRequest("POST", conv.backend.chatPath(conv.model)).
+		Header("Accept", "text/event-stream").
+		JSONBody(body).
+		// The body handler is only called if a target is provided
+		Into(&output).
+		BodyHandler(func(_ int, _ string, body io.Reader, _ interface{}) error {
+			return sse.Read(body, func(data []byte) error {
+				var chunk streamChunk
+				if err := json.Unmarshal(data, &chunk); err != nil {
+					return fmt.Errorf("failed parsing stream chunk: %w", err)
+				}
+
+				if chunk.Provider != "" {
+					res.Provider = chunk.Provider
+				}
+
+				if chunk.SystemFingerprint != "" {
+					res.SystemFingerprint = chunk.SystemFingerprint
+				}
+
+				// Every chunk includes all citations so far
+				if len(chunk.Citations) > 0 {
+					res.Citations = chunk.Citations
+				}
+
+				if chunk.Usage == nil {
+					chunk.Usage = chunk.XGroq.Usage
+				}
+
+				if chunk.Usage != nil {
+					res.TokensUsed = chunk.Usage.TotalTokens
+					res.InputTokens = chunk.Usage.PromptTokens
+					res.OutputTokens = chunk.Usage.CompletionTokens
+					res.ReasoningTokens = chunk.Usage.CompletionTokensDetails.ReasoningTokens
+				}
+
+				if len(chunk.Choices) == 0 {
+					return nil
+				}
+
+				if chunk.Choices[0].FinishReason != "" {
+					res.StopReason = chunk.Choices[0].FinishReason
+				}
+
+				reasoning.WriteString(chunk.Choices[0].Delta.ReasoningContent)
+				reasoning.WriteString(chunk.Choices[0].Delta.Reasoning)
+
+				text := chunk.Choices[0].Delta.Content
+				output.WriteString(text)
+				_, err := io.WriteString(w, text)
+				return err
+			})
+		})
+
+	for key, val := range conv.extraHeaders {
+		req.Header(key, val)
+	}
+
+	err = req.RunContext(ctx)
+	if err != nil {
+		return res, fmt.Errorf("failed sending prompt: %w", err)
+	}
+
+	if output.Len() == 0 {
+		return res, types.ErrNoResults
+	}
+
+	conv.messages = append(conv.messages, types.Message{
+		Role:    "assistant",
+		Content: output.String(),
+	})
+
+	res.FullOutput = strings.TrimSpace(output.String())
+	res.Reasoning = strings.TrimSpace(reasoning.String())
+	res.APIKeyUsed = conv.backend.apiKey
 
 	var ok bool
 	if res.Code, ok = types.ExtractCode(res.FullOutput); !ok {
@@ -111,6 +341,21 @@ func (conv *Conversation) Messages() []types.Message {
 	return conv.messages
 }
 
+// AttachImages attaches images to the next prompt sent in the conversation.
+func (conv *Conversation) AttachImages(images ...types.Image) error {
+	conv.images = append(conv.images, images...)
+	return nil
+}
+
+// userMessage returns the message of a prompt, with the images attached to
+// the conversation, which are then detached from it.
+func (conv *Conversation) userMessage(prompt string) types.Message {
+	msg := types.Message{Role: "user", Content: prompt, Images: conv.images}
+	conv.images = nil
+
+	return msg
+}
+
 // AddHeader adds an extra HTTP header that will be added to every HTTP
 // request issued as part of this conversation. Any headers added will be in
 // addition to any extra headers defined for the backend itself, and will
@@ -121,3 +366,107 @@ func (conv *Conversation) AddHeader(key, val string) {
 	}
 	conv.extraHeaders[key] = val
 }
+
+// SetParameters sets the generation parameters used for all subsequent
+// messages sent in this conversation.
+func (conv *Conversation) SetParameters(params types.Parameters) {
+	conv.params = params
+}
+
+// requestBody builds the body of a chat completion request, translating the
+// conversation's generation parameters to their OpenAI equivalents. The
+// backend's extra body fields and extra parameters are included, unless
+// overridden.
+func (conv *Conversation) requestBody() map[string]interface{} {
+	body := make(map[string]interface{})
+	for key, val := range conv.backend.extraBody {
+		body[key] = val
+	}
+
+	for key, val := range conv.params.Extra {
+		body[key] = val
+	}
+
+	body["model"] = conv.backend.modelID(conv.model)
+	body["messages"] = requestMessages(conv.messages)
+
+	// Reasoning models reject temperatures other than their own default, so
+	// aiac's default temperature is only sent to other models
+	if conv.params.Temperature != nil || conv.params.ReasoningEffort == "" {
+		body["temperature"] = conv.params.TemperatureOrDefault()
+	}
+
+	if conv.params.TopP != nil {
+		body["top_p"] = *conv.params.TopP
+	}
+
+	if conv.params.MaxTokens != nil {
+		body["max_tokens"] = *conv.params.MaxTokens
+	}
+
+	if len(conv.params.Stop) > 0 {
+		body["stop"] = conv.params.Stop
+	}
+
+	if conv.params.Format == types.FormatJSON {
+		body["response_format"] = map[string]string{"type": "json_object"}
+	}
+
+	if conv.params.Seed != nil {
+		body[conv.backend.seedParam] = *conv.params.Seed
+	}
+
+	if conv.params.ReasoningEffort != "" {
+		if conv.backend.nestedReasoning {
+			body["reasoning"] = map[string]string{"effort": conv.params.ReasoningEffort}
+		} else {
+			body["reasoning_effort"] = conv.params.ReasoningEffort
+		}
+	}
+
+	return body
+}
+
+// requestMessage is a message of a chat completion request. The content of
+// messages with images is a list of content parts rather than text.
+type requestMessage struct {
+	Role    string      `json:"role"`
+	Content interface{} `json:"content"`
+}
+
+// contentPart is a part of the content of a message, either text or an image
+// provided as a data URL.
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+// requestMessages converts the conversation's messages into those of a chat
+// completion request, with the images of messages that have them following
+// their text.
+func requestMessages(msgs []types.Message) []requestMessage {
+	converted := make([]requestMessage, len(msgs))
+	for i, msg := range msgs {
+		converted[i] = requestMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.Images) == 0 {
+			continue
+		}
+
+		parts := []contentPart{{Type: "text", Text: msg.Content}}
+		for _, img := range msg.Images {
+			parts = append(parts, contentPart{
+				Type:     "image_url",
+				ImageURL: &imageURL{URL: img.DataURL()},
+			})
+		}
+
+		converted[i].Content = parts
+	}
+
+	return converted
+}
@@ -0,0 +1,77 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// CompatibleOptions is a struct containing all the parameters accepted by the
+// NewCompatible constructor.
+type CompatibleOptions struct {
+	// URL is the base URL of the server's API, including any path prefix
+	// (e.g. "http://localhost:8000/v1"). Required.
+	URL string
+
+	// APIKey is the API key to use for requests. Optional, as many local
+	// servers do not require authentication.
+	APIKey string
+
+	// AuthHeader is the header where the API key is sent. Optional, defaults
+	// to Authorization. See Options.AuthHeader.
+	AuthHeader string
+
+	// APIVersion is sent as the "api-version" query parameter, if provided.
+	APIVersion string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// server.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewCompatible creates a new instance of the OpenAI struct that talks to any
+// server implementing the OpenAI chat completions API, such as LocalAI, vLLM
+// or LM Studio. Unlike New, nothing is inferred from the URL: the API key is
+// sent exactly as configured, and options only the official API is known to
+// support are never used. An error is returned if the URL is not provided.
+func NewCompatible(opts *CompatibleOptions) (*OpenAI, error) {
+	if opts == nil || opts.URL == "" {
+		return nil, fmt.Errorf(
+			"%w: openai_compatible backends require a url",
+			types.ErrInvalidBackendConfig,
+		)
+	}
+
+	// An explicit header prevents New from guessing it based on the URL
+	authHeader := opts.AuthHeader
+	if authHeader == "" {
+		authHeader = "Authorization"
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		APIVersion:   opts.APIVersion,
+		AuthHeader:   authHeader,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "OpenAI-compatible API",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.streamUsage = false
+
+	return backend, nil
+}
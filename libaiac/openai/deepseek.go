@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DeepSeekBackend is the default URI endpoint for DeepSeek's OpenAI-compatible
+// API.
+const DeepSeekBackend = "https://api.deepseek.com"
+
+// DeepSeekOptions is a struct containing all the parameters accepted by the
+// NewDeepSeek constructor.
+type DeepSeekOptions struct {
+	// APIKey is the DeepSeek API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the DeepSeek API URL to use. Optional, defaults to DeepSeekBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewDeepSeek creates a new instance of the OpenAI struct that talks to
+// DeepSeek's OpenAI-compatible API. Reasoning models such as
+// "deepseek-reasoner" return their chain of thought separately from the
+// answer; it is available in types.Response.Reasoning, and is never sent back
+// to the API in subsequent messages, as DeepSeek requires. An error is
+// returned if an API key is not provided.
+func NewDeepSeek(opts *DeepSeekOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: deepseek backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = DeepSeekBackend
+	}
+
+	return New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "DeepSeek",
+	})
+}
@@ -0,0 +1,137 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// FireworksBackend is the default URI endpoint for Fireworks AI's
+// OpenAI-compatible API.
+const FireworksBackend = "https://api.fireworks.ai/inference/v1"
+
+// FireworksModelPrefix is the prefix of the IDs of the models Fireworks AI
+// serves itself, as opposed to models deployed by other accounts.
+const FireworksModelPrefix = "accounts/fireworks/models/"
+
+// FireworksOptions is a struct containing all the parameters accepted by the
+// NewFireworks constructor.
+type FireworksOptions struct {
+	// APIKey is the Fireworks AI API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Fireworks AI API URL to use. Optional, defaults to
+	// FireworksBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewFireworks creates a new instance of the OpenAI struct that talks to
+// Fireworks AI's OpenAI-compatible API, which serves open models by IDs such
+// as "accounts/fireworks/models/llama-v3p3-70b-instruct". Models served by
+// Fireworks itself may also be referred to by their names alone (e.g.
+// "llama-v3p3-70b-instruct"), which are expanded to their full IDs.
+// Fireworks reports token usage in the last chunk of streamed responses
+// without being asked to. An error is returned if an API key is not
+// provided.
+func NewFireworks(opts *FireworksOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: fireworks backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = FireworksBackend
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Fireworks AI",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.fireworks = true
+	backend.candidates = true
+
+	return backend, nil
+}
+
+// modelID returns the ID of a model to send in requests. For Fireworks AI,
+// models referred to by their names alone are expanded to the IDs of the
+// models Fireworks serves itself (see FireworksModelPrefix).
+func (backend *OpenAI) modelID(model string) string {
+	if backend.fireworks && model != "" && !strings.Contains(model, "/") {
+		return FireworksModelPrefix + model
+	}
+
+	return model
+}
+
+// listFireworksModels lists the models served by Fireworks AI's API, with
+// their context length. Models that do not support chat, such as embedding
+// and image models, are not listed.
+func (backend *OpenAI) listFireworksModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	var answer struct {
+		Data []struct {
+			ID            string `json:"id"`
+			OwnedBy       string `json:"owned_by"`
+			SupportsChat  bool   `json:"supports_chat"`
+			ContextLength int    `json:"context_length"`
+		} `json:"data"`
+	}
+
+	err = backend.NewRequest("GET", "/models").
+		Into(&answer).
+		RunContext(ctx)
+	if err != nil {
+		return models, fmt.Errorf("failed listing models: %w", err)
+	}
+
+	for _, model := range answer.Data {
+		if !model.SupportsChat {
+			continue
+		}
+
+		models = append(models, types.Model{
+			ID:            model.ID,
+			Owner:         model.OwnedBy,
+			ContextWindow: model.ContextLength,
+		})
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// GroqBackend is the default URI endpoint for Groq's OpenAI-compatible API.
+const GroqBackend = "https://api.groq.com/openai/v1"
+
+// GroqOptions is a struct containing all the parameters accepted by the
+// NewGroq constructor.
+type GroqOptions struct {
+	// APIKey is the Groq API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Groq API URL to use. Optional, defaults to GroqBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewGroq creates a new instance of the OpenAI struct that talks to Groq's
+// OpenAI-compatible API. Groq enforces strict rate limits; when retries are
+// enabled in the HTTP client (see the transport package), the rate limit
+// headers returned by Groq determine how long to wait before retrying. An
+// error is returned if an API key is not provided.
+func NewGroq(opts *GroqOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: groq backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = GroqBackend
+	}
+
+	return New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Groq",
+	})
+}
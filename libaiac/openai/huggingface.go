@@ -0,0 +1,123 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// HuggingFaceBackend is the default URI endpoint for the Hugging Face
+// serverless Inference API, under which every model is served at its own URL.
+const HuggingFaceBackend = "https://api-inference.huggingface.co/models"
+
+// HuggingFaceOptions is a struct containing all the parameters accepted by
+// the NewHuggingFace constructor.
+type HuggingFaceOptions struct {
+	// APIKey is the Hugging Face access token, sent as a bearer token.
+	// Required for the serverless Inference API.
+	APIKey string
+
+	// URL is the URL of a dedicated Inference Endpoint (e.g.
+	// "https://xyz.us-east-1.aws.endpoints.huggingface.cloud"). Optional,
+	// the serverless Inference API is used if not provided.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewHuggingFace creates a new instance of the OpenAI struct that talks to
+// the Hugging Face Inference API, or to a dedicated Inference Endpoint if a
+// URL is provided, via their OpenAI-compatible chat completions route for
+// text-generation models. With the serverless API, models are identified by
+// their IDs on the Hugging Face Hub (e.g. "meta-llama/Meta-Llama-3-8B-Instruct"),
+// and cannot be listed. Models that are not loaded yet are reported with a
+// 503 status and an estimated loading time, which the retry middleware waits
+// for when retries are enabled (see the transport package). An error is
+// returned if neither an API key nor a URL are provided.
+func NewHuggingFace(opts *HuggingFaceOptions) (*OpenAI, error) {
+	if opts == nil || (opts.APIKey == "" && opts.URL == "") {
+		return nil, fmt.Errorf(
+			"%w: huggingface backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	url := HuggingFaceBackend
+	if opts.URL != "" {
+		// Inference Endpoints serve the chat completions route under /v1
+		url = strings.TrimSuffix(opts.URL, "/")
+		if !strings.HasSuffix(url, "/v1") {
+			url += "/v1"
+		}
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          url,
+		AuthHeader:   "Authorization",
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Hugging Face",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.perModel = opts.URL == ""
+	backend.HTTPClient.ErrorHandler(huggingFaceError)
+
+	return backend, nil
+}
+
+// huggingFaceError handles error responses from the Hugging Face Inference
+// API, whose error is generally a string rather than an object, accompanied
+// by an estimated time for models that are still loading. Errors in the
+// format of the OpenAI API are handled as well.
+func huggingFaceError(httpStatus int, _ string, body io.Reader) error {
+	var res struct {
+		Error         json.RawMessage `json:"error"`
+		EstimatedTime float64         `json:"estimated_time"`
+	}
+
+	err := json.NewDecoder(body).Decode(&res)
+	if err == nil && len(res.Error) > 0 {
+		var msg string
+		if json.Unmarshal(res.Error, &msg) != nil {
+			var obj struct {
+				Message string `json:"message"`
+			}
+			if json.Unmarshal(res.Error, &obj) == nil {
+				msg = obj.Message
+			}
+		}
+
+		if msg != "" {
+			if res.EstimatedTime > 0 {
+				msg = fmt.Sprintf("%s (estimated time: %.0fs)", msg, res.EstimatedTime)
+			}
+
+			return &types.APIError{
+				Provider:   "Hugging Face",
+				StatusCode: httpStatus,
+				Message:    msg,
+			}
+		}
+	}
+
+	return &types.APIError{Provider: "Hugging Face", StatusCode: httpStatus}
+}
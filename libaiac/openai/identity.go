@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Identity returns the organization and project the API key belongs to, as
+// reported by the OpenAI API in the headers of its responses, or for
+// OpenRouter, the label of the API key. An error wrapping
+// types.ErrUnsupported is returned for other providers, which do not report
+// them.
+func (backend *OpenAI) Identity(ctx context.Context) (identity types.Identity, err error) {
+	if backend.openrouter {
+		return backend.openRouterIdentity(ctx)
+	}
+
+	// Azure OpenAI authenticates with resource keys, and the serverless
+	// Inference API has no list of models to request
+	if backend.azure || backend.perModel {
+		return identity, fmt.Errorf("%w: identity of API keys", types.ErrUnsupported)
+	}
+
+	err = backend.NewRequest("GET", "/models").
+		HeaderInto("openai-organization", &identity.Organization).
+		HeaderInto("openai-project", &identity.Project).
+		RunContext(ctx)
+	if err != nil {
+		return identity, fmt.Errorf("failed getting identity: %w", err)
+	}
+
+	if identity.Organization == "" {
+		return identity, fmt.Errorf(
+			"%w: the provider does not report the organization of API keys",
+			types.ErrUnsupported,
+		)
+	}
+
+	return identity, nil
+}
+
+// openRouterIdentity returns the label of the OpenRouter API key.
+func (backend *OpenAI) openRouterIdentity(ctx context.Context) (identity types.Identity, err error) {
+	var answer struct {
+		Data struct {
+			Label string `json:"label"`
+		} `json:"data"`
+	}
+
+	err = backend.NewRequest("GET", "/key").
+		Into(&answer).
+		RunContext(ctx)
+	if err != nil {
+		return identity, fmt.Errorf("failed getting identity: %w", err)
+	}
+
+	identity.User = answer.Data.Label
+
+	return identity, nil
+}
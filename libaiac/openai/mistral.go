@@ -0,0 +1,67 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// MistralBackend is the default URI endpoint for Mistral's La Plateforme API.
+const MistralBackend = "https://api.mistral.ai/v1"
+
+// MistralOptions is a struct containing all the parameters accepted by the
+// NewMistral constructor.
+type MistralOptions struct {
+	// APIKey is the Mistral API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Mistral API URL to use. Optional, defaults to
+	// MistralBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request, such as Mistral's "safe_prompt" or "random_seed" options.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewMistral creates a new instance of the OpenAI struct that talks to
+// Mistral's API, which implements the chat completions and model listing
+// endpoints of the OpenAI API. An error is returned if an API key is not
+// provided.
+func NewMistral(opts *MistralOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: mistral backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = MistralBackend
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Mistral",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.seedParam = "random_seed"
+
+	return backend, nil
+}
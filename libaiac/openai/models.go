@@ -3,6 +3,7 @@ package openai
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"sort"
 
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
@@ -10,33 +11,102 @@ import (
 
 // ListModels returns a list of all the models supported by this backend.
 func (backend *OpenAI) ListModels(ctx context.Context) (
-	models []string,
+	models []types.Model,
 	err error,
 ) {
 	var answer struct {
 		Data []struct {
-			ID string `json:"id"`
+			ID      string `json:"id"`
+			OwnedBy string `json:"owned_by"`
+			Model   string `json:"model"`
+
+			// Returned by some OpenAI-compatible providers, such as Mistral,
+			// OpenRouter and Groq
+			Name             string `json:"name"`
+			MaxContextLength int    `json:"max_context_length"`
+			ContextLength    int    `json:"context_length"`
+			ContextWindow    int    `json:"context_window"`
 		} `json:"data"`
 	}
 
-	err = backend.
-		NewRequest("GET", "/models").
+	// The Hugging Face serverless Inference API serves every model of the
+	// Hub at its own URL, without a list of them
+	if backend.perModel {
+		return models, fmt.Errorf(
+			"%w: listing models of the serverless Inference API, browse "+
+				"text-generation models on the Hugging Face Hub instead",
+			types.ErrUnsupported,
+		)
+	}
+
+	if backend.models != nil {
+		return append(models, backend.models...), nil
+	}
+
+	if backend.together {
+		return backend.listTogetherModels(ctx)
+	}
+
+	if backend.fireworks {
+		return backend.listFireworksModels(ctx)
+	}
+
+	// Azure OpenAI lists deployments rather than models, as deployment names
+	// are used in place of model names
+	path := "/models"
+	if backend.azure {
+		path = "/deployments"
+	}
+
+	var status int
+
+	req := backend.NewRequest("GET", path).
 		Into(&answer).
-		RunContext(ctx)
+		StatusInto(&status)
+	if len(backend.apiVersion) > 0 {
+		req.QueryParam("api-version", backend.apiVersion)
+	}
+
+	err = req.RunContext(ctx)
 	if err != nil {
-		return models, fmt.Errorf("failed sending prompt: %w", err)
+		// Newer versions of the Azure OpenAI API no longer allow listing
+		// deployments through the data plane
+		if backend.azure && status == http.StatusNotFound {
+			return models, fmt.Errorf(
+				"%w: listing deployments with API version %s",
+				types.ErrUnsupported,
+				backend.apiVersion,
+			)
+		}
+		return models, fmt.Errorf("failed listing models: %w", err)
 	}
 
 	if len(answer.Data) == 0 {
 		return models, types.ErrNoResults
 	}
 
-	models = make([]string, len(answer.Data))
-	for i := range answer.Data {
-		models[i] = answer.Data[i].ID
+	models = make([]types.Model, len(answer.Data))
+	for i, model := range answer.Data {
+		models[i] = types.Model{
+			ID:            model.ID,
+			Name:          model.Name,
+			Owner:         model.OwnedBy,
+			ContextWindow: model.MaxContextLength,
+		}
+		if model.ContextLength > 0 {
+			models[i].ContextWindow = model.ContextLength
+		}
+		if model.ContextWindow > 0 {
+			models[i].ContextWindow = model.ContextWindow
+		}
+		if backend.azure {
+			models[i].Name = model.Model
+		}
 	}
 
-	sort.Strings(models)
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
 
 	return models, nil
 }
@@ -20,6 +20,52 @@ type OpenAI struct {
 	apiKey     string
 	apiVersion string
 	authHeader string
+
+	// azure is true when the backend talks to an Azure OpenAI resource,
+	// which addresses models by deployment names
+	azure bool
+
+	// perModel is true when every model is served at its own URL, under the
+	// model's ID, as by the Hugging Face serverless Inference API
+	perModel bool
+
+	// together is true when the backend talks to Together AI's API, which
+	// lists models in its own format
+	together bool
+
+	// openrouter is true when the backend talks to OpenRouter's API, which
+	// reports the identity of API keys at its own endpoint
+	openrouter bool
+
+	// fireworks is true when the backend talks to Fireworks AI's API, which
+	// addresses models by account-scoped IDs
+	fireworks bool
+
+	// streamUsage is true when the API is known to support reporting token
+	// usage in streamed responses. OpenAI-compatible servers may reject the
+	// option, so it is only enabled for the official API.
+	streamUsage bool
+
+	// candidates is true when the API is known to support generating
+	// multiple responses in a single request (the "n" parameter)
+	candidates bool
+
+	// seedParam is the name of the field of chat requests holding the seed,
+	// which is "seed" except for providers that name it differently (e.g.
+	// Mistral's "random_seed")
+	seedParam string
+
+	// nestedReasoning is true when the API expects the reasoning effort in a
+	// "reasoning" object, as OpenRouter does, rather than in the
+	// "reasoning_effort" field
+	nestedReasoning bool
+
+	// extraBody holds extra fields to include in chat requests
+	extraBody map[string]interface{}
+
+	// models, if not nil, are the models listed by ListModels, for APIs
+	// that do not provide a way to list them
+	models []types.Model
 }
 
 // Options is a struct containing all the parameters accepted by the New
@@ -44,6 +90,20 @@ type Options struct {
 	// ExtraHeaders are extra HTTP headers to send with every request to the
 	// provider.
 	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request, for options supported by OpenAI-compatible providers but not
+	// by aiac itself. Fields set by aiac (such as the model and messages)
+	// take precedence.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+
+	// Provider is the name of the provider reported in errors it returns
+	// (see types.APIError). Optional, defaults to "OpenAI".
+	Provider string
 }
 
 // New creates a new instance of the OpenAI struct, with the provided input
@@ -57,51 +117,23 @@ func New(opts *Options) (*OpenAI, error) {
 		opts.URL = OpenAIBackend
 	}
 
+	provider := opts.Provider
+	if provider == "" {
+		provider = "OpenAI"
+	}
+
 	backend := &OpenAI{
-		apiKey:     opts.ApiKey,
-		apiVersion: opts.APIVersion,
+		apiKey:      opts.ApiKey,
+		apiVersion:  opts.APIVersion,
+		streamUsage: opts.URL == OpenAIBackend,
+		candidates:  opts.URL == OpenAIBackend,
+		seedParam:   "seed",
+		extraBody:   opts.ExtraBody,
 
 		HTTPClient: requests.NewClient(opts.URL).
 			Accept("application/json").
-			ErrorHandler(func(
-				httpStatus int,
-				contentType string,
-				body io.Reader,
-			) error {
-				var res struct {
-					Error struct {
-						Message string `json:"message"`
-						Type    string `json:"type"`
-					} `json:"error"`
-					Message string `json:"message"`
-					Status  string `json:"status"`
-				}
-
-				err := json.NewDecoder(body).Decode(&res)
-				if err == nil {
-					if res.Error.Type != "" {
-						return fmt.Errorf(
-							"%w: [%s]: %s",
-							types.ErrRequestFailed,
-							res.Error.Type,
-							res.Error.Message,
-						)
-					} else if res.Message != "" {
-						return fmt.Errorf(
-							"%w: [%s]: %s",
-							types.ErrRequestFailed,
-							res.Status,
-							res.Message,
-						)
-					}
-				}
-
-				return fmt.Errorf(
-					"%w %s",
-					types.ErrUnexpectedStatus,
-					http.StatusText(httpStatus),
-				)
-			}),
+			Timeout(types.NoTimeout).
+			ErrorHandler(apiErrorHandler(provider)),
 	}
 
 	if opts.ApiKey != "" {
@@ -142,5 +174,70 @@ func New(opts *Options) (*OpenAI, error) {
 		backend.HTTPClient.Header(header, value)
 	}
 
+	if opts.HTTPClient != nil {
+		backend.HTTPClient.CustomHTTPClient(opts.HTTPClient)
+	}
+
 	return backend, nil
 }
+
+// chatPath returns the API path for chat completions with the provided model.
+// With Azure OpenAI, the model is the name of the deployment, and with the
+// Hugging Face serverless Inference API, the ID of the model.
+func (backend *OpenAI) chatPath(model string) string {
+	path := "/chat/completions"
+	switch {
+	case backend.azure:
+		path = fmt.Sprintf("/deployments/%s/chat/completions", model)
+	case backend.perModel:
+		path = fmt.Sprintf("/%s/v1/chat/completions", model)
+	}
+
+	if len(backend.apiVersion) > 0 {
+		path = fmt.Sprintf("%s?api-version=%s", path, backend.apiVersion)
+	}
+
+	return path
+}
+
+// apiErrorHandler returns a handler of error responses from the OpenAI API, or
+// from OpenAI-compatible APIs of the provider with the provided name, which
+// generally return errors in the same format, but some return a message and
+// status at the top level instead.
+func apiErrorHandler(provider string) requests.ErrorHandlerFunc {
+	return func(httpStatus int, _ string, body io.Reader) error {
+		var res struct {
+			Error struct {
+				Message string          `json:"message"`
+				Type    string          `json:"type"`
+				Code    json.RawMessage `json:"code"`
+			} `json:"error"`
+			Message string `json:"message"`
+			Status  string `json:"status"`
+		}
+
+		apiErr := &types.APIError{Provider: provider, StatusCode: httpStatus}
+
+		if json.NewDecoder(body).Decode(&res) != nil {
+			return apiErr
+		}
+
+		switch {
+		case res.Error.Message != "":
+			// The code is more specific than the type (e.g.
+			// "invalid_api_key" rather than "invalid_request_error"), but
+			// it is not always set, and not always a string
+			apiErr.Message = res.Error.Message
+			apiErr.Code = res.Error.Type
+			var code string
+			if json.Unmarshal(res.Error.Code, &code) == nil && code != "" {
+				apiErr.Code = code
+			}
+		case res.Message != "":
+			apiErr.Message = res.Message
+			apiErr.Code = res.Status
+		}
+
+		return apiErr
+	}
+}
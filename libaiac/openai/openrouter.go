@@ -0,0 +1,94 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// OpenRouterBackend is the default URI endpoint for the OpenRouter API.
+const OpenRouterBackend = "https://openrouter.ai/api/v1"
+
+// OpenRouter identifies applications by the HTTP-Referer and X-Title headers.
+// These are the values sent unless overridden via extra headers.
+const (
+	DefaultOpenRouterReferer = "https://github.com/gofireflyio/aiac"
+	DefaultOpenRouterTitle   = "aiac"
+)
+
+// OpenRouterOptions is a struct containing all the parameters accepted by the
+// NewOpenRouter constructor.
+type OpenRouterOptions struct {
+	// APIKey is the OpenRouter API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the OpenRouter API URL to use. Optional, defaults to
+	// OpenRouterBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider. These may override the HTTP-Referer and X-Title headers.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request, such as OpenRouter's "provider" routing preferences.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewOpenRouter creates a new instance of the OpenAI struct that talks to
+// OpenRouter, which proxies models of many providers behind the OpenAI API.
+// Models are identified by IDs such as "anthropic/claude-3.5-sonnet". The
+// provider that served each response is reported in Response.Provider, and
+// the reasoning effort is translated to OpenRouter's unified "reasoning"
+// object, which it maps to each provider's native control. An error is
+// returned if an API key is not provided.
+func NewOpenRouter(opts *OpenRouterOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: openrouter backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = OpenRouterBackend
+	}
+
+	headers := map[string]string{
+		"HTTP-Referer": DefaultOpenRouterReferer,
+		"X-Title":      DefaultOpenRouterTitle,
+	}
+
+	for header, value := range opts.ExtraHeaders {
+		// Extra headers may use any capitalization
+		for defaultHeader := range headers {
+			if http.CanonicalHeaderKey(header) == http.CanonicalHeaderKey(defaultHeader) {
+				delete(headers, defaultHeader)
+			}
+		}
+
+		headers[header] = value
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: headers,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "OpenRouter",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.openrouter = true
+	backend.nestedReasoning = true
+
+	return backend, nil
+}
@@ -0,0 +1,78 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// PerplexityBackend is the default URI endpoint for Perplexity's
+// OpenAI-compatible API.
+const PerplexityBackend = "https://api.perplexity.ai"
+
+// PerplexityModels are the models served by Perplexity's API, which does not
+// provide a way to list them. The "online" models search the web to ground
+// their responses.
+var PerplexityModels = []types.Model{
+	{ID: "llama-3.1-sonar-small-128k-online", ContextWindow: 127072},
+	{ID: "llama-3.1-sonar-large-128k-online", ContextWindow: 127072},
+	{ID: "llama-3.1-sonar-huge-128k-online", ContextWindow: 127072},
+}
+
+// PerplexityOptions is a struct containing all the parameters accepted by the
+// NewPerplexity constructor.
+type PerplexityOptions struct {
+	// APIKey is the Perplexity API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Perplexity API URL to use. Optional, defaults to
+	// PerplexityBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewPerplexity creates a new instance of the OpenAI struct that talks to
+// Perplexity's OpenAI-compatible API. Perplexity's models search the web to
+// ground their responses, and return the URLs of the sources they used; they
+// are available in types.Response.Citations. Listing models returns
+// PerplexityModels. An error is returned if an API key is not provided.
+func NewPerplexity(opts *PerplexityOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: perplexity backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = PerplexityBackend
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Perplexity",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.models = PerplexityModels
+
+	return backend, nil
+}
@@ -0,0 +1,127 @@
+package openai
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// TogetherBackend is the default URI endpoint for Together AI's
+// OpenAI-compatible API.
+const TogetherBackend = "https://api.together.xyz/v1"
+
+// TogetherOptions is a struct containing all the parameters accepted by the
+// NewTogether constructor.
+type TogetherOptions struct {
+	// APIKey is the Together AI API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the Together AI API URL to use. Optional, defaults to
+	// TogetherBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewTogether creates a new instance of the OpenAI struct that talks to
+// Together AI's OpenAI-compatible API, which serves open models by their
+// Hugging Face-style IDs (e.g. "meta-llama/Llama-3.3-70B-Instruct-Turbo").
+// Together reports token usage in the last chunk of streamed responses
+// without being asked to. An error is returned if an API key is not
+// provided.
+func NewTogether(opts *TogetherOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: together backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = TogetherBackend
+	}
+
+	backend, err := New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "Together AI",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	backend.together = true
+
+	return backend, nil
+}
+
+// togetherModelTypes are the types of models listed by Together AI's API
+// that generate text from chat messages. Image, embedding, moderation and
+// rerank models are not listed.
+var togetherModelTypes = map[string]bool{
+	"chat":     true,
+	"language": true,
+	"code":     true,
+}
+
+// listTogetherModels lists the models served by Together AI's API, which
+// returns them as a bare array rather than under "data", with their type,
+// display name and context length.
+func (backend *OpenAI) listTogetherModels(ctx context.Context) (
+	models []types.Model,
+	err error,
+) {
+	var answer []struct {
+		ID            string `json:"id"`
+		Type          string `json:"type"`
+		DisplayName   string `json:"display_name"`
+		Organization  string `json:"organization"`
+		ContextLength int    `json:"context_length"`
+	}
+
+	err = backend.NewRequest("GET", "/models").
+		Into(&answer).
+		RunContext(ctx)
+	if err != nil {
+		return models, fmt.Errorf("failed listing models: %w", err)
+	}
+
+	for _, model := range answer {
+		if !togetherModelTypes[model.Type] {
+			continue
+		}
+
+		models = append(models, types.Model{
+			ID:            model.ID,
+			Name:          model.DisplayName,
+			Owner:         model.Organization,
+			ContextWindow: model.ContextLength,
+		})
+	}
+
+	if len(models) == 0 {
+		return models, types.ErrNoResults
+	}
+
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].ID < models[j].ID
+	})
+
+	return models, nil
+}
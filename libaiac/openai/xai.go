@@ -0,0 +1,58 @@
+package openai
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// XAIBackend is the default URI endpoint for xAI's OpenAI-compatible API.
+const XAIBackend = "https://api.x.ai/v1"
+
+// XAIOptions is a struct containing all the parameters accepted by the
+// NewXAI constructor.
+type XAIOptions struct {
+	// APIKey is the xAI API key, sent as a bearer token. Required.
+	APIKey string
+
+	// URL is the xAI API URL to use. Optional, defaults to XAIBackend.
+	URL string
+
+	// ExtraHeaders are extra HTTP headers to send with every request to the
+	// provider.
+	ExtraHeaders map[string]string
+
+	// ExtraBody holds extra fields to include in the body of every chat
+	// request.
+	ExtraBody map[string]interface{}
+
+	// HTTPClient is the HTTP client to use for requests. Optional, a default
+	// client is used if not provided.
+	HTTPClient *http.Client
+}
+
+// NewXAI creates a new instance of the OpenAI struct that talks to xAI's
+// OpenAI-compatible API, which serves the Grok models (e.g. "grok-2" and
+// "grok-beta"). An error is returned if an API key is not provided.
+func NewXAI(opts *XAIOptions) (*OpenAI, error) {
+	if opts == nil || opts.APIKey == "" {
+		return nil, fmt.Errorf(
+			"%w: xai backends require an api_key",
+			types.ErrMissingAPIKey,
+		)
+	}
+
+	if opts.URL == "" {
+		opts.URL = XAIBackend
+	}
+
+	return New(&Options{
+		ApiKey:       opts.APIKey,
+		URL:          opts.URL,
+		ExtraHeaders: opts.ExtraHeaders,
+		ExtraBody:    opts.ExtraBody,
+		HTTPClient:   opts.HTTPClient,
+		Provider:     "xAI",
+	})
+}
@@ -0,0 +1,99 @@
+package libaiac
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+const (
+	// EnvPostProcessLanguage is the environment variable holding the
+	// language of the code fed to post-processing commands (see
+	// types.Response.Language). It is empty if the language is unknown.
+	EnvPostProcessLanguage = "AIAC_LANGUAGE"
+
+	// EnvPostProcessBackend is the environment variable holding the name of
+	// the backend that generated the code fed to post-processing commands.
+	EnvPostProcessBackend = "AIAC_BACKEND"
+
+	// EnvPostProcessModel is the environment variable holding the model that
+	// generated the code fed to post-processing commands.
+	EnvPostProcessModel = "AIAC_MODEL"
+)
+
+// PostProcess pipes the code of a response through the provided commands, in
+// order, feeding the code to the standard input of the first and the standard
+// output of each to the next. Commands are run by the system shell ("sh" on
+// Unix-like operating systems, "cmd" on Windows), so they may include
+// arguments, quotes and pipes. Besides the environment of aiac, they receive
+// the language of the code, and the backend and model that generated it (see
+// EnvPostProcessLanguage, EnvPostProcessBackend and EnvPostProcessModel). The
+// response is returned with the output of the last command as its code. If a
+// command cannot be run or exits with a non-zero status, an error wrapping
+// types.ErrPostProcessFailed is returned, including the command's standard
+// error.
+func PostProcess(ctx context.Context, res types.Response, commands ...string) (
+	types.Response,
+	error,
+) {
+	env := append(
+		os.Environ(),
+		EnvPostProcessLanguage+"="+res.Language,
+		EnvPostProcessBackend+"="+res.Backend,
+		EnvPostProcessModel+"="+res.Model,
+	)
+
+	for _, command := range commands {
+		code, err := runShell(ctx, command, res.Code+"\n", env)
+		if err != nil {
+			return res, err
+		}
+
+		res.Code = strings.TrimRight(code, "\n")
+	}
+
+	return res, nil
+}
+
+// runShell runs a command with the system shell, feeding it the provided
+// input, and returns its standard output.
+func runShell(ctx context.Context, command, input string, env []string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	cmd := exec.CommandContext(ctx, shell, flag, command) //nolint: gosec
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return "", fmt.Errorf("failed running %q: %w", command, ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = exitErr.Error()
+		}
+		return "", fmt.Errorf("%w: %q: %s", types.ErrPostProcessFailed, command, msg)
+	case err != nil:
+		return "", fmt.Errorf("%w: %q: %s", types.ErrPostProcessFailed, command, err)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,100 @@
+package libaiac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// BuiltinPresets are the built-in instruction presets, by name (see
+// Aiac.ApplyPresets). Presets of the same name defined in the configuration
+// take precedence.
+var BuiltinPresets = map[string]string{
+	"pinned-versions": "Pin the versions of providers, modules, container " +
+		"images and other dependencies, rather than using the latest ones.",
+	"aws-tagged": "Tag every AWS resource that supports tags with at least " +
+		"Name, Environment and Owner tags, using the provider's default_tags " +
+		"where possible.",
+	"variables": "Use input variables with types and descriptions instead of " +
+		"hardcoding values such as names, regions, sizes and counts, and " +
+		"output the identifiers of the resources created.",
+	"least-privilege": "Follow the principle of least privilege: grant only " +
+		"the permissions and network access required, never wildcards or " +
+		"access from the entire internet.",
+	"encrypted": "Enable encryption at rest and in transit for every " +
+		"resource that supports it.",
+}
+
+// Preset returns the instructions of the preset with the provided name,
+// defined either in the configuration or among BuiltinPresets. An error
+// wrapping types.ErrNoSuchPreset, listing the available presets, is returned
+// if there is no such preset.
+func (aiac *Aiac) Preset(name string) (string, error) {
+	if preset, ok := aiac.Conf.Presets[name]; ok {
+		return strings.TrimSpace(preset), nil
+	}
+
+	if preset, ok := BuiltinPresets[name]; ok {
+		return preset, nil
+	}
+
+	return "", fmt.Errorf(
+		"%w: %q (available presets: %s)",
+		types.ErrNoSuchPreset, name, strings.Join(aiac.Presets(), ", "),
+	)
+}
+
+// Presets returns the names of all available presets, from the
+// configuration and among BuiltinPresets, sorted.
+func (aiac *Aiac) Presets() []string {
+	names := make([]string, 0, len(BuiltinPresets)+len(aiac.Conf.Presets))
+	for name := range BuiltinPresets {
+		names = append(names, name)
+	}
+
+	for name := range aiac.Conf.Presets {
+		if _, ok := BuiltinPresets[name]; !ok {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// ApplyPresets appends the instructions of the named presets (see Preset) to
+// a prompt, in order, as a list of conventions the generated code must
+// follow. Unlike templates, presets augment the prompt rather than replace
+// it. Presets named more than once are only applied once. The prompt is
+// returned as-is if no presets are named.
+func (aiac *Aiac) ApplyPresets(prompt string, names ...string) (string, error) {
+	if len(names) == 0 {
+		return prompt, nil
+	}
+
+	seen := make(map[string]bool, len(names))
+	instructions := make([]string, 0, len(names))
+
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		preset, err := aiac.Preset(name)
+		if err != nil {
+			return "", err
+		}
+
+		instructions = append(instructions, "- "+preset)
+	}
+
+	return fmt.Sprintf(
+		"%s\n\nFollow these conventions:\n%s",
+		strings.TrimRight(prompt, "\n"),
+		strings.Join(instructions, "\n"),
+	), nil
+}
@@ -0,0 +1,214 @@
+package libaiac
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/openai"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Price is the price of using a model, in US dollars per 1,000 tokens.
+type Price struct {
+	// Input is the price of 1,000 prompt tokens.
+	Input float64 `toml:"input"`
+
+	// Output is the price of 1,000 generated tokens.
+	Output float64 `toml:"output"`
+}
+
+// DefaultPricing holds the list prices of commonly used models, by backend
+// type and model name. Model names are matched by prefix, with the longest
+// matching name winning, so that "gpt-4o" also applies to dated versions
+// such as "gpt-4o-2024-08-06". Prices change over time, so costs computed
+// from this table are estimates only; they can be overridden via the
+// configuration (see Config.Pricing).
+var DefaultPricing = map[BackendType]map[string]Price{
+	BackendOpenAI: {
+		"gpt-4.1":       {Input: 0.002, Output: 0.008},
+		"gpt-4.1-mini":  {Input: 0.0004, Output: 0.0016},
+		"gpt-4.1-nano":  {Input: 0.0001, Output: 0.0004},
+		"gpt-4o":        {Input: 0.0025, Output: 0.01},
+		"gpt-4o-mini":   {Input: 0.00015, Output: 0.0006},
+		"gpt-4-turbo":   {Input: 0.01, Output: 0.03},
+		"gpt-4":         {Input: 0.03, Output: 0.06},
+		"gpt-3.5-turbo": {Input: 0.0005, Output: 0.0015},
+		"o1":            {Input: 0.015, Output: 0.06},
+		"o1-mini":       {Input: 0.0011, Output: 0.0044},
+		"o3-mini":       {Input: 0.0011, Output: 0.0044},
+	},
+	BackendAnthropic: {
+		"claude-opus-4":     {Input: 0.015, Output: 0.075},
+		"claude-sonnet-4":   {Input: 0.003, Output: 0.015},
+		"claude-3-7-sonnet": {Input: 0.003, Output: 0.015},
+		"claude-3-5-sonnet": {Input: 0.003, Output: 0.015},
+		"claude-3-5-haiku":  {Input: 0.0008, Output: 0.004},
+		"claude-3-opus":     {Input: 0.015, Output: 0.075},
+		"claude-3-haiku":    {Input: 0.00025, Output: 0.00125},
+	},
+	BackendGemini: {
+		"gemini-2.5-pro":   {Input: 0.00125, Output: 0.01},
+		"gemini-2.5-flash": {Input: 0.0003, Output: 0.0025},
+		"gemini-2.0-flash": {Input: 0.0001, Output: 0.0004},
+		"gemini-1.5-pro":   {Input: 0.00125, Output: 0.005},
+		"gemini-1.5-flash": {Input: 0.000075, Output: 0.0003},
+	},
+	BackendMistral: {
+		"mistral-large":     {Input: 0.002, Output: 0.006},
+		"mistral-medium":    {Input: 0.0004, Output: 0.002},
+		"mistral-small":     {Input: 0.0001, Output: 0.0003},
+		"codestral":         {Input: 0.0003, Output: 0.0009},
+		"open-mistral-nemo": {Input: 0.00015, Output: 0.00015},
+	},
+	BackendGroq: {
+		"llama-3.3-70b-versatile": {Input: 0.00059, Output: 0.00079},
+		"llama-3.1-70b-versatile": {Input: 0.00059, Output: 0.00079},
+		"llama-3.1-8b-instant":    {Input: 0.00005, Output: 0.00008},
+		"mixtral-8x7b-32768":      {Input: 0.00024, Output: 0.00024},
+		"gemma2-9b-it":            {Input: 0.0002, Output: 0.0002},
+	},
+	BackendDeepSeek: {
+		"deepseek-chat":     {Input: 0.00027, Output: 0.0011},
+		"deepseek-reasoner": {Input: 0.00055, Output: 0.00219},
+	},
+	BackendXAI: {
+		"grok-2":    {Input: 0.002, Output: 0.01},
+		"grok-beta": {Input: 0.005, Output: 0.015},
+	},
+	BackendPerplexity: {
+		"llama-3.1-sonar-small": {Input: 0.0002, Output: 0.0002},
+		"llama-3.1-sonar-large": {Input: 0.001, Output: 0.001},
+		"llama-3.1-sonar-huge":  {Input: 0.005, Output: 0.005},
+	},
+	BackendFireworks: {
+		"llama-v3p3-70b-instruct":    {Input: 0.0009, Output: 0.0009},
+		"llama-v3p1-8b-instruct":     {Input: 0.0002, Output: 0.0002},
+		"llama-v3p1-405b-instruct":   {Input: 0.003, Output: 0.003},
+		"qwen2p5-coder-32b-instruct": {Input: 0.0009, Output: 0.0009},
+		"deepseek-v3":                {Input: 0.0009, Output: 0.0009},
+		"mixtral-8x22b-instruct":     {Input: 0.0012, Output: 0.0012},
+	},
+	BackendTogether: {
+		"meta-llama/Llama-3.3-70B-Instruct-Turbo":      {Input: 0.00088, Output: 0.00088},
+		"meta-llama/Meta-Llama-3.1-8B-Instruct-Turbo":  {Input: 0.00018, Output: 0.00018},
+		"meta-llama/Meta-Llama-3.1-70B-Instruct-Turbo": {Input: 0.00088, Output: 0.00088},
+		"Qwen/Qwen2.5-Coder-32B-Instruct":              {Input: 0.0008, Output: 0.0008},
+		"deepseek-ai/DeepSeek-V3":                      {Input: 0.00125, Output: 0.00125},
+	},
+	BackendCohere: {
+		"command-a":      {Input: 0.0025, Output: 0.01},
+		"command-r-plus": {Input: 0.0025, Output: 0.01},
+		"command-r7b":    {Input: 0.0000375, Output: 0.00015},
+		"command-r":      {Input: 0.00015, Output: 0.0006},
+	},
+	BackendBedrock: {
+		"anthropic.claude-3-7-sonnet": {Input: 0.003, Output: 0.015},
+		"anthropic.claude-3-5-sonnet": {Input: 0.003, Output: 0.015},
+		"anthropic.claude-3-5-haiku":  {Input: 0.0008, Output: 0.004},
+		"anthropic.claude-3-haiku":    {Input: 0.00025, Output: 0.00125},
+		"amazon.nova-pro":             {Input: 0.0008, Output: 0.0032},
+		"amazon.nova-lite":            {Input: 0.00006, Output: 0.00024},
+		"amazon.nova-micro":           {Input: 0.000035, Output: 0.00014},
+		"amazon.titan-text-express":   {Input: 0.0002, Output: 0.0006},
+		"meta.llama3-70b-instruct":    {Input: 0.00265, Output: 0.0035},
+		"meta.llama3-8b-instruct":     {Input: 0.0003, Output: 0.0006},
+	},
+}
+
+// Cost estimates the cost of generating the provided response, in US dollars,
+// based on its token usage and the price of the model that generated it. The
+// pricing configuration takes precedence over DefaultPricing. Models of
+// Ollama backends are free unless priced in the configuration. Returns false
+// if the price of the model is unknown.
+func (aiac *Aiac) Cost(res types.Response) (cost float64, ok bool) {
+	backendConf, ok := aiac.Conf.Backends[res.Backend]
+	if !ok {
+		return 0, false
+	}
+
+	backendType := backendConf.Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	price, ok := findModel(aiac.Conf.Pricing[backendType], res.Model)
+	if !ok {
+		price, ok = findModel(DefaultPricing[backendType], res.Model)
+	}
+
+	if !ok {
+		return 0, backendType == BackendOllama
+	}
+
+	return (float64(res.InputTokens)*price.Input +
+		float64(res.OutputTokens)*price.Output) / 1000, true //nolint: gomnd
+}
+
+// findModel finds the entry of a model in a table keyed by model names, such
+// as a pricing table, matching model names by their longest prefix. Bedrock
+// inference profile IDs (e.g. "us.anthropic.claude-3-5-sonnet...") are
+// matched without their region prefix if the full ID does not match, and
+// the IDs of Fireworks AI's models without their account prefix (see
+// openai.FireworksModelPrefix).
+func findModel[T any](table map[string]T, model string) (entry T, ok bool) {
+	model = strings.TrimPrefix(model, "models/")
+	model = strings.TrimPrefix(model, openai.FireworksModelPrefix)
+
+	candidates := []string{model}
+	if _, rest, found := strings.Cut(model, "."); found {
+		candidates = append(candidates, rest)
+	}
+
+	for _, candidate := range candidates {
+		longest := -1
+		for name, e := range table {
+			if strings.HasPrefix(candidate, name) && len(name) > longest {
+				entry, longest = e, len(name)
+			}
+		}
+
+		if longest >= 0 {
+			return entry, true
+		}
+	}
+
+	return entry, false
+}
+
+// estimateUsage estimates the prompt and output token counts of a response
+// for which the API did not report them, based on the provided message
+// history and prompt, and on the output. This is a rough estimate that
+// assumes an average of four characters per token, which is typical for
+// English text and code. The output includes the reasoning, if returned, as
+// reasoning tokens are generated like any other. If the API reported the
+// total number of tokens, it is split between the prompt and the output
+// proportionally to the estimate.
+func estimateUsage(res *types.Response, history []types.Message, prompt string) {
+	if res.InputTokens > 0 || res.OutputTokens > 0 {
+		return
+	}
+
+	input := estimateTokens(prompt)
+	for _, msg := range history {
+		input += estimateTokens(msg.Content)
+	}
+
+	reasoning := estimateTokens(res.Reasoning)
+	output := estimateTokens(res.FullOutput) + reasoning
+
+	if res.TokensUsed > 0 && input+output > 0 {
+		input = res.TokensUsed * input / (input + output)
+		output = res.TokensUsed - input
+	}
+
+	res.InputTokens = input
+	res.OutputTokens = output
+	res.ReasoningTokens = reasoning
+	res.TokensUsed = input + output
+	res.TokensEstimated = true
+}
+
+// estimateTokens estimates the number of tokens in a string.
+func estimateTokens(s string) int64 {
+	return int64(utf8.RuneCountInString(s)+3) / 4 //nolint: gomnd
+}
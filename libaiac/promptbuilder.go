@@ -0,0 +1,195 @@
+package libaiac
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// PromptRequest describes the code requested by the user, from which a
+// PromptBuilder builds the prompt sent to the model.
+type PromptRequest struct {
+	// Kind is the kind of code requested (see DetectKind), or empty if the
+	// request does not mention a known kind.
+	Kind string
+
+	// Request is what to generate, as written by the user (e.g. "terraform
+	// for an EKS cluster").
+	Request string
+
+	// Explain is true if the response should include explanations along with
+	// the code, such as when its full output is saved or printed.
+	Explain bool
+}
+
+// Prompt is a prompt built by a PromptBuilder.
+type Prompt struct {
+	// System is the system prompt of the conversation. If empty, the system
+	// prompt of the backend applies, if any (see BackendConfig.SystemPrompt).
+	System string
+
+	// User is the message sent to the model.
+	User string
+}
+
+// PromptBuilder builds the prompts that request code from models, for a kind
+// of code (see Aiac.BuildPrompt).
+type PromptBuilder interface {
+	// BuildPrompt returns the prompt to send to the model for the request.
+	BuildPrompt(req PromptRequest) (Prompt, error)
+}
+
+// PromptBuilderFunc is a function that implements PromptBuilder.
+type PromptBuilderFunc func(req PromptRequest) (Prompt, error)
+
+// BuildPrompt implements PromptBuilder by calling the function.
+func (f PromptBuilderFunc) BuildPrompt(req PromptRequest) (Prompt, error) {
+	return f(req)
+}
+
+// DefaultPromptBuilder builds prompts for kinds of code that have no other
+// builder, and for requests of no known kind. It asks for sample code of the
+// request, without a system prompt.
+var DefaultPromptBuilder PromptBuilder = PromptBuilderFunc(func(req PromptRequest) (Prompt, error) {
+	// NOTE: we are prepending the string "generate sample code for a..." to
+	// the request, this is meant to ensure that the language model actually
+	// generates code.
+	if req.Explain {
+		return Prompt{
+			User: fmt.Sprintf("Generate sample code for a %s. Include explanations.", req.Request),
+		}, nil
+	}
+
+	return Prompt{User: fmt.Sprintf("Generate sample code for a %s", req.Request)}, nil
+})
+
+// BuiltinPromptBuilders are the built-in prompt builders, by kind of code
+// (see DetectKind). Every known kind has one, which is currently
+// DefaultPromptBuilder.
+var BuiltinPromptBuilders = builtinPromptBuilders()
+
+// builtinPromptBuilders returns the built-in prompt builders of every known
+// kind of code.
+func builtinPromptBuilders() map[string]PromptBuilder {
+	builders := make(map[string]PromptBuilder, len(codeKinds))
+	for _, kind := range codeKinds {
+		builders[kind.name] = DefaultPromptBuilder
+	}
+
+	return builders
+}
+
+// PromptConfig overrides the prompts of a kind of code in the configuration
+// (see Config.Prompts). It implements PromptBuilder.
+type PromptConfig struct {
+	// System is the system prompt of conversations requesting the kind of
+	// code, which takes precedence over the system prompts of backends.
+	System string `toml:"system"`
+
+	// Template is the Go template (see text/template) the prompt is rendered
+	// from, with the fields of PromptRequest (e.g. {{.Request}}). Defaults
+	// to the prompt of DefaultPromptBuilder.
+	Template string `toml:"template"`
+}
+
+// BuildPrompt implements PromptBuilder by rendering the prompt's template.
+func (conf PromptConfig) BuildPrompt(req PromptRequest) (prompt Prompt, err error) {
+	if conf.Template == "" {
+		prompt, err = DefaultPromptBuilder.BuildPrompt(req)
+	} else {
+		prompt.User, err = conf.render(req)
+	}
+
+	prompt.System = strings.TrimSpace(conf.System)
+
+	return prompt, err
+}
+
+// render renders the prompt's template for the request.
+func (conf PromptConfig) render(req PromptRequest) (string, error) {
+	tmpl, err := template.New("prompt").Parse(conf.Template)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+
+	err = tmpl.Execute(&b, req)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// validate verifies that the prompts are of a known kind of code, and that
+// their template can be rendered.
+func (conf PromptConfig) validate(kind string) (errs []error) {
+	if !knownKind(kind) {
+		errs = append(errs, fmt.Errorf("prompts: unknown kind of code %q", kind))
+	}
+
+	if conf.Template != "" {
+		if _, err := conf.render(PromptRequest{}); err != nil {
+			errs = append(errs, fmt.Errorf("prompts.%s.template: %w", kind, err))
+		}
+	}
+
+	return errs
+}
+
+// RegisterPromptBuilder registers a prompt builder for a kind of code, by the
+// name of the kind or any of its keywords (see DetectKind), replacing the
+// prompts of the kind in the configuration and the built-in ones.
+func (aiac *Aiac) RegisterPromptBuilder(kind string, builder PromptBuilder) {
+	if aiac.PromptBuilders == nil {
+		aiac.PromptBuilders = make(map[string]PromptBuilder)
+	}
+
+	aiac.PromptBuilders[kind] = builder
+}
+
+// BuildPrompt builds the prompt for a request with the prompt builder of its
+// kind: the one registered with RegisterPromptBuilder, if any, or else the
+// prompts configured for the kind (see Config.Prompts), or else the kind's
+// built-in builder (see BuiltinPromptBuilders). Requests of no known kind use
+// DefaultPromptBuilder. An error wrapping types.ErrEmptyPrompt is returned if
+// the builder returns an empty prompt.
+func (aiac *Aiac) BuildPrompt(req PromptRequest) (Prompt, error) {
+	prompt, err := aiac.promptBuilder(req.Kind).BuildPrompt(req)
+	if err != nil {
+		return prompt, fmt.Errorf("failed building prompt: %w", err)
+	}
+
+	if strings.TrimSpace(prompt.User) == "" {
+		return prompt, fmt.Errorf("%w: prompt built for %q", types.ErrEmptyPrompt, req.Request)
+	}
+
+	return prompt, nil
+}
+
+// promptBuilder returns the prompt builder of a kind of code (see
+// BuildPrompt).
+func (aiac *Aiac) promptBuilder(kind string) PromptBuilder {
+	aliases := kindAliases(kind)
+
+	for _, alias := range aliases {
+		if builder, ok := aiac.PromptBuilders[alias]; ok {
+			return builder
+		}
+	}
+
+	for _, alias := range aliases {
+		if conf, ok := aiac.Conf.Prompts[alias]; ok {
+			return conf
+		}
+	}
+
+	if builder, ok := BuiltinPromptBuilders[kind]; ok {
+		return builder
+	}
+
+	return DefaultPromptBuilder
+}
@@ -0,0 +1,260 @@
+package libaiac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// MaxRacers is the maximum number of backends a prompt is sent to at the same
+// time in a race (see Aiac.Race). Backends beyond it only receive the prompt
+// when backends before them fail.
+const MaxRacers = 4
+
+// race is a conversation whose prompts are sent to several backends at the
+// same time, using the first response. Each backend has its own conversation,
+// and all of them share the same history, that of the backend whose response
+// was used.
+type race struct {
+	aiac    *Aiac
+	racers  []*conversation
+	current *conversation
+}
+
+// Race starts a conversation whose prompts are sent to all of the backends
+// with the provided names concurrently, up to MaxRacers at a time, using the
+// response of the first backend to return one. The requests to the other
+// backends are canceled. Unlike fallback backends, which are tried in order
+// when the previous one fails, racing backends compete for the lowest latency.
+// Every backend uses its default model (see BackendConfig.ResolveModel), and
+// generation parameters and headers set on the conversation apply to all of
+// them. Requests are subject to the rate limits of each backend as usual.
+// Responses report the outcome of the prompt in every backend it was sent to
+// (see types.Response.Race). Responses are not streamed: Stream writes the
+// winning response to the writer in its entirety once it is received.
+//
+// Backends are selected as with Chat, and previous messages are shared by all
+// of them, each with its own system prompt if configured. An error wrapping
+// types.ErrNoRacers is returned if no backends are provided.
+func (aiac *Aiac) Race(
+	ctx context.Context,
+	backendNames []string,
+	msgs ...types.Message,
+) (types.Conversation, error) {
+	r := &race{aiac: aiac}
+	seen := make(map[string]bool)
+
+	for _, name := range backendNames {
+		resolved, err := aiac.ResolveBackend(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[resolved] {
+			continue
+		}
+		seen[resolved] = true
+
+		chat, err := aiac.Chat(ctx, resolved, "", msgs...)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %w", resolved, err)
+		}
+
+		// The race takes the place of fallback backends
+		conv := chat.(*conversation)
+		conv.fallbacks = nil
+
+		r.racers = append(r.racers, conv)
+	}
+
+	if len(r.racers) == 0 {
+		return nil, types.ErrNoRacers
+	}
+
+	r.current = r.racers[0]
+
+	return r, nil
+}
+
+// raceOutcome is the outcome of a prompt in one of the backends of a race.
+type raceOutcome struct {
+	i   int
+	res types.Response
+	err error
+	at  time.Duration
+}
+
+// Send sends the prompt to the racing backends and returns the first response
+// received, after canceling the requests to the other backends. If every
+// backend fails, an error joining their errors is returned.
+func (r *race) Send(ctx context.Context, prompt string) (types.Response, error) {
+	return r.send(ctx, prompt)
+}
+
+// Stream is the same as Send, writing the winning response to w once it is
+// received.
+func (r *race) Stream(ctx context.Context, prompt string, w io.Writer) (types.Response, error) {
+	res, err := r.send(ctx, prompt)
+	if err != nil {
+		return res, err
+	}
+
+	_, err = io.WriteString(w, res.FullOutput)
+
+	return res, err
+}
+
+// send implements Send and Stream. Backends are started in order, up to
+// MaxRacers at a time, and every time one fails while no backend has won yet,
+// the next one is started. The race returns once every request started has
+// completed or was canceled, after which the histories of all backends are
+// made the same: that of the winner.
+func (r *race) send(ctx context.Context, prompt string) (res types.Response, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	histories := make([][]types.Message, len(r.racers))
+	for i, racer := range r.racers {
+		histories[i] = append([]types.Message(nil), racer.Messages()...)
+	}
+
+	outcomes := make(chan raceOutcome, len(r.racers))
+	start := time.Now()
+	started := 0
+
+	next := func() {
+		i, racer := started, r.racers[started]
+		started++
+
+		go func() {
+			res, err := racer.Send(ctx, prompt)
+			outcomes <- raceOutcome{i: i, res: res, err: err, at: time.Since(start)}
+		}()
+	}
+
+	for started < len(r.racers) && started < MaxRacers {
+		next()
+	}
+
+	entries := make([]types.RaceEntry, len(r.racers))
+	winner := -1
+
+	var errs []error
+
+	for pending := started; pending > 0; pending-- {
+		outcome := <-outcomes
+		racer := r.racers[outcome.i]
+
+		entry := types.RaceEntry{
+			Backend: racer.backendName,
+			Model:   racer.model,
+			Latency: outcome.at,
+			Err:     outcome.err,
+		}
+
+		switch {
+		case outcome.err == nil && winner < 0:
+			winner = outcome.i
+			entry.Won = true
+			res = outcome.res
+
+			r.aiac.log().InfoContext(
+				ctx, "race won",
+				"backend", racer.backendName,
+				"model", racer.model,
+				"latency", outcome.at,
+			)
+
+			cancel()
+		case winner >= 0 && (outcome.err == nil || errors.Is(outcome.err, context.Canceled)):
+			// Responses completing after the winner's are discarded too
+			entry.Err = fmt.Errorf(
+				"canceled as backend %s won: %w",
+				r.racers[winner].backendName, context.Canceled,
+			)
+		case winner >= 0:
+			// Failures after a backend won do not matter
+		default:
+			errs = append(errs, fmt.Errorf("backend %s: %w", racer.backendName, outcome.err))
+
+			if started < len(r.racers) && ctx.Err() == nil {
+				next()
+				pending++
+			}
+		}
+
+		entries[outcome.i] = entry
+	}
+
+	// Only the backends the prompt was sent to are reported
+	res.Race = entries[:started]
+
+	if winner < 0 {
+		for i, racer := range r.racers {
+			racer.reset(histories[i])
+		}
+
+		if len(errs) == 1 {
+			return res, errs[0]
+		}
+
+		return res, fmt.Errorf("all backends failed: %w", errors.Join(errs...))
+	}
+
+	r.current = r.racers[winner]
+
+	for i, racer := range r.racers {
+		if i == winner {
+			continue
+		}
+
+		racer.reset(histories[i])
+		racer.replay(racer.formatPrompt(prompt), res)
+		racer.images = nil
+	}
+
+	return res, nil
+}
+
+// Messages returns the history of the conversation, as exchanged with the
+// backend whose response was used last (or the first backend, if none was
+// used yet).
+func (r *race) Messages() []types.Message {
+	return r.current.Messages()
+}
+
+// AddHeader adds an extra HTTP header to the requests of every backend.
+func (r *race) AddHeader(key, val string) {
+	for _, racer := range r.racers {
+		racer.AddHeader(key, val)
+	}
+}
+
+// SetParameters sets the generation parameters of every backend, overriding
+// their default ones.
+func (r *race) SetParameters(params types.Parameters) {
+	for _, racer := range r.racers {
+		racer.SetParameters(params)
+	}
+}
+
+// AttachImages attaches images to the next prompt sent to every backend (see
+// conversation.AttachImages). An error is returned if any of the backends
+// does not accept them, as the race would not be fair otherwise.
+func (r *race) AttachImages(images ...types.Image) error {
+	for _, racer := range r.racers {
+		err := racer.AttachImages(images...)
+		if err != nil {
+			for _, attached := range r.racers {
+				attached.images = nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package libaiac
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// RefineHistory returns a message history in which the model has already
+// generated the provided code, read from the file at the provided path. A
+// conversation started with this history can revise the code with prompts
+// created by RefinePrompt, without the original request being repeated. The
+// code is provided as a fenced code block, with a language hint based on the
+// file's name.
+func RefineHistory(path, code string) []types.Message {
+	return []types.Message{
+		{
+			Role:    "user",
+			Content: fmt.Sprintf("Generate the contents of %s", filepath.Base(path)),
+		},
+		{
+			Role:    "assistant",
+			Content: codeBlock(fileLanguage(path), code),
+		},
+	}
+}
+
+// RefinePrompt returns a prompt asking the model to revise the code it
+// previously generated in the conversation according to the provided
+// instruction (e.g. "add a NAT gateway"). The model is asked to respond with
+// the complete revised file in a single code block, rather than just the
+// changes, so that the extracted code can replace the original file.
+func RefinePrompt(instruction string) string {
+	instruction = strings.TrimSuffix(strings.TrimSpace(instruction), ".")
+
+	return fmt.Sprintf(
+		"Revise the code you previously generated as follows: %s. Respond "+
+			"with the complete revised file in a single code block, not just "+
+			"the changes.",
+		instruction,
+	)
+}
+
+// CorrectionPrompt returns a prompt asking the model to fix the problems that
+// a validator found in the code it previously generated in the conversation
+// (see Validator). Like with RefinePrompt, the model is asked to respond with
+// the complete corrected file.
+func CorrectionPrompt(problems string) string {
+	return fmt.Sprintf(
+		"Validating the code you previously generated failed with the "+
+			"following errors:\n\n%s\n\nFix them, and respond with the "+
+			"complete corrected file in a single code block, not just the "+
+			"changes.",
+		strings.TrimSpace(problems),
+	)
+}
+
+// FindingsPrompt returns a prompt asking the model to fix the problems that
+// guardrails found in the code it previously generated in the conversation
+// (see Guardrails). Like with CorrectionPrompt, the model is asked to respond
+// with the complete corrected file.
+func FindingsPrompt(findings []Finding) string {
+	lines := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		lines = append(lines, "- "+finding.String())
+	}
+
+	return fmt.Sprintf(
+		"Scanning the code you previously generated found the following "+
+			"problems:\n\n%s\n\nFix them, and respond with the complete "+
+			"corrected file in a single code block, not just the changes.",
+		strings.Join(lines, "\n"),
+	)
+}
+
+// codeBlock returns code as a fenced Markdown code block with the provided
+// language hint.
+func codeBlock(language, code string) string {
+	code = strings.TrimRight(code, "\n")
+
+	// The fence must be longer than any run of backticks in the code itself
+	fence := "```"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+
+	return fmt.Sprintf("%s%s\n%s\n%s", fence, language, code, fence)
+}
+
+// fileLanguage returns the code block language hint for a file, based on its
+// name (e.g. "hcl" for "main.tf"). Kinds of code with a well-known filename
+// (see codeKinds) are matched by name first, then by extension. Files of
+// unknown kinds use their extension as the hint, if any.
+func fileLanguage(path string) string {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+
+	for _, kind := range codeKinds {
+		if strings.EqualFold(kind.filename, base) {
+			return kind.languages[0]
+		}
+	}
+
+	if ext == "" {
+		return ""
+	}
+
+	for _, kind := range codeKinds {
+		if filepath.Ext(kind.filename) == ext {
+			return kind.languages[0]
+		}
+	}
+
+	return strings.TrimPrefix(ext, ".")
+}
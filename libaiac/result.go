@@ -0,0 +1,169 @@
+package libaiac
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// Result is a machine-readable summary of a response, meant to be encoded as
+// JSON for consumption by scripts, wrappers and editor plugins. It is what
+// the CLI prints in --json mode.
+type Result struct {
+	// Code is the generated code, without the surrounding Markdown.
+	Code string `json:"code"`
+
+	// Language is the language hint of the generated code block, if any.
+	Language string `json:"language,omitempty"`
+
+	// Output is the complete Markdown output of the model.
+	Output string `json:"output"`
+
+	// Backend and Model are the names of the backend and model that
+	// generated the response.
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+
+	// Provider is the upstream provider that served the request, for backends
+	// that route requests (e.g. OpenRouter).
+	Provider string `json:"provider,omitempty"`
+
+	// StopReason is the reason the model stopped generating, as reported by
+	// the provider.
+	StopReason string `json:"stop_reason,omitempty"`
+
+	// Citations are the URLs of the sources of web-grounded responses, if
+	// returned by the provider.
+	Citations []string `json:"citations,omitempty"`
+
+	// Seed is the seed the response was generated with, if set and
+	// supported by the provider, and SystemFingerprint identifies the
+	// configuration of the provider's servers, if returned.
+	Seed              *int64 `json:"seed,omitempty"`
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+
+	// Usage is the token usage of the request.
+	Usage Usage `json:"usage"`
+
+	// Cost is the estimated cost of the request in US dollars, if the price
+	// of the model is known (see Aiac.Cost) and the response was not cached.
+	Cost *float64 `json:"cost,omitempty"`
+
+	// DurationMS is the time it took to generate the response, in
+	// milliseconds.
+	DurationMS int64 `json:"duration_ms"`
+
+	// Cached is true if the response was loaded from the response cache.
+	Cached bool `json:"cached,omitempty"`
+
+	// Warnings are non-fatal issues encountered while generating the
+	// response.
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Explanation is the explanation of the key decisions made in the code,
+	// if one was requested (see ExplainPrompt and Explanation).
+	Explanation string `json:"explanation,omitempty"`
+
+	// Findings are the problems guardrails found in the generated code, if
+	// it was scanned (see Guardrails).
+	Findings []Finding `json:"findings,omitempty"`
+
+	// Race is the outcome of the prompt in every backend it was sent to, if
+	// backends raced each other (see Aiac.Race).
+	Race []RaceResult `json:"race,omitempty"`
+}
+
+// RaceResult is the outcome of a prompt in one of the backends of a race.
+type RaceResult struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+
+	// LatencyMS is the time from the start of the race until the backend
+	// responded, failed or was canceled, in milliseconds.
+	LatencyMS int64 `json:"latency_ms"`
+
+	// Won is true for the backend whose response was used, and Canceled for
+	// backends canceled because another one won.
+	Won      bool `json:"won,omitempty"`
+	Canceled bool `json:"canceled,omitempty"`
+
+	// Error is the error the backend failed with, if it was not canceled.
+	Error string `json:"error,omitempty"`
+}
+
+// ErrorResult is the machine-readable counterpart of Result for requests that
+// failed, which the CLI prints in --json mode.
+type ErrorResult struct {
+	// Error is the message of the error that prevented generating a
+	// response.
+	Error string `json:"error"`
+}
+
+// Usage is the token usage of a request.
+type Usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+	TotalTokens  int64 `json:"total_tokens"`
+
+	// ReasoningTokens are the output tokens of reasoning, for providers
+	// that report them. They are not part of the output.
+	ReasoningTokens int64 `json:"reasoning_tokens,omitempty"`
+
+	// Estimated is true if the provider did not report token usage, and the
+	// counts were estimated instead.
+	Estimated bool `json:"estimated,omitempty"`
+}
+
+// NewResult creates the Result of a response that took the provided amount of
+// time to generate, estimating its cost.
+func (aiac *Aiac) NewResult(res types.Response, duration time.Duration) Result {
+	result := Result{
+		Code:       res.Code,
+		Language:   res.Language,
+		Output:     res.FullOutput,
+		Backend:    res.Backend,
+		Model:      res.Model,
+		Provider:   res.Provider,
+		StopReason: res.StopReason,
+		Citations:  res.Citations,
+		Seed:       res.Seed,
+		Usage: Usage{
+			InputTokens:  res.InputTokens,
+			OutputTokens: res.OutputTokens,
+			TotalTokens:  res.TokensUsed,
+			Estimated:    res.TokensEstimated,
+
+			ReasoningTokens: res.ReasoningTokens,
+		},
+		DurationMS: duration.Milliseconds(),
+		Cached:     res.Cached,
+		Warnings:   res.Warnings,
+
+		SystemFingerprint: res.SystemFingerprint,
+	}
+
+	for _, entry := range res.Race {
+		race := RaceResult{
+			Backend:   entry.Backend,
+			Model:     entry.Model,
+			LatencyMS: entry.Latency.Milliseconds(),
+			Won:       entry.Won,
+			Canceled:  errors.Is(entry.Err, context.Canceled),
+		}
+
+		if entry.Err != nil && !race.Canceled {
+			race.Error = entry.Err.Error()
+		}
+
+		result.Race = append(result.Race, race)
+	}
+
+	// Cached responses cost nothing
+	if cost, ok := aiac.Cost(res); ok && !res.Cached {
+		result.Cost = &cost
+	}
+
+	return result
+}
@@ -0,0 +1,195 @@
+package libaiac
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemaBase is the base of the location schemas are registered under when
+// compiled (see schemaURL).
+const schemaBase = "aiac:///"
+
+// schemaURL is the location schemas are registered under when compiled, so
+// that references within them resolve.
+const schemaURL = schemaBase + "schema.json"
+
+// schemaDrafts are the drafts of JSON Schema that schemas not declaring one
+// with the "$schema" keyword are tried against, in order. Many schemas in
+// the wild use keywords whose form changed between drafts (e.g. "items" as
+// an array, or "exclusiveMinimum" as a boolean) without declaring the draft
+// they follow.
+var schemaDrafts = []*jsonschema.Draft{
+	jsonschema.Draft2020,
+	jsonschema.Draft7,
+	jsonschema.Draft4,
+}
+
+// LoadSchema reads a JSON Schema from a file, for types.Parameters.Schema. An
+// error wrapping types.ErrInvalidSchema is returned if the file is not valid
+// JSON, or not a valid schema (see ParseSchema).
+func LoadSchema(path string) (json.RawMessage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading schema: %w", err)
+	}
+
+	_, err = ParseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return json.RawMessage(data), nil
+}
+
+// Schema is a compiled JSON Schema, which JSON values can be validated
+// against.
+type Schema struct {
+	compiled *jsonschema.Schema
+}
+
+// ParseSchema parses and compiles a JSON Schema, which must be a JSON object.
+// The draft declared by its "$schema" keyword is honored, and schemas that do
+// not declare one are compiled as the first of drafts 2020-12, 7 and 4 they
+// are valid against. Patterns are ECMA-262 regular expressions, as the
+// specification requires. References are only resolved within the schema.
+// An error wrapping types.ErrInvalidSchema is returned if the schema is not
+// valid JSON, or not a valid schema.
+func ParseSchema(data []byte) (*Schema, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", types.ErrInvalidSchema, err)
+	}
+
+	obj, ok := doc.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: schema must be a JSON object", types.ErrInvalidSchema)
+	}
+
+	drafts := schemaDrafts
+	if _, ok := obj["$schema"]; ok {
+		// The default draft is ignored by the compiler
+		drafts = drafts[:1]
+	}
+
+	var firstErr error
+	for _, draft := range drafts {
+		compiled, err := compileSchema(doc, draft)
+		if err == nil {
+			return &Schema{compiled: compiled}, nil
+		}
+
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", types.ErrInvalidSchema, schemaError(firstErr))
+}
+
+// compileSchema compiles a decoded JSON Schema, using a draft unless it
+// declares its own.
+func compileSchema(doc interface{}, draft *jsonschema.Draft) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.DefaultDraft(draft)
+	compiler.UseRegexpEngine(compileECMARegexp)
+	compiler.UseLoader(noSchemaLoader{})
+
+	if err := compiler.AddResource(schemaURL, doc); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(schemaURL)
+}
+
+// Validate verifies that a JSON document conforms to the schema. An error
+// wrapping types.ErrSchemaViolation is returned listing the values that do
+// not, with their location in the document as JSON pointers (e.g.
+// "/subnets/1/cidr").
+func (schema *Schema) Validate(data []byte) error {
+	val, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s", types.ErrInvalidJSON, err)
+	}
+
+	if err := schema.compiled.Validate(val); err != nil {
+		return fmt.Errorf("%w: %s", types.ErrSchemaViolation, schemaError(err))
+	}
+
+	return nil
+}
+
+// schemaError describes an error returned by the JSON Schema library on a
+// single line, listing the locations of the values that are not valid, and
+// without the base of the location the schema was registered under.
+func schemaError(err error) string {
+	var metaErr *jsonschema.SchemaValidationError
+	if errors.As(err, &metaErr) {
+		err = metaErr.Err
+	}
+
+	var valErr *jsonschema.ValidationError
+	if !errors.As(err, &valErr) {
+		return strings.ReplaceAll(err.Error(), schemaBase, "")
+	}
+
+	return strings.Join(validationLeaves(valErr, nil), "; ")
+}
+
+// validationLeaves appends the descriptions of the innermost causes of a
+// validation error, such as "at '/subnets/1/cidr': ...", to msgs.
+func validationLeaves(err *jsonschema.ValidationError, msgs []string) []string {
+	if len(err.Causes) == 0 {
+		return append(msgs, err.Error())
+	}
+
+	for _, cause := range err.Causes {
+		msgs = validationLeaves(cause, msgs)
+	}
+
+	return msgs
+}
+
+// ecmaRegexp is a regular expression with ECMA-262 semantics, as JSON Schema
+// requires for the "pattern" and "patternProperties" keywords. Unlike Go's
+// RE2 syntax, these support lookarounds and backreferences.
+type ecmaRegexp regexp2.Regexp
+
+// MatchString reports whether the string contains a match of the regular
+// expression.
+func (re *ecmaRegexp) MatchString(s string) bool {
+	matched, err := (*regexp2.Regexp)(re).MatchString(s)
+	return err == nil && matched
+}
+
+// String returns the source of the regular expression.
+func (re *ecmaRegexp) String() string {
+	return (*regexp2.Regexp)(re).String()
+}
+
+// compileECMARegexp compiles a regular expression with ECMA-262 semantics.
+func compileECMARegexp(s string) (jsonschema.Regexp, error) {
+	re, err := regexp2.Compile(s, regexp2.ECMAScript)
+	if err != nil {
+		return nil, err
+	}
+
+	return (*ecmaRegexp)(re), nil
+}
+
+// noSchemaLoader refuses to load schemas referenced from outside the schema
+// being compiled, so that compiling a schema never reads files or accesses
+// the network.
+type noSchemaLoader struct{}
+
+// Load returns an error for every location.
+func (noSchemaLoader) Load(url string) (interface{}, error) {
+	return nil, fmt.Errorf("unsupported reference %q, only references within the schema are supported", url)
+}
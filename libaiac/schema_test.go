@@ -0,0 +1,177 @@
+package libaiac
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestParseSchema(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		// wantErr is true if parsing must fail with an error wrapping
+		// types.ErrInvalidSchema
+		wantErr bool
+	}{
+		{name: "empty schema", schema: `{}`},
+		{
+			name:   "object schema",
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`,
+		},
+		{name: "ECMA-262 lookahead", schema: `{"type": "string", "pattern": "^(?!default$).+"}`},
+		{
+			name:   "draft-04 boolean exclusiveMinimum",
+			schema: `{"type": "number", "minimum": 0, "exclusiveMinimum": true}`,
+		},
+		{
+			name: "declared draft-04 boolean exclusiveMinimum",
+			schema: `{
+				"$schema": "http://json-schema.org/draft-04/schema#",
+				"type": "number", "minimum": 0, "exclusiveMinimum": true
+			}`,
+		},
+		{name: "tuple items", schema: `{"type": "array", "items": [{"type": "string"}, {"type": "integer"}]}`},
+		{name: "prefix items", schema: `{"type": "array", "prefixItems": [{"type": "string"}]}`},
+		{
+			name: "local reference",
+			schema: `{
+				"$defs": {"cidr": {"type": "string"}},
+				"properties": {"cidr": {"$ref": "#/$defs/cidr"}}
+			}`,
+		},
+		{name: "not JSON", schema: `{"type":`, wantErr: true},
+		{name: "not an object", schema: `["string"]`, wantErr: true},
+		{name: "unknown type", schema: `{"type": "text"}`, wantErr: true},
+		{name: "invalid pattern", schema: `{"pattern": "(unclosed"}`, wantErr: true},
+		{name: "negative minLength", schema: `{"minLength": -1}`, wantErr: true},
+		{name: "unresolved reference", schema: `{"$ref": "#/$defs/missing"}`, wantErr: true},
+		{name: "external reference", schema: `{"$ref": "other.json"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseSchema([]byte(tt.schema))
+			if tt.wantErr {
+				if !errors.Is(err, types.ErrInvalidSchema) {
+					t.Fatalf("expected error %q, got %v", types.ErrInvalidSchema, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestSchemaValidate(t *testing.T) {
+	const subnets = `{
+		"type": "object",
+		"properties": {
+			"subnets": {
+				"type": "array",
+				"items": {
+					"type": "object",
+					"properties": {"cidr": {"type": "string", "pattern": "^\\d+\\.\\d+\\.\\d+\\.\\d+/\\d+$"}},
+					"required": ["cidr"]
+				}
+			}
+		},
+		"required": ["subnets"],
+		"additionalProperties": false
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		doc    string
+		// wantErr is the error validation must fail with, if any, and
+		// wantMsg a string its message must contain
+		wantErr error
+		wantMsg string
+	}{
+		{name: "conforming", schema: subnets, doc: `{"subnets": [{"cidr": "10.0.0.0/24"}]}`},
+		{
+			name:    "pattern mismatch",
+			schema:  subnets,
+			doc:     `{"subnets": [{"cidr": "10.0.0.0/24"}, {"cidr": "nope"}]}`,
+			wantErr: types.ErrSchemaViolation,
+			wantMsg: "/subnets/1/cidr",
+		},
+		{
+			name:    "missing required property",
+			schema:  subnets,
+			doc:     `{}`,
+			wantErr: types.ErrSchemaViolation,
+			wantMsg: "subnets",
+		},
+		{
+			name:    "additional property",
+			schema:  subnets,
+			doc:     `{"subnets": [], "vpc": "main"}`,
+			wantErr: types.ErrSchemaViolation,
+			wantMsg: "vpc",
+		},
+		{
+			name:   "lookahead match",
+			schema: `{"type": "string", "pattern": "^(?!default$).+"}`,
+			doc:    `"prod"`,
+		},
+		{
+			name:    "lookahead mismatch",
+			schema:  `{"type": "string", "pattern": "^(?!default$).+"}`,
+			doc:     `"default"`,
+			wantErr: types.ErrSchemaViolation,
+		},
+		{
+			name:    "draft-04 exclusive minimum",
+			schema:  `{"type": "number", "minimum": 0, "exclusiveMinimum": true}`,
+			doc:     `0`,
+			wantErr: types.ErrSchemaViolation,
+		},
+		{
+			name:   "tuple items",
+			schema: `{"type": "array", "items": [{"type": "string"}, {"type": "integer"}]}`,
+			doc:    `["a", 1]`,
+		},
+		{
+			name:    "tuple items mismatch",
+			schema:  `{"type": "array", "items": [{"type": "string"}, {"type": "integer"}]}`,
+			doc:     `["a", "b"]`,
+			wantErr: types.ErrSchemaViolation,
+			wantMsg: "/1",
+		},
+		{name: "invalid JSON", schema: subnets, doc: `{"subnets":`, wantErr: types.ErrInvalidJSON},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := ParseSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			err = schema.Validate([]byte(tt.doc))
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+
+				if !strings.Contains(err.Error(), tt.wantMsg) {
+					t.Errorf("expected error to contain %q, got %q", tt.wantMsg, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
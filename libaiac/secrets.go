@@ -0,0 +1,143 @@
+package libaiac
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/zalando/go-keyring"
+)
+
+// KeyringPrefix is the prefix of configuration values that reference a secret
+// stored in the operating system's keyring rather than containing it
+// directly, e.g. "keyring:aiac/openai". The part after the prefix is the
+// keyring service and user (account) names, separated by a slash. If there
+// is no slash, DefaultKeyringService is used as the service.
+const KeyringPrefix = "keyring:"
+
+// DefaultKeyringService is the keyring service under which aiac stores
+// secrets.
+const DefaultKeyringService = "aiac"
+
+// KeyringRef returns the configuration value referencing the keyring entry in
+// which SetSecret stores the secret of the provided backend.
+func KeyringRef(backendName string) string {
+	return KeyringPrefix + DefaultKeyringService + "/" + backendName
+}
+
+// SetSecret stores a backend's API key in the operating system's keyring,
+// under DefaultKeyringService. The configuration can then reference it via
+// the value returned by KeyringRef.
+func SetSecret(backendName, secret string) error {
+	err := keyring.Set(DefaultKeyringService, backendName, secret)
+	if err != nil {
+		return fmt.Errorf("failed storing secret in keyring: %w", err)
+	}
+
+	return nil
+}
+
+// resolveSecrets replaces any API keys in the config that reference the
+// keyring or Vault (see KeyringPrefix and VaultPrefix) with the secrets
+// stored there. An error is returned if an entry does not exist.
+func resolveSecrets(conf Config) (Config, error) {
+	var vault *vaultClient
+
+	for backendName, backendConfig := range conf.Backends {
+		if strings.HasPrefix(backendConfig.APIKey, VaultPrefix) {
+			var err error
+			if vault == nil {
+				vault, err = newVaultClient()
+				if err != nil {
+					return conf, fmt.Errorf("backend %s, field api_key: %w", backendName, err)
+				}
+			}
+
+			backendConfig.APIKey, err = vault.get(context.Background(), backendConfig.APIKey)
+			if err != nil {
+				return conf, fmt.Errorf("backend %s, field api_key: %w", backendName, err)
+			}
+
+			conf.Backends[backendName] = backendConfig
+			continue
+		}
+
+		if !strings.HasPrefix(backendConfig.APIKey, KeyringPrefix) {
+			continue
+		}
+
+		service, user := parseKeyringRef(backendConfig.APIKey)
+
+		secret, err := keyring.Get(service, user)
+		if err != nil {
+			if errors.Is(err, keyring.ErrNotFound) {
+				err = types.ErrSecretNotFound
+			}
+
+			return conf, fmt.Errorf(
+				"backend %s, field api_key: keyring entry %s/%s: %w",
+				backendName, service, user, err,
+			)
+		}
+
+		backendConfig.APIKey = secret
+		conf.Backends[backendName] = backendConfig
+	}
+
+	return conf, nil
+}
+
+// parseKeyringRef parses a value referencing a keyring entry into the entry's
+// service and user names.
+func parseKeyringRef(value string) (service, user string) {
+	ref := strings.TrimPrefix(value, KeyringPrefix)
+
+	service, user, ok := strings.Cut(ref, "/")
+	if !ok {
+		return DefaultKeyringService, ref
+	}
+
+	return service, user
+}
+
+// awsCredentialEnvVars are the environment variables holding AWS credentials,
+// which are redacted from errors.
+var awsCredentialEnvVars = []string{
+	"AWS_ACCESS_KEY_ID",
+	"AWS_SECRET_ACCESS_KEY",
+	"AWS_SESSION_TOKEN",
+}
+
+// secrets returns the secrets redacted from errors returned by libaiac: the
+// API keys and proxy passwords of all backends, as errors may combine those
+// of several backends (see Config.Fallback), and the AWS credentials in the
+// environment.
+func (aiac *Aiac) secrets() (secrets []string) {
+	for _, backendConf := range aiac.Conf.Backends {
+		secrets = append(secrets, backendConf.APIKey)
+
+		if proxyURL, err := url.Parse(backendConf.Proxy); err == nil && proxyURL.User != nil {
+			if password, ok := proxyURL.User.Password(); ok {
+				secrets = append(secrets, password)
+			}
+		}
+	}
+
+	for _, name := range awsCredentialEnvVars {
+		secrets = append(secrets, os.Getenv(name))
+	}
+
+	return secrets
+}
+
+// redactError returns the provided error with secrets redacted from its
+// message (see transport.RedactError), so that they are never printed or
+// logged. Every error returned by a backend passes through it.
+func (aiac *Aiac) redactError(err error) error {
+	return transport.RedactError(err, aiac.secrets()...)
+}
@@ -0,0 +1,131 @@
+package libaiac
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// SessionVersion is the current version of the session file schema. It must
+// be incremented whenever the schema changes in a backwards-incompatible way,
+// and LoadSession must be taught to migrate files from older versions.
+const SessionVersion = 1
+
+// Session is a backend-agnostic record of a chat conversation, which can be
+// persisted to disk and resumed later. It records the message history, the
+// backend and model used, and the generation parameters.
+type Session struct {
+	// Version is the schema version the session was written with.
+	Version int `json:"version"`
+
+	// Backend is the name of the backend used, as defined in the
+	// configuration file.
+	Backend string `json:"backend"`
+
+	// Model is the name of the model used.
+	Model string `json:"model"`
+
+	// Parameters are the generation parameters used.
+	Parameters types.Parameters `json:"parameters"`
+
+	// Messages is the message history of the conversation.
+	Messages []types.Message `json:"messages"`
+}
+
+// NewSession creates a new, empty session for the provided backend and model.
+func NewSession(backend, model string, params types.Parameters) *Session {
+	return &Session{
+		Version:    SessionVersion,
+		Backend:    backend,
+		Model:      model,
+		Parameters: params,
+	}
+}
+
+// LoadSession loads a session from the provided file path. If the file does
+// not exist, an error wrapping fs.ErrNotExist is returned. Session files
+// written with older schema versions are migrated to the current version.
+func LoadSession(path string) (*Session, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading session file: %w", err)
+	}
+
+	var header struct {
+		Version int `json:"version"`
+	}
+
+	err = json.Unmarshal(data, &header)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %s", types.ErrInvalidSession, path, err)
+	}
+
+	var sess Session
+
+	switch {
+	case header.Version > SessionVersion:
+		return nil, fmt.Errorf(
+			"%w: %s was written with version %d, only up to %d is supported",
+			types.ErrUnsupportedSessionVersion,
+			path,
+			header.Version,
+			SessionVersion,
+		)
+	case header.Version == SessionVersion:
+		err = json.Unmarshal(data, &sess)
+		if err != nil {
+			return nil, fmt.Errorf("%w %s: %s", types.ErrInvalidSession, path, err)
+		}
+	default:
+		return nil, fmt.Errorf(
+			"%w %s: missing or invalid version %d",
+			types.ErrInvalidSession,
+			path,
+			header.Version,
+		)
+	}
+
+	return &sess, nil
+}
+
+// Record updates the session's message history from the provided
+// conversation.
+func (sess *Session) Record(chat types.Conversation) {
+	sess.Messages = chat.Messages()
+}
+
+// Save writes the session to the provided file path. The file is written
+// atomically, so an existing session is never left truncated.
+func (sess *Session) Save(path string) error {
+	sess.Version = SessionVersion
+
+	data, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding session: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".aiac-session-*")
+	if err != nil {
+		return fmt.Errorf("failed creating session file: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(append(data, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed writing session file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed saving session file: %w", err)
+	}
+
+	return nil
+}
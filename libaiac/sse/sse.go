@@ -0,0 +1,49 @@
+// Package sse implements reading server-sent event streams, as returned by
+// the streaming endpoints of most LLM provider APIs.
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// maxLineSize is the maximum size of a single line in a stream.
+const maxLineSize = 1024 * 1024
+
+// Done is the payload some providers (e.g. OpenAI) send to mark the end of a
+// stream.
+const Done = "[DONE]"
+
+// Read reads a server-sent events stream from r, and calls fn with the payload
+// of every "data" line in it. Other fields are ignored. Reading stops when the
+// stream ends, a Done payload is received, or fn returns an error (which is
+// returned as-is).
+func Read(r io.Reader, fn func(data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize) //nolint: gomnd
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if !bytes.HasPrefix(line, []byte("data:")) {
+			continue
+		}
+
+		data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		if string(data) == Done {
+			return nil
+		}
+
+		err := fn(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed reading response stream: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package libaiac
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/adrg/xdg"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// templateExt is the extension of prompt template files
+const templateExt = ".tmpl"
+
+// Template is a prompt template: a Go text/template string rendering a
+// prompt from variables (e.g. "a Terraform module for {{.resource}}").
+type Template struct {
+	// Prompt is the text of the template.
+	Prompt string `toml:"prompt"`
+
+	// Defaults are the values of variables that do not have to be provided
+	// when rendering the template.
+	Defaults map[string]string `toml:"defaults"`
+}
+
+// UnmarshalTOML implements the toml.Unmarshaler interface. Templates may be
+// defined either as strings, or as tables with "prompt" and "defaults" keys.
+func (tmpl *Template) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		tmpl.Prompt = v
+		return nil
+	case map[string]interface{}:
+		for key, val := range v {
+			switch key {
+			case "prompt":
+				prompt, ok := val.(string)
+				if !ok {
+					return fmt.Errorf("template prompt must be a string, got %T", val)
+				}
+				tmpl.Prompt = prompt
+			case "defaults":
+				defaults, ok := val.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("template defaults must be a table, got %T", val)
+				}
+
+				tmpl.Defaults = make(map[string]string, len(defaults))
+				for name, def := range defaults {
+					tmpl.Defaults[name] = fmt.Sprint(def)
+				}
+			default:
+				return fmt.Errorf("unknown template key %q", key)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("template must be a string or a table, got %T", data)
+	}
+}
+
+// TemplateDirs returns the directories where prompt template files are
+// looked for, in order of precedence: "aiac/templates" under the user's XDG
+// configuration directory, and then under the system's.
+func TemplateDirs() []string {
+	dirs := []string{filepath.Join(xdg.ConfigHome, "aiac", "templates")}
+	for _, dir := range xdg.ConfigDirs {
+		dirs = append(dirs, filepath.Join(dir, "aiac", "templates"))
+	}
+
+	return dirs
+}
+
+// LoadTemplate finds a prompt template by name. Templates defined in the
+// configuration take precedence over template files named after them (e.g.
+// "tf-module.tmpl") in the directories returned by TemplateDirs. Template
+// files have no defaults. An error wrapping types.ErrNoSuchTemplate is
+// returned if the template is not found.
+func (aiac *Aiac) LoadTemplate(name string) (tmpl Template, err error) {
+	if tmpl, ok := aiac.Conf.Templates[name]; ok {
+		return tmpl, nil
+	}
+
+	// Names must not lead outside of the template directories
+	if name == "" || filepath.Base(name) != name || strings.HasPrefix(name, ".") {
+		return tmpl, fmt.Errorf("%w: %q", types.ErrNoSuchTemplate, name)
+	}
+
+	for _, dir := range TemplateDirs() {
+		data, err := os.ReadFile(filepath.Join(dir, name+templateExt))
+		switch {
+		case err == nil:
+			tmpl.Prompt = strings.TrimSpace(string(data))
+			return tmpl, nil
+		case !errors.Is(err, fs.ErrNotExist):
+			return tmpl, fmt.Errorf("failed reading template %s: %w", name, err)
+		}
+	}
+
+	return tmpl, fmt.Errorf("%w: %q", types.ErrNoSuchTemplate, name)
+}
+
+// RenderTemplate loads a prompt template by name (see LoadTemplate) and
+// renders it with the provided variables, which take precedence over the
+// template's defaults. An error wrapping types.ErrMissingTemplateVar, naming
+// all missing variables, is returned if the template references variables
+// that are neither provided nor have defaults.
+func (aiac *Aiac) RenderTemplate(name string, vars map[string]string) (string, error) {
+	tmpl, err := aiac.LoadTemplate(name)
+	if err != nil {
+		return "", err
+	}
+
+	return tmpl.Render(name, vars)
+}
+
+// Render renders the template with the provided variables, which take
+// precedence over the template's defaults. The name is only used in error
+// messages. See Aiac.RenderTemplate.
+func (tmpl Template) Render(name string, vars map[string]string) (string, error) {
+	parsed, err := template.New(name).Option("missingkey=error").Parse(tmpl.Prompt)
+	if err != nil {
+		return "", fmt.Errorf("invalid template %s: %w", name, err)
+	}
+
+	data := make(map[string]string, len(tmpl.Defaults)+len(vars))
+	for key, val := range tmpl.Defaults {
+		data[key] = val
+	}
+	for key, val := range vars {
+		data[key] = val
+	}
+
+	var missing []string
+	for _, field := range templateFields(parsed.Tree.Root) {
+		if _, ok := data[field]; !ok {
+			missing = append(missing, field)
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return "", fmt.Errorf(
+			"%w: template %s requires %s",
+			types.ErrMissingTemplateVar, name, strings.Join(missing, ", "),
+		)
+	}
+
+	var out strings.Builder
+	err = parsed.Execute(&out, data)
+	if err != nil {
+		return "", fmt.Errorf("failed rendering template %s: %w", name, err)
+	}
+
+	return strings.TrimSpace(out.String()), nil
+}
+
+// templateFields returns the names of the variables referenced by a parsed
+// template (e.g. "region" for "{{.region}}"), each included once. Variables
+// referenced within range and with blocks, where the meaning of the dot
+// changes, are not included.
+func templateFields(root parse.Node) (fields []string) {
+	seen := make(map[string]bool)
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.FieldNode:
+			if name := n.Ident[0]; !seen[name] {
+				seen[name] = true
+				fields = append(fields, name)
+			}
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+		case *parse.WithNode:
+			walk(n.Pipe)
+		}
+	}
+
+	walk(root)
+
+	return fields
+}
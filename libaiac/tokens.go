@@ -0,0 +1,332 @@
+package libaiac
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// DefaultContextWindows holds the context windows of commonly used models, in
+// tokens, by backend type and model name. Model names are matched the same
+// way as in DefaultPricing. Context windows can be set or overridden via the
+// configuration (see Config.ContextWindows).
+var DefaultContextWindows = map[BackendType]map[string]int{
+	BackendOpenAI: {
+		"gpt-4.1":       1047576,
+		"gpt-4o":        128000,
+		"gpt-4-turbo":   128000,
+		"gpt-4-32k":     32768,
+		"gpt-4":         8192,
+		"gpt-3.5-turbo": 16385,
+		"o1":            200000,
+		"o1-mini":       128000,
+		"o3":            200000,
+		"o4-mini":       200000,
+	},
+	BackendAnthropic: {
+		"claude-": 200000,
+	},
+	BackendGemini: {
+		"gemini-2.5":       1048576,
+		"gemini-2.0-flash": 1048576,
+		"gemini-1.5-pro":   2097152,
+		"gemini-1.5-flash": 1048576,
+	},
+	BackendMistral: {
+		"mistral-large":     131072,
+		"mistral-medium":    131072,
+		"mistral-small":     131072,
+		"codestral":         256000,
+		"open-mistral-nemo": 131072,
+	},
+	BackendGroq: {
+		"llama-3.3-70b-versatile": 131072,
+		"llama-3.1-70b-versatile": 131072,
+		"llama-3.1-8b-instant":    131072,
+		"mixtral-8x7b-32768":      32768,
+		"gemma2-9b-it":            8192,
+	},
+	BackendDeepSeek: {
+		"deepseek-chat":     65536,
+		"deepseek-reasoner": 65536,
+	},
+	BackendXAI: {
+		"grok-2":    131072,
+		"grok-beta": 131072,
+	},
+	BackendPerplexity: {
+		"llama-3.1-sonar-": 127072,
+	},
+	BackendFireworks: {
+		"llama-v3p3-70b-instruct":    131072,
+		"llama-v3p1-8b-instruct":     131072,
+		"llama-v3p1-405b-instruct":   131072,
+		"qwen2p5-coder-32b-instruct": 32768,
+		"deepseek-v3":                131072,
+		"mixtral-8x22b-instruct":     65536,
+	},
+	BackendTogether: {
+		"meta-llama/Llama-3.3-70B-Instruct-Turbo":      131072,
+		"meta-llama/Meta-Llama-3.1-8B-Instruct-Turbo":  131072,
+		"meta-llama/Meta-Llama-3.1-70B-Instruct-Turbo": 131072,
+		"Qwen/Qwen2.5-Coder-32B-Instruct":              32768,
+		"deepseek-ai/DeepSeek-V3":                      131072,
+	},
+	BackendCohere: {
+		"command-a":      256000,
+		"command-r-plus": 128000,
+		"command-r7b":    128000,
+		"command-r":      128000,
+	},
+	BackendBedrock: {
+		"anthropic.claude-":         200000,
+		"amazon.nova-pro":           300000,
+		"amazon.nova-lite":          300000,
+		"amazon.nova-micro":         128000,
+		"amazon.titan-text-express": 8192,
+		"meta.llama3-70b-instruct":  8192,
+		"meta.llama3-8b-instruct":   8192,
+	},
+}
+
+// contextWarningRatio is the share of a model's context window above which a
+// prompt triggers a warning, as it leaves little room for the response.
+const contextWarningRatio = 0.9
+
+// TokenEstimate is an estimate of the number of tokens a prompt takes up in
+// the context window of the model it is sent to.
+type TokenEstimate struct {
+	// Prompt is the number of tokens in the prompt, including the message
+	// history it is sent with.
+	Prompt int64 `json:"prompt"`
+
+	// Exact is true if Prompt was counted with the model's tokenizer, and
+	// false if it was estimated from the length of the prompt.
+	Exact bool `json:"exact"`
+
+	// MaxTokens is the maximum number of tokens to generate, if set.
+	MaxTokens int64 `json:"max_tokens,omitempty"`
+
+	// ContextWindow is the size of the model's context window, if known.
+	ContextWindow int64 `json:"context_window,omitempty"`
+}
+
+// Exceeds returns whether the prompt and the maximum number of tokens to
+// generate do not fit in the model's context window. Always false if the
+// context window is unknown.
+func (est TokenEstimate) Exceeds() bool {
+	return est.ContextWindow > 0 && est.Prompt+est.MaxTokens > est.ContextWindow
+}
+
+// Tight returns whether the prompt fits in the model's context window, but
+// leaves little room for the response.
+func (est TokenEstimate) Tight() bool {
+	return est.ContextWindow > 0 && !est.Exceeds() &&
+		float64(est.Prompt+est.MaxTokens) > contextWarningRatio*float64(est.ContextWindow)
+}
+
+// String returns a human readable description of the estimate.
+func (est TokenEstimate) String() string {
+	approx := ""
+	if !est.Exact {
+		approx = "~"
+	}
+
+	desc := fmt.Sprintf("%s%d prompt tokens", approx, est.Prompt)
+	if est.MaxTokens > 0 {
+		desc += fmt.Sprintf(" + %d max tokens", est.MaxTokens)
+	}
+
+	if est.ContextWindow > 0 {
+		desc += fmt.Sprintf(", context window of %d tokens", est.ContextWindow)
+	}
+
+	return desc
+}
+
+// EstimateTokens estimates the number of tokens a prompt takes up when sent to
+// a model of a backend, together with the provided message history and
+// generation parameters, and finds the model's context window. The
+// configuration takes precedence over DefaultContextWindows, and the context
+// windows reported by the backend, if already listed (see ContextWindow), are
+// used for models in neither. Prompts to OpenAI models (by name, regardless
+// of the backend) are counted with the model's tokenizer; other prompts are
+// estimated from their length.
+func (aiac *Aiac) EstimateTokens(
+	backendName, model string,
+	history []types.Message,
+	prompt string,
+	params types.Parameters,
+) (est TokenEstimate) {
+	msgs := append(history[:len(history):len(history)], types.Message{
+		Role:    "user",
+		Content: prompt,
+	})
+
+	est.Prompt, est.Exact = countTokens(model, msgs)
+
+	if params.MaxTokens != nil {
+		est.MaxTokens = int64(*params.MaxTokens)
+	}
+
+	backendType := aiac.Conf.Backends[backendName].Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	window, ok := aiac.knownContextWindow(backendType, model)
+	if !ok {
+		aiac.listedWindowsMu.Lock()
+		window = aiac.listedWindows[backendName][model]
+		aiac.listedWindowsMu.Unlock()
+	}
+
+	est.ContextWindow = int64(window)
+
+	return est
+}
+
+// ContextWindow returns the context window of a model of a backend, in
+// tokens: the one set in the configuration or DefaultContextWindows, or else
+// the one the backend reports when listing its models (see types.Model).
+// Backends' models are only listed once, the first time a model of theirs is
+// in neither, and later estimates use the context windows they report (see
+// EstimateTokens). Returns zero if the context window is unknown, including
+// when the models cannot be listed.
+func (aiac *Aiac) ContextWindow(ctx context.Context, backendName, model string) int {
+	backendType := aiac.Conf.Backends[backendName].Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	if window, ok := aiac.knownContextWindow(backendType, model); ok {
+		return window
+	}
+
+	aiac.listedWindowsMu.Lock()
+	windows, listed := aiac.listedWindows[backendName]
+	aiac.listedWindowsMu.Unlock()
+
+	if listed {
+		return windows[model]
+	}
+
+	// Failures are remembered too, so that backends are only asked once
+	models, err := aiac.ListModels(ctx, backendName)
+	if err != nil {
+		aiac.log().DebugContext(
+			ctx, "failed listing models for their context windows",
+			"backend", backendName,
+			"error", err,
+		)
+	}
+
+	windows = make(map[string]int, len(models))
+	for _, m := range models {
+		if m.ContextWindow > 0 {
+			windows[m.ID] = m.ContextWindow
+		}
+	}
+
+	aiac.listedWindowsMu.Lock()
+	if aiac.listedWindows == nil {
+		aiac.listedWindows = make(map[string]map[string]int)
+	}
+	aiac.listedWindows[backendName] = windows
+	aiac.listedWindowsMu.Unlock()
+
+	return windows[model]
+}
+
+// knownContextWindow returns the context window of a model of a backend type
+// set in the configuration, or else in DefaultContextWindows, if any.
+func (aiac *Aiac) knownContextWindow(backendType BackendType, model string) (int, bool) {
+	window, ok := findModel(aiac.Conf.ContextWindows[backendType], model)
+	if !ok {
+		window, ok = findModel(DefaultContextWindows[backendType], model)
+	}
+
+	return window, ok
+}
+
+// Tokenizer encodings are loaded from data embedded in the binary, once, when
+// first used
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*tiktoken.Tiktoken)
+)
+
+// tokenizerEncodings maps prefixes of OpenAI model names to the encodings of
+// their tokenizers. The longest matching prefix wins.
+var tokenizerEncodings = map[string]string{
+	"gpt-5":         "o200k_base",
+	"gpt-4.1":       "o200k_base",
+	"gpt-4o":        "o200k_base",
+	"gpt-4":         "cl100k_base",
+	"gpt-3.5-turbo": "cl100k_base",
+	"o1":            "o200k_base",
+	"o3":            "o200k_base",
+	"o4":            "o200k_base",
+}
+
+// countTokens counts the tokens in a list of messages sent to a model. If the
+// model's tokenizer is known, tokens are counted exactly, including the
+// overhead of the chat format (per OpenAI's documentation), and exact is true.
+// Otherwise, they are estimated from the length of the messages.
+func countTokens(model string, msgs []types.Message) (n int64, exact bool) {
+	// Routers and gateways prefix model names with the provider (e.g.
+	// "openai/gpt-4o")
+	name, found := strings.CutPrefix(model, "openai/")
+	if !found && strings.Contains(model, "/") {
+		name = ""
+	}
+
+	encoding, ok := findModel(tokenizerEncodings, name)
+	if ok {
+		var tokenizer *tiktoken.Tiktoken
+		if tokenizer, ok = loadEncoding(encoding); ok {
+			// Every message is wrapped in 3 tokens, and responses are primed
+			// with another 3
+			n = 3 //nolint: gomnd
+			for _, msg := range msgs {
+				n += 3 + int64(len(tokenizer.EncodeOrdinary(msg.Role))) +
+					int64(len(tokenizer.EncodeOrdinary(msg.Content)))
+			}
+
+			return n, true
+		}
+	}
+
+	for _, msg := range msgs {
+		n += estimateTokens(msg.Content)
+	}
+
+	return n, false
+}
+
+// loadEncoding returns the tokenizer of the provided encoding, loading it if
+// necessary. Returns false if it cannot be loaded.
+func loadEncoding(name string) (*tiktoken.Tiktoken, bool) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if tokenizer, ok := encodings[name]; ok {
+		return tokenizer, tokenizer != nil
+	}
+
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+
+	tokenizer, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		tokenizer = nil
+	}
+
+	encodings[name] = tokenizer
+
+	return tokenizer, tokenizer != nil
+}
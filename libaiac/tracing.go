@@ -0,0 +1,96 @@
+package libaiac
+
+import (
+	"context"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer libaiac records spans
+// with.
+const tracerName = "github.com/gofireflyio/aiac/v5/libaiac"
+
+// tracerProvider returns the OpenTelemetry tracer provider to record spans
+// with: Aiac.TracerProvider if set, or the global one otherwise.
+func (aiac *Aiac) tracerProvider() trace.TracerProvider {
+	if aiac == nil || aiac.TracerProvider == nil {
+		return otel.GetTracerProvider()
+	}
+
+	return aiac.TracerProvider
+}
+
+// startSpan starts a span with the provided name and attributes, as a child
+// of the span in ctx, if any.
+func (aiac *Aiac) startSpan(
+	ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	tracer := aiac.tracerProvider().Tracer(
+		tracerName,
+		trace.WithInstrumentationVersion(Version),
+	)
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, recording err and marking the span as failed if it is
+// not nil. Errors must already be redacted.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// startGeneration starts the span of a request to generate a response with
+// the conversation's current backend, following the OpenTelemetry semantic
+// conventions for generative AI where possible. If candidates is larger than
+// one, that many alternative responses are requested.
+func (conv *conversation) startGeneration(
+	ctx context.Context,
+	stream bool,
+	candidates int,
+) (context.Context, trace.Span) {
+	backendType := conv.aiac.Conf.Backends[conv.backendName].Type
+	if backendType == "" {
+		backendType = BackendOpenAI
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("gen_ai.operation.name", "chat"),
+		attribute.String("gen_ai.system", string(backendType)),
+		attribute.String("gen_ai.request.model", conv.model),
+		attribute.String("aiac.backend", conv.backendName),
+		attribute.Bool("aiac.stream", stream),
+	}
+
+	if candidates > 1 {
+		attrs = append(attrs, attribute.Int("gen_ai.request.choice.count", candidates))
+	}
+
+	return conv.aiac.startSpan(ctx, "chat "+conv.model, attrs...)
+}
+
+// recordUsage records the token usage and stop reason of a response in the
+// span of the request that generated it.
+func recordUsage(span trace.Span, res types.Response) {
+	span.SetAttributes(
+		attribute.Int64("gen_ai.usage.input_tokens", res.InputTokens),
+		attribute.Int64("gen_ai.usage.output_tokens", res.OutputTokens),
+	)
+
+	if res.StopReason != "" {
+		span.SetAttributes(attribute.StringSlice(
+			"gen_ai.response.finish_reasons",
+			[]string{res.StopReason},
+		))
+	}
+}
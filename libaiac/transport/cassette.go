@@ -0,0 +1,294 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrNoRecording is wrapped by errors returned by clients replaying recorded
+// responses (see Options.ReplayDir) for requests that were not recorded.
+var ErrNoRecording = errors.New("no recorded response for request")
+
+// Cassette is a recorded HTTP request and the response it received, as
+// stored by Record and served by Replay. Secrets are redacted from both.
+type Cassette struct {
+	// Request is the recorded request.
+	Request Request `json:"request"`
+
+	// Response is the response received.
+	Response Response `json:"response"`
+}
+
+// Response is a record of an HTTP response, with secrets redacted.
+type Response struct {
+	// Status is the HTTP status code of the response.
+	Status int `json:"status"`
+
+	// Headers are the response headers, with multiple values joined by
+	// commas.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Body is the response body, if it is valid UTF-8 text.
+	Body string `json:"body,omitempty"`
+
+	// BinaryBody is the response body, if it is not valid UTF-8 text (e.g.
+	// the event streams of Amazon Bedrock).
+	BinaryBody []byte `json:"binary_body,omitempty"`
+}
+
+// Record returns middleware that records every request and the response it
+// receives in a cassette (see Cassette), stored as a JSON file in dir, which
+// is created if necessary. Files are named after a hash of the request's
+// method, URL and body (see CassetteKey), so a request that is sent again
+// replaces its previous recording. Responses are recorded once their body is
+// read entirely or closed, so streamed responses still arrive as they are
+// generated; if the cassette cannot be written, reading the body fails.
+// Secrets are redacted from recordings, but requests are sent intact.
+func Record(dir string, secrets []string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &recordingTransport{
+			next:     next,
+			dir:      dir,
+			redactor: redactor{secrets},
+		}
+	}
+}
+
+type recordingTransport struct {
+	redactor
+	next http.RoundTripper
+	dir  string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading request body: %w", err)
+		}
+	}
+
+	rec, redactedBody, err := t.recordRequest(withBody(req, body))
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.next.RoundTrip(withBody(req, body))
+	if err != nil {
+		return res, err
+	}
+
+	res.Body = &recordingBody{
+		ReadCloser: res.Body,
+		transport:  t,
+		path:       filepath.Join(t.dir, CassetteKey(rec.Method, rec.URL, redactedBody)+".json"),
+		cassette: Cassette{
+			Request: rec,
+			Response: Response{
+				Status:  res.StatusCode,
+				Headers: t.recordHeaders(res.Header),
+			},
+		},
+	}
+
+	return res, nil
+}
+
+// recordHeaders records response headers with secrets redacted.
+func (r redactor) recordHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string, len(header))
+	for key, vals := range header {
+		if secretHeaders[key] || key == "Set-Cookie" {
+			headers[key] = Redacted
+			continue
+		}
+
+		headers[key] = r.redactText(strings.Join(vals, ", "))
+	}
+
+	return headers
+}
+
+// recordingBody is a response body that records the response in a cassette
+// once read entirely or closed.
+type recordingBody struct {
+	io.ReadCloser
+	transport *recordingTransport
+	path      string
+	cassette  Cassette
+	buf       bytes.Buffer
+	once      sync.Once
+	err       error
+}
+
+// Read implements the io.Reader interface.
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if saveErr := b.save(); saveErr != nil {
+			return n, saveErr
+		}
+	}
+
+	return n, err
+}
+
+// Close implements the io.Closer interface. The rest of the body is read
+// before closing it, so that the response is recorded whole.
+func (b *recordingBody) Close() error {
+	_, err := io.Copy(&b.buf, b.ReadCloser)
+	if err == nil {
+		err = b.save()
+	}
+
+	if closeErr := b.ReadCloser.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// save writes the cassette, the first time it is called.
+func (b *recordingBody) save() error {
+	b.once.Do(func() {
+		body := b.buf.Bytes()
+		if utf8.Valid(body) {
+			b.cassette.Response.Body = b.transport.redactText(string(body))
+		} else {
+			b.cassette.Response.BinaryBody = body
+		}
+
+		b.err = writeCassette(b.path, b.cassette)
+	})
+
+	return b.err
+}
+
+// writeCassette writes a cassette to the file at path, creating its
+// directory if necessary.
+func writeCassette(path string, cassette Cassette) error {
+	data, err := json.MarshalIndent(cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed encoding recording: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o700) //nolint: gomnd
+	if err != nil {
+		return fmt.Errorf("failed creating recordings directory: %w", err)
+	}
+
+	err = os.WriteFile(path, append(data, '\n'), 0o600) //nolint: gomnd
+	if err != nil {
+		return fmt.Errorf("failed writing recording: %w", err)
+	}
+
+	return nil
+}
+
+// replayTransport is an http.RoundTripper that serves responses from
+// cassettes recorded by Record, rather than sending requests.
+type replayTransport struct {
+	redactor
+	dir string
+}
+
+// RoundTrip implements the http.RoundTripper interface. Requests that were
+// not recorded fail with an error wrapping ErrNoRecording.
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, body, err := t.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(t.dir, CassetteKey(rec.Method, rec.URL, body)+".json")
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRecording, rec.Method, rec.URL)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed reading recording: %w", err)
+	}
+
+	var cassette Cassette
+	err = json.Unmarshal(data, &cassette)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding recording %s: %w", path, err)
+	}
+
+	resBody := cassette.Response.BinaryBody
+	if resBody == nil {
+		resBody = []byte(cassette.Response.Body)
+	}
+
+	res := &http.Response{
+		Status: fmt.Sprintf(
+			"%d %s",
+			cassette.Response.Status, http.StatusText(cassette.Response.Status),
+		),
+		StatusCode:    cassette.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header, len(cassette.Response.Headers)),
+		Body:          io.NopCloser(bytes.NewReader(resBody)),
+		ContentLength: int64(len(resBody)),
+		Request:       req,
+	}
+
+	for key, val := range cassette.Response.Headers {
+		res.Header.Set(key, val)
+	}
+
+	// The body may have been modified when recorded
+	res.Header.Del("Content-Length")
+
+	return res, nil
+}
+
+// CassetteKey returns the key identifying recordings of requests with the
+// provided method, URL and body, with secrets redacted: the hex-encoded
+// SHA-256 hash of all three. Headers are not part of the key, as they
+// include credentials and values that change between requests.
+func CassetteKey(method, url string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(method + "\n" + url + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// withBody returns a copy of req whose body is the provided one, which may be
+// read again (see http.Request.GetBody). If body is nil, the copy has the
+// body of req.
+func withBody(req *http.Request, body []byte) *http.Request {
+	r := req.Clone(req.Context())
+	if body == nil {
+		return r
+	}
+
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+
+	return r
+}
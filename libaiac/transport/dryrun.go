@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrDryRun is wrapped by errors returned by clients created in dry-run mode
+// (see Options.DryRun) instead of sending requests. Use errors.As with a
+// *DryRunError to retrieve the request.
+var ErrDryRun = errors.New("dry run, request not sent")
+
+// volatileHeaders are headers whose values change between otherwise identical
+// requests, and are omitted from recorded requests so they can be compared.
+var volatileHeaders = map[string]bool{
+	"X-Amz-Date":            true,
+	"Amz-Sdk-Invocation-Id": true,
+	"Amz-Sdk-Request":       true,
+}
+
+// Request is a record of an HTTP request that was not sent, with secrets
+// redacted.
+type Request struct {
+	// Method is the HTTP method of the request.
+	Method string `json:"method"`
+
+	// URL is the URL of the request.
+	URL string `json:"url"`
+
+	// Headers are the request headers, with multiple values joined by commas.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Body is the request body. JSON bodies are included as JSON, other
+	// bodies as strings.
+	Body interface{} `json:"body,omitempty"`
+}
+
+// DryRunError is returned by clients created in dry-run mode instead of
+// sending requests. It records the request that would have been sent.
+type DryRunError struct {
+	Request Request
+}
+
+// Error implements the error interface.
+func (err *DryRunError) Error() string {
+	return ErrDryRun.Error()
+}
+
+// Unwrap returns ErrDryRun.
+func (err *DryRunError) Unwrap() error {
+	return ErrDryRun
+}
+
+// dryRunTransport is an http.RoundTripper that records requests rather than
+// sending them.
+type dryRunTransport struct {
+	redactor
+}
+
+// RoundTrip implements the http.RoundTripper interface. It always fails with
+// a *DryRunError.
+func (t *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec, _, err := t.recordRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, &DryRunError{Request: rec}
+}
+
+// recordRequest records a request with secrets redacted, reading its body.
+// The redacted body is returned as well.
+func (r redactor) recordRequest(req *http.Request) (rec Request, body []byte, err error) {
+	rec = Request{
+		Method:  req.Method,
+		URL:     r.redactURL(req.URL),
+		Headers: make(map[string]string),
+	}
+
+	for key, vals := range req.Header {
+		key = http.CanonicalHeaderKey(key)
+
+		switch {
+		case volatileHeaders[key]:
+			continue
+		case secretHeaders[key]:
+			rec.Headers[key] = Redacted
+		default:
+			rec.Headers[key] = r.redact(strings.Join(vals, ", "))
+		}
+	}
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return rec, nil, fmt.Errorf("failed reading request body: %w", err)
+		}
+
+		body = []byte(r.redact(string(body)))
+		if json.Valid(body) {
+			rec.Body = json.RawMessage(body)
+		} else {
+			rec.Body = string(body)
+		}
+	}
+
+	return rec, body, nil
+}
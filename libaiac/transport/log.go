@@ -0,0 +1,68 @@
+package transport
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// requestIDHeaders are response headers in which providers return the ID
+// they assigned to a request, which is useful when reporting issues to them.
+var requestIDHeaders = []string{
+	"X-Request-Id",
+	"Request-Id",
+	"X-Amzn-Requestid",
+}
+
+// Logging returns middleware that logs the metadata of every request and its
+// response at debug level: the method, URL, status code, duration, and the
+// ID the provider assigned to the request, if any. Secrets are redacted
+// from URLs and errors. Headers and bodies are never logged.
+func Logging(logger *slog.Logger, secrets []string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &loggingTransport{
+			next:     next,
+			logger:   logger,
+			redactor: redactor{secrets},
+		}
+	}
+}
+
+type loggingTransport struct {
+	redactor
+	next   http.RoundTripper
+	logger *slog.Logger
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	if !t.logger.Enabled(ctx, slog.LevelDebug) {
+		return t.next.RoundTrip(req)
+	}
+
+	attrs := []any{"method", req.Method, "url", t.redactURL(req.URL)}
+	t.logger.DebugContext(ctx, "sending request", attrs...)
+
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	attrs = append(attrs, "duration", time.Since(start))
+
+	if err != nil {
+		attrs = append(attrs, "error", t.redactText(err.Error()))
+		t.logger.DebugContext(ctx, "request failed", attrs...)
+		return res, err
+	}
+
+	attrs = append(attrs, "status", res.StatusCode)
+	for _, header := range requestIDHeaders {
+		if id := res.Header.Get(header); id != "" {
+			attrs = append(attrs, "request_id", id)
+			break
+		}
+	}
+
+	t.logger.DebugContext(ctx, "received response", attrs...)
+
+	return res, nil
+}
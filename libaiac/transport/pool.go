@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default settings of the connection pools of base transports (see
+// PoolOptions).
+const (
+	// DefaultMaxIdleConns is the default maximum number of idle connections
+	// kept open across all hosts.
+	DefaultMaxIdleConns = 100
+
+	// DefaultMaxIdleConnsPerHost is the default maximum number of idle
+	// connections kept open to each host. It is higher than the standard
+	// library's default of 2, so that concurrent requests to a provider,
+	// such as those of batches, reuse their connections rather than opening
+	// new ones.
+	DefaultMaxIdleConnsPerHost = 16
+
+	// DefaultIdleConnTimeout is the default amount of time idle connections
+	// are kept open for.
+	DefaultIdleConnTimeout = 90 * time.Second
+)
+
+// PoolOptions configures the connections of base transports (see NewClient).
+// Zero values are replaced by the defaults above.
+type PoolOptions struct {
+	// MaxIdleConns is the maximum number of idle connections kept open
+	// across all hosts.
+	MaxIdleConns int
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections kept
+	// open to each host.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is the amount of time idle connections are kept open
+	// for before they are closed.
+	IdleConnTimeout time.Duration
+
+	// HTTP1Only disables HTTP/2, which is otherwise negotiated with servers
+	// that support it over TLS, forcing HTTP/1.1 (e.g. for proxies that
+	// mishandle HTTP/2).
+	HTTP1Only bool
+}
+
+// withDefaults returns the options with zero values replaced by their
+// defaults.
+func (opts PoolOptions) withDefaults() PoolOptions {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+
+	if opts.MaxIdleConnsPerHost == 0 {
+		opts.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	}
+
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+
+	return opts
+}
+
+// apply applies the options to a base transport.
+func (opts PoolOptions) apply(base *http.Transport) {
+	opts = opts.withDefaults()
+
+	base.MaxIdleConns = opts.MaxIdleConns
+	base.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	base.IdleConnTimeout = opts.IdleConnTimeout
+
+	if opts.HTTP1Only {
+		// A non-nil, empty map disables HTTP/2
+		base.ForceAttemptHTTP2 = false
+		base.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+}
+
+// Pool shares base transports, and so their idle connections, among the HTTP
+// clients created with it (see Options.Pool). Clients whose options dial
+// connections the same way (with the same proxy, TLS and Unix socket
+// settings) share a single base transport, so that clients of backends
+// talking to the same host reuse each other's connections rather than each
+// opening their own. It is safe for concurrent use.
+type Pool struct {
+	opts PoolOptions
+
+	mu         sync.Mutex
+	transports map[baseKey]*http.Transport
+}
+
+// baseKey identifies the options of a base transport that affect how its
+// connections are dialed.
+type baseKey struct {
+	proxy              string
+	caCertFile         string
+	insecureSkipVerify bool
+	unixSocket         string
+}
+
+// NewPool creates a pool of base transports whose connections are configured
+// with the provided options.
+func NewPool(opts PoolOptions) *Pool {
+	return &Pool{opts: opts}
+}
+
+// transport returns the base transport for the provided options, creating it
+// if none of the pool's clients were created with the same settings.
+func (pool *Pool) transport(opts Options) (*http.Transport, error) {
+	key := baseKey{
+		proxy:              opts.Proxy,
+		caCertFile:         opts.CACertFile,
+		insecureSkipVerify: opts.InsecureSkipVerify,
+		unixSocket:         opts.UnixSocket,
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if base, ok := pool.transports[key]; ok {
+		return base, nil
+	}
+
+	base, err := newBaseTransport(opts, pool.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if pool.transports == nil {
+		pool.transports = make(map[baseKey]*http.Transport)
+	}
+
+	pool.transports[key] = base
+
+	return base, nil
+}
@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"net/http"
+)
+
+// QueryParams returns middleware that adds the provided query parameters to
+// the URL of every request, replacing parameters of the same name. Names and
+// values are encoded as necessary, so they may contain any character.
+func QueryParams(params map[string]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &queryTransport{next: next, params: params}
+	}
+}
+
+type queryTransport struct {
+	next   http.RoundTripper
+	params map[string]string
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *queryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Requests must not be modified by transports, so the URL of a copy is
+	// changed
+	req = req.Clone(req.Context())
+
+	query := req.URL.Query()
+	for key, val := range t.params {
+		query.Set(key, val)
+	}
+
+	req.URL.RawQuery = query.Encode()
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,254 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitOptions configures the RateLimit middleware.
+type RateLimitOptions struct {
+	// RequestsPerMinute is the maximum number of requests sent per minute.
+	// Zero means no limit.
+	RequestsPerMinute int
+
+	// TokensPerMinute is the maximum number of tokens sent per minute. The
+	// tokens of a request are estimated from the size of its body. Zero
+	// means no limit.
+	TokensPerMinute int
+
+	// Logger, if not nil, is used to log requests that are delayed at info
+	// level.
+	Logger *slog.Logger
+
+	// secrets are redacted from logged URLs (see Options.Secrets)
+	secrets []string
+}
+
+// enabled returns whether any limit is set.
+func (opts RateLimitOptions) enabled() bool {
+	return opts.RequestsPerMinute > 0 || opts.TokensPerMinute > 0
+}
+
+// RateLimit returns middleware that paces requests to stay under the provided
+// limits, blocking until they can be sent or their context is done. Limits
+// are enforced with token buckets that refill continuously, so up to a
+// minute's worth of requests can be sent at once before pacing kicks in.
+// When a response reports that the provider's rate limit was hit (with a 429
+// status and a Retry-After or rate limit reset header), all requests are held
+// back until the limit resets, including retries (see Retry). All transports
+// wrapped by the returned middleware share the same limits.
+func RateLimit(opts RateLimitOptions) Middleware {
+	limiter := &rateLimiter{
+		requests: newBucket(opts.RequestsPerMinute),
+		tokens:   newBucket(opts.TokensPerMinute),
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &rateLimitTransport{next: next, opts: opts, limiter: limiter}
+	}
+}
+
+type rateLimitTransport struct {
+	next    http.RoundTripper
+	opts    RateLimitOptions
+	limiter *rateLimiter
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	req, tokens, err := requestTokens(req)
+	if err != nil {
+		return nil, err
+	}
+
+	delay, err := t.limiter.wait(ctx, tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	if delay > 0 && t.opts.Logger != nil {
+		t.opts.Logger.InfoContext(
+			ctx, "request delayed by rate limit",
+			"url", redactor{t.opts.secrets}.redactURL(req.URL),
+			"delay", delay,
+		)
+	}
+
+	res, err := t.next.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusTooManyRequests {
+		if after, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+			t.limiter.pause(after)
+		} else if reset, ok := rateLimitReset(res.Header); ok {
+			t.limiter.pause(reset)
+		}
+	}
+
+	return res, err
+}
+
+// requestTokens estimates the number of tokens in a request from the size of
+// its body, at roughly four bytes per token. Bodies of unknown length are
+// read via GetBody if set, and buffered otherwise, in which case a copy of the
+// request with the buffered body is returned.
+func requestTokens(req *http.Request) (*http.Request, int, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, 0, nil
+	}
+
+	size := req.ContentLength
+
+	switch {
+	case size > 0:
+	case req.GetBody != nil:
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed reading request body: %w", err)
+		}
+
+		size, err = io.Copy(io.Discard, body)
+		body.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed reading request body: %w", err)
+		}
+	default:
+		data, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed reading request body: %w", err)
+		}
+
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+
+		size = int64(len(data))
+	}
+
+	return req, int(size+3) / 4, nil //nolint: gomnd
+}
+
+// rateLimiter holds the token buckets of the RateLimit middleware.
+type rateLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+
+	// until is when requests may be sent again after the provider reported
+	// that its rate limit was hit
+	until time.Time
+}
+
+// wait blocks until a request with the provided number of tokens can be sent,
+// or the context is done, and returns how long it waited.
+func (l *rateLimiter) wait(ctx context.Context, tokens int) (waited time.Duration, err error) {
+	for {
+		l.mu.Lock()
+
+		now := time.Now()
+
+		delay := l.until.Sub(now)
+		if d := l.requests.delay(now, 1); d > delay {
+			delay = d
+		}
+		if d := l.tokens.delay(now, tokens); d > delay {
+			delay = d
+		}
+
+		if delay <= 0 {
+			l.requests.take(1)
+			l.tokens.take(tokens)
+			l.mu.Unlock()
+
+			return waited, nil
+		}
+
+		l.mu.Unlock()
+
+		if err := sleep(ctx, delay); err != nil {
+			return waited, err
+		}
+
+		waited += delay
+	}
+}
+
+// pause holds back all requests for the provided duration.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.until) {
+		l.until = until
+	}
+}
+
+// bucket is a token bucket holding up to a minute's worth of a limit, which
+// refills continuously. A nil bucket has no limit.
+type bucket struct {
+	capacity  float64
+	available float64
+	updated   time.Time
+}
+
+// newBucket creates a full bucket for a per-minute limit, or returns nil if
+// the limit is zero or less.
+func newBucket(perMinute int) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	return &bucket{
+		capacity:  float64(perMinute),
+		available: float64(perMinute),
+		updated:   time.Now(),
+	}
+}
+
+// delay refills the bucket, and returns how long it takes until n tokens are
+// available. Requests for more than the bucket's capacity wait for a full
+// bucket.
+func (b *bucket) delay(now time.Time, n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.available += now.Sub(b.updated).Minutes() * b.capacity
+	if b.available > b.capacity {
+		b.available = b.capacity
+	}
+	b.updated = now
+
+	missing := b.need(n) - b.available
+	if missing <= 0 {
+		return 0
+	}
+
+	return time.Duration(missing / b.capacity * float64(time.Minute))
+}
+
+// take removes n tokens from the bucket.
+func (b *bucket) take(n int) {
+	if b != nil {
+		b.available -= b.need(n)
+	}
+}
+
+// need returns the number of tokens a request for n tokens takes from the
+// bucket, which is at most its capacity.
+func (b *bucket) need(n int) float64 {
+	if float64(n) > b.capacity {
+		return b.capacity
+	}
+
+	return float64(n)
+}
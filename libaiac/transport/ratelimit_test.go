@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBucketDelay(t *testing.T) {
+	now := time.Now()
+
+	b := &bucket{capacity: 60, available: 60, updated: now}
+
+	if d := b.delay(now, 60); d != 0 {
+		t.Fatalf("expected a full bucket not to delay, got %s", d)
+	}
+	b.take(60)
+
+	// The bucket refills at one token per second
+	if d := b.delay(now, 2); d != 2*time.Second {
+		t.Errorf("expected a delay of 2s for an empty bucket, got %s", d)
+	}
+
+	if d := b.delay(now.Add(time.Second), 2); d != time.Second {
+		t.Errorf("expected a delay of 1s after refilling a token, got %s", d)
+	}
+
+	// Requests larger than the bucket wait for a full bucket
+	if d := b.delay(now.Add(time.Second), 600); d != 59*time.Second {
+		t.Errorf("expected an oversized request to wait for a full bucket, got %s", d)
+	}
+
+	if d := (*bucket)(nil).delay(now, 1000); d != 0 {
+		t.Errorf("expected an unlimited bucket not to delay, got %s", d)
+	}
+}
+
+func TestRateLimitPacing(t *testing.T) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+	}))
+	defer srv.Close()
+
+	// Ten tokens per second, with the first request taking the whole bucket
+	rt := RateLimit(RateLimitOptions{TokensPerMinute: 600})(http.DefaultTransport)
+
+	send(t, rt, srv.URL, strings.Repeat("x", 2400))
+
+	start := time.Now()
+	send(t, rt, srv.URL, strings.Repeat("x", 8))
+
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("expected the second request to be delayed by about 200ms, got %s", elapsed)
+	}
+
+	if n := hits.Load(); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestRateLimitPauseSharedAcrossRetries(t *testing.T) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Header().Set("X-Ratelimit-Reset-Requests", "300ms")
+			w.Header().Set("X-Ratelimit-Remaining-Requests", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	// Transports wrapped by the same middleware share its limits, as the
+	// transports of different backends do
+	limit := RateLimit(RateLimitOptions{RequestsPerMinute: 1000})
+	retried := Retry(RetryOptions{MaxRetries: 1, Backoff: time.Millisecond})(limit(http.DefaultTransport))
+	other := limit(http.DefaultTransport)
+
+	start := time.Now()
+	send(t, retried, srv.URL, "")
+
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("expected the retry to wait for the rate limit to reset, got %s", elapsed)
+	}
+
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+
+	// The pause is over, so other requests are not held back anymore
+	start = time.Now()
+	send(t, other, srv.URL, "")
+
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected no delay after the rate limit reset, got %s", elapsed)
+	}
+}
+
+func TestRateLimitPauseHoldsBackOtherRequests(t *testing.T) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if hits.Add(1) == 1 {
+			w.Header().Set("Retry-After", "60")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	limit := RateLimit(RateLimitOptions{RequestsPerMinute: 1000})
+	first, second := limit(http.DefaultTransport), limit(http.DefaultTransport)
+
+	res := send(t, first, srv.URL, "")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", res.StatusCode)
+	}
+
+	// The second transport is paused until the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+	start := time.Now()
+
+	_, err := second.RoundTrip(req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %q, got %v", context.DeadlineExceeded, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected waiting to stop with the context, took %s", elapsed)
+	}
+
+	if n := hits.Load(); n != 1 {
+		t.Errorf("expected the paused request not to be sent, got %d requests", n)
+	}
+}
+
+// send sends a POST request with the provided body, or a GET request if it is
+// empty, through a transport, failing the test if it fails.
+func send(t *testing.T, rt http.RoundTripper, url, body string) *http.Response {
+	t.Helper()
+
+	method := http.MethodGet
+	if body != "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed creating request: %s", err)
+	}
+
+	res, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request failed: %s", err)
+	}
+
+	drain(res.Body)
+
+	return res
+}
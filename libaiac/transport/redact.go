@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in recorded and logged requests.
+const Redacted = "REDACTED"
+
+// secretHeaders are headers whose values are always redacted, as they carry
+// credentials.
+var secretHeaders = map[string]bool{
+	"Authorization":        true,
+	"Proxy-Authorization":  true,
+	"Api-Key":              true,
+	"X-Api-Key":            true,
+	"X-Goog-Api-Key":       true,
+	"X-Amz-Security-Token": true,
+	"Cookie":               true,
+}
+
+// secretParams are URL query parameters whose values are always redacted.
+var secretParams = []string{"key", "api_key", "api-key"}
+
+// credentialPatterns match credentials that may appear in text such as error
+// messages, with the credential itself in the last submatch: the values of
+// secretHeaders (with or without an authentication scheme such as "Bearer"),
+// the values of secretParams in URLs, and AWS access key IDs.
+var credentialPatterns = []*regexp.Regexp{
+	regexp.MustCompile(
+		`(?i)((?:proxy-)?authorization|x-api-key|api-key|x-goog-api-key|x-amz-security-token|cookie)` +
+			`(["']?\s*[:=]\s*["']?(?:(?:bearer|basic|token)\s+)?)([^\s"',;&]+)`,
+	),
+	regexp.MustCompile(`(?i)([?&](?:key|api_key|api-key)=)()([^&\s"'#]+)`),
+	regexp.MustCompile(`()()\b((?:AKIA|ASIA)[A-Z0-9]{16})\b`),
+}
+
+// redactor redacts secrets from requests, so that they can be displayed.
+type redactor struct {
+	// secrets are strings redacted wherever they appear, such as API keys
+	secrets []string
+}
+
+// redactURL returns the provided URL with credentials redacted.
+func (r redactor) redactURL(u *url.URL) string {
+	redacted := *u
+	redacted.User = nil
+
+	query := redacted.Query()
+	for _, param := range secretParams {
+		if query.Has(param) {
+			query.Set(param, Redacted)
+		}
+	}
+	redacted.RawQuery = query.Encode()
+
+	return r.redact(redacted.String())
+}
+
+// redact replaces all occurrences of the redactor's secrets in s.
+func (r redactor) redact(s string) string {
+	for _, secret := range r.secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+	}
+
+	return s
+}
+
+// redactText replaces all occurrences of the redactor's secrets in s, and any
+// credentials matching credentialPatterns.
+func (r redactor) redactText(s string) string {
+	s = r.redact(s)
+
+	for _, pattern := range credentialPatterns {
+		s = pattern.ReplaceAllString(s, "${1}${2}"+Redacted)
+	}
+
+	return s
+}
+
+// RedactText returns the provided text with the provided secrets, and values
+// that look like credentials, redacted: the values of headers carrying
+// credentials (e.g. "Authorization: Bearer ..."), of URL query parameters
+// carrying API keys, and AWS access key IDs.
+func RedactText(s string, secrets ...string) string {
+	return redactor{secrets}.redactText(s)
+}
+
+// RedactError returns an error whose message is that of err with secrets
+// redacted, as by RedactText. The returned error wraps err, so that it can
+// still be inspected with errors.Is and errors.As. Returns err itself if
+// there is nothing to redact, including if err is nil.
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	redacted := RedactText(msg, secrets...)
+	if redacted == msg {
+		return err
+	}
+
+	return &redactedError{err: err, msg: redacted}
+}
+
+// redactedError is an error whose message has secrets redacted.
+type redactedError struct {
+	err error
+	msg string
+}
+
+// Error implements the error interface.
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the original error.
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,326 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultRetryBackoff is the base delay for exponential backoff between
+// retries, used when one is not provided.
+const DefaultRetryBackoff = time.Second
+
+// MaxRetryBackoff is the delay exponential backoff between retries stops
+// growing at, unless the base delay is longer.
+const MaxRetryBackoff = time.Minute
+
+// RetryOptions configures the Retry middleware.
+type RetryOptions struct {
+	// MaxRetries is the maximum number of times a failed request is retried.
+	MaxRetries int
+
+	// Backoff is the base delay for exponential backoff between retries. The
+	// delay before retry number n is approximately Backoff * 2^(n-1), with
+	// random jitter, up to MaxRetryBackoff. Defaults to DefaultRetryBackoff.
+	Backoff time.Duration
+
+	// Logger, if not nil, is used to log retries at info level.
+	Logger *slog.Logger
+
+	// secrets are redacted from logged retries (see Options.Secrets)
+	secrets []string
+}
+
+// Retry returns middleware that retries requests failing due to transient
+// errors: network errors, and responses with a transient status (see
+// types.TransientStatus). Other responses (including authentication errors)
+// are returned immediately. When a response includes a Retry-After header, it
+// is honored instead of the exponential backoff delay, as are the rate limit
+// reset headers (e.g. X-Ratelimit-Reset-Tokens) some providers include with
+// 429 responses, and the estimated loading time of models included with 503
+// responses by the Hugging Face Inference API. Retries stop as soon as the
+// request's context is done, and a retry is not attempted if its delay would
+// exceed the context's deadline.
+func Retry(opts RetryOptions) Middleware {
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultRetryBackoff
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &retryTransport{next: next, opts: opts}
+	}
+}
+
+// attemptKey is the context key under which the number of the attempt of a
+// retried request is stored, starting at 1 for the first retry.
+type attemptKey struct{}
+
+// attempt returns the number of times the request with the provided context
+// was already attempted, which is zero unless it is a retry.
+func attempt(ctx context.Context) int {
+	n, _ := ctx.Value(attemptKey{}).(int)
+	return n
+}
+
+type retryTransport struct {
+	next http.RoundTripper
+	opts RetryOptions
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	// Request bodies must be re-read for every attempt, which requires a
+	// GetBody function. Not all clients set one, so we buffer the body if
+	// necessary.
+	getBody := req.GetBody
+	if req.Body != nil && req.Body != http.NoBody && getBody == nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed reading request body: %w", err)
+		}
+
+		getBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		r := req
+		if attempt > 0 {
+			r = req.WithContext(context.WithValue(ctx, attemptKey{}, attempt))
+		}
+
+		if getBody != nil {
+			body, err := getBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed rewinding request body: %w", err)
+			}
+
+			r = r.Clone(r.Context())
+			r.Body = body
+			r.GetBody = getBody
+		}
+
+		res, err := t.next.RoundTrip(r)
+		if attempt >= t.opts.MaxRetries || ctx.Err() != nil || !retryable(res, err) {
+			return res, err
+		}
+
+		delay := t.delay(attempt, res)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return res, err
+		}
+
+		if t.opts.Logger != nil {
+			r := redactor{t.opts.secrets}
+
+			var reason string
+			if err != nil {
+				reason = r.redactText(err.Error())
+			} else {
+				reason = res.Status
+			}
+
+			t.opts.Logger.InfoContext(
+				ctx, "retrying request",
+				"url", r.redactURL(req.URL),
+				"attempt", attempt+1,
+				"reason", reason,
+				"delay", delay,
+			)
+		}
+
+		if res != nil {
+			drain(res.Body)
+		}
+
+		if err := sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// delay returns the amount of time to wait before retrying a failed attempt.
+func (t *retryTransport) delay(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if after, ok := retryAfter(res.Header.Get("Retry-After")); ok {
+			return after
+		}
+
+		if res.StatusCode == http.StatusTooManyRequests {
+			if reset, ok := rateLimitReset(res.Header); ok {
+				return reset
+			}
+		}
+
+		if res.StatusCode == http.StatusServiceUnavailable {
+			if loading, ok := modelLoadingTime(res); ok {
+				return loading
+			}
+		}
+	}
+
+	// Doubling stops at the maximum, so that large numbers of retries do not
+	// overflow
+	backoff := t.opts.Backoff
+	for i := 0; i < attempt && backoff < MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > MaxRetryBackoff && t.opts.Backoff <= MaxRetryBackoff {
+		backoff = MaxRetryBackoff
+	}
+
+	// Full exponential backoff is used as an upper bound, the actual delay
+	// falls randomly between half of it and the full value
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1)) //nolint: gosec
+}
+
+// retryable returns whether a request that resulted in the provided response
+// and error should be retried.
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return types.TransientStatus(res.StatusCode)
+}
+
+// retryAfter parses the value of a Retry-After header, which may be either a
+// number of seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		after := time.Until(date)
+		if after < 0 {
+			after = 0
+		}
+
+		return after, true
+	}
+
+	return 0, false
+}
+
+// rateLimitResetHeaders are the headers some providers (e.g. OpenAI and Groq)
+// use to report when their rate limits reset, as durations such as "1m30s".
+var rateLimitResetHeaders = []string{
+	"X-Ratelimit-Reset-Requests",
+	"X-Ratelimit-Reset-Tokens",
+}
+
+// maxErrorBodySize is the maximum size of error response bodies read by
+// modelLoadingTime.
+const maxErrorBodySize = 64 * 1024
+
+// modelLoadingTime returns the estimated time until the model a request was
+// sent to is loaded, as reported in the body of 503 responses by the Hugging
+// Face Inference API (e.g. {"error": "...", "estimated_time": 20.5}). The
+// body remains readable.
+func modelLoadingTime(res *http.Response) (time.Duration, bool) {
+	if res.Body == nil || !strings.Contains(res.Header.Get("Content-Type"), "json") {
+		return 0, false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+	res.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), res.Body), res.Body}
+	if err != nil {
+		return 0, false
+	}
+
+	var body struct {
+		EstimatedTime float64 `json:"estimated_time"`
+	}
+
+	if json.Unmarshal(data, &body) != nil || body.EstimatedTime <= 0 {
+		return 0, false
+	}
+
+	return time.Duration(body.EstimatedTime * float64(time.Second)), true
+}
+
+// rateLimitReset returns the amount of time until the rate limits reported in
+// the provided response headers reset. Only limits that are exhausted (i.e.
+// whose matching remaining header is zero) are considered, unless no
+// remaining headers are provided, in which case the longest reset is used.
+func rateLimitReset(header http.Header) (reset time.Duration, ok bool) {
+	var exhausted, unknown []time.Duration
+
+	for _, name := range rateLimitResetHeaders {
+		value := header.Get(name)
+		if value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			continue
+		}
+
+		remaining := header.Get(strings.Replace(name, "-Reset-", "-Remaining-", 1))
+		switch remaining {
+		case "":
+			unknown = append(unknown, d)
+		case "0":
+			exhausted = append(exhausted, d)
+		}
+	}
+
+	candidates := exhausted
+	if len(candidates) == 0 {
+		candidates = unknown
+	}
+
+	for _, d := range candidates {
+		if d > reset {
+			reset = d
+		}
+		ok = true
+	}
+
+	return reset, ok
+}
+
+// sleep waits for the provided duration, or until the context is done,
+// whichever comes first.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// drain reads and closes a response body, allowing the underlying connection
+// to be reused.
+func drain(body io.ReadCloser) {
+	io.Copy(io.Discard, body) //nolint: errcheck
+	body.Close()
+}
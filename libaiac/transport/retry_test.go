@@ -0,0 +1,240 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff time.Duration
+		attempt int
+		// header and status are those of the failed response, if any, and
+		// body its JSON body
+		header http.Header
+		status int
+		body   string
+		// min and max bound the expected delay
+		min, max time.Duration
+	}{
+		{name: "first retry", backoff: time.Second, attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{name: "third retry", backoff: time.Second, attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+		{name: "capped", backoff: time.Second, attempt: 10, min: MaxRetryBackoff / 2, max: MaxRetryBackoff},
+		{name: "no overflow", backoff: time.Second, attempt: 100, min: MaxRetryBackoff / 2, max: MaxRetryBackoff},
+		{
+			name:    "base above cap",
+			backoff: 2 * MaxRetryBackoff,
+			attempt: 5,
+			min:     MaxRetryBackoff,
+			max:     2 * MaxRetryBackoff,
+		},
+		{
+			name:    "Retry-After",
+			backoff: time.Second,
+			header:  http.Header{"Retry-After": []string{"7"}},
+			status:  http.StatusServiceUnavailable,
+			min:     7 * time.Second,
+			max:     7 * time.Second,
+		},
+		{
+			name:    "rate limit reset",
+			backoff: time.Second,
+			header: http.Header{
+				"X-Ratelimit-Reset-Requests":     []string{"2s"},
+				"X-Ratelimit-Remaining-Requests": []string{"5"},
+				"X-Ratelimit-Reset-Tokens":       []string{"1m30s"},
+				"X-Ratelimit-Remaining-Tokens":   []string{"0"},
+			},
+			status: http.StatusTooManyRequests,
+			min:    90 * time.Second,
+			max:    90 * time.Second,
+		},
+		{
+			name:    "model loading",
+			backoff: time.Second,
+			header:  http.Header{"Content-Type": []string{"application/json"}},
+			status:  http.StatusServiceUnavailable,
+			body:    `{"error": "Model is currently loading", "estimated_time": 20.5}`,
+			min:     20500 * time.Millisecond,
+			max:     20500 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := Retry(RetryOptions{Backoff: tt.backoff})(http.DefaultTransport).(*retryTransport)
+
+			var res *http.Response
+			if tt.status != 0 {
+				res = &http.Response{
+					StatusCode: tt.status,
+					Header:     tt.header,
+					Body:       io.NopCloser(strings.NewReader(tt.body)),
+				}
+			}
+
+			// Jitter is random, so the delay is checked repeatedly
+			for i := 0; i < 100; i++ {
+				d := rt.delay(tt.attempt, res)
+				if d < tt.min || d > tt.max {
+					t.Fatalf("expected a delay between %s and %s, got %s", tt.min, tt.max, d)
+				}
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		// statuses are the statuses of consecutive responses, with the last
+		// one repeated
+		statuses []int
+		// wantStatus is the status of the returned response, and wantHits
+		// the expected number of requests
+		wantStatus int
+		wantHits   int32
+	}{
+		{
+			name:       "transient failures",
+			maxRetries: 3,
+			statuses:   []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK},
+			wantStatus: http.StatusOK,
+			wantHits:   3,
+		},
+		{
+			name:       "retries exhausted",
+			maxRetries: 2,
+			statuses:   []int{http.StatusInternalServerError},
+			wantStatus: http.StatusInternalServerError,
+			wantHits:   3,
+		},
+		{
+			name:       "not retryable",
+			maxRetries: 3,
+			statuses:   []int{http.StatusUnauthorized},
+			wantStatus: http.StatusUnauthorized,
+			wantHits:   1,
+		},
+		{
+			name:       "retries disabled",
+			statuses:   []int{http.StatusServiceUnavailable},
+			wantStatus: http.StatusServiceUnavailable,
+			wantHits:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(hits.Add(1))
+				if n > len(tt.statuses) {
+					n = len(tt.statuses)
+				}
+
+				// Every attempt must send the whole body
+				if body, _ := io.ReadAll(r.Body); string(body) != "prompt" {
+					t.Errorf("expected body %q, got %q", "prompt", body)
+				}
+
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			rt := Retry(RetryOptions{
+				MaxRetries: tt.maxRetries,
+				Backoff:    time.Millisecond,
+			})(http.DefaultTransport)
+
+			// A body without GetBody must be buffered to be sent again
+			req, _ := http.NewRequest(http.MethodPost, srv.URL, io.NopCloser(strings.NewReader("prompt")))
+
+			res, err := rt.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			drain(res.Body)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, res.StatusCode)
+			}
+
+			if n := hits.Load(); n != tt.wantHits {
+				t.Errorf("expected %d requests, got %d", tt.wantHits, n)
+			}
+		})
+	}
+}
+
+func TestRetryCancellation(t *testing.T) {
+	var hits atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	rt := Retry(RetryOptions{MaxRetries: 3})(http.DefaultTransport)
+
+	t.Run("canceled while waiting", func(t *testing.T) {
+		hits.Store(0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+		start := time.Now()
+
+		_, err := rt.RoundTrip(req)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected %q, got %v", context.Canceled, err)
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected waiting to stop with the context, took %s", elapsed)
+		}
+
+		if n := hits.Load(); n != 1 {
+			t.Errorf("expected 1 request, got %d", n)
+		}
+	})
+
+	t.Run("delay beyond deadline", func(t *testing.T) {
+		hits.Store(0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+		// The failed response is returned as-is, rather than waiting for a
+		// retry that cannot complete in time
+		res, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		drain(res.Body)
+
+		if res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected status %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+		}
+
+		if n := hits.Load(); n != 1 {
+			t.Errorf("expected 1 request, got %d", n)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"net/http"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer requests are recorded
+// with.
+const tracerName = "github.com/gofireflyio/aiac/v5/libaiac/transport"
+
+// Tracing returns middleware that records every request in an OpenTelemetry
+// client span, as a child of the span in the request's context, following the
+// semantic conventions for HTTP clients. Every attempt of retried requests is
+// recorded in its own span, with the number of previous attempts in the
+// http.request.resend_count attribute. The trace context is propagated to
+// the server with the global propagator (see otel.SetTextMapPropagator).
+// Secrets are redacted from URLs and errors.
+func Tracing(provider trace.TracerProvider, secrets []string) Middleware {
+	tracer := provider.Tracer(tracerName)
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &tracingTransport{
+			next:     next,
+			tracer:   tracer,
+			redactor: redactor{secrets},
+		}
+	}
+}
+
+type tracingTransport struct {
+	redactor
+	next   http.RoundTripper
+	tracer trace.Tracer
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", t.redactURL(req.URL)),
+		attribute.String("server.address", req.URL.Hostname()),
+	}
+
+	if port, err := strconv.Atoi(req.URL.Port()); err == nil {
+		attrs = append(attrs, attribute.Int("server.port", port))
+	}
+
+	if n := attempt(req.Context()); n > 0 {
+		attrs = append(attrs, attribute.Int("http.request.resend_count", n))
+	}
+
+	ctx, span := t.tracer.Start(
+		req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	defer span.End()
+
+	// Requests must not be modified by transports, so headers are added to
+	// a copy
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		msg := t.redactText(err.Error())
+		span.RecordError(&redactedError{err: err, msg: msg})
+		span.SetStatus(codes.Error, msg)
+		return res, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, res.Status)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrStreamIdle is returned when reading a streamed response fails because
+// no data arrived for longer than the idle timeout (see StreamWatchdog).
+var ErrStreamIdle = errors.New("stream stalled")
+
+// streamMediaTypes are the media types of streamed responses, whose bodies
+// are watched by StreamWatchdog: server-sent events (most providers), JSON
+// lines (Ollama) and AWS event streams (Bedrock).
+var streamMediaTypes = map[string]bool{
+	"text/event-stream":                  true,
+	"application/x-ndjson":               true,
+	"application/vnd.amazon.eventstream": true,
+}
+
+// StreamWatchdog returns middleware that aborts streamed responses when no
+// data arrives for longer than the provided timeout, so that streams stalled
+// by flaky networks fail rather than hang. Reading the body of such responses
+// then fails with an error wrapping ErrStreamIdle. The timer starts once the
+// response's headers are received, and is reset whenever data is read; the
+// time until then is only bounded by the deadline of the request's context.
+// Responses that are not streamed (see streamMediaTypes) are not watched.
+func StreamWatchdog(timeout time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &watchdogTransport{next: next, timeout: timeout}
+	}
+}
+
+type watchdogTransport struct {
+	next    http.RoundTripper
+	timeout time.Duration
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *watchdogTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Stalled reads are interrupted by canceling the request, which also
+	// cooperates with the deadline and cancellation of its own context
+	ctx, cancel := context.WithCancelCause(req.Context())
+
+	res, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel(nil)
+		return res, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+
+	body := &watchedBody{ReadCloser: res.Body, ctx: ctx, cancel: cancel}
+	if streamMediaTypes[mediaType] {
+		body.timeout = t.timeout
+		body.timer = time.AfterFunc(t.timeout, func() {
+			cancel(fmt.Errorf("%w: no data received for %s", ErrStreamIdle, t.timeout))
+		})
+	}
+
+	res.Body = body
+
+	return res, nil
+}
+
+// watchedBody is the body of a response sent through StreamWatchdog. The
+// request's context is canceled once the body is closed, or when no data was
+// read from it for longer than the timeout, if it is streamed.
+type watchedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timeout time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// Read implements the io.Reader interface, resetting the idle timer whenever
+// data is read. Once the timer fires, reads fail with the cause of the
+// cancellation, which wraps ErrStreamIdle.
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if b.timer != nil && n > 0 {
+		b.mu.Lock()
+		if b.ctx.Err() == nil {
+			b.timer.Reset(b.timeout)
+		}
+		b.mu.Unlock()
+	}
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		if cause := context.Cause(b.ctx); errors.Is(cause, ErrStreamIdle) {
+			err = cause
+		}
+	}
+
+	return n, err
+}
+
+// Close implements the io.Closer interface, stopping the idle timer.
+func (b *watchedBody) Close() error {
+	if b.timer != nil {
+		b.mu.Lock()
+		b.timer.Stop()
+		b.mu.Unlock()
+	}
+
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+
+	return err
+}
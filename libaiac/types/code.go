@@ -0,0 +1,321 @@
+package types
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// CodeBlock is a fenced code block found in Markdown output.
+type CodeBlock struct {
+	// Language is the language hint of the block (the first word after the
+	// opening fence), lowercased. It is empty if the block has no hint.
+	Language string
+
+	// Code is the contents of the block, without the fences.
+	Code string
+
+	// Filename is the name of the file the block belongs to, if the output
+	// names it, either in the block's info string (e.g. ```hcl title="main.tf")
+	// or in the line preceding the block (e.g. a "### main.tf" heading). It is
+	// empty otherwise.
+	Filename string
+}
+
+// ExtractCode receives the full output string from an LLM provider and
+// attempts to extract the code from it (see ExtractCodeBlock). If successful,
+// the code string will be returned together with a true value, otherwise an
+// empty string is returned together with a false value.
+func ExtractCode(output string) (string, bool) {
+	code, _, ok := ExtractCodeBlock(output)
+	return code, ok
+}
+
+// shellLanguages are language hints of blocks that typically contain
+// instructions for running the generated code, rather than the code itself.
+var shellLanguages = map[string]bool{
+	"bash": true, "sh": true, "shell": true, "console": true, "zsh": true,
+	"cmd": true, "powershell": true, "ps1": true,
+}
+
+// ExtractCodeBlock extracts the generated code from the full output of an LLM
+// provider, which is generally Markdown containing one or more fenced code
+// blocks, possibly surrounded by explanations. The largest block is selected,
+// and any other blocks with the same language are concatenated to it in the
+// order in which they appear (so a response split into several files of the
+// same language is kept whole, while auxiliary blocks are dropped). Blocks of
+// shell commands are only selected if there are no other blocks. The language
+// hint of the selected block is returned as well. If the output contains no
+// code blocks, ok is false.
+func ExtractCodeBlock(output string) (code, language string, ok bool) {
+	return selectCode(FindCodeBlocks(output))
+}
+
+// selectCode selects the generated code from the provided code blocks, as
+// described by ExtractCodeBlock.
+func selectCode(blocks []CodeBlock) (code, language string, ok bool) {
+	if len(blocks) == 0 {
+		return "", "", false
+	}
+
+	largest := -1
+	for _, skipShell := range []bool{true, false} {
+		for i := range blocks {
+			if skipShell && shellLanguages[blocks[i].Language] {
+				continue
+			}
+			if largest == -1 || len(blocks[i].Code) > len(blocks[largest].Code) {
+				largest = i
+			}
+		}
+		if largest != -1 {
+			break
+		}
+	}
+
+	language = blocks[largest].Language
+
+	var parts []string
+	for i := range blocks {
+		if i == largest || (language != "" && blocks[i].Language == language) {
+			parts = append(parts, blocks[i].Code)
+		}
+	}
+
+	code = strings.Join(parts, "\n\n")
+	if strings.TrimSpace(code) == "" {
+		return "", "", false
+	}
+
+	return code, language, true
+}
+
+// FindCodeBlocks returns all fenced code blocks in Markdown output, in order.
+// Both backtick and tilde fences are supported, indented by up to three
+// spaces. A block is closed by a fence of the same character that is at least
+// as long as the opening fence. A block that is never closed (e.g. because the
+// output was truncated) extends to the end of the output.
+func FindCodeBlocks(output string) (blocks []CodeBlock) {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+
+	var current *CodeBlock
+	var fence string
+	var body []string
+
+	// prev is the last non-empty line outside of code blocks, which may name
+	// the file the next block belongs to
+	var prev string
+
+	for _, line := range lines {
+		if current == nil {
+			f, info, isFence := parseFence(line)
+			if !isFence {
+				if strings.TrimSpace(line) != "" {
+					prev = line
+				}
+				continue
+			}
+
+			current = &CodeBlock{}
+			current.Language, current.Filename = parseInfo(info)
+			if current.Filename == "" {
+				current.Filename = headingFilename(prev)
+			}
+
+			fence = f
+			body = nil
+			prev = ""
+			continue
+		}
+
+		if isClosingFence(line, fence) {
+			current.Code = strings.Join(body, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+
+		body = append(body, line)
+	}
+
+	if current != nil {
+		current.Code = strings.TrimRight(strings.Join(body, "\n"), "\n")
+		blocks = append(blocks, *current)
+	}
+
+	return blocks
+}
+
+// Prose returns the text of Markdown output outside of its fenced code
+// blocks, which are found as by FindCodeBlocks, so that the output is split
+// into the code and the prose around it consistently. Blank lines left by
+// removed blocks are collapsed, and surrounding whitespace is trimmed.
+func Prose(output string) string {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+
+	var prose []string
+	var fence string
+
+	for _, line := range lines {
+		if fence != "" {
+			if isClosingFence(line, fence) {
+				fence = ""
+			}
+			continue
+		}
+
+		if f, _, isFence := parseFence(line); isFence {
+			fence = f
+			continue
+		}
+
+		// Consecutive blank lines are collapsed into one
+		if strings.TrimSpace(line) == "" && len(prose) > 0 &&
+			strings.TrimSpace(prose[len(prose)-1]) == "" {
+			continue
+		}
+
+		prose = append(prose, line)
+	}
+
+	return strings.TrimSpace(strings.Join(prose, "\n"))
+}
+
+// parseFence checks whether the line is an opening code fence, and returns the
+// fence and its info string if so.
+func parseFence(line string) (fence, info string, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 { //nolint: gomnd
+		return "", "", false
+	}
+
+	if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+		return "", "", false
+	}
+
+	char := trimmed[0]
+	n := 0
+	for n < len(trimmed) && trimmed[n] == char {
+		n++
+	}
+
+	info = strings.TrimSpace(trimmed[n:])
+
+	// Backtick fences cannot have backticks in their info string, this
+	// prevents inline code such as ```foo``` from being considered a fence
+	if char == '`' && strings.Contains(info, "`") {
+		return "", "", false
+	}
+
+	return trimmed[:n], info, true
+}
+
+// parseInfo parses the info string of a code fence, returning the language
+// hint and the filename, if any. Filenames are recognized as attributes (e.g.
+// title="main.tf" or filename=main.tf), after the language separated by a
+// colon (e.g. hcl:main.tf), or in place of the language (e.g. variables.tf).
+func parseInfo(info string) (language, filename string) {
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return "", ""
+	}
+
+	first := strings.Trim(fields[0], "{}")
+	if lang, name, found := strings.Cut(first, ":"); found && IsFilename(name) {
+		first, filename = lang, name
+	}
+
+	// Well-known filenames without an extension (e.g. "Dockerfile") are
+	// language hints in this position
+	if IsFilename(first) && filepath.Ext(first) != "" {
+		first, filename = "", first
+	}
+
+	language = strings.ToLower(strings.Trim(first, "."))
+
+	for _, field := range fields[1:] {
+		key, val, found := strings.Cut(strings.Trim(field, "{}"), "=")
+		if !found {
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "title", "file", "filename", "name", "path":
+			if val = strings.Trim(val, `"'`); IsFilename(val) {
+				filename = val
+			}
+		}
+	}
+
+	return language, filename
+}
+
+// headingFilename returns the filename named by a line of Markdown preceding
+// a code block, if the line consists of nothing but the filename, possibly
+// formatted as a heading, in bold or as code, prefixed with "File:", or
+// followed by a colon (e.g. "### `variables.tf`" or "**File: main.tf**").
+func headingFilename(line string) string {
+	const markup = "#*_`: "
+
+	name := strings.Trim(line, markup)
+	for _, prefix := range []string{"file", "filename"} {
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)+1], prefix+":") {
+			name = strings.Trim(name[len(prefix)+1:], markup)
+		}
+	}
+
+	if !IsFilename(name) {
+		return ""
+	}
+
+	return name
+}
+
+// wellKnownFilenames are filenames without an extension that are recognized
+// as such (see IsFilename).
+var wellKnownFilenames = map[string]bool{
+	"dockerfile": true, "makefile": true, "jenkinsfile": true,
+	"vagrantfile": true, "procfile": true, "gemfile": true,
+}
+
+// IsFilename checks whether the provided string looks like the relative path
+// of a file: a single word with a file extension (e.g. "variables.tf" or
+// "modules/vpc/main.tf"), or a well-known name without one (e.g.
+// "Dockerfile"). Absolute paths and paths leading outside of the current
+// directory are not considered filenames, so that names taken from model
+// output are safe to write files to.
+func IsFilename(s string) bool {
+	if s == "" || strings.ContainsAny(s, " \t\\\"'<>|*?") || !filepath.IsLocal(s) {
+		return false
+	}
+
+	base := filepath.Base(s)
+	if wellKnownFilenames[strings.ToLower(base)] {
+		return true
+	}
+
+	ext := filepath.Ext(base)
+	if len(ext) < 2 || len(ext) == len(base) {
+		return false
+	}
+
+	for _, r := range ext[1:] {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+
+	// Version numbers (e.g. "1.5") are not filenames
+	return !unicode.IsDigit(rune(ext[1]))
+}
+
+// isClosingFence checks whether the line closes a block opened with the
+// provided fence.
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	if len(trimmed) < len(fence) {
+		return false
+	}
+
+	return strings.Trim(trimmed, fence[:1]) == ""
+}
@@ -0,0 +1,304 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindCodeBlocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []CodeBlock
+	}{
+		{
+			name:   "no fences",
+			output: "resource \"aws_s3_bucket\" \"main\" {}",
+			want:   nil,
+		},
+		{
+			name:   "prose before and after",
+			output: "Here is the code:\n\n```hcl\nresource {}\n```\n\nApply it with terraform.",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+		{
+			name:   "several blocks",
+			output: "```bash\nterraform init\n```\nThen:\n```HCL\nresource {}\n```",
+			want: []CodeBlock{
+				{Language: "bash", Code: "terraform init"},
+				{Language: "hcl", Code: "resource {}"},
+			},
+		},
+		{
+			name:   "tilde fence",
+			output: "~~~yaml\nkey: value\n~~~",
+			want:   []CodeBlock{{Language: "yaml", Code: "key: value"}},
+		},
+		{
+			name:   "backtick fence inside tilde fence",
+			output: "~~~markdown\n```hcl\nresource {}\n```\n~~~",
+			want:   []CodeBlock{{Language: "markdown", Code: "```hcl\nresource {}\n```"}},
+		},
+		{
+			name:   "shorter fence inside longer fence",
+			output: "````md\n```\ninner\n```\n````",
+			want:   []CodeBlock{{Language: "md", Code: "```\ninner\n```"}},
+		},
+		{
+			name:   "unterminated fence",
+			output: "Here:\n```hcl\nresource {}\n\n",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+		{
+			name:   "indented fence",
+			output: "   ```hcl\nresource {}\n   ```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+		{
+			name:   "fence indented too deeply",
+			output: "    ```hcl\n    resource {}\n    ```",
+			want:   nil,
+		},
+		{
+			name:   "inline code",
+			output: "Use ```terraform apply``` to apply.",
+			want:   nil,
+		},
+		{
+			name:   "windows line endings",
+			output: "```hcl\r\nresource {}\r\n```\r\n",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+		{
+			name:   "filename attribute",
+			output: "```hcl title=\"main.tf\"\nresource {}\n```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}", Filename: "main.tf"}},
+		},
+		{
+			name:   "filename attribute in braces",
+			output: "```{hcl filename=modules/vpc/main.tf}\nresource {}\n```",
+			want: []CodeBlock{
+				{Language: "hcl", Code: "resource {}", Filename: "modules/vpc/main.tf"},
+			},
+		},
+		{
+			name:   "filename after language",
+			output: "```hcl:variables.tf\nvariable \"name\" {}\n```",
+			want: []CodeBlock{
+				{Language: "hcl", Code: "variable \"name\" {}", Filename: "variables.tf"},
+			},
+		},
+		{
+			name:   "filename instead of language",
+			output: "```outputs.tf\noutput \"id\" {}\n```",
+			want:   []CodeBlock{{Code: "output \"id\" {}", Filename: "outputs.tf"}},
+		},
+		{
+			name:   "well-known filename as language",
+			output: "```Dockerfile\nFROM alpine\n```",
+			want:   []CodeBlock{{Language: "dockerfile", Code: "FROM alpine"}},
+		},
+		{
+			name:   "unsafe filename attribute",
+			output: "```hcl title=\"../main.tf\"\nresource {}\n```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+		{
+			name:   "filename in heading",
+			output: "### `main.tf`\n\n```hcl\nresource {}\n```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}", Filename: "main.tf"}},
+		},
+		{
+			name:   "filename in bold",
+			output: "**File: main.tf**\n```hcl\nresource {}\n```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}", Filename: "main.tf"}},
+		},
+		{
+			name:   "prose is not a filename",
+			output: "Save this as main.tf:\n```hcl\nresource {}\n```",
+			want:   []CodeBlock{{Language: "hcl", Code: "resource {}"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindCodeBlocks(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %#v, got %#v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestExtractCodeBlock(t *testing.T) {
+	tests := []struct {
+		name         string
+		output       string
+		wantCode     string
+		wantLanguage string
+		wantOK       bool
+	}{
+		{
+			name:   "no fences",
+			output: "resource \"aws_s3_bucket\" \"main\" {}",
+			wantOK: false,
+		},
+		{
+			name:   "empty block",
+			output: "```hcl\n\n```",
+			wantOK: false,
+		},
+		{
+			name:         "prose before and after",
+			output:       "Here is the code:\n\n```hcl\nresource {}\n```\n\nApply it with terraform.",
+			wantCode:     "resource {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+		{
+			name: "largest block",
+			output: "```json\n{}\n```\n" +
+				"```hcl\nresource \"aws_s3_bucket\" \"main\" {}\n```\n" +
+				"```yaml\nkey: value\n```",
+			wantCode:     "resource \"aws_s3_bucket\" \"main\" {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+		{
+			name: "blocks of the same language",
+			output: "```hcl\nvariable \"name\" {}\n```\n" +
+				"```json\n{}\n```\n" +
+				"```hcl\nresource \"aws_s3_bucket\" \"main\" {}\n```",
+			wantCode:     "variable \"name\" {}\n\nresource \"aws_s3_bucket\" \"main\" {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+		{
+			name: "blocks without a language",
+			output: "```\nshort\n```\n" +
+				"```\nthe longest block\n```",
+			wantCode: "the longest block",
+			wantOK:   true,
+		},
+		{
+			name: "larger shell block",
+			output: "```bash\nterraform init && terraform plan && terraform apply\n```\n" +
+				"```hcl\nresource {}\n```",
+			wantCode:     "resource {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+		{
+			name:         "only shell blocks",
+			output:       "```sh\nterraform init\n```\n```bash\nterraform apply -auto-approve\n```",
+			wantCode:     "terraform apply -auto-approve",
+			wantLanguage: "bash",
+			wantOK:       true,
+		},
+		{
+			name:         "tilde fence",
+			output:       "Here:\n~~~yaml\nkey: value\n~~~\nDone.",
+			wantCode:     "key: value",
+			wantLanguage: "yaml",
+			wantOK:       true,
+		},
+		{
+			name:         "unterminated fence",
+			output:       "```hcl\nresource {}\n",
+			wantCode:     "resource {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+		{
+			name:         "filename in info string",
+			output:       "```hcl title=\"main.tf\"\nresource {}\n```",
+			wantCode:     "resource {}",
+			wantLanguage: "hcl",
+			wantOK:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, language, ok := ExtractCodeBlock(tt.output)
+			if ok != tt.wantOK {
+				t.Fatalf("expected ok %t, got %t", tt.wantOK, ok)
+			}
+
+			if code != tt.wantCode {
+				t.Errorf("expected code %q, got %q", tt.wantCode, code)
+			}
+
+			if language != tt.wantLanguage {
+				t.Errorf("expected language %q, got %q", tt.wantLanguage, language)
+			}
+
+			// ExtractCode is the same without the language
+			code, ok = ExtractCode(tt.output)
+			if code != tt.wantCode || ok != tt.wantOK {
+				t.Errorf("expected ExtractCode to return %q, %t, got %q, %t", tt.wantCode, tt.wantOK, code, ok)
+			}
+		})
+	}
+}
+
+func TestSelectCode(t *testing.T) {
+	tests := []struct {
+		name         string
+		blocks       []CodeBlock
+		wantCode     string
+		wantLanguage string
+		wantOK       bool
+	}{
+		{
+			name:   "no blocks",
+			wantOK: false,
+		},
+		{
+			name:   "whitespace only",
+			blocks: []CodeBlock{{Language: "hcl", Code: " \n\t"}},
+			wantOK: false,
+		},
+		{
+			name: "first of equally large blocks",
+			blocks: []CodeBlock{
+				{Language: "json", Code: "abc"},
+				{Language: "yaml", Code: "def"},
+			},
+			wantCode:     "abc",
+			wantLanguage: "json",
+			wantOK:       true,
+		},
+		{
+			name: "blocks without a language are not concatenated",
+			blocks: []CodeBlock{
+				{Code: "a"},
+				{Code: "longest"},
+			},
+			wantCode: "longest",
+			wantOK:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, language, ok := selectCode(tt.blocks)
+			if code != tt.wantCode || language != tt.wantLanguage || ok != tt.wantOK {
+				t.Errorf(
+					"expected %q, %q, %t, got %q, %q, %t",
+					tt.wantCode, tt.wantLanguage, tt.wantOK, code, language, ok,
+				)
+			}
+		})
+	}
+}
+
+func TestSplitFilesWithoutFences(t *testing.T) {
+	output := "resource \"aws_s3_bucket\" \"main\" {}"
+
+	// Output without code blocks is taken to be code in its entirety
+	want := []File{{Name: "main.tf", Code: output}}
+	if got := SplitFiles(output, "main.tf"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %#v, got %#v", want, got)
+	}
+}
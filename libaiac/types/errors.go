@@ -1,20 +1,37 @@
 package types
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
 
 var (
 	// ErrNoSuchBackend is returned when the user provides a backend name that
 	// does not exist in the configuration.
 	ErrNoSuchBackend = errors.New("no such backend")
 
+	// ErrAmbiguousBackend is returned when the user selects a backend by
+	// type, and multiple backends of that type exist in the configuration.
+	ErrAmbiguousBackend = errors.New("multiple backends match")
+
 	// ErrNoDefaultBackend is returned when the user does not select a backend,
 	// and the configuration file does not define a default backend.
 	ErrNoDefaultBackend = errors.New("backend not selected and no default configured")
 
+	// ErrNoRacers is returned when a race between backends is started
+	// without any backend.
+	ErrNoRacers = errors.New("no backends to race")
+
 	// ErrNoDefaultModel is returned when the user does not select a model, and
 	// the configuration file does not defined a default model.
 	ErrNoDefaultModel = errors.New("model not selected and no default configured")
 
+	// ErrModelNotFound is returned when the model selected does not exist, or
+	// is not available to the account or region used.
+	ErrModelNotFound = errors.New("model not available")
+
 	// ErrNoResults is returned if the LLM provider API returned an empty
 	// result. This should not generally happen.
 	ErrNoResults = errors.New("no results returned from API")
@@ -26,4 +43,271 @@ var (
 	// ErrRequestFailed is returned when the LLM provider API returned an error
 	// for the request.
 	ErrRequestFailed = errors.New("request failed")
+
+	// ErrMissingAPIKey is returned when a backend that requires an API key is
+	// configured without one.
+	ErrMissingAPIKey = errors.New("missing API key")
+
+	// ErrUnknownBackendType is returned when a backend is configured with a
+	// type that is not supported.
+	ErrUnknownBackendType = errors.New("unknown backend type")
+
+	// ErrInvalidBackendConfig is returned when a backend's configuration is
+	// invalid or ambiguous.
+	ErrInvalidBackendConfig = errors.New("invalid backend configuration")
+
+	// ErrConfigConflict is returned when multiple configuration files are
+	// merged and contain settings that cannot be reconciled.
+	ErrConfigConflict = errors.New("conflicting configuration")
+
+	// ErrUnsupported is returned when an operation is not supported by the
+	// selected backend.
+	ErrUnsupported = errors.New("operation not supported by backend")
+
+	// ErrRequiredEnvVar is returned when the configuration references a
+	// required environment variable (via the ${VAR:?message} syntax) that is
+	// not set.
+	ErrRequiredEnvVar = errors.New("required environment variable not set")
+
+	// ErrSecretNotFound is returned when the configuration references a
+	// keyring entry, or a Vault secret or field, that does not exist.
+	ErrSecretNotFound = errors.New("secret not found")
+
+	// ErrSecretAccessDenied is returned when the configuration references a
+	// Vault secret that the token used is not allowed to read.
+	ErrSecretAccessDenied = errors.New("permission denied reading secret")
+
+	// ErrInvalidSecretRef is returned when the configuration contains a
+	// malformed reference to a secret.
+	ErrInvalidSecretRef = errors.New("invalid secret reference")
+
+	// ErrInvalidEnvVar is returned when the configuration contains a malformed
+	// environment variable reference.
+	ErrInvalidEnvVar = errors.New("invalid environment variable reference")
+
+	// ErrInvalidSession is returned when a session file cannot be parsed.
+	ErrInvalidSession = errors.New("invalid session file")
+
+	// ErrUnsupportedSessionVersion is returned when a session file was
+	// written with a schema version newer than the one supported.
+	ErrUnsupportedSessionVersion = errors.New("unsupported session version")
+
+	// ErrValidatorNotFound is returned when the program used to validate
+	// generated code is not installed.
+	ErrValidatorNotFound = errors.New("validator not found")
+
+	// ErrPostProcessFailed is returned when a command that generated code is
+	// piped through fails.
+	ErrPostProcessFailed = errors.New("post-processing failed")
+
+	// ErrContextWindowExceeded is returned when a prompt, together with the
+	// maximum number of tokens to generate, does not fit in the context
+	// window of the model it is sent to.
+	ErrContextWindowExceeded = errors.New("prompt exceeds the model's context window")
+
+	// ErrBudgetExceeded is returned when the estimated cost of a prompt
+	// exceeds the maximum cost of a request, or would exceed the monthly
+	// budget.
+	ErrBudgetExceeded = errors.New("cost budget exceeded")
+
+	// ErrNoSuchTemplate is returned when a prompt template is neither
+	// defined in the configuration nor found in a template file.
+	ErrNoSuchTemplate = errors.New("no such template")
+
+	// ErrNoSuchPreset is returned when an instruction preset is neither
+	// defined in the configuration nor built in.
+	ErrNoSuchPreset = errors.New("no such preset")
+
+	// ErrNoSuchProfile is returned when the configuration profile selected
+	// is not defined in the configuration.
+	ErrNoSuchProfile = errors.New("no such profile")
+
+	// ErrMissingTemplateVar is returned when a prompt template references
+	// variables that were not provided and have no defaults.
+	ErrMissingTemplateVar = errors.New("missing template variables")
+
+	// ErrNoSuchHistoryEntry is returned when an entry of the history is
+	// requested by an ID that does not exist.
+	ErrNoSuchHistoryEntry = errors.New("no such history entry")
+
+	// ErrEmptyPrompt is returned when a completion is requested without a
+	// prompt.
+	ErrEmptyPrompt = errors.New("prompt is empty")
+
+	// ErrEmptyResponse is returned when the model returns a response without
+	// any code, or only whitespace.
+	ErrEmptyResponse = errors.New("model returned an empty response")
+
+	// ErrInvalidJSON is returned when responses are constrained to JSON (see
+	// Parameters.Format), and the model returns code that is not valid JSON.
+	ErrInvalidJSON = errors.New("model returned invalid JSON")
+
+	// ErrInvalidSchema is returned when the JSON Schema responses must conform
+	// to (see Parameters.Schema) is not valid JSON, or not a valid schema.
+	ErrInvalidSchema = errors.New("invalid JSON schema")
+
+	// ErrSchemaViolation is returned when responses must conform to a JSON
+	// Schema (see Parameters.Schema), and the model returns JSON that does not.
+	ErrSchemaViolation = errors.New("model returned JSON not conforming to the schema")
+
+	// ErrInvalidHCL is returned when code merged as HCL, or the code it is
+	// merged into, cannot be parsed.
+	ErrInvalidHCL = errors.New("invalid HCL")
+
+	// ErrMergeConflict is returned when generated HCL is merged into existing
+	// HCL defining some of the same blocks differently, and no side is
+	// preferred.
+	ErrMergeConflict = errors.New("conflicting blocks")
+
+	// ErrInvalidGuardrail is returned when the guardrails configuration
+	// references checks that do not exist, or rules with invalid patterns.
+	ErrInvalidGuardrail = errors.New("invalid guardrail")
+
+	// ErrInvalidImage is returned when an image attached to a prompt is not
+	// in a supported format, or exceeds the size limit of the provider.
+	ErrInvalidImage = errors.New("invalid image")
+
+	// ErrNoCommentSyntax is returned when a header is to be added to a file
+	// whose language does not support comments, or is not known.
+	ErrNoCommentSyntax = errors.New("file format does not support comments")
+)
+
+// ErrTransient is wrapped by errors caused by failures that may not recur if
+// the request is retried, such as rate limiting or server errors. Use
+// errors.Is to check for it.
+var ErrTransient = errors.New("transient failure")
+
+// transientError marks an error as transient without changing its message.
+type transientError struct {
+	error
+}
+
+// Unwrap returns both the original error and ErrTransient.
+func (err transientError) Unwrap() []error {
+	return []error{err.error, ErrTransient}
+}
+
+// Transient marks err as transient if httpStatus is a status code returned
+// for failures that may not recur: 429, 500, 502, 503, 504, or 529 (used by
+// some providers when overloaded). Otherwise, err is returned as-is.
+func Transient(httpStatus int, err error) error {
+	if !TransientStatus(httpStatus) {
+		return err
+	}
+
+	return transientError{err}
+}
+
+// TransientStatus returns whether httpStatus is a status code returned for
+// failures that may not recur if the request is retried.
+func TransientStatus(httpStatus int) bool {
+	switch httpStatus {
+	case 429, 500, 502, 503, 504, 529: //nolint: gomnd
+		return true
+	default:
+		return false
+	}
+}
+
+var (
+	// ErrAuthentication is wrapped by errors caused by the provider rejecting
+	// the credentials of a request, such as a missing, invalid or revoked API
+	// key, or one without access to the resource requested.
+	ErrAuthentication = errors.New("authentication failed")
+
+	// ErrQuotaExceeded is wrapped by errors caused by the account's quota or
+	// credit with the provider being exhausted.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrRateLimited is wrapped by errors caused by sending requests faster
+	// than the provider allows.
+	ErrRateLimited = errors.New("rate limited")
 )
+
+// APIError is an error response of an LLM provider's API, parsed from its
+// structured body. Backends return errors wrapping it, which can be retrieved
+// with errors.As. It wraps ErrRequestFailed, or ErrUnexpectedStatus if the
+// body could not be parsed, and ErrTransient, ErrAuthentication,
+// ErrQuotaExceeded or ErrRateLimited depending on the failure, so it can also
+// be checked with errors.Is.
+type APIError struct {
+	// Provider is the name of the provider that returned the error (e.g.
+	// "OpenAI").
+	Provider string
+
+	// StatusCode is the HTTP status code of the response, or zero for errors
+	// reported in streamed responses.
+	StatusCode int
+
+	// Code is the provider's code or type of the error (e.g.
+	// "insufficient_quota"), if it returns one.
+	Code string
+
+	// Message is the provider's description of the error. It is empty if the
+	// body of the response could not be parsed.
+	Message string
+
+	// Err is the underlying error, for providers whose errors are returned
+	// by an SDK (e.g. Amazon Bedrock).
+	Err error
+}
+
+// Error returns the error as a string, including the provider's code and
+// message, if any.
+func (err *APIError) Error() string {
+	switch {
+	case err.Message == "":
+		return fmt.Sprintf("%s %s", ErrUnexpectedStatus, http.StatusText(err.StatusCode))
+	case err.Code == "":
+		return fmt.Sprintf("%s: %s", ErrRequestFailed, err.Message)
+	default:
+		return fmt.Sprintf("%s: [%s]: %s", ErrRequestFailed, err.Code, err.Message)
+	}
+}
+
+// Unwrap returns the errors the error wraps, depending on its status code,
+// code and message (see APIError).
+func (err *APIError) Unwrap() []error {
+	errs := []error{ErrRequestFailed}
+	if err.Message == "" {
+		errs = []error{ErrUnexpectedStatus}
+	}
+
+	if TransientStatus(err.StatusCode) {
+		errs = append(errs, ErrTransient)
+	}
+
+	switch {
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		errs = append(errs, ErrAuthentication)
+	case err.quota():
+		errs = append(errs, ErrQuotaExceeded)
+	case err.StatusCode == http.StatusTooManyRequests:
+		errs = append(errs, ErrRateLimited)
+	}
+
+	if err.Err != nil {
+		errs = append(errs, err.Err)
+	}
+
+	return errs
+}
+
+// quota returns whether the error was caused by an exhausted quota or credit.
+// Providers report these differently: OpenAI with an "insufficient_quota"
+// code, Anthropic with a message about the credit balance, Gemini with a
+// "RESOURCE_EXHAUSTED" status, and DeepSeek with a 402 status code.
+func (err *APIError) quota() bool {
+	if err.StatusCode == http.StatusPaymentRequired {
+		return true
+	}
+
+	text := strings.ToLower(err.Code + " " + err.Message)
+	for _, word := range []string{"quota", "billing", "credit", "balance", "resource_exhausted"} {
+		if strings.Contains(text, word) {
+			return true
+		}
+	}
+
+	return false
+}
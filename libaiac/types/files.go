@@ -0,0 +1,124 @@
+package types
+
+import (
+	"regexp"
+	"strings"
+)
+
+// File is a file of generated code found in the output of a model (see
+// SplitFiles).
+type File struct {
+	// Name is the relative path of the file.
+	Name string
+
+	// Language is the language hint of the code block the file was found in,
+	// if any.
+	Language string
+
+	// Code is the contents of the file.
+	Code string
+}
+
+// fileComment matches comment lines naming the file that the following code
+// belongs to, e.g. "# file: variables.tf" or "// main.tf". The "file:" prefix
+// is optional for the first line of a block only.
+var fileComment = regexp.MustCompile(
+	`(?i)^\s*(?:#|//|--|;|/\*|<!--)\s*(file(?:name)?\s*:\s*)?(\S+?)\s*(?:\*/|-->)?\s*$`,
+)
+
+// SplitFiles splits the output of a model that generated several files (for
+// example, a complete Terraform module with "main.tf", "variables.tf" and
+// "outputs.tf") into separate files. Code blocks are attributed to files
+// named in their info string or in the line preceding them (see
+// CodeBlock.Filename), or by a comment naming the file, such as
+// "# file: variables.tf". A single block may hold several files separated by
+// such comments, which are not included in the files themselves. Code that is
+// not attributed to any file is selected as described by ExtractCodeBlock
+// (except that shell commands are dropped if other files were found), and
+// returned last as a file with the provided fallback name. Code attributed to
+// the same file more than once is concatenated. If the output contains no
+// code blocks, it is returned whole as the fallback file.
+func SplitFiles(output, fallback string) (files []File) {
+	blocks := FindCodeBlocks(output)
+	if len(blocks) == 0 {
+		return []File{{Name: fallback, Code: strings.TrimSpace(output)}}
+	}
+
+	index := make(map[string]int)
+	add := func(name, language, code string) {
+		code = strings.Trim(code, "\n")
+		if strings.TrimSpace(code) == "" {
+			return
+		}
+
+		if i, ok := index[name]; ok {
+			files[i].Code += "\n\n" + code
+			return
+		}
+
+		index[name] = len(files)
+		files = append(files, File{Name: name, Language: language, Code: code})
+	}
+
+	var unnamed []CodeBlock
+	for _, block := range blocks {
+		for _, part := range splitBlock(block) {
+			if part.Filename == "" {
+				unnamed = append(unnamed, part)
+			} else {
+				add(part.Filename, part.Language, part.Code)
+			}
+		}
+	}
+
+	// Unattributed shell commands accompanying named files are most likely
+	// instructions for using them (e.g. "terraform init")
+	if len(files) > 0 {
+		var code []CodeBlock
+		for _, block := range unnamed {
+			if !shellLanguages[block.Language] {
+				code = append(code, block)
+			}
+		}
+		unnamed = code
+	}
+
+	if code, language, ok := selectCode(unnamed); ok {
+		add(fallback, language, code)
+	}
+
+	return files
+}
+
+// splitBlock splits a code block into parts at comments naming files (see
+// fileComment). The part preceding the first such comment keeps the block's
+// filename, if any.
+func splitBlock(block CodeBlock) (parts []CodeBlock) {
+	current := CodeBlock{Language: block.Language, Filename: block.Filename}
+	var body []string
+	first := true
+
+	for _, line := range strings.Split(block.Code, "\n") {
+		m := fileComment.FindStringSubmatch(line)
+		if m != nil && (m[1] != "" || first) && IsFilename(m[2]) {
+			current.Code = strings.Join(body, "\n")
+			if strings.TrimSpace(current.Code) != "" {
+				parts = append(parts, current)
+			}
+
+			current = CodeBlock{Language: block.Language, Filename: m[2]}
+			body = nil
+			first = false
+			continue
+		}
+
+		if strings.TrimSpace(line) != "" {
+			first = false
+		}
+
+		body = append(body, line)
+	}
+
+	current.Code = strings.Join(body, "\n")
+	return append(parts, current)
+}
@@ -2,13 +2,25 @@ package types
 
 import (
 	"context"
+	"io"
+	"math"
+	"time"
 )
 
+// NoTimeout is a timeout value that effectively disables timeouts in HTTP
+// clients. Backends must honor the deadline of the context provided to each
+// call rather than impose timeouts of their own, so clients that otherwise
+// default to a timeout should be configured with this value.
+const NoTimeout = time.Duration(math.MaxInt64)
+
 // Backend is an interface that must be implemented in order to support an LLM
-// provider.
+// provider. Deadlines and cancellation are controlled through the context
+// provided to its methods (and those of Conversation).
 type Backend interface {
-	// ListModels returns a list of all models supported by the backend.
-	ListModels(context.Context) ([]string, error)
+	// ListModels returns a list of all models supported by the backend. If the
+	// backend cannot list models, an error wrapping ErrUnsupported is
+	// returned.
+	ListModels(context.Context) ([]Model, error)
 
 	// Chat initiates a conversation with an LLM backend. The name of the model
 	// to use must be provided. Users can also supply zero or more "previous
@@ -23,6 +35,11 @@ type Conversation interface {
 	// Send sends a message to the model and returns the response.
 	Send(context.Context, string) (Response, error)
 
+	// Stream is the same as Send, but streams the response from the model,
+	// writing generated text to the provided writer as it arrives. The
+	// returned response contains the complete output, as with Send.
+	Stream(context.Context, string, io.Writer) (Response, error)
+
 	// Messages returns all the messages that have been exchanged between the
 	// user and the assistant up to this point.
 	Messages() []Message
@@ -33,4 +50,60 @@ type Conversation interface {
 	// take precedence over them. Not all providers may support this
 	// (specifically, bedrock doesn't).
 	AddHeader(string, string)
+
+	// SetParameters sets the generation parameters used for all subsequent
+	// messages sent in this conversation. Parameters not supported by the
+	// provider are ignored, with a warning included in responses.
+	SetParameters(Parameters)
+}
+
+// CandidateGenerator is an optional interface implemented by conversations
+// that can generate several alternative responses to the same prompt in a
+// single request, such as those of backends implementing the OpenAI API (via
+// the "n" parameter).
+type CandidateGenerator interface {
+	// SendCandidates is the same as Send, but returns n alternative responses
+	// to the prompt. Only the first is kept in the conversation's history.
+	// Token usage is reported for the request as a whole, in the first
+	// response. If the provider does not support generating multiple
+	// responses, an error wrapping ErrUnsupported is returned.
+	SendCandidates(ctx context.Context, prompt string, n int) ([]Response, error)
+}
+
+// ModelPuller is an optional interface implemented by backends that can
+// download models that are missing from the server, such as Ollama.
+type ModelPuller interface {
+	// PullModel downloads a model to the server, writing human readable
+	// progress to the provided writer.
+	PullModel(ctx context.Context, model string, w io.Writer) error
+}
+
+// IdentityProvider is an optional interface implemented by backends whose
+// provider can report who they are authenticated as, such as the
+// organization an OpenAI API key belongs to, or the AWS principal of Bedrock
+// credentials.
+type IdentityProvider interface {
+	// Identity returns the identity the backend is authenticated as. If the
+	// provider does not report it (e.g. a server that merely implements the
+	// provider's API), an error wrapping ErrUnsupported is returned.
+	Identity(ctx context.Context) (Identity, error)
+}
+
+// ImageAttacher is an optional interface implemented by conversations whose
+// provider accepts images in prompts, for vision-capable models.
+type ImageAttacher interface {
+	// AttachImages attaches images to the next prompt sent in the
+	// conversation, with which they are kept in its history (see
+	// Message.Images). If the provider or model does not accept images, an
+	// error wrapping ErrUnsupported is returned.
+	AttachImages(images ...Image) error
+}
+
+// StreamRestarter is an optional interface implemented by the writers that
+// streamed responses are written to (see Conversation.Stream), if they must
+// know when a response that failed midway is requested again from scratch,
+// such as to discard the output written so far.
+type StreamRestarter interface {
+	// RestartStream is called before the response is streamed again.
+	RestartStream()
 }
@@ -1,17 +1,77 @@
 package types
 
-import "regexp"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // Message represents a single message in an exchange between a user and an
 // AI model, either as part of a chat or a single completion request.
 type Message struct {
 	// Role is the type of the participant. The user is named "user" (in Amazon
-	// Bedrock, this is equivalent to the "Human" identifier). Anything else is
-	// considered the AI model.
+	// Bedrock, this is equivalent to the "Human" identifier). Messages with
+	// the "system" role hold the system prompt, which instructs the model how
+	// to behave; backends send them in whatever way the provider expects.
+	// Anything else is considered the AI model.
 	Role string `json:"role"`
 
 	// Content is the text content of the message.
 	Content string `json:"content"`
+
+	// Images are the images attached to the message, for vision-capable
+	// models (see ImageAttacher). Only messages of the user may have images.
+	Images []Image `json:"images,omitempty"`
+}
+
+// Image is an image attached to a message.
+type Image struct {
+	// MediaType is the MIME type of the image, e.g. "image/png".
+	MediaType string `json:"media_type"`
+
+	// Data is the content of the image. It is encoded as base64 in JSON.
+	Data []byte `json:"data"`
+}
+
+// Base64 returns the content of the image encoded as standard base64, as
+// providers expect it in requests.
+func (img Image) Base64() string {
+	return base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// DataURL returns the image as a data URL (e.g. "data:image/png;base64,...").
+func (img Image) DataURL() string {
+	return "data:" + img.MediaType + ";base64," + img.Base64()
+}
+
+// SplitSystem separates the system prompt from the provided messages, for
+// providers that accept it separately from the conversation. If there are
+// multiple system messages, their contents are joined by empty lines.
+func SplitSystem(msgs []Message) (system string, rest []Message) {
+	var prompts []string
+	for _, msg := range msgs {
+		if msg.Role == "system" {
+			prompts = append(prompts, msg.Content)
+		} else {
+			rest = append(rest, msg)
+		}
+	}
+
+	return strings.Join(prompts, "\n\n"), rest
+}
+
+// WithSystem returns a copy of the provided messages with the system prompt
+// replaced by the provided one. Any existing system messages are removed, and
+// the new system prompt, if not empty, is placed first.
+func WithSystem(msgs []Message, system string) []Message {
+	_, rest := SplitSystem(msgs)
+	if system == "" {
+		return rest
+	}
+
+	return append([]Message{{Role: "system", Content: system}}, rest...)
 }
 
 // Response is the struct returned from methods generating code via the OpenAI
@@ -27,6 +87,16 @@ type Response struct {
 	// FullOutput.
 	Code string
 
+	// Reasoning is the reasoning that preceded the output, for reasoning
+	// models that return it separately (e.g. DeepSeek's "deepseek-reasoner").
+	// It is not part of FullOutput or Code.
+	Reasoning string
+
+	// Citations are the URLs of the sources of the response, for models that
+	// search the web to ground their responses (e.g. Perplexity's). They are
+	// not part of FullOutput or Code.
+	Citations []string
+
 	// APIKeyUsed is the API key used when making the request.
 	APIKeyUsed string
 
@@ -34,22 +104,340 @@ type Response struct {
 	// the "usage.total_tokens" value returned from the API.
 	TokensUsed int64
 
+	// InputTokens is the number of tokens in the prompt, including previous
+	// messages in the conversation, as reported by the API.
+	InputTokens int64
+
+	// OutputTokens is the number of tokens generated, as reported by the API.
+	OutputTokens int64
+
+	// ReasoningTokens is the number of tokens of reasoning generated, for
+	// providers that report it. They are included in OutputTokens.
+	ReasoningTokens int64
+
+	// TokensEstimated is true if the API did not report token usage, and the
+	// token counts were estimated by libaiac instead.
+	TokensEstimated bool
+
 	// StopReason
 	StopReason string
+
+	// Provider is the name of the upstream provider that served the request,
+	// for backends that route requests between multiple providers, such as
+	// OpenRouter. It is empty for other backends.
+	Provider string
+
+	// SystemFingerprint identifies the configuration of the provider's
+	// servers that generated the response, for providers that return it
+	// (e.g. OpenAI's "system_fingerprint"). Responses to requests with the
+	// same seed may differ when it changes.
+	SystemFingerprint string
+
+	// Seed is the seed the response was generated with, if one was set and
+	// the provider supports it (see Parameters.Seed). It is only set by
+	// libaiac.
+	Seed *int64
+
+	// Warnings holds non-fatal issues encountered while preparing the
+	// request, such as generation parameters that the provider does not
+	// support and were therefore ignored.
+	Warnings []string
+
+	// Language is the language hint of the extracted code block (e.g.
+	// "hcl"), if any. It is only set by libaiac.
+	Language string
+
+	// Backend is the name of the backend that generated the response, which
+	// may be a fallback backend rather than the one selected. It is only set
+	// by libaiac.
+	Backend string
+
+	// Model is the name of the model that generated the response. It is only
+	// set by libaiac.
+	Model string
+
+	// Cached is true if the response was loaded from the response cache
+	// rather than generated by the provider.
+	Cached bool
+
+	// Race holds the outcome of the prompt in every backend it was sent to,
+	// for responses of backends that raced each other, in the order the
+	// backends were provided. It is only set by libaiac.
+	Race []RaceEntry
+}
+
+// RaceEntry is the outcome of a prompt sent to one of the backends of a race.
+type RaceEntry struct {
+	// Backend and Model are the names of the backend and model the prompt
+	// was sent to.
+	Backend string
+	Model   string
+
+	// Latency is the time from the start of the race until the backend
+	// responded, failed, or was canceled.
+	Latency time.Duration
+
+	// Won is true for the backend whose response was used.
+	Won bool
+
+	// Err is the error the backend failed with, if any. It wraps
+	// context.Canceled for backends canceled because another one won.
+	Err error
+}
+
+// Model represents a model supported by a backend. Only the ID is guaranteed
+// to be set, other fields depend on the information returned by the provider.
+type Model struct {
+	// ID is the identifier of the model, as it should be provided when
+	// starting a chat.
+	ID string `json:"id"`
+
+	// Name is a human-readable name of the model.
+	Name string `json:"name,omitempty"`
+
+	// Owner is the organization that owns or provides the model.
+	Owner string `json:"owner,omitempty"`
+
+	// ContextWindow is the maximum number of input tokens the model accepts.
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// Identity describes who a backend is authenticated as (see
+// IdentityProvider). Fields depend on the information returned by the
+// provider.
+type Identity struct {
+	// User is the user, API key or principal the backend is authenticated
+	// as, such as the ARN of an AWS IAM principal, or the label of an
+	// OpenRouter API key.
+	User string `json:"user,omitempty"`
+
+	// Organization is the organization or account the identity belongs to,
+	// such as an OpenAI organization or an AWS account ID.
+	Organization string `json:"organization,omitempty"`
+
+	// Project is the project the identity belongs to, such as an OpenAI
+	// project, if any.
+	Project string `json:"project,omitempty"`
+}
+
+// DefaultTemperature is the sampling temperature used when one is not
+// explicitly provided. A low temperature is used as generated code should be
+// as deterministic as possible.
+const DefaultTemperature = 0.2
+
+// FormatJSON is the value of Parameters.Format constraining responses to a
+// single JSON value.
+const FormatJSON = "json"
+
+// Efforts of reasoning accepted by Parameters.ReasoningEffort.
+const (
+	ReasoningLow    = "low"
+	ReasoningMedium = "medium"
+	ReasoningHigh   = "high"
+)
+
+// ReasoningEfforts lists the efforts of reasoning accepted by
+// Parameters.ReasoningEffort, from least to most.
+var ReasoningEfforts = []string{ReasoningLow, ReasoningMedium, ReasoningHigh}
+
+// ReasoningBudget returns the number of tokens models may think for with the
+// provided reasoning effort, for providers that control reasoning with a
+// budget of tokens rather than an effort (e.g. Anthropic and Gemini), or zero
+// if effort is not one of ReasoningEfforts.
+func ReasoningBudget(effort string) int {
+	switch effort {
+	case ReasoningLow:
+		return 1024 //nolint: gomnd
+	case ReasoningMedium:
+		return 8192 //nolint: gomnd
+	case ReasoningHigh:
+		return 24576 //nolint: gomnd
+	default:
+		return 0
+	}
+}
+
+// Parameters holds optional generation parameters for chat models. Fields
+// that are nil are not set, in which case the backend's defaults apply (for
+// temperature, this is DefaultTemperature). Backends translate these to their
+// provider's native parameter names.
+type Parameters struct {
+	// Temperature is the sampling temperature.
+	Temperature *float64 `json:"temperature,omitempty" toml:"temperature"`
+
+	// TopP is the nucleus sampling probability mass.
+	TopP *float64 `json:"top_p,omitempty" toml:"top_p"`
+
+	// MaxTokens is the maximum number of tokens to generate.
+	MaxTokens *int `json:"max_tokens,omitempty" toml:"max_tokens"`
+
+	// Stop holds sequences that stop generation when the model generates
+	// them. The sequences themselves are not included in responses.
+	Stop []string `json:"stop,omitempty" toml:"stop"`
+
+	// Format is the format responses must be in, if constrained: FormatJSON
+	// for a single JSON value. Providers that support it are instructed to
+	// only generate valid JSON; others are only asked to in the prompt.
+	Format string `json:"format,omitempty" toml:"format"`
+
+	// Schema is a JSON Schema responses must conform to, which implies
+	// FormatJSON. Providers that support it (see libaiac.NativeSchema) are
+	// constrained to generate conforming JSON; others are given the schema in
+	// the prompt. Either way, responses are validated against it.
+	Schema json.RawMessage `json:"schema,omitempty" toml:"-"`
+
+	// Seed is the seed of the provider's sampling, for reproducible
+	// generations. Providers that support it make a best effort to return
+	// the same response to the same request with the same seed, but do not
+	// guarantee it.
+	Seed *int64 `json:"seed,omitempty" toml:"seed"`
+
+	// ReasoningEffort is how much reasoning models think before responding,
+	// one of ReasoningEfforts, trading latency and cost for quality. It is
+	// translated to each provider's native control (e.g. OpenAI's
+	// "reasoning_effort", or a budget of thinking tokens for Anthropic and
+	// Gemini, see ReasoningBudget), and ignored for models that do not
+	// support it.
+	ReasoningEffort string `json:"reasoning_effort,omitempty" toml:"reasoning_effort"`
+
+	// Extra holds provider-specific parameters that aiac does not support
+	// directly (e.g. Ollama's "num_ctx"), by their native names. Backends
+	// pass them through as-is, alongside the other generation parameters.
+	Extra map[string]interface{} `json:"extra,omitempty" toml:"-"`
+}
+
+// UnmarshalTOML decodes parameters from a TOML table. Keys other than those
+// of the known parameters are stored in Extra, rather than ignored. Integer
+// values are accepted for the temperature and top_p, and a single string for
+// the stop sequences.
+func (params *Parameters) UnmarshalTOML(data interface{}) error {
+	table, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("parameters must be a table, got %T", data)
+	}
+
+	for key, val := range table {
+		switch key {
+		case "temperature", "top_p":
+			var num float64
+			switch v := val.(type) {
+			case float64:
+				num = v
+			case int64:
+				num = float64(v)
+			default:
+				return fmt.Errorf("parameter %s must be a number, got %T", key, val)
+			}
+
+			if key == "temperature" {
+				params.Temperature = &num
+			} else {
+				params.TopP = &num
+			}
+		case "max_tokens":
+			num, ok := val.(int64)
+			if !ok {
+				return fmt.Errorf("parameter %s must be an integer, got %T", key, val)
+			}
+
+			tokens := int(num)
+			params.MaxTokens = &tokens
+		case "stop":
+			switch v := val.(type) {
+			case string:
+				params.Stop = []string{v}
+			case []interface{}:
+				params.Stop = make([]string, 0, len(v))
+				for _, item := range v {
+					seq, ok := item.(string)
+					if !ok {
+						return fmt.Errorf("parameter %s must be a list of strings, got %T", key, item)
+					}
+					params.Stop = append(params.Stop, seq)
+				}
+			default:
+				return fmt.Errorf("parameter %s must be a list of strings, got %T", key, val)
+			}
+		case "format":
+			format, ok := val.(string)
+			if !ok {
+				return fmt.Errorf("parameter %s must be a string, got %T", key, val)
+			}
+
+			params.Format = format
+		case "seed":
+			seed, ok := val.(int64)
+			if !ok {
+				return fmt.Errorf("parameter %s must be an integer, got %T", key, val)
+			}
+
+			params.Seed = &seed
+		case "reasoning_effort":
+			effort, ok := val.(string)
+			if !ok {
+				return fmt.Errorf("parameter %s must be a string, got %T", key, val)
+			}
+
+			params.ReasoningEffort = effort
+		default:
+			if params.Extra == nil {
+				params.Extra = make(map[string]interface{})
+			}
+			params.Extra[key] = val
+		}
+	}
+
+	return nil
 }
 
-var codeRegex = regexp.MustCompile("(?ms)^```(?:[^\n]*)\n(.*?)\n```$")
+// TemperatureOrDefault returns the temperature parameter if set, or
+// DefaultTemperature otherwise.
+func (params Parameters) TemperatureOrDefault() float64 {
+	if params.Temperature == nil {
+		return DefaultTemperature
+	}
 
-// ExtractCode receives the full output string from the OpenAI API and attempts
-// to extract a code block from it. OpenAI code blocks are generally Markdown
-// blocks surrounded by the ``` string on both sides. If successful, the code
-// string will be returned together with a true value, otherwise an empty string
-// is returned together with a false value.
-func ExtractCode(output string) (string, bool) {
-	m := codeRegex.FindStringSubmatch(output)
-	if m == nil || m[1] == "" {
-		return "", false
+	return *params.Temperature
+}
+
+// Override returns a copy of the parameters, with every parameter that is set
+// in other replacing the corresponding one. Stop sequences are replaced as a
+// whole, and extra parameters are merged key by key.
+func (params Parameters) Override(other Parameters) Parameters {
+	if other.Temperature != nil {
+		params.Temperature = other.Temperature
+	}
+	if other.TopP != nil {
+		params.TopP = other.TopP
+	}
+	if other.MaxTokens != nil {
+		params.MaxTokens = other.MaxTokens
+	}
+	if len(other.Stop) > 0 {
+		params.Stop = other.Stop
+	}
+	if other.Format != "" {
+		params.Format = other.Format
+	}
+	if len(other.Schema) > 0 {
+		params.Schema = other.Schema
+	}
+	if other.Seed != nil {
+		params.Seed = other.Seed
+	}
+	if other.ReasoningEffort != "" {
+		params.ReasoningEffort = other.ReasoningEffort
+	}
+	if len(other.Extra) > 0 {
+		extra := make(map[string]interface{}, len(params.Extra)+len(other.Extra))
+		for key, val := range params.Extra {
+			extra[key] = val
+		}
+		for key, val := range other.Extra {
+			extra[key] = val
+		}
+		params.Extra = extra
 	}
 
-	return m[1], true
+	return params
 }
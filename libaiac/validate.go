@@ -0,0 +1,180 @@
+package libaiac
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// DefaultHCLValidator is the program used by HCLValidator by default.
+const DefaultHCLValidator = "terraform"
+
+// Validator checks generated code for problems, generally by running external
+// tools on it.
+type Validator interface {
+	// Supports returns whether the validator can validate code in the provided
+	// language (see types.Response.Language).
+	Supports(language string) bool
+
+	// Validate validates the provided code. It returns the code, possibly in a
+	// canonical format, and the problems found, if any. An error is only
+	// returned if validation could not be performed at all.
+	Validate(ctx context.Context, code string) (ValidationResult, error)
+}
+
+// ValidationResult is the result of validating generated code.
+type ValidationResult struct {
+	// Code is the validated code, canonically formatted if the validator
+	// supports formatting.
+	Code string
+
+	// Problems is the output of the validator describing the problems found
+	// in the code. It is empty if the code is valid.
+	Problems string
+
+	// Warnings holds non-fatal issues that prevented parts of the validation
+	// from being performed.
+	Warnings []string
+}
+
+// HCLValidator validates Terraform code by formatting it with "fmt" and
+// validating it with "validate", using the Terraform CLI or a compatible one,
+// such as OpenTofu's.
+type HCLValidator struct {
+	// Program is the name or path of the program to run. Defaults to
+	// DefaultHCLValidator.
+	Program string
+}
+
+// NewHCLValidator creates a new HCLValidator that runs the provided program,
+// e.g. "tofu". If program is empty, DefaultHCLValidator is used.
+func NewHCLValidator(program string) *HCLValidator {
+	if program == "" {
+		program = DefaultHCLValidator
+	}
+
+	return &HCLValidator{Program: program}
+}
+
+// Supports implements the Validator interface. Only HCL is supported.
+func (v *HCLValidator) Supports(language string) bool {
+	switch strings.ToLower(language) {
+	case "hcl", "terraform", "tf", "opentofu", "tofu":
+		return true
+	default:
+		return false
+	}
+}
+
+// Validate implements the Validator interface. The code is formatted first,
+// which also catches syntax errors. It is then validated in a temporary
+// directory, which requires initializing it without a state backend. If
+// initialization fails (for example, because providers cannot be downloaded),
+// a warning is returned instead of the problems. Returns an error wrapping
+// types.ErrValidatorNotFound if the program is not installed.
+func (v *HCLValidator) Validate(ctx context.Context, code string) (
+	res ValidationResult,
+	err error,
+) {
+	res.Code = code
+
+	program, err := exec.LookPath(v.Program)
+	if err != nil {
+		return res, fmt.Errorf("%w: %s", types.ErrValidatorNotFound, err)
+	}
+
+	formatted, problems, err := run(ctx, program, "", code, "fmt", "-no-color", "-")
+	if err != nil {
+		return res, err
+	}
+	if problems != "" {
+		res.Problems = problems
+		return res, nil
+	}
+
+	res.Code = strings.TrimRight(formatted, "\n")
+
+	dir, err := os.MkdirTemp("", "aiac-validate-")
+	if err != nil {
+		return res, fmt.Errorf("failed creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = os.WriteFile(filepath.Join(dir, "main.tf"), []byte(formatted), 0o600) //nolint: gomnd
+	if err != nil {
+		return res, fmt.Errorf("failed writing code to validate: %w", err)
+	}
+
+	_, problems, err = run(
+		ctx, program, dir, "",
+		"init", "-backend=false", "-input=false", "-no-color",
+	)
+	if err != nil {
+		return res, err
+	}
+	if problems != "" {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(
+			"skipped validation, %s init failed: %s",
+			filepath.Base(v.Program), firstLine(problems),
+		))
+		return res, nil
+	}
+
+	_, res.Problems, err = run(ctx, program, dir, "", "validate", "-no-color")
+	return res, err
+}
+
+// run runs a program with the provided arguments in the provided directory
+// (or the current one, if empty), feeding it the provided input. It returns
+// the program's standard output. If the program exits with a non-zero status,
+// its output is returned as problems instead. An error is only returned if
+// the program could not be run.
+func run(ctx context.Context, program, dir, input string, args ...string) (
+	output, problems string,
+	err error,
+) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return "", "", fmt.Errorf("failed running %s: %w", program, ctx.Err())
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		problems = strings.TrimSpace(stderr.String() + "\n" + stdout.String())
+		if problems == "" {
+			problems = exitErr.Error()
+		}
+		return "", problems, nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed running %s: %w", program, err)
+	}
+
+	return stdout.String(), "", nil
+}
+
+// firstLine returns the first non-empty line of the provided text.
+func firstLine(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			return line
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,202 @@
+package libaiac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// VaultPrefix is the prefix of configuration values that reference a secret
+// stored in HashiCorp Vault rather than containing it directly, e.g.
+// "vault:secret/data/aiac#openai_key". The part after the prefix is the path
+// of the secret, as used with the Vault HTTP API (for KV version 2 secrets
+// engines, including "data/" after the mount path), and the field holding
+// the value, separated by "#".
+const VaultPrefix = "vault:"
+
+const (
+	// EnvVaultAddr is the environment variable holding the address of the
+	// Vault server secrets are read from (e.g. "https://vault:8200").
+	EnvVaultAddr = "VAULT_ADDR"
+
+	// EnvVaultToken is the environment variable holding the token to read
+	// secrets from Vault with.
+	EnvVaultToken = "VAULT_TOKEN"
+
+	// EnvVaultNamespace is the environment variable holding the Vault
+	// Enterprise namespace to read secrets from, if any.
+	EnvVaultNamespace = "VAULT_NAMESPACE"
+
+	// EnvVaultCACert is the environment variable holding the path to a
+	// PEM-encoded CA certificate to verify the Vault server's certificate
+	// with, if it is signed by a private CA.
+	EnvVaultCACert = "VAULT_CACERT"
+)
+
+// vaultTimeout is the maximum amount of time reading a secret from Vault may
+// take.
+const vaultTimeout = 30 * time.Second
+
+// vaultClient reads secrets from the Vault server configured by the
+// environment, reading every path at most once.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	http      *http.Client
+	secrets   map[string]map[string]interface{}
+}
+
+// newVaultClient creates a client for the Vault server configured by the
+// EnvVaultAddr and EnvVaultToken environment variables, which must be set,
+// and optionally EnvVaultNamespace and EnvVaultCACert.
+func newVaultClient() (*vaultClient, error) {
+	for _, name := range []string{EnvVaultAddr, EnvVaultToken} {
+		if os.Getenv(name) == "" {
+			return nil, fmt.Errorf(
+				"%w: %s (required to read secrets from Vault)",
+				types.ErrRequiredEnvVar, name,
+			)
+		}
+	}
+
+	client, err := transport.NewClient(transport.Options{
+		CACertFile: os.Getenv(EnvVaultCACert),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed creating Vault client: %w", err)
+	}
+
+	client.Timeout = vaultTimeout
+
+	return &vaultClient{
+		addr:      strings.TrimSuffix(os.Getenv(EnvVaultAddr), "/"),
+		token:     os.Getenv(EnvVaultToken),
+		namespace: os.Getenv(EnvVaultNamespace),
+		http:      client,
+		secrets:   make(map[string]map[string]interface{}),
+	}, nil
+}
+
+// get returns the value of a field of the secret referenced by a value with
+// VaultPrefix. An error wrapping types.ErrInvalidSecretRef is returned if the
+// reference is malformed, types.ErrSecretNotFound if the secret or field do
+// not exist, and types.ErrSecretAccessDenied if the token is not allowed to
+// read the secret.
+func (vault *vaultClient) get(ctx context.Context, ref string) (string, error) {
+	path, field, ok := strings.Cut(strings.TrimPrefix(ref, VaultPrefix), "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf(
+			"%w: %q, expected %spath#field",
+			types.ErrInvalidSecretRef, ref, VaultPrefix,
+		)
+	}
+
+	secret, ok := vault.secrets[path]
+	if !ok {
+		var err error
+		secret, err = vault.read(ctx, path)
+		if err != nil {
+			return "", fmt.Errorf("vault secret %s: %w", path, err)
+		}
+
+		vault.secrets[path] = secret
+	}
+
+	val, ok := secret[field]
+	if !ok {
+		return "", fmt.Errorf(
+			"vault secret %s: field %q: %w",
+			path, field, types.ErrSecretNotFound,
+		)
+	}
+
+	str, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf(
+			"vault secret %s: field %q must be a string, got %T",
+			path, field, val,
+		)
+	}
+
+	return str, nil
+}
+
+// read reads the secret at path. The fields of secrets of KV version 2
+// secrets engines, which are nested under "data" with the secret's metadata,
+// are returned like those of version 1.
+func (vault *vaultClient) read(ctx context.Context, path string) (
+	map[string]interface{},
+	error,
+) {
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		vault.addr+"/v1/"+path,
+		nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", vault.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if vault.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vault.namespace)
+	}
+
+	res, err := vault.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed sending request: %w", err)
+	}
+
+	defer res.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading response: %w", err)
+	}
+
+	// Error responses may not be JSON, in which case they are reported by
+	// status only
+	_ = json.Unmarshal(data, &body)
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return nil, types.ErrSecretNotFound
+	case res.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf(
+			"%w (%s)",
+			types.ErrSecretAccessDenied, strings.Join(body.Errors, "; "),
+		)
+	case res.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf(
+			"%w: %s %s",
+			types.ErrUnexpectedStatus, res.Status, strings.Join(body.Errors, "; "),
+		)
+	case body.Data == nil:
+		return nil, fmt.Errorf("%w: response has no data", types.ErrUnexpectedStatus)
+	}
+
+	if nested, ok := body.Data["data"].(map[string]interface{}); ok {
+		if _, ok := body.Data["metadata"]; ok {
+			return nested, nil
+		}
+	}
+
+	return body.Data, nil
+}
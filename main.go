@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alecthomas/kong"
@@ -14,242 +23,1903 @@ import (
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
 	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/transport"
 	"github.com/gofireflyio/aiac/v5/libaiac/types"
 	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
 )
 
 type flags struct {
-	Config     string   `help:"Configuration file path" type:"path" short:"c"`
-	Backend    string   `help:"Backend to use" short:"b"`
-	OutputFile string   `help:"Output file to push resulting code to" optional:"" type:"path" short:"o"`         //nolint: lll
-	ReadmeFile string   `help:"Readme file to push entire Markdown output to" optional:"" type:"path" short:"r"` //nolint: lll
-	Quiet      bool     `help:"Non-interactive mode, print/save output and exit" default:"false" short:"q"`      //nolint: lll
-	Full       bool     `help:"Print full Markdown output to stdout" default:"false" short:"f"`                  //nolint: lll
-	Model      string   `help:"Model to use" short:"m"`
-	What       []string `arg:"" optional:"" help:"Which IaC template to generate"`
-	Clipboard  bool     `help:"Copy generated code to clipboard (in --quiet mode)"`
-	ListModels bool     `help:"List supported models and exit"`
-	Version    bool     `help:"Print aiac version and exit"`
+	Config      []string          `help:"Configuration file path, may be repeated to merge several files" type:"path" short:"c" sep:"none"`          //nolint: lll
+	StrictConf  bool              `help:"Treat configuration warnings (e.g. unknown keys) as errors" name:"strict-config"`                           //nolint: lll
+	NoConfig    bool              `help:"Do not load configuration files, configuring aiac from AIAC_* environment variables only" name:"no-config"` //nolint: lll
+	Profile     string            `help:"Configuration profile to apply, overriding the AIAC_PROFILE environment variable"`                          //nolint: lll
+	Backend     string            `help:"Backend to use" short:"b"`
+	Timeout     *time.Duration    `help:"Time limit (e.g. 5m) of non-interactive runs as a whole, and of every request, overriding the backends' timeout; interactive sessions are only bounded per request"` //nolint: lll
+	Fallback    []string          `help:"Backends to fall back to, in order, on transient failures"`
+	Race        []string          `help:"Backends to send the prompt to concurrently, using the first response"`
+	OutputFile  string            `help:"Output file to push resulting code to" optional:"" type:"path" short:"o" xor:"output"`              //nolint: lll
+	OutputDir   string            `help:"Directory to save every generated file to" type:"path" xor:"output"`                                //nolint: lll
+	Diff        string            `help:"Existing file to show the changes to, saving the code to it if confirmed" type:"path" xor:"output"` //nolint: lll
+	Yes         bool              `help:"Save the changes shown by --diff without asking" short:"y"`
+	Merge       string            `help:"Existing HCL file to merge the generated blocks into, keeping the rest of its content" type:"path" xor:"output"` //nolint: lll
+	Prefer      string            `help:"Side of blocks conflicting in --merge to keep (generated or existing)" enum:",generated,existing" default:""`    //nolint: lll
+	ReadmeFile  string            `help:"Readme file to push entire Markdown output to" optional:"" type:"path" short:"r"`                                //nolint: lll
+	Quiet       bool              `help:"Non-interactive mode, print/save output and exit" default:"false" short:"q"`                                     //nolint: lll
+	JSON        bool              `help:"Print the result as a JSON object (implies --quiet)" name:"json"`
+	Full        bool              `help:"Print full Markdown output to stdout" default:"false" short:"f"` //nolint: lll
+	Model       string            `help:"Model to use, overriding those of the session and the backend" short:"m"`
+	PromptFile  string            `help:"File to read more of the prompt from (- for standard input)"`
+	Template    string            `help:"Prompt template to render, from the configuration or template files"`
+	Preset      []string          `help:"Preset of instructions to append to the prompt, may be repeated" sep:"none"`                //nolint: lll
+	Var         map[string]string `help:"Variable of the prompt template, as key=value (may be repeated)" mapsep:"none"`             //nolint: lll
+	ContextFile []string          `help:"Existing file to provide to the model as context, may be repeated" sep:"none"`              //nolint: lll
+	ContextGlob []string          `help:"Glob pattern of existing files to provide as context, may be repeated" sep:"none"`          //nolint: lll
+	ContextMax  int               `help:"Maximum total size of context files, in bytes (0 for no limit)" default:"${context_limit}"` //nolint: lll
+	Image       []string          `help:"Image to attach to the prompt, for vision-capable models, may be repeated" sep:"none"`      //nolint: lll
+	System      string            `help:"System prompt to use, overriding the backend's configured one" xor:"system"`                //nolint: lll
+	SystemFile  string            `help:"File to read the system prompt from" type:"existingfile" xor:"system"`                      //nolint: lll
+	Temperature *float64          `help:"Sampling temperature (defaults to 0.2)"`
+	TopP        *float64          `help:"Nucleus sampling probability mass"`
+	MaxTokens   *int              `help:"Maximum number of tokens to generate"`
+	Stop        []string          `help:"Sequence to stop generating at, may be repeated" sep:"none"`
+	Format      string            `help:"Format of generated code (text or json, validated and enforced by supporting providers)" enum:"text,json" default:"text"` //nolint: lll
+	Schema      string            `help:"JSON Schema file generated JSON must conform to (implies --format json)" type:"existingfile"`                             //nolint: lll
+	Seed        *int64            `help:"Seed for reproducible generations, where the provider supports it"`
+	Reasoning   string            `help:"Reasoning effort of reasoning models (low, medium or high), ignored for other models" enum:",low,medium,high" default:""` //nolint: lll
+	Count       int               `help:"Number of candidate outputs to generate (implies --quiet)" short:"n" default:"1"`                                         //nolint: lll
+	Clipboard   bool              `help:"Copy generated code to clipboard (in --quiet mode)"`
+	Refine      string            `help:"Code file to revise according to the prompt" type:"existingfile"`
+	Explain     bool              `help:"Ask for an explanation of the key decisions made in the code, printed to standard error"`                   //nolint: lll
+	ExplainFile string            `help:"File to save the explanation to, rather than printing it (implies --explain)" type:"path"`                  //nolint: lll
+	Force       bool              `help:"Send prompts even if they seem to exceed the model's context window or the cost budget"`                    //nolint: lll
+	NoTruncate  bool              `help:"Fail rather than drop context files when the prompt exceeds the model's context window" name:"no-truncate"` //nolint: lll
+	MaxCost     *float64          `help:"Refuse to send prompts whose estimated cost exceeds this amount, in US dollars"`                            //nolint: lll
+	Pull        bool              `help:"Pull the model if it is missing from the Ollama server"`
+	RetryEmpty  int               `help:"Number of times to send the prompt again if the response contains no code" name:"retry-on-empty"` //nolint: lll
+	Resume      bool              `help:"Request streamed responses that stall again, from scratch" name:"resume-stream"`                  //nolint: lll
+	Validate    bool              `help:"Format and validate generated Terraform code"`
+	Validator   string            `help:"Program to validate Terraform code with (e.g. tofu)" default:"terraform"`
+	Guardrails  bool              `help:"Scan generated code for insecure patterns and secrets" xor:"guardrails"`                                        //nolint: lll
+	NoGuardrail bool              `help:"Do not scan generated code, even if enabled in the configuration" name:"no-guardrails" xor:"guardrails,strict"` //nolint: lll
+	Strict      bool              `help:"Refuse to save generated code with guardrail findings (implies --guardrails)" xor:"strict"`                     //nolint: lll
+	PostProcess []string          `help:"Command to pipe generated code through, may be repeated to form a pipeline" sep:"none"`                         //nolint: lll
+	Session     string            `help:"Session file to save the conversation to, resumed if it exists" type:"path"`                                    //nolint: lll
+	Cache       bool              `help:"Cache responses on disk and reuse them for identical requests"`
+	NoStream    bool              `help:"Wait for the complete response rather than printing it as it arrives"`
+	DryRun      bool              `help:"Print the request that would be sent, with secrets redacted, and exit"`
+	Record      string            `help:"Record HTTP requests and responses in a directory, with secrets redacted" type:"path" xor:"replay"`                       //nolint: lll
+	Replay      string            `help:"Serve HTTP responses recorded with --record from a directory, rather than send requests" type:"existingdir" xor:"replay"` //nolint: lll
+	ShowUsage   bool              `help:"Print token usage and estimated cost after every response"`
+	ShowReason  bool              `help:"Print the reasoning of reasoning models, if returned" name:"show-reasoning"`
+	ShowCites   bool              `help:"Print the sources of web-grounded responses, if returned" name:"show-citations"` //nolint: lll
+	Verbose     bool              `help:"Print details about every response, and log what aiac is doing" short:"v"`
+	Debug       bool              `help:"Log debugging information, including the metadata of HTTP requests"`
+	LogFormat   string            `help:"Format of log messages (text or json)" enum:"text,json" default:"text"`
+	OTel        bool              `help:"Export OpenTelemetry traces with OTLP (requires building with -tags otel)" name:"otel"`                                    //nolint: lll
+	WriteMode   string            `help:"How to write to existing files (error, overwrite, append or backup)" enum:"error,overwrite,append,backup" default:"error"` //nolint: lll
+	AutoOutput  bool              `help:"Save code to a file named after its detected type (e.g. main.tf), unless --output-file is set" short:"O"`                  //nolint: lll
+	Header      bool              `help:"Start saved code with a comment recording how it was generated"`
+	ListModels  bool              `help:"List supported models and exit (same as the models command)"`
+	ListBackend bool              `help:"List configured backends and exit (same as backends list)" name:"list-backends"`         //nolint: lll
+	NoColor     bool              `help:"Disable colored output (also disabled by NO_COLOR, and when not writing to a terminal)"` //nolint: lll
+	Version     bool              `help:"Print aiac version and exit"`
+
+	Get        getCmd        `cmd:"" default:"withargs" help:"Generate IaC code (default command)"`
+	Models     modelsCmd     `cmd:"" help:"List the models supported by a backend"`
+	Backends   backendsCmd   `cmd:"" help:"Inspect the configured backends"`
+	Batch      batchCmd      `cmd:"" help:"Generate code for every prompt of a JSONL or CSV file"`
+	VersionCmd struct{}      `cmd:"" name:"version" help:"Print build information and the configuration files aiac loads"` //nolint: lll
+	CacheCmd   cacheCmd      `cmd:"" name:"cache" help:"Manage the response cache"`
+	Budget     budgetCmd     `cmd:"" help:"Inspect and reset the spend tracked against the monthly budget"`
+	History    historyCmd    `cmd:"" help:"Inspect the history of prompts"`
+	ConfigCmd  configCmd     `cmd:"" name:"config" help:"Inspect and validate the configuration"`
+	Doctor     struct{}      `cmd:"" help:"Check the configuration, and that every backend can be reached"`
+	Whoami     struct{}      `cmd:"" help:"Print who a backend is authenticated as with its provider"`
+	Secret     secretCmd     `cmd:"" help:"Manage API keys stored in the system keyring"`
+	Completion completionCmd `cmd:"" help:"Print a shell completion script"`
+}
+
+type getCmd struct {
+	What []string `arg:"" optional:"" help:"Which IaC template to generate"`
+}
+
+type cacheCmd struct {
+	Clear struct{} `cmd:"" help:"Remove all cached responses"`
+}
+
+type secretCmd struct {
+	Set struct {
+		Backend string `arg:"" help:"Name of the backend the API key belongs to"`
+	} `cmd:"" help:"Store a backend's API key in the system keyring"`
+}
+
+type modelsCmd struct {
+	Output string `help:"Output format (text or json)" enum:"text,json" default:"text"`
+}
+
+// newParser returns the parser of aiac's command line, parsing into cli.
+func newParser(cli *flags) *kong.Kong {
+	return kong.Must(
+		cli,
+		kong.Name("aiac"),
+		kong.Description("Artificial Intelligence Infrastructure-as-Code Generator."),
+		kong.ConfigureHelp(kong.HelpOptions{
+			FlagsLast: true,
+		}),
+		kong.Vars{
+			"context_limit": strconv.Itoa(libaiac.DefaultContextLimit),
+		},
+	)
+}
+
+// commandHint returns a hint for a command line that failed to parse because
+// its prompt starts with the name of a command other than get (e.g. "aiac
+// config map for nginx"), or an empty string if it did not.
+func commandHint(err error) string {
+	var parseErr *kong.ParseError
+	if !errors.As(err, &parseErr) || parseErr.Context == nil {
+		return ""
+	}
+
+	cmd := parseErr.Context.Selected()
+	if cmd == nil {
+		return ""
+	}
+
+	for cmd.Parent != nil && cmd.Parent.Parent != nil {
+		cmd = cmd.Parent
+	}
+
+	if cmd.Name == "get" {
+		return ""
+	}
+
+	return fmt.Sprintf(
+		"%q is a command, to send a prompt starting with it use \"aiac get %s ...\"",
+		cmd.Name, cmd.Name,
+	)
+}
+
+func main() {
+	var cli flags
+	parser := newParser(&cli)
+
+	if completeMain(parser.Model) {
+		os.Exit(0)
+	}
+
+	ctx, err := parser.Parse(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		if hint := commandHint(err); hint != "" {
+			fmt.Fprintf(os.Stderr, "Hint: %s\n", hint)
+		}
+		os.Exit(1)
+	}
+
+	setupColors(cli)
+
+	if len(cli.Config) > 0 && configOptions(cli).NoFiles() {
+		fmt.Fprintf(os.Stderr, "%v\n", errNoConfigCombined)
+		os.Exit(1)
+	}
+
+	if cli.Version {
+		fmt.Fprintf(os.Stdout, "aiac version %s\n", libaiac.Version)
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "version" {
+		err := printVersion(cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if strings.HasPrefix(ctx.Command(), "history ") {
+		err := runHistoryCmd(ctx.Command(), cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if strings.HasPrefix(ctx.Command(), "config ") {
+		err := runConfigCmd(ctx.Command(), cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	// The doctor command loads the configuration itself, to report failures
+	// to load it like those of other checks
+	if ctx.Command() == "doctor" {
+		doctorCtx, cancel := withRunTimeout(context.Background(), cli)
+		err := runDoctor(doctorCtx, cli)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	if ctx.Command() == "completion <shell>" {
+		err := printCompletion(cli.Completion.Shell)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	// Secrets are managed before loading the configuration, as it may
+	// reference keyring entries that do not exist yet
+	if ctx.Command() == "secret set <backend>" {
+		err := setSecret(cli.Secret.Set.Backend)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed storing API key: %s\n", err)
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	traceCtx, endTracing, err := setupTracing(cli, ctx.Command())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed setting up tracing: %s\n", err)
+		os.Exit(1)
+	}
+
+	// The root span is ended, and remaining spans exported, before exiting
+	exit := func(code int) {
+		endTracing(code)
+		os.Exit(code)
+	}
+
+	aiac, err := libaiac.NewContextWithOptions(traceCtx, configOptions(cli), cli.Config...)
+	if err != nil {
+		if cli.JSON {
+			printJSONError(fmt.Errorf("failed loading aiac client: %w", err))
+			exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Failed loading aiac client: %s\n", err)
+		printErrorHint(err)
+		exit(1)
+	}
+
+	err = checkConfigWarnings(cli, aiac.Conf.Warnings)
+	if err != nil {
+		if cli.JSON {
+			printJSONError(err)
+			exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		printErrorHint(err)
+		exit(1)
+	}
+
+	aiac.DryRun = cli.DryRun
+	aiac.RecordDir = cli.Record
+	aiac.ReplayDir = cli.Replay
+	aiac.SkipContextCheck = cli.Force
+	aiac.SkipBudgetCheck = cli.Force
+	aiac.PullModels = cli.Pull
+	aiac.RetryOnEmpty = cli.RetryEmpty
+	aiac.ResumeStreams = cli.Resume
+	aiac.Logger = newLogger(cli)
+
+	if cli.Cache && aiac.Cache == nil {
+		aiac.Cache = libaiac.NewCache(aiac.Conf.Cache)
+	}
+
+	if len(cli.Fallback) > 0 {
+		aiac.Conf.Fallback = cli.Fallback
+	}
+
+	if cli.MaxCost != nil {
+		aiac.Conf.Budget.MaxCost = *cli.MaxCost
+	}
+
+	if cli.Header {
+		aiac.Conf.Output.Header = true
+	}
+
+	if cli.Timeout != nil {
+		for name, backendConf := range aiac.Conf.Backends {
+			backendConf.Timeout = cli.Timeout
+			aiac.Conf.Backends[name] = backendConf
+		}
+	}
+
+	// Backends may be selected by type, but sessions record their name
+	if name, err := aiac.ResolveBackend(cli.Backend); err == nil && cli.Backend != "" {
+		cli.Backend = name
+	}
+
+	switch {
+	case cli.NoGuardrail:
+		aiac.Conf.Guardrails.Enabled = false
+	case cli.Strict:
+		aiac.Conf.Guardrails.Enabled = true
+		aiac.Conf.Guardrails.Strict = true
+	case cli.Guardrails:
+		aiac.Conf.Guardrails.Enabled = true
+	}
+
+	if ctx.Command() == "cache clear" {
+		cache := aiac.Cache
+		if cache == nil {
+			cache = libaiac.NewCache(aiac.Conf.Cache)
+		}
+
+		err := cache.Clear()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed clearing cache: %s\n", err)
+			exit(1)
+		}
+
+		fmt.Fprintf(os.Stderr, "Cache cleared (%s)\n", cache.Dir())
+		exit(0)
+	}
+
+	if strings.HasPrefix(ctx.Command(), "budget ") {
+		err := runBudgetCmd(ctx.Command(), aiac, cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	if cli.ListBackend || ctx.Command() == "backends list" {
+		err := listBackends(aiac, cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed listing backends: %s\n", err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	// In-flight requests are canceled on SIGINT or SIGTERM. Once canceled, the
+	// default behavior is restored, so another signal terminates immediately.
+	runCtx, stop := signal.NotifyContext(traceCtx, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-runCtx.Done()
+		stop()
+	}()
+
+	// Batch runs are not interactive, and fail without a backend as before
+	if ctx.Command() != "batch" {
+		err := pickBackend(aiac, cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed selecting backend: %s\n", err)
+			exit(1)
+		}
+	}
+
+	// Runs other than interactive conversations are bounded as a whole by
+	// the timeout, including retries and fallbacks. Interactive
+	// conversations wait for the user between requests, so only their
+	// requests are bounded (by the backends' timeout, which it overrides).
+	generating := !cli.ListModels && ctx.Command() != "models" &&
+		ctx.Command() != "whoami" && ctx.Command() != "batch"
+	if !generating || cli.Quiet || cli.JSON || cli.Count > 1 {
+		var cancel context.CancelFunc
+		runCtx, cancel = withRunTimeout(runCtx, cli)
+		defer cancel()
+	}
+
+	if cli.ListModels || ctx.Command() == "models" {
+		err := printModels(runCtx, aiac, cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed listing models: %s\n", describeError(err))
+			printErrorHint(err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	if ctx.Command() == "whoami" {
+		err := runWhoami(runCtx, aiac, cli)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed getting identity: %s\n", describeError(err))
+			printErrorHint(err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	if ctx.Command() == "batch" {
+		err := runBatch(runCtx, aiac, cli)
+		if errors.Is(err, errInterrupted) {
+			fmt.Fprintln(os.Stderr, "Interrupted.")
+			exit(exitInterrupted)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			exit(1)
+		}
+
+		exit(0)
+	}
+
+	err = generateCode(runCtx, aiac, cli)
+	if cli.JSON && err != nil {
+		printJSONError(err)
+	}
+	if errors.Is(err, errInterrupted) {
+		fmt.Fprintln(os.Stderr, "Interrupted.")
+		exit(exitInterrupted)
+	}
+	if cli.JSON && err != nil {
+		exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", describeError(err))
+		printErrorHint(err)
+		exit(1)
+	}
+	exit(0)
+}
+
+// interrupted returns whether ctx was canceled because the user interrupted
+// aiac, rather than because its deadline was reached (see --timeout).
+func interrupted(ctx context.Context) bool {
+	return errors.Is(ctx.Err(), context.Canceled)
+}
+
+// printErrorHint prints a hint on how to resolve an error to standard error,
+// for errors that users can resolve from the command line.
+func printErrorHint(err error) {
+	switch {
+	case errors.Is(err, types.ErrContextWindowExceeded):
+		fmt.Fprintln(
+			os.Stderr,
+			"Shorten the prompt or choose a model with a larger context window, "+
+				"or provide the --force flag to send it anyway.",
+		)
+	case errors.Is(err, types.ErrNoDefaultBackend):
+		fmt.Fprintln(
+			os.Stderr,
+			"Select a backend with the --backend (-b) flag, or set "+
+				"default_backend in the configuration.",
+		)
+	case errors.Is(err, types.ErrBudgetExceeded):
+		fmt.Fprintln(
+			os.Stderr,
+			"Choose a cheaper model or lower --max-tokens, reset the monthly "+
+				"spend with \"aiac budget reset\", or provide the --force flag "+
+				"to send the prompt anyway.",
+		)
+	case errors.Is(err, types.ErrNoDefaultModel):
+		fmt.Fprintln(
+			os.Stderr,
+			"Select a model with the --model (-m) flag, or set default_model "+
+				"for the backend in the configuration.",
+		)
+	case errors.Is(err, errFileExists):
+		fmt.Fprintln(
+			os.Stderr,
+			"Choose another file, or select how to write to existing files "+
+				"with the --write-mode flag.",
+		)
+	case errors.Is(err, types.ErrEmptyResponse):
+		fmt.Fprintln(
+			os.Stderr,
+			"Rephrase the prompt, or provide the --retry-on-empty flag to send "+
+				"it again when the response contains no code.",
+		)
+	case errors.Is(err, transport.ErrStreamIdle):
+		fmt.Fprintln(
+			os.Stderr,
+			"The backend stopped sending the response; the --resume-stream flag "+
+				"requests stalled responses again, and the backend's stream_idle_timeout "+
+				"setting is how long to wait for data.",
+		)
+	case errors.Is(err, transport.ErrNoRecording):
+		fmt.Fprintln(
+			os.Stderr,
+			"The request differs from those recorded; record it again with the "+
+				"--record flag, using the same prompt, backend, model and parameters.",
+		)
+	case errors.Is(err, types.ErrInvalidJSON):
+		fmt.Fprintln(
+			os.Stderr,
+			"Try again, or describe the JSON to generate in more detail; the "+
+				"backend may not support constraining responses to JSON.",
+		)
+	case errors.Is(err, types.ErrMergeConflict):
+		fmt.Fprintln(
+			os.Stderr,
+			"Select which of the conflicting blocks to keep with the "+
+				"--prefer generated or --prefer existing flag.",
+		)
+	case errors.Is(err, types.ErrSchemaViolation):
+		fmt.Fprintln(
+			os.Stderr,
+			"Try again, or use a backend that constrains responses to the "+
+				"schema (such as Ollama) with the --backend flag.",
+		)
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintln(
+			os.Stderr,
+			"The deadline was reached before the backend responded; raise it "+
+				"with the --timeout flag, or the backend's timeout setting.",
+		)
+	case errors.Is(err, types.ErrAuthentication):
+		fmt.Fprintln(
+			os.Stderr,
+			"Check the backend's api_key in the configuration, or store a new "+
+				"one in the system keyring with \"aiac secret set\".",
+		)
+	case errors.Is(err, types.ErrQuotaExceeded):
+		fmt.Fprintln(
+			os.Stderr,
+			"The account's quota or credit with the provider is exhausted; "+
+				"check your plan and billing details.",
+		)
+	case errors.Is(err, types.ErrRateLimited):
+		fmt.Fprintln(
+			os.Stderr,
+			"The provider is rate limiting requests; try again later, or pace "+
+				"them with the backend's rate_limit setting.",
+		)
+	case errors.Is(err, types.ErrNoSuchProfile):
+		fmt.Fprintln(
+			os.Stderr,
+			"Define the profile in the profiles section of the configuration, "+
+				"or check the --profile flag and AIAC_PROFILE environment variable.",
+		)
+	case errors.Is(err, errConfigWarnings):
+		fmt.Fprintln(
+			os.Stderr,
+			"Fix the configuration (unknown keys are often misspelled settings), "+
+				"or omit the --strict-config flag to only warn about them.",
+		)
+	case errors.Is(err, errGuardrails):
+		fmt.Fprintln(
+			os.Stderr,
+			"Revise the code to fix the findings, adjust the checks in the "+
+				"guardrails section of the configuration, or provide the "+
+				"--no-guardrails flag to skip them.",
+		)
+	case errors.Is(err, types.ErrModelNotFound):
+		fmt.Fprintln(
+			os.Stderr,
+			"Check the name of the model, or for Ollama backends, provide the "+
+				"--pull flag to pull it.",
+		)
+	}
+}
+
+// describeError returns the message printed for an error. Errors returned by
+// a provider's API (see types.APIError) are summarized by the provider, the
+// error's code and its message, rather than by the chain of operations that
+// failed, unless several of them are combined (e.g. when every fallback
+// backend failed).
+func describeError(err error) string {
+	var apiErr *types.APIError
+	if !errors.As(err, &apiErr) || countAPIErrors(err) > 1 {
+		return err.Error()
+	}
+
+	code := apiErr.Code
+	if code == "" {
+		code = http.StatusText(apiErr.StatusCode)
+	}
+
+	summary := apiErr.Provider
+	if code != "" {
+		summary += ": " + code
+	}
+	if apiErr.Message != "" {
+		summary += " — " + apiErr.Message
+	}
+
+	return summary
+}
+
+// countAPIErrors returns the number of errors returned by providers' APIs that
+// err wraps.
+func countAPIErrors(err error) (count int) {
+	if _, ok := err.(*types.APIError); ok {
+		return 1
+	}
+
+	switch wrapped := err.(type) {
+	case interface{ Unwrap() error }:
+		return countAPIErrors(wrapped.Unwrap())
+	case interface{ Unwrap() []error }:
+		for _, err := range wrapped.Unwrap() {
+			count += countAPIErrors(err)
+		}
+	}
+
+	return count
+}
+
+// newLogger creates the logger for aiac's log messages, which are written to
+// standard error so that they do not mix with generated code. Returns nil if
+// neither the --verbose nor the --debug flags were provided, in which case
+// nothing is logged.
+func newLogger(cli flags) *slog.Logger {
+	var level slog.Level
+
+	switch {
+	case cli.Debug:
+		level = slog.LevelDebug
+	case cli.Verbose:
+		level = slog.LevelInfo
+	default:
+		return nil
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if cli.LogFormat == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, opts))
+}
+
+func printModels(ctx context.Context, aiac *libaiac.Aiac, cli flags) error {
+	models, err := aiac.ListModels(ctx, cli.Backend)
+	if err != nil {
+		if errors.Is(err, types.ErrUnsupported) {
+			return fmt.Errorf("backend does not support listing models: %w", err)
+		}
+		return err
+	}
+
+	if cli.Models.Output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(models)
+	}
+
+	for _, model := range models {
+		fmt.Println(model.ID)
+	}
+
+	return nil
+}
+
+var (
+	errInvalidInput  = errors.New("invalid input, please try again")
+	errMissingPrompt = errors.New("please describe what to generate")
+	errEmptySecret   = errors.New("API key must not be empty")
+
+	errDryRunUnsupported = errors.New("backend does not support dry runs")
+	errInterrupted       = errors.New("interrupted")
+	errInvalidCount      = errors.New("--count must be at least 1")
+	errJSONCount         = errors.New("--json cannot be combined with --count")
+	errDiffCombined      = errors.New("--diff cannot be combined with --json or --count")
+	errRaceCombined      = errors.New("--race cannot be combined with --count, --dry-run or --model")
+	errMergeCombined     = errors.New("--merge cannot be combined with --count")
+	errGuardrails        = errors.New("generated code failed guardrail checks")
+	errConfigWarnings    = errors.New("configuration has warnings")
+	errNoConfigCombined  = errors.New("--config cannot be combined with --no-config or " + libaiac.EnvNoConfig)
+)
+
+// exitInterrupted is the exit status when aiac is interrupted, following the
+// shell convention of 128 plus the signal number (SIGINT).
+const exitInterrupted = 130
+
+func setSecret(backendName string) (err error) {
+	var secret string
+
+	// Read the key from a masked prompt when running interactively, or from
+	// standard input otherwise (e.g. when piped from a password manager)
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		input := promptui.Prompt{
+			Label: fmt.Sprintf("API key for %s", backendName),
+			Mask:  '*',
+		}
+
+		secret, err = input.Run()
+		if err != nil {
+			return fmt.Errorf("prompt failed: %w", err)
+		}
+	} else {
+		secret, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("failed reading API key: %w", err)
+		}
+	}
+
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return errEmptySecret
+	}
+
+	err = libaiac.SetSecret(backendName, secret)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(
+		os.Stderr,
+		"API key stored. Reference it from the backend's configuration with:\n\n"+
+			"    api_key = %q\n",
+		libaiac.KeyringRef(backendName),
+	)
+
+	return nil
+}
+
+// generateCode runs a conversation with the selected backend. The provided
+// context is canceled when the user interrupts aiac, in which case the
+// in-flight request is canceled, the output received so far is printed, and
+// errInterrupted is returned. Request timeouts are configured per backend,
+// and may be overridden with the --timeout flag.
+func generateCode(ctx context.Context, aiac *libaiac.Aiac, cli flags) error { //nolint: funlen, cyclop
+	// Log messages would be garbled by the spinner, tools consuming JSON
+	// output have no use for it, and neither do files standard error is
+	// redirected to
+	var spinOut io.Writer = color.Error
+	if aiac.Logger != nil || cli.JSON || !isTerminal(os.Stderr) {
+		spinOut = io.Discard
+	}
+
+	spin := spinner.New(
+		spinner.CharSets[11],
+		100*time.Millisecond, //nolint: gomnd
+		spinner.WithWriter(spinOut),
+		spinner.WithSuffix("\tGenerating code ..."))
+
+	// The spinner would garble the progress of pulling models
+	aiac.PullProgress = &spinnerWriter{spin: spin, w: os.Stderr}
+
+	defer func() {
+		if spin.Active() {
+			spin.Stop()
+		}
+	}()
+
+	// If the prompt starts with the word "get" or "generate", remove it. This
+	// is here for backwards compatibility purposes, as previous versions used
+	// these words as command names (that weren't truly part of the prompt), so
+	// people may be used to adding them and we don't want them to actually be
+	// in the prompt. "get" is normally consumed as the command name.
+	what := cli.Get.What
+	if len(what) > 0 &&
+		(strings.ToLower(what[0]) == "get" ||
+			strings.ToLower(what[0]) == "generate") {
+		what = what[1:]
+	}
+
+	if cli.Count < 1 {
+		return errInvalidCount
+	}
+
+	if cli.JSON && cli.Count > 1 {
+		return errJSONCount
+	}
+
+	// Diffs are printed to standard output, and are of a single response
+	if cli.Diff != "" && (cli.JSON || cli.Count > 1) {
+		return errDiffCombined
+	}
+
+	// Merges are of a single response, into an HCL file
+	if cli.Merge != "" {
+		if cli.Count > 1 {
+			return errMergeCombined
+		}
+
+		err := checkMerge(cli.Merge)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Racing backends use their default models, with one response each
+	if len(cli.Race) > 0 && (cli.Count > 1 || cli.DryRun || cli.Model != "") {
+		return errRaceCombined
+	}
+
+	what, input, err := readPromptInput(cli.PromptFile, what)
+	if err != nil {
+		return err
+	}
+
+	if cli.Template != "" {
+		rendered, err := aiac.RenderTemplate(cli.Template, cli.Var)
+		if err != nil {
+			return err
+		}
+
+		// The rendered template is treated like input read from a file
+		input = joinNonEmpty(rendered, input)
+	}
+
+	request := strings.Join(what, " ")
+
+	// The kind of code requested selects the model, if the backend
+	// configures models by kind, and the prompt builder
+	kind := libaiac.DetectKind(joinNonEmpty(request, input))
+
+	sess, err := loadSession(aiac, cli, kind)
+	if err != nil {
+		return err
+	}
+
+	// A system prompt alone does not make a conversation to resume
+	_, history := types.SplitSystem(sess.Messages)
+
+	if cli.Refine != "" {
+		data, err := os.ReadFile(cli.Refine)
+		if err != nil {
+			return fmt.Errorf("failed reading code to refine: %w", err)
+		}
+
+		sess.Messages = append(
+			sess.Messages,
+			libaiac.RefineHistory(cli.Refine, string(data))...,
+		)
+	}
+
+	var prompt string
+
+	switch {
+	case cli.Refine != "" && (request != "" || input != ""):
+		prompt = libaiac.RefinePrompt(joinNonEmpty(request, input))
+	case cli.Refine != "" && !cli.Quiet:
+		prompt = libaiac.RefinePrompt(newMessage())
+	case request != "":
+		prompt, err = buildPrompt(aiac, sess, kind, request, cli.ReadmeFile != "" || cli.Full)
+		if err != nil {
+			return err
+		}
+
+		// Input read from files follows the instruction on the command line
+		prompt = joinNonEmpty(prompt, input)
+	case input != "":
+		prompt = input
+	case len(history) > 0 && !cli.Quiet:
+		// Resuming a session without a prompt continues the conversation
+		prompt = newMessage()
+	case isatty.IsTerminal(os.Stdin.Fd()):
+		prompt, err = editPrompt()
+		if err != nil {
+			return err
+		}
+	default:
+		return errMissingPrompt
+	}
+
+	// The kind of code requested is detected from the prompt when saving
+	// output, which is the command line prompt unless there is none
+	if request == "" {
+		request = prompt
+	}
+
+	prompt, err = aiac.ApplyPresets(prompt, cli.Preset...)
+	if err != nil {
+		return err
+	}
+
+	if explains(cli) {
+		prompt = libaiac.ExplainPrompt(prompt)
+	}
+
+	files, err := readContextFiles(cli)
+	if err != nil {
+		return err
+	}
+
+	var res types.Response
+
+	chat, err := startChat(ctx, aiac, cli, sess)
+	if err != nil {
+		return fmt.Errorf("failed starting chat: %w", err)
+	}
+
+	chat.SetParameters(sess.Parameters)
+
+	// Existing files precede the prompt, but are not part of the request,
+	// and only those that fit in the model's context window are provided
+	files, err = fitContextFiles(ctx, aiac, cli, chat, sess, files, prompt)
+	if err != nil {
+		return err
+	}
+
+	prompt = joinNonEmpty(libaiac.ContextPrompt(files), prompt)
+
+	err = attachImages(cli, chat)
+	if err != nil {
+		return err
+	}
+
+	if cli.DryRun {
+		return printDryRun(ctx, aiac, chat, sess, prompt, !cli.NoStream)
+	}
+
+	if cli.JSON {
+		return generateJSON(ctx, aiac, cli, chat, sess, prompt, request)
+	}
+
+	if cli.Count > 1 {
+		return generateCandidates(ctx, aiac, cli, chat, sess, prompt, request, spin)
+	}
+
+	// Warnings are generally the same for every response, so we only print
+	// each one once
+	warned := make(map[string]bool)
+
+	// problems found when validating the last response, if any
+	var problems string
+
+	// findings of guardrails in the last response, if any, and whether they
+	// prevent saving it
+	var findings []libaiac.Finding
+	var blocked error
+
+	// Responses are recorded in the history once it is known whether, and
+	// where, their code is saved, with the prompt as written by the user
+	asked := request
+	var pending *historyRecord
+	defer func() { recordHistory(aiac, pending) }()
+
+ATTEMPTS:
+	for {
+		recordHistory(aiac, pending)
+		pending = nil
+
+		spin.Start()
+
+		streamed := streams(cli)
+		switch {
+		case streamed && cli.OutputFile != "":
+			// The response is saved to a file, so rather than printing it
+			// as it arrives, the spinner reports its progress, and it is
+			// printed once complete
+			streamed = false
+
+			pw := newProgressWriter(spin)
+			res, err = chat.Stream(ctx, prompt, pw)
+			pw.Finish()
+		case streamed:
+			sw := newStreamWriter(os.Stdout, cli.Full, spin.Stop)
+			res, err = chat.Stream(ctx, prompt, sw)
+			switch {
+			case err == nil:
+				sw.Finish(res)
+			case ctx.Err() != nil:
+				spin.Stop()
+				sw.Flush()
+			case sw.started:
+				fmt.Fprintln(os.Stdout)
+			}
+		default:
+			res, err = chat.Send(ctx, prompt)
+		}
+
+		if err != nil && interrupted(ctx) {
+			return errInterrupted
+		}
+
+		options := [][2]string{
+			{"r", "retry same prompt"},
+			{"y", "copy to clipboard"},
+			{"q", "quit"},
+		}
+
+		if err != nil {
+			spin.Stop()
+			fmt.Fprintf(os.Stderr, "Failed generating code: %s\n", describeError(err))
+			printErrorHint(err)
+		} else {
+			spin.Stop()
+
+			if res.Cached {
+				fmt.Fprintf(os.Stderr, "Using cached response.\n")
+			}
+
+			switch {
+			case len(res.Race) > 0:
+				printRace(res)
+			case res.Backend != "" && res.Backend != sess.Backend:
+				fmt.Fprintf(os.Stderr, "Response generated by backend %s.\n", res.Backend)
+			}
+
+			if cli.Session != "" {
+				sess.Record(chat)
+				err = sess.Save(cli.Session)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Failed saving session: %s\n", err)
+				}
+			}
+
+			for _, warning := range res.Warnings {
+				if !warned[warning] {
+					fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+					warned[warning] = true
+				}
+			}
+
+			if cli.ShowReason && res.Reasoning != "" {
+				printReasoning(res.Reasoning)
+			}
+
+			problems = ""
+			if cli.Validate {
+				res, problems = validateCode(ctx, cli, res, request)
+			}
+
+			res, err = libaiac.PostProcess(ctx, res, cli.PostProcess...)
+			if err != nil {
+				if interrupted(ctx) {
+					return errInterrupted
+				}
+				return err
+			}
+
+			pending = &historyRecord{prompt: asked, res: res}
+
+			stdoutOutput := res.Code
+			if cli.Full {
+				stdoutOutput = res.FullOutput
+			}
+
+			if !streamed {
+				fmt.Fprintln(os.Stdout, stdoutOutput)
+			}
+
+			if cli.ShowCites && len(res.Citations) > 0 {
+				printCitations(res.Citations)
+			}
+
+			printExplanation(cli, res)
+
+			findings, blocked = checkGuardrails(aiac, res.Code)
+			printFindings(findings)
+
+			if cli.Verbose {
+				printDetails(res)
+			}
+
+			if cli.ShowUsage && !res.Cached {
+				printUsage(aiac, res)
+			}
+
+			if cli.Quiet {
+				if cli.Clipboard {
+					clipboard.WriteAll(stdoutOutput)
+				}
+
+				if cli.OutputFile != "" || cli.OutputDir != "" || cli.Diff != "" || cli.Merge != "" ||
+					cli.ReadmeFile != "" || cli.ExplainFile != "" || cli.AutoOutput {
+					if blocked != nil {
+						return blocked
+					}
+
+					pending.path, err = saveOutput(aiac, cli, res, request, 0)
+					if err != nil {
+						return fmt.Errorf("failed saving output: %w", err)
+					}
+				}
+
+				break ATTEMPTS
+			}
+
+			options = append(
+				[][2]string{
+					{"c", "continue chatting"},
+					{"e", "revise the code"},
+				},
+				options...,
+			)
+
+			// Code with findings of strict guardrails cannot be saved
+			if blocked == nil {
+				options = append(
+					[][2]string{
+						{"s", "save and exit"},
+						{"w", "save and chat"},
+					},
+					options...,
+				)
+			}
+
+			if len(findings) > 0 {
+				options = append(
+					[][2]string{{"g", "fix guardrail findings"}},
+					options...,
+				)
+			}
+
+			if problems != "" {
+				options = append(
+					[][2]string{{"v", "fix validation errors"}},
+					options...,
+				)
+			}
+		}
+
+	PROMPT:
+		for {
+			fmt.Println()
+			for _, opt := range options {
+				fmt.Printf(
+					"[%s/%s]: %s\n",
+					strings.ToUpper(opt[0]), opt[0], opt[1],
+				)
+			}
+
+			input := promptui.Prompt{
+				Label: "Choice",
+				Validate: func(s string) error {
+					key := strings.ToLower(s)
+					for _, opt := range options {
+						if opt[0] == key {
+							return nil
+						}
+					}
+
+					return errInvalidInput
+				},
+			}
+
+			result, err := input.Run()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+
+				return fmt.Errorf("prompt failed: %w", err)
+			}
+
+			choice := strings.ToLower(result)
+
+			switch choice {
+			case "r":
+				continue ATTEMPTS
+			case "q":
+				// finish without saving
+				return nil
+			case "y":
+				// copy code to clipboard
+				clipboard.WriteAll(res.Code)
+				fmt.Fprintf(os.Stderr, "Generated code copied to clipboard.\n")
+				continue PROMPT
+			case "c":
+				// continue chatting
+				asked = newMessage()
+				prompt = asked
+				continue ATTEMPTS
+			case "e":
+				// ask for a complete revised version of the code
+				asked = newMessage()
+				prompt = libaiac.RefinePrompt(asked)
+				continue ATTEMPTS
+			case "v":
+				// send the validation errors back to the model
+				prompt = libaiac.CorrectionPrompt(problems)
+				asked = prompt
+				continue ATTEMPTS
+			case "g":
+				// send the guardrail findings back to the model
+				prompt = libaiac.FindingsPrompt(findings)
+				asked = prompt
+				continue ATTEMPTS
+			case "s", "w":
+				path, err := saveOutput(aiac, cli, res, request, 0)
+				if err != nil {
+					return fmt.Errorf("failed saving output: %w", err)
+				}
+
+				if pending != nil {
+					pending.path = path
+				}
+
+				if choice == "w" {
+					asked = newMessage()
+					prompt = asked
+					continue ATTEMPTS
+				} else {
+					break ATTEMPTS
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// streams returns whether responses are printed as they arrive, which they are
+// when writing to a terminal, unless disabled. Responses are otherwise
+// printed once complete, which is also required to print them
+// post-processed.
+func streams(cli flags) bool {
+	return !cli.NoStream && len(cli.PostProcess) == 0 && isatty.IsTerminal(os.Stdout.Fd())
+}
+
+// generateCandidates generates the number of alternative responses to the
+// prompt requested via the --count flag, and prints them numbered, in
+// non-interactive mode. Responses are generated in a single request where the
+// backend supports it, otherwise one by one. Streamed responses are always
+// generated one by one, each in a new conversation with the session's
+// history. Every candidate is saved to the requested output files, numbered
+// (e.g. main-1.tf, main-2.tf), and the token usage of all candidates is
+// summed. Only the first candidate is recorded in the session.
+func generateCandidates( //nolint: funlen, cyclop
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	cli flags,
+	chat types.Conversation,
+	sess *libaiac.Session,
+	prompt, request string,
+	spin *spinner.Spinner,
+) (err error) {
+	cli.Quiet = true
+
+	streamed := streams(cli)
+	warned := make(map[string]bool)
+
+	var total types.Response
+
+	// handle presents a candidate once generated, and saves it
+	handle := func(i int, res types.Response) error {
+		for _, warning := range res.Warnings {
+			if !warned[warning] {
+				fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+				warned[warning] = true
+			}
+		}
+
+		if cli.ShowReason && res.Reasoning != "" {
+			printReasoning(res.Reasoning)
+		}
+
+		if cli.Validate {
+			res, _ = validateCode(ctx, cli, res, request)
+		}
+
+		res, err := libaiac.PostProcess(ctx, res, cli.PostProcess...)
+		if err != nil {
+			return err
+		}
+
+		if !streamed {
+			printCandidateHeader(i, cli.Count)
+
+			if cli.Full {
+				fmt.Fprintln(os.Stdout, res.FullOutput)
+			} else {
+				fmt.Fprintln(os.Stdout, res.Code)
+			}
+		}
+
+		if cli.ShowCites && len(res.Citations) > 0 {
+			printCitations(res.Citations)
+		}
+
+		printExplanation(cli, res)
+
+		findings, blocked := checkGuardrails(aiac, res.Code)
+		printFindings(findings)
+
+		if cli.Verbose {
+			printDetails(res)
+		}
+
+		if !res.Cached {
+			total.Backend, total.Model = res.Backend, res.Model
+			total.Seed, total.SystemFingerprint = res.Seed, res.SystemFingerprint
+			total.InputTokens += res.InputTokens
+			total.OutputTokens += res.OutputTokens
+			total.ReasoningTokens += res.ReasoningTokens
+			total.TokensUsed += res.TokensUsed
+			total.TokensEstimated = total.TokensEstimated || res.TokensEstimated
+		}
+
+		rec := &historyRecord{prompt: request, res: res}
+		defer recordHistory(aiac, rec)
+
+		if cli.OutputFile != "" || cli.OutputDir != "" ||
+			cli.ReadmeFile != "" || cli.ExplainFile != "" || cli.AutoOutput {
+			if blocked != nil {
+				return fmt.Errorf("candidate %d: %w", i, blocked)
+			}
+
+			rec.path, err = saveOutput(aiac, cli, res, request, i)
+			if err != nil {
+				return fmt.Errorf("failed saving output: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	generator, native := chat.(types.CandidateGenerator)
+
+	switch {
+	case !streamed && native:
+		spin.Start()
+		results, err := generator.SendCandidates(ctx, prompt, cli.Count)
+		spin.Stop()
+		if err != nil {
+			if interrupted(ctx) {
+				return errInterrupted
+			}
+			return fmt.Errorf("failed generating code: %w", err)
+		}
+
+		for i, res := range results {
+			err = handle(i+1, res)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		for i := 1; i <= cli.Count; i++ {
+			conv := chat
+			if i > 1 {
+				conv, err = aiac.Chat(ctx, sess.Backend, sess.Model, sess.Messages...)
+				if err != nil {
+					return fmt.Errorf("failed starting chat: %w", err)
+				}
+
+				conv.SetParameters(sess.Parameters)
+
+				err = attachImages(cli, conv)
+				if err != nil {
+					return err
+				}
+			}
+
+			var res types.Response
+
+			if streamed {
+				printCandidateHeader(i, cli.Count)
+				spin.Start()
+
+				sw := newStreamWriter(os.Stdout, cli.Full, spin.Stop)
+				res, err = conv.Stream(ctx, prompt, sw)
+				switch {
+				case err == nil:
+					sw.Finish(res)
+				case ctx.Err() != nil:
+					spin.Stop()
+					sw.Flush()
+				case sw.started:
+					fmt.Fprintln(os.Stdout)
+				}
+			} else {
+				spin.Start()
+				res, err = conv.Send(ctx, prompt)
+			}
+			spin.Stop()
+
+			if err != nil {
+				if interrupted(ctx) {
+					return errInterrupted
+				}
+				return fmt.Errorf("failed generating candidate %d: %w", i, err)
+			}
+
+			err = handle(i, res)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if cli.Session != "" {
+		sess.Record(chat)
+		err = sess.Save(cli.Session)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed saving session: %s\n", err)
+		}
+	}
+
+	if cli.ShowUsage && total.Backend != "" {
+		printUsage(aiac, total)
+	}
+
+	return nil
+}
+
+// generateJSON generates a response to the prompt in non-interactive mode,
+// and prints it to standard output as a JSON object (see libaiac.Result),
+// rather than printing the code. Responses are never streamed. Output files
+// are saved as in quiet mode. Errors are returned to be printed as JSON
+// objects by the caller (see printJSONError).
+func generateJSON(
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	cli flags,
+	chat types.Conversation,
+	sess *libaiac.Session,
+	prompt, request string,
+) error {
+	cli.Quiet = true
+
+	start := time.Now()
+
+	res, err := chat.Send(ctx, prompt)
+	if err != nil {
+		if interrupted(ctx) {
+			return errInterrupted
+		}
+		return fmt.Errorf("failed generating code: %w", err)
+	}
+
+	duration := time.Since(start)
+
+	if cli.Session != "" {
+		sess.Record(chat)
+		err = sess.Save(cli.Session)
+		if err != nil {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("failed saving session: %s", err))
+		}
+	}
+
+	if cli.Validate {
+		res, _ = validateCode(ctx, cli, res, request)
+	}
+
+	res, err = libaiac.PostProcess(ctx, res, cli.PostProcess...)
+	if err != nil {
+		return err
+	}
+
+	findings, blocked := checkGuardrails(aiac, res.Code)
+
+	rec := &historyRecord{prompt: request, res: res}
+	defer recordHistory(aiac, rec)
+
+	if cli.OutputFile != "" || cli.OutputDir != "" || cli.Merge != "" ||
+		cli.ReadmeFile != "" || cli.ExplainFile != "" || cli.AutoOutput {
+		if blocked != nil {
+			return blocked
+		}
+
+		rec.path, err = saveOutput(aiac, cli, res, request, 0)
+		if err != nil {
+			return fmt.Errorf("failed saving output: %w", err)
+		}
+	}
+
+	result := aiac.NewResult(res, duration)
+	result.Findings = findings
+	if explains(cli) {
+		result.Explanation = libaiac.Explanation(res.FullOutput)
+	}
+
+	return printJSON(result)
+}
+
+// printJSON prints a result to standard output as an indented JSON object.
+func printJSON(result interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(result)
+}
+
+// printJSONError prints an error to standard output as a JSON object, in
+// --json mode.
+func printJSONError(err error) {
+	printJSON(libaiac.ErrorResult{Error: err.Error()}) //nolint: errcheck
+}
+
+// printCandidateHeader prints the header that precedes every candidate
+// response to standard output, so that candidates can be told apart.
+func printCandidateHeader(i, count int) {
+	if i > 1 {
+		fmt.Fprintln(os.Stdout)
+	}
+
+	fmt.Fprintln(os.Stdout, color.New(color.Bold).Sprintf("--- Candidate %d of %d ---", i, count))
+}
+
+// validateCode formats and validates the code of a response, if it is Terraform
+// code, with the validator selected on the command line. The response is
+// returned with the formatted code, together with the problems found, which
+// are printed to standard error. If the validator is not installed, a hint is
+// printed and the response is returned as-is.
+func validateCode(
+	ctx context.Context,
+	cli flags,
+	res types.Response,
+	request string,
+) (types.Response, string) {
+	validator := libaiac.NewHCLValidator(cli.Validator)
+
+	language := res.Language
+	if language == "" {
+		if filename, _ := libaiac.DetectFilename(request, ""); filename == "main.tf" {
+			language = "hcl"
+		}
+	}
+
+	if !validator.Supports(language) {
+		return res, ""
+	}
+
+	result, err := validator.Validate(ctx, res.Code)
+	switch {
+	case errors.Is(err, types.ErrValidatorNotFound):
+		fmt.Fprintf(
+			os.Stderr,
+			"Hint: %s is not installed, skipping validation (use --validator "+
+				"to validate with a different program, such as tofu)\n",
+			cli.Validator,
+		)
+		return res, ""
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed validating code: %s\n", err)
+		return res, ""
+	}
+
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	res.Code = result.Code
+
+	if result.Problems != "" {
+		fmt.Fprintf(os.Stderr, "Validation failed:\n\n%s\n\n", result.Problems)
+	} else if len(result.Warnings) == 0 {
+		fmt.Fprintf(os.Stderr, "Validation passed.\n")
+	}
+
+	return res, result.Problems
 }
 
-func main() {
-	var cli flags
-	parser := kong.Must(
-		&cli,
-		kong.Name("aiac"),
-		kong.Description("Artificial Intelligence Infrastructure-as-Code Generator."),
-		kong.ConfigureHelp(kong.HelpOptions{
-			FlagsLast: true,
-		}),
-	)
+// checkGuardrails scans code with the guardrails of the configuration, if
+// enabled, returning their findings. If guardrails are strict and there are
+// findings, an error wrapping errGuardrails is also returned, and the code
+// must not be saved.
+func checkGuardrails(aiac *libaiac.Aiac, code string) ([]libaiac.Finding, error) {
+	if !aiac.Conf.Guardrails.Enabled {
+		return nil, nil
+	}
 
-	_, err := parser.Parse(os.Args[1:])
+	// The configuration was validated when loaded
+	guardrails, err := libaiac.NewGuardrails(aiac.Conf.Guardrails)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	if cli.Version {
-		fmt.Fprintf(os.Stdout, "aiac version %s\n", libaiac.Version)
-		os.Exit(0)
+	findings := guardrails.Scan(code)
+	if len(findings) > 0 && guardrails.Strict {
+		rules := make([]string, 0, len(findings))
+		seen := make(map[string]bool, len(findings))
+		for _, finding := range findings {
+			if !seen[finding.Rule] {
+				rules = append(rules, finding.Rule)
+				seen[finding.Rule] = true
+			}
+		}
+
+		return findings, fmt.Errorf("%w: %s", errGuardrails, strings.Join(rules, ", "))
 	}
 
-	aiac, err := libaiac.New(cli.Config)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed loading aiac client: %s\n", err)
-		os.Exit(1)
+	return findings, nil
+}
+
+// printFindings prints the findings of guardrails to standard error.
+func printFindings(findings []libaiac.Finding) {
+	if len(findings) == 0 {
+		return
 	}
 
-	if cli.ListModels {
-		err := printModels(aiac, cli)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed listing models: %s\n", err)
-			os.Exit(1)
+	fmt.Fprintf(os.Stderr, "Guardrails found problems in the generated code:\n\n")
+	for _, finding := range findings {
+		fmt.Fprintf(os.Stderr, "  %s\n", finding)
+	}
+	fmt.Fprintln(os.Stderr)
+}
+
+// printDryRun sends the prompt in dry-run mode (see libaiac.Aiac.DryRun), and
+// prints the resolved backend, model and parameters, and the request that
+// would have been sent, as JSON.
+func printDryRun(
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	chat types.Conversation,
+	sess *libaiac.Session,
+	prompt string,
+	stream bool,
+) error {
+	backendConf := aiac.Conf.Backends[sess.Backend]
+	params := backendConf.Parameters.Override(sess.Parameters)
+
+	// Estimated before sending, as backends add the prompt to the history
+	tokens := aiac.EstimateTokens(sess.Backend, sess.Model, chat.Messages(), prompt, params)
+
+	var err error
+	if stream {
+		_, err = chat.Stream(ctx, prompt, io.Discard)
+	} else {
+		_, err = chat.Send(ctx, prompt)
+	}
+
+	var dryRun *transport.DryRunError
+	if !errors.As(err, &dryRun) {
+		if err == nil {
+			return errDryRunUnsupported
 		}
 
-		os.Exit(0)
+		return fmt.Errorf("failed preparing request: %w", err)
 	}
 
-	err = generateCode(aiac, cli)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-		os.Exit(1)
+	backendType := backendConf.Type
+	if backendType == "" {
+		backendType = libaiac.BackendOpenAI
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	return enc.Encode(struct {
+		Backend    string                `json:"backend"`
+		Type       libaiac.BackendType   `json:"type"`
+		Model      string                `json:"model"`
+		Parameters types.Parameters      `json:"parameters"`
+		Tokens     libaiac.TokenEstimate `json:"tokens"`
+		Request    transport.Request     `json:"request"`
+	}{
+		Backend:    sess.Backend,
+		Type:       backendType,
+		Model:      sess.Model,
+		Parameters: params,
+		Tokens:     tokens,
+		Request:    dryRun.Request,
+	})
+}
+
+// printDetails prints details about a response to standard error, such as the
+// backend and model that generated it, and the upstream provider that served
+// it for backends that route requests (e.g. OpenRouter).
+func printDetails(res types.Response) {
+	details := [][2]string{
+		{"Backend", res.Backend},
+		{"Model", res.Model},
+		{"Provider", res.Provider},
+		{"Stop reason", res.StopReason},
+	}
+
+	for _, detail := range details {
+		if detail[1] != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", detail[0], detail[1])
+		}
 	}
-	os.Exit(0)
 }
 
-func printModels(aiac *libaiac.Aiac, cli flags) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// printReasoning prints the reasoning that preceded a response to standard
+// error, so that it is never mixed with the generated code.
+func printReasoning(reasoning string) {
+	fmt.Fprintf(os.Stderr, "%s\n%s\n\n", stderrColor(color.Bold).Sprint("Reasoning:"), reasoning)
+}
 
-	models, err := aiac.ListModels(ctx, cli.Backend)
-	if err != nil {
-		return err
+// explains returns whether an explanation of the generated code is requested,
+// with the --explain or --explain-file flags.
+func explains(cli flags) bool {
+	return cli.Explain || cli.ExplainFile != ""
+}
+
+// printExplanation prints the explanation of the code of a response to
+// standard error (see libaiac.Explanation), if requested with the --explain
+// flag, unless it is saved to a file with the --explain-file flag.
+func printExplanation(cli flags, res types.Response) {
+	if !cli.Explain || cli.ExplainFile != "" {
+		return
 	}
 
-	for _, model := range models {
-		fmt.Println(model)
+	explanation := libaiac.Explanation(res.FullOutput)
+	if explanation == "" {
+		return
 	}
 
-	return nil
+	fmt.Fprintf(os.Stderr, "%s\n%s\n\n", stderrColor(color.Bold).Sprint("Explanation:"), explanation)
+}
+
+// startChat starts the conversation of the session, with its backend and
+// model, or with the backends selected with the --race flag racing each other
+// (see Aiac.Race).
+func startChat(
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	cli flags,
+	sess *libaiac.Session,
+) (types.Conversation, error) {
+	if len(cli.Race) > 0 {
+		return aiac.Race(ctx, cli.Race, sess.Messages...)
+	}
+
+	return aiac.Chat(ctx, sess.Backend, sess.Model, sess.Messages...)
 }
 
-var errInvalidInput = errors.New("invalid input, please try again")
+// withRunTimeout returns a context bounding a whole run by the time limit
+// provided with the --timeout flag, if any.
+func withRunTimeout(ctx context.Context, cli flags) (context.Context, context.CancelFunc) {
+	if cli.Timeout == nil || *cli.Timeout <= 0 {
+		return ctx, func() {}
+	}
 
-func generateCode(aiac *libaiac.Aiac, cli flags) error { //nolint: funlen, cyclop
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
+	return context.WithTimeout(ctx, *cli.Timeout)
+}
 
-	spin := spinner.New(
-		spinner.CharSets[11],
-		100*time.Millisecond, //nolint: gomnd
-		spinner.WithWriter(color.Error),
-		spinner.WithSuffix("\tGenerating code ..."))
+// printRace prints which backend won the race that generated a response to
+// standard error, with the latency of every backend the prompt was sent to.
+func printRace(res types.Response) {
+	var winner string
+	var others []string
 
-	defer func() {
-		if spin.Active() {
-			spin.Stop()
+	for _, entry := range res.Race {
+		latency := entry.Latency.Round(time.Millisecond)
+
+		switch {
+		case entry.Won:
+			winner = fmt.Sprintf("%s won the race in %s", entry.Backend, latency)
+		case errors.Is(entry.Err, context.Canceled):
+			others = append(others, fmt.Sprintf("%s: canceled after %s", entry.Backend, latency))
+		default:
+			others = append(others, fmt.Sprintf(
+				"%s: failed after %s: %s",
+				entry.Backend, latency, describeError(entry.Err),
+			))
 		}
-	}()
+	}
 
-	// If the prompt starts with the word "get" or "generate", remove it. This
-	// is here for backwards compatibility purposes, as previous versions used
-	// these words as command names (that weren't truly part of the prompt), so
-	// people may be used to adding them and we don't want them to actually be
-	// in the prompt.
-	if strings.ToLower(cli.What[0]) == "get" ||
-		strings.ToLower(cli.What[0]) == "generate" {
-		cli.What = cli.What[1:]
+	if len(others) == 0 {
+		fmt.Fprintf(os.Stderr, "Backend %s.\n", winner)
+		return
 	}
 
-	// NOTE: we are prepending the string "generate sample code for a..."
-	// to the prompt, this is meant to ensure that the language model
-	// actually generates code.
-	prompt := fmt.Sprintf("Generate sample code for a %s", strings.Join(cli.What, " "))
+	fmt.Fprintf(os.Stderr, "Backend %s (%s).\n", winner, strings.Join(others, "; "))
+}
 
-	if cli.ReadmeFile != "" || cli.Full {
-		prompt = fmt.Sprintf(
-			"Generate sample code for a %s. Include explanations.",
-			strings.Join(cli.What, " "),
-		)
+// printCitations prints the sources of a web-grounded response to standard
+// error, numbered as models refer to them in their output (e.g. "[1]").
+func printCitations(citations []string) {
+	fmt.Fprintln(os.Stderr, stderrColor(color.Bold).Sprint("Citations:"))
+	for i, citation := range citations {
+		fmt.Fprintf(os.Stderr, "[%d] %s\n", i+1, citation)
 	}
+	fmt.Fprintln(os.Stderr)
+}
 
-	var res types.Response
+// printUsage prints the token usage of a response to standard error, along
+// with its estimated cost, if known and not zero (e.g. for local models), and
+// the seed it was generated with, if any.
+func printUsage(aiac *libaiac.Aiac, res types.Response) {
+	estimated := ""
+	if res.TokensEstimated {
+		estimated = " (estimated)"
+	}
 
-	chat, err := aiac.Chat(ctx, cli.Backend, cli.Model)
-	if err != nil {
-		return fmt.Errorf("failed starting chat: %w", err)
+	// Reasoning tokens are part of the completion tokens
+	reasoning := ""
+	if res.ReasoningTokens > 0 {
+		reasoning = fmt.Sprintf(" (%d reasoning)", res.ReasoningTokens)
 	}
 
-ATTEMPTS:
-	for {
-		spin.Start()
+	fmt.Fprintf(
+		os.Stderr,
+		"Tokens: %d prompt, %d completion%s, %d total%s\n",
+		res.InputTokens, res.OutputTokens, reasoning, res.TokensUsed, estimated,
+	)
 
-		res, err = chat.Send(ctx, prompt)
+	if cost, ok := aiac.Cost(res); ok && cost > 0 {
+		fmt.Fprintf(os.Stderr, "Estimated cost: $%.6f\n", cost)
+	}
 
-		options := [][2]string{
-			{"r", "retry same prompt"},
-			{"y", "copy to clipboard"},
-			{"q", "quit"},
+	if res.Seed != nil {
+		fingerprint := ""
+		if res.SystemFingerprint != "" {
+			fingerprint = fmt.Sprintf(" (system fingerprint %s)", res.SystemFingerprint)
 		}
 
-		if err != nil {
-			spin.Stop()
-			fmt.Fprintf(os.Stderr, "Failed generating code: %s\n", err)
-		} else {
-			spin.Stop()
+		fmt.Fprintf(os.Stderr, "Seed: %d%s\n", *res.Seed, fingerprint)
+	}
+}
 
-			stdoutOutput := res.Code
-			if cli.Full {
-				stdoutOutput = res.FullOutput
-			}
+// loadSession returns the session to use for the conversation. If a session
+// file was provided and exists, it is resumed, with the backend, model,
+// generation parameters and system prompt provided on the command line taking
+// precedence.
+// Otherwise, a new session is created from the command line flags and the
+// defaults in the configuration. Unless a model is selected, new sessions use
+// the backend's model for the provided kind of code, if it configures one
+// (see libaiac.DetectKind).
+func loadSession(aiac *libaiac.Aiac, cli flags, kind string) (*libaiac.Session, error) {
+	params := types.Parameters{
+		Temperature: cli.Temperature,
+		TopP:        cli.TopP,
+		MaxTokens:   cli.MaxTokens,
+		Stop:        cli.Stop,
+		Seed:        cli.Seed,
 
-			fmt.Fprintln(os.Stdout, stdoutOutput)
+		ReasoningEffort: cli.Reasoning,
+	}
 
-			if cli.Quiet {
-				if cli.Clipboard {
-					clipboard.WriteAll(stdoutOutput)
-				}
-				break ATTEMPTS
-			}
+	if cli.Format == types.FormatJSON {
+		params.Format = types.FormatJSON
+	}
 
-			options = append(
-				[][2]string{
-					{"s", "save and exit"},
-					{"w", "save and chat"},
-					{"c", "continue chatting"},
-				},
-				options...,
-			)
+	if cli.Schema != "" {
+		schema, err := libaiac.LoadSchema(cli.Schema)
+		if err != nil {
+			return nil, err
 		}
 
-	PROMPT:
-		for {
-			fmt.Println()
-			for _, opt := range options {
-				fmt.Printf(
-					"[%s/%s]: %s\n",
-					strings.ToUpper(opt[0]), opt[0], opt[1],
-				)
-			}
-
-			input := promptui.Prompt{
-				Label: "Choice",
-				Validate: func(s string) error {
-					key := strings.ToLower(s)
-					for _, opt := range options {
-						if opt[0] == key {
-							return nil
-						}
-					}
+		params.Format = types.FormatJSON
+		params.Schema = schema
+	}
 
-					return errInvalidInput
-				},
-			}
+	system := cli.System
+	if cli.SystemFile != "" {
+		data, err := os.ReadFile(cli.SystemFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading system prompt: %w", err)
+		}
 
-			result, err := input.Run()
-			if err != nil {
-				if errors.Is(err, io.EOF) {
-					return nil
-				}
+		system = strings.TrimSpace(string(data))
+	}
 
-				return fmt.Errorf("prompt failed: %w", err)
-			}
+	sess := libaiac.NewSession(cli.Backend, cli.Model, params)
 
-			choice := strings.ToLower(result)
+	if cli.Session != "" {
+		stored, err := libaiac.LoadSession(cli.Session)
+		switch {
+		case err == nil:
+			sess = stored
+			sess.Parameters = sess.Parameters.Override(params)
 
-			switch choice {
-			case "r":
-				continue ATTEMPTS
-			case "q":
-				// finish without saving
-				return nil
-			case "y":
-				// copy code to clipboard
-				clipboard.WriteAll(res.Code)
-				fmt.Fprintf(os.Stderr, "Generated code copied to clipboard.\n")
-				continue PROMPT
-			case "c":
-				// continue chatting
-				prompt = newMessage()
-				continue ATTEMPTS
-			case "s", "w":
-				err = saveOutput(cli, res)
-				if err != nil {
-					return fmt.Errorf("failed saving output: %w", err)
-				}
+			if cli.Backend != "" && cli.Backend != sess.Backend {
+				// The stored model may not exist in the chosen backend
+				sess.Backend = cli.Backend
+				sess.Model = ""
+			}
 
-				if choice == "w" {
-					prompt = newMessage()
-					continue ATTEMPTS
-				} else {
-					break ATTEMPTS
-				}
+			if cli.Model != "" {
+				sess.Model = cli.Model
 			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, fmt.Errorf("failed loading session: %w", err)
 		}
 	}
 
-	return nil
+	if system != "" {
+		sess.Messages = types.WithSystem(sess.Messages, system)
+	}
+
+	// Record the actual backend and model used, so that resuming the session
+	// is not affected by changes to the defaults in the configuration
+	if sess.Backend == "" {
+		sess.Backend = aiac.Conf.DefaultBackend
+	}
+
+	if sess.Model == "" {
+		sess.Model = aiac.Conf.Backends[sess.Backend].ResolveModelForKind("", kind)
+	}
+
+	return sess, nil
 }
 
 func newMessage() string {
@@ -266,35 +1936,113 @@ func newMessage() string {
 	return prompt
 }
 
-func saveOutput(cli flags, res types.Response) (err error) {
-	if !cli.Quiet && cli.OutputFile == "" {
+// saveOutput saves the code and full output of a response to the files
+// selected on the command line, prompting for them in interactive mode. If
+// candidate is not zero, the response is one of several candidates, and its
+// number is added to the names of all files saved (see numbered). Code is
+// saved with a header if enabled (see addHeader). Returns the path of the
+// file or directory the code was saved to, or of the full output if only it
+// was saved.
+func saveOutput( //nolint: cyclop
+	aiac *libaiac.Aiac, cli flags, res types.Response, request string, candidate int,
+) (path string, err error) {
+	// Suggest a filename based on the kind of code requested and generated,
+	// or the file being refined, so that it can be overwritten with the
+	// revised code
+	filename, detected := libaiac.DetectFilename(request, res.Language)
+	if cli.Refine != "" {
+		filename, detected = cli.Refine, true
+	}
+
+	if cli.OutputFile == "" && cli.OutputDir == "" && cli.Diff == "" && cli.Merge == "" && cli.AutoOutput {
+		cli.OutputFile = filename
+		if !detected {
+			fmt.Fprintf(
+				os.Stderr,
+				"Warning: could not detect the type of the generated code, using %s\n",
+				filename,
+			)
+		}
+	}
+
+	if !cli.Quiet && cli.OutputFile == "" && cli.OutputDir == "" && cli.Diff == "" && cli.Merge == "" {
 		input := promptui.Prompt{
-			Label: "Enter file path for generated code",
+			Label:     "Enter file path for generated code",
+			Default:   filename,
+			AllowEdit: true,
 		}
 
 		cli.OutputFile, err = input.Run()
 		if err != nil {
-			return fmt.Errorf("prompt failed: %w", err)
+			return "", fmt.Errorf("prompt failed: %w", err)
 		}
 	}
 
+	cli.OutputFile = numbered(cli.OutputFile, candidate)
+	cli.ReadmeFile = numbered(cli.ReadmeFile, candidate)
+	cli.ExplainFile = numbered(cli.ExplainFile, candidate)
+
 	var codeSaved, fullSaved bool
 
+	if cli.OutputDir != "" {
+		err = saveFiles(aiac, cli, res, request, filepath.Base(filename), candidate)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The file the code is saved to, either as-is or after showing a diff
+	target := cli.OutputFile
+	if cli.Diff != "" {
+		target = cli.Diff
+	}
+
+	code := res.Code
+	if target != "" {
+		code = addHeader(aiac, res, request, types.File{
+			Name:     target,
+			Language: res.Language,
+			Code:     res.Code,
+		})
+	}
+
 	if cli.OutputFile != "" {
-		f, err := os.Create(cli.OutputFile)
+		err = writeFile(cli, cli.OutputFile, code)
 		if err != nil {
-			return fmt.Errorf(
-				"failed creating output file %s: %w",
+			return "", fmt.Errorf(
+				"failed writing output file %s: %w",
 				cli.OutputFile, err,
 			)
 		}
 
-		fmt.Fprintln(f, res.Code)
-		f.Close()
-
 		codeSaved = true
 	}
 
+	if cli.Diff != "" {
+		codeSaved, err = writeDiff(cli, code)
+		if err != nil {
+			return "", fmt.Errorf("failed writing %s: %w", cli.Diff, err)
+		}
+
+		if codeSaved {
+			cli.OutputFile = target
+		}
+	}
+
+	// Merged code is saved without a header, as it is added to existing code
+	if cli.Merge != "" {
+		var merged string
+
+		merged, codeSaved, err = writeMerge(cli, res.Code)
+		if err != nil {
+			return "", fmt.Errorf("failed merging into %s: %w", cli.Merge, err)
+		}
+
+		if codeSaved {
+			cli.OutputFile, code = cli.Merge, merged
+		}
+	}
+
 	if !cli.Quiet && cli.ReadmeFile == "" {
 		input := promptui.Prompt{
 			Label: "Enter file path for full output, or leave empty to ignore",
@@ -302,31 +2050,126 @@ func saveOutput(cli flags, res types.Response) (err error) {
 
 		cli.ReadmeFile, err = input.Run()
 		if err != nil {
-			return fmt.Errorf("prompt failed: %w", err)
+			return "", fmt.Errorf("prompt failed: %w", err)
 		}
 	}
 
 	if cli.ReadmeFile != "" {
-		f, err := os.Create(cli.ReadmeFile)
+		err = writeFile(cli, cli.ReadmeFile, res.FullOutput)
 		if err != nil {
-			return fmt.Errorf(
-				"failed creating readme file %s: %w",
+			return "", fmt.Errorf(
+				"failed writing readme file %s: %w",
 				cli.ReadmeFile, err,
 			)
 		}
 
-		fmt.Fprintln(f, res.FullOutput)
-		f.Close()
-
 		fullSaved = true
 	}
 
+	if cli.ExplainFile != "" {
+		err = writeFile(cli, cli.ExplainFile, libaiac.Explanation(res.FullOutput))
+		if err != nil {
+			return "", fmt.Errorf(
+				"failed writing explanation file %s: %w",
+				cli.ExplainFile, err,
+			)
+		}
+
+		fmt.Fprintf(os.Stderr, "Explanation saved successfully to %s\n", cli.ExplainFile)
+	}
+
 	if codeSaved {
-		fmt.Fprintf(os.Stderr, "Code saved successfully to %s\n", cli.OutputFile)
+		// The code is saved followed by a newline
+		fmt.Fprintf(
+			os.Stderr,
+			"Code saved successfully to %s (%d bytes)\n",
+			cli.OutputFile, len(code)+1,
+		)
 	}
 	if fullSaved {
 		fmt.Fprintf(os.Stderr, "Full output saved successfully to %s\n", cli.ReadmeFile)
 	}
 
+	switch {
+	case codeSaved:
+		return cli.OutputFile, nil
+	case cli.OutputDir != "":
+		return cli.OutputDir, nil
+	default:
+		return cli.ReadmeFile, nil
+	}
+}
+
+// saveFiles saves the files generated in the response to the directory
+// selected with the --output-dir flag, creating it if necessary. Responses are
+// split into files as described by types.SplitFiles, with code not attributed
+// to any file saved to the provided fallback filename. If candidate is not
+// zero, its number is added to the names of all files (see numbered).
+func saveFiles(
+	aiac *libaiac.Aiac, cli flags, res types.Response, request, fallback string, candidate int,
+) error {
+	for _, file := range types.SplitFiles(res.FullOutput, fallback) {
+		path := numbered(filepath.Join(cli.OutputDir, file.Name), candidate)
+
+		err := os.MkdirAll(filepath.Dir(path), 0o755) //nolint: gomnd
+		if err != nil {
+			return fmt.Errorf("failed creating output directory: %w", err)
+		}
+
+		err = writeFile(cli, path, addHeader(aiac, res, request, file))
+		if err != nil {
+			return fmt.Errorf("failed writing output file %s: %w", path, err)
+		}
+
+		fmt.Fprintf(os.Stderr, "Code saved successfully to %s\n", path)
+	}
+
 	return nil
 }
+
+// addHeader returns the code of a file with a header recording how it was
+// generated, if enabled with the --header flag or in the configuration (see
+// Aiac.AddHeader). Files whose language does not support comments are saved
+// without one, with a warning.
+func addHeader(aiac *libaiac.Aiac, res types.Response, request string, file types.File) string {
+	code, err := aiac.AddHeader(file, res, request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err)
+		return file.Code
+	}
+
+	return code
+}
+
+// numbered adds the number of a candidate response to a file path, before its
+// extension (e.g. main.tf becomes main-2.tf). Paths are returned as-is if
+// candidate is zero or the path is empty.
+func numbered(path string, candidate int) string {
+	if candidate == 0 || path == "" {
+		return path
+	}
+
+	ext := filepath.Ext(path)
+	if ext == filepath.Base(path) {
+		// Dotfiles such as .gitignore have no extension
+		ext = ""
+	}
+
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), candidate, ext)
+}
+
+// spinnerWriter writes to w, stopping the spinner first so that its output is
+// not garbled.
+type spinnerWriter struct {
+	spin *spinner.Spinner
+	w    io.Writer
+}
+
+// Write implements io.Writer.
+func (sw *spinnerWriter) Write(p []byte) (int, error) {
+	if sw.spin.Active() {
+		sw.spin.Stop()
+	}
+
+	return sw.w.Write(p)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// reservedWords are the names of the commands other than get. Prompts
+// starting with them are parsed as these commands, and must be prefixed with
+// "get" to be sent to the model, as documented in the README.
+var reservedWords = []string{
+	"backends", "batch", "budget", "cache", "completion", "config",
+	"doctor", "history", "models", "secret", "version", "whoami",
+}
+
+func TestReservedWords(t *testing.T) {
+	var cli flags
+
+	var got []string
+	for _, cmd := range newParser(&cli).Model.Children {
+		if cmd.Name != "get" {
+			got = append(got, cmd.Name)
+		}
+	}
+
+	sort.Strings(got)
+
+	if !reflect.DeepEqual(got, reservedWords) {
+		t.Errorf(
+			"commands changed, update the README and the reserved words: expected %v, got %v",
+			reservedWords, got,
+		)
+	}
+}
+
+func TestParsePrompt(t *testing.T) {
+	type parseTest struct {
+		name string
+		args []string
+		// wantWhat is the expected prompt, and wantHint a string the hint
+		// for the parse error must contain, if parsing must fail
+		wantWhat []string
+		wantHint string
+	}
+
+	tests := []parseTest{
+		{
+			name:     "prompt",
+			args:     []string{"-b", "claude", "terraform", "for", "s3"},
+			wantWhat: []string{"terraform", "for", "s3"},
+		},
+		{
+			name:     "explicit get",
+			args:     []string{"get", "terraform", "for", "s3"},
+			wantWhat: []string{"terraform", "for", "s3"},
+		},
+	}
+
+	for _, word := range reservedWords {
+		tests = append(tests, parseTest{
+			name:     "reserved " + word,
+			args:     []string{word, "prompt", "for", "nginx"},
+			wantHint: `"aiac get ` + word + ` ..."`,
+		}, parseTest{
+			name:     "get " + word,
+			args:     []string{"get", word, "prompt", "for", "nginx"},
+			wantWhat: []string{word, "prompt", "for", "nginx"},
+		})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cli flags
+
+			ctx, err := newParser(&cli).Parse(tt.args)
+			if tt.wantHint != "" {
+				if err == nil {
+					t.Fatalf("expected an error, parsed command %q", ctx.Command())
+				}
+
+				if hint := commandHint(err); !strings.Contains(hint, tt.wantHint) {
+					t.Errorf("expected hint to contain %q, got %q", tt.wantHint, hint)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if !reflect.DeepEqual(cli.Get.What, tt.wantWhat) {
+				t.Errorf("expected prompt %q, got %q", tt.wantWhat, cli.Get.What)
+			}
+		})
+	}
+}
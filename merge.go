@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+// mergeExtensions are the extensions of the files that may be selected with
+// the --merge flag, as only HCL can be merged.
+var mergeExtensions = map[string]bool{
+	".tf":     true,
+	".tofu":   true,
+	".tfvars": true,
+	".hcl":    true,
+}
+
+var errMergeUnsupported = errors.New("--merge only supports HCL files (.tf, .tofu, .tfvars or .hcl)")
+
+// writeMerge merges the code into the HCL file selected with the --merge flag
+// (see libaiac.MergeHCL), resolving conflicting blocks as selected with the
+// --prefer flag, and prints a summary of the merge to standard error. Files
+// that do not exist are merged into as if they were empty. Nothing is written
+// when the merge changes nothing. Returns the merged code, and whether the
+// file was written.
+func writeMerge(cli flags, code string) (merged string, written bool, err error) {
+	existing, err := os.ReadFile(cli.Merge)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", false, err
+	}
+
+	res, err := libaiac.MergeHCL(string(existing), code, cli.Prefer)
+	if err != nil {
+		return "", false, err
+	}
+
+	printMerge(res)
+
+	if !res.Changed() {
+		fmt.Fprintf(os.Stderr, "No changes to %s\n", cli.Merge)
+		return "", false, nil
+	}
+
+	// The code is written followed by a newline (see writeFile)
+	merged = strings.TrimRight(res.Code, "\n")
+
+	err = writeFile(cli, cli.Merge, merged)
+	if err != nil {
+		return "", false, err
+	}
+
+	return merged, true, nil
+}
+
+// printMerge prints the addresses of the blocks and attributes added,
+// replaced, kept and left unchanged by a merge to standard error.
+func printMerge(res libaiac.MergeResult) {
+	for _, change := range []struct {
+		what      string
+		addresses []string
+	}{
+		{"Added", res.Added},
+		{"Replaced", res.Replaced},
+		{"Kept", res.Kept},
+		{"Unchanged", res.Unchanged},
+	} {
+		if len(change.addresses) > 0 {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", change.what, strings.Join(change.addresses, ", "))
+		}
+	}
+}
+
+// checkMerge verifies that the file selected with the --merge flag is HCL.
+func checkMerge(path string) error {
+	if !mergeExtensions[filepath.Ext(path)] {
+		return errMergeUnsupported
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+//go:build otel
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// tracingEnvVars are environment variables configuring where the OTLP
+// exporter sends traces, any of which enables tracing without --otel.
+var tracingEnvVars = []string{
+	"OTEL_EXPORTER_OTLP_ENDPOINT",
+	"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+}
+
+// tracingFlushTimeout is the maximum amount of time spent exporting remaining
+// spans on exit.
+const tracingFlushTimeout = 5 * time.Second
+
+// setupTracing enables exporting OpenTelemetry traces with OTLP over HTTP,
+// if requested with the --otel flag or one of tracingEnvVars, and starts the
+// root span of the command being run. The exporter is configured with the
+// standard OTEL_* environment variables, and sends traces to
+// http://localhost:4318 by default. If the TRACEPARENT environment variable
+// is set, e.g. by a CI system, the root span continues the trace it refers
+// to. The returned function ends the root span, marking it as failed if the
+// exit code is not zero, and flushes remaining spans; it must be called
+// before exiting.
+func setupTracing(cli flags, command string) (context.Context, func(code int), error) {
+	ctx := context.Background()
+
+	enabled := cli.OTel
+	for _, name := range tracingEnvVars {
+		if os.Getenv(name) != "" {
+			enabled = true
+		}
+	}
+
+	if !enabled {
+		return ctx, func(int) {}, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed creating trace exporter: %w", err)
+	}
+
+	// The service name may be overridden with OTEL_SERVICE_NAME
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("aiac"),
+			semconv.ServiceVersion(libaiac.Version),
+		),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("failed creating trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	propagator := propagation.TraceContext{}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	if parent := os.Getenv("TRACEPARENT"); parent != "" {
+		ctx = propagator.Extract(ctx, propagation.MapCarrier{
+			"traceparent": parent,
+			"tracestate":  os.Getenv("TRACESTATE"),
+		})
+	}
+
+	ctx, span := provider.Tracer("github.com/gofireflyio/aiac/v5").Start(ctx, "aiac "+command)
+
+	return ctx, func(code int) {
+		if code != 0 {
+			span.SetStatus(codes.Error, fmt.Sprintf("exit code %d", code))
+		}
+		span.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), tracingFlushTimeout)
+		defer cancel()
+
+		if err := provider.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed exporting traces: %s\n", err)
+		}
+	}, nil
+}
@@ -0,0 +1,21 @@
+//go:build !otel
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoTracing = errors.New("aiac was built without OpenTelemetry support, rebuild it with -tags otel")
+
+// setupTracing fails if exporting traces is requested with the --otel flag,
+// as aiac was built without the otel build tag, which keeps the OpenTelemetry
+// SDK out of the binary. Spans are otherwise not recorded.
+func setupTracing(cli flags, _ string) (context.Context, func(code int), error) {
+	if cli.OTel {
+		return nil, nil, errNoTracing
+	}
+
+	return context.Background(), func(int) {}, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
+)
+
+// maxPickerSize is the number of backends listed at once by pickBackend.
+const maxPickerSize = 10
+
+// pickBackend prompts for the backend to use if none was selected with
+// --backend (or backends to race with --race) and the configuration has no
+// default backend, listing the configured backends with their types and
+// default models. The choice is made the default backend for the rest of the
+// run, so it applies to every prompt of the conversation, while resumed
+// sessions keep the backend they recorded. Nothing is prompted when not
+// running interactively (in --quiet or --json mode, or if standard input or
+// output is not a terminal), so selecting the default backend fails later, as
+// without the picker.
+func pickBackend(aiac *libaiac.Aiac, cli flags) error {
+	if cli.Backend != "" || len(cli.Race) > 0 || aiac.Conf.DefaultBackend != "" || len(aiac.Conf.Backends) == 0 {
+		return nil
+	}
+
+	if cli.Quiet || cli.JSON || !isatty.IsTerminal(os.Stdin.Fd()) || !isatty.IsTerminal(os.Stdout.Fd()) {
+		return nil
+	}
+
+	names := make([]string, 0, len(aiac.Conf.Backends))
+	for name := range aiac.Conf.Backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	items := make([]string, len(names))
+	for i, name := range names {
+		backendConf := aiac.Conf.Backends[name]
+
+		backendType := backendConf.Type
+		if backendType == "" {
+			backendType = libaiac.BackendOpenAI
+		}
+
+		model := backendConf.ResolveModel("")
+		if model == "" {
+			model = "no default model"
+		}
+
+		items[i] = fmt.Sprintf("%s (%s, %s)", name, backendType, model)
+	}
+
+	input := promptui.Select{
+		Label: "Select a backend",
+		Items: items,
+		Size:  min(len(items), maxPickerSize),
+	}
+
+	i, _, err := input.Run()
+	if err != nil {
+		return fmt.Errorf("prompt failed: %w", err)
+	}
+
+	aiac.Conf.DefaultBackend = names[i]
+
+	return nil
+}
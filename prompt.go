@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// stdinArg is the prompt argument, or prompt file, that stands for standard
+// input.
+const stdinArg = "-"
+
+// defaultEditor is the editor used to compose prompts if neither the VISUAL
+// nor the EDITOR environment variables are set.
+const defaultEditor = "vi"
+
+var errEmptyEditorPrompt = errors.New("prompt is empty, nothing to generate")
+
+// readPromptInput reads additional prompt input from the prompt file, if
+// provided, and from standard input if one of the words of the prompt is "-",
+// in which case that word is removed. Input from both sources is joined by
+// empty lines. Byte order marks and surrounding whitespace are trimmed.
+func readPromptInput(promptFile string, what []string) (
+	words []string,
+	input string,
+	err error,
+) {
+	var inputs []string
+
+	readStdin := promptFile == stdinArg
+	for _, word := range what {
+		if word == stdinArg {
+			readStdin = true
+		} else {
+			words = append(words, word)
+		}
+	}
+
+	if promptFile != "" && promptFile != stdinArg {
+		data, err := os.ReadFile(promptFile)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed reading prompt file: %w", err)
+		}
+
+		inputs = append(inputs, trimInput(string(data)))
+	}
+
+	if readStdin {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed reading prompt from standard input: %w", err)
+		}
+
+		inputs = append(inputs, trimInput(string(data)))
+	}
+
+	return words, joinNonEmpty(inputs...), nil
+}
+
+// readContextFiles reads the context files provided via the command line, if
+// any (see libaiac.ReadContextFiles). Skipped files are reported as warnings.
+func readContextFiles(cli flags) ([]libaiac.ContextFile, error) {
+	if len(cli.ContextFile) == 0 && len(cli.ContextGlob) == 0 {
+		return nil, nil
+	}
+
+	files, warnings, err := libaiac.ReadContextFiles(
+		cli.ContextFile,
+		cli.ContextGlob,
+		cli.ContextMax,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	return files, nil
+}
+
+// fitContextFiles drops the context files that do not fit in the context
+// window of the model the prompt they precede is sent to, or of any of the
+// raced backends' models (see libaiac.Aiac.FitContextFiles), warning about
+// them. With the --no-truncate flag, an error wrapping
+// types.ErrContextWindowExceeded is returned instead. All files are kept
+// with the --force flag, as the prompt is sent anyway.
+func fitContextFiles(
+	ctx context.Context,
+	aiac *libaiac.Aiac,
+	cli flags,
+	chat types.Conversation,
+	sess *libaiac.Session,
+	files []libaiac.ContextFile,
+	prompt string,
+) ([]libaiac.ContextFile, error) {
+	if len(files) == 0 || cli.Force {
+		return files, nil
+	}
+
+	// Racing backends use their default models
+	targets := [][2]string{{sess.Backend, sess.Model}}
+	if len(cli.Race) > 0 {
+		targets = targets[:0]
+		for _, name := range cli.Race {
+			resolved, err := aiac.ResolveBackend(name)
+			if err != nil {
+				return nil, err
+			}
+
+			model := aiac.Conf.Backends[resolved].ResolveModel("")
+			targets = append(targets, [2]string{resolved, model})
+		}
+	}
+
+	var paths []string
+
+	for _, target := range targets {
+		backend, model := target[0], target[1]
+		params := aiac.Conf.Backends[backend].Parameters.Override(sess.Parameters)
+
+		kept, dropped, est := aiac.FitContextFiles(
+			ctx, backend, model, chat.Messages(), files, prompt, params,
+		)
+		if len(dropped) == 0 {
+			continue
+		}
+
+		if cli.NoTruncate {
+			return nil, fmt.Errorf(
+				"%w with the context files: %s (model %s)",
+				types.ErrContextWindowExceeded, est, model,
+			)
+		}
+
+		for _, file := range dropped {
+			paths = append(paths, file.Path)
+		}
+
+		files = kept
+	}
+
+	if len(paths) > 0 {
+		fmt.Fprintf(
+			os.Stderr,
+			"Warning: dropped context files %s to fit the prompt in the model's "+
+				"context window (the least recently modified are dropped first)\n",
+			strings.Join(paths, ", "),
+		)
+	}
+
+	return files, nil
+}
+
+// attachImages attaches the images provided via the command line to the next
+// prompt sent in the conversation, if any (see libaiac.LoadImage). An error is
+// returned if the backend or model does not accept images.
+func attachImages(cli flags, chat types.Conversation) error {
+	if len(cli.Image) == 0 {
+		return nil
+	}
+
+	images := make([]types.Image, 0, len(cli.Image))
+	for _, path := range cli.Image {
+		img, err := libaiac.LoadImage(path)
+		if err != nil {
+			return err
+		}
+
+		images = append(images, img)
+	}
+
+	attacher, ok := chat.(types.ImageAttacher)
+	if !ok {
+		return fmt.Errorf("%w: attaching images", types.ErrUnsupported)
+	}
+
+	err := attacher.AttachImages(images...)
+	if err != nil {
+		return fmt.Errorf("failed attaching images: %w", err)
+	}
+
+	return nil
+}
+
+// buildPrompt builds the prompt requesting the code described on the command
+// line with the prompt builder of its kind (see Aiac.BuildPrompt). The system
+// prompt of the builder, if any, becomes that of the session, unless it has
+// one already (e.g. from the --system flag).
+func buildPrompt(
+	aiac *libaiac.Aiac,
+	sess *libaiac.Session,
+	kind, request string,
+	explain bool,
+) (string, error) {
+	prompt, err := aiac.BuildPrompt(libaiac.PromptRequest{
+		Kind:    kind,
+		Request: request,
+		Explain: explain,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if system, _ := types.SplitSystem(sess.Messages); prompt.System != "" && system == "" {
+		sess.Messages = types.WithSystem(sess.Messages, prompt.System)
+	}
+
+	return prompt.User, nil
+}
+
+// editPrompt opens the user's editor (per the VISUAL or EDITOR environment
+// variables) on an empty temporary file to compose the prompt, and returns
+// its contents once the editor exits.
+func editPrompt() (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = defaultEditor
+	}
+
+	f, err := os.CreateTemp("", "aiac-prompt-*.md")
+	if err != nil {
+		return "", fmt.Errorf("failed creating prompt file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	// The editor may include arguments, e.g. "code --wait"
+	args := append(strings.Fields(editor), f.Name())
+
+	cmd := exec.Command(args[0], args[1:]...) //nolint: gosec
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("failed running editor %s: %w", editor, err)
+	}
+
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed reading prompt file: %w", err)
+	}
+
+	prompt := trimInput(string(data))
+	if prompt == "" {
+		return "", errEmptyEditorPrompt
+	}
+
+	return prompt, nil
+}
+
+// trimInput removes a leading byte order mark and surrounding whitespace
+// (including trailing newlines) from prompt input.
+func trimInput(input string) string {
+	return strings.TrimSpace(strings.TrimPrefix(input, "\ufeff"))
+}
+
+// joinNonEmpty joins the non-empty strings provided with empty lines.
+func joinNonEmpty(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+
+	return strings.Join(nonEmpty, "\n\n")
+}
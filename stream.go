@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/briandowns/spinner"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// streamWriter prints a streamed response to the terminal as it arrives.
+// Unless the full output is requested, only the contents of the first code
+// block are printed, mirroring the output printed when streaming is disabled.
+// If the response turns out not to contain a code block, it is printed in its
+// entirety once complete.
+type streamWriter struct {
+	out     io.Writer
+	full    bool
+	onStart func()
+
+	started  bool
+	state    int          // one of the stream states below
+	line     []byte       // the current, incomplete line
+	flushed  int          // how much of line was already printed
+	received bytes.Buffer // everything written so far
+}
+
+const (
+	beforeCode = iota
+	inCode
+	afterCode
+)
+
+func newStreamWriter(out io.Writer, full bool, onStart func()) *streamWriter {
+	return &streamWriter{out: out, full: full, onStart: onStart}
+}
+
+// Write implements io.Writer.
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if !sw.started {
+		sw.started = true
+		sw.onStart()
+	}
+
+	if sw.full {
+		return sw.out.Write(p)
+	}
+
+	sw.received.Write(p)
+
+	for _, b := range p {
+		if b != '\n' {
+			sw.line = append(sw.line, b)
+			continue
+		}
+
+		if err := sw.endLine(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Print the current line as far as possible, unless it may turn out to
+	// be a code fence
+	if sw.state == inCode && len(sw.line) > 0 && sw.line[0] != '`' {
+		if _, err := sw.out.Write(sw.line[sw.flushed:]); err != nil {
+			return 0, err
+		}
+		sw.flushed = len(sw.line)
+	}
+
+	return len(p), nil
+}
+
+// endLine handles a complete line of output.
+func (sw *streamWriter) endLine() (err error) {
+	isFence := bytes.HasPrefix(sw.line, []byte("```"))
+
+	switch {
+	case sw.state == beforeCode && isFence:
+		sw.state = inCode
+	case sw.state == inCode && isFence && len(bytes.TrimSpace(sw.line)) == 3:
+		sw.state = afterCode
+	case sw.state == inCode:
+		_, err = sw.out.Write(append(sw.line[sw.flushed:], '\n'))
+	}
+
+	sw.line = sw.line[:0]
+	sw.flushed = 0
+
+	return err
+}
+
+// Finish must be called once the response is complete. If the response did
+// not contain a code block, it is printed now.
+func (sw *streamWriter) Finish(res types.Response) {
+	switch {
+	case sw.full:
+		io.WriteString(sw.out, "\n") //nolint: errcheck
+	case sw.state == beforeCode:
+		io.WriteString(sw.out, res.Code+"\n") //nolint: errcheck
+	case sw.state == inCode:
+		// The code block was never closed
+		sw.out.Write(append(sw.line[sw.flushed:], '\n')) //nolint: errcheck
+	}
+}
+
+// Flush must be called instead of Finish if the response was interrupted. The
+// output received so far is printed: the rest of the code block, if one was
+// started, or everything otherwise.
+func (sw *streamWriter) Flush() {
+	switch {
+	case !sw.started:
+		return
+	case sw.full:
+		io.WriteString(sw.out, "\n") //nolint: errcheck
+	case sw.state == beforeCode:
+		sw.out.Write(append(sw.received.Bytes(), '\n')) //nolint: errcheck
+	case sw.state == inCode && len(sw.line) > 0:
+		sw.out.Write(append(sw.line[sw.flushed:], '\n')) //nolint: errcheck
+	}
+}
+
+// RestartStream implements types.StreamRestarter. The output printed so far
+// is left as-is, followed by a notice, and the response is printed again as
+// it arrives.
+func (sw *streamWriter) RestartStream() {
+	if sw.started {
+		io.WriteString(sw.out, "\n") //nolint: errcheck
+	}
+
+	fmt.Fprintln(os.Stderr, "The response stalled, requesting it again...")
+
+	sw.state = beforeCode
+	sw.line = sw.line[:0]
+	sw.flushed = 0
+	sw.received.Reset()
+}
+
+// progressWriter receives a streamed response without printing it, updating
+// the spinner with the approximate number of tokens received so far. It is
+// used when the response is saved to a file, so that long generations report
+// their progress.
+type progressWriter struct {
+	spin   *spinner.Spinner
+	suffix string
+	runes  int
+}
+
+func newProgressWriter(spin *spinner.Spinner) *progressWriter {
+	return &progressWriter{spin: spin, suffix: spin.Suffix}
+}
+
+// Write implements io.Writer.
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	pw.runes += utf8.RuneCount(p)
+
+	// Tokens are estimated as four characters each, as by libaiac for
+	// providers that do not report usage
+	pw.spin.Lock()
+	pw.spin.Suffix = fmt.Sprintf("%s ~%d tokens received", pw.suffix, (pw.runes+3)/4) //nolint: gomnd
+	pw.spin.Unlock()
+
+	return len(p), nil
+}
+
+// RestartStream implements types.StreamRestarter, counting tokens from
+// scratch.
+func (pw *progressWriter) RestartStream() {
+	pw.runes = 0
+}
+
+// Finish must be called once the response is complete or interrupted,
+// restoring the spinner's original suffix.
+func (pw *progressWriter) Finish() {
+	pw.spin.Lock()
+	pw.spin.Suffix = pw.suffix
+	pw.spin.Unlock()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+)
+
+// versionInfo describes the build of aiac, as printed by the version command.
+type versionInfo struct {
+	Version     string   `json:"version"`
+	Commit      string   `json:"commit,omitempty"`
+	BuildDate   string   `json:"build_date,omitempty"`
+	GoVersion   string   `json:"go_version"`
+	Platform    string   `json:"platform"`
+	ConfigPaths []string `json:"config_paths"`
+}
+
+// buildInfo returns information about the build of aiac. The commit and build
+// date are set via -ldflags by releases (see libaiac.Commit), and are
+// otherwise taken from the version control information Go embeds in
+// binaries, if any. The configuration paths are those aiac loads, or a
+// description of the environment variables it is read from, if there are no
+// configuration files.
+func buildInfo(cli flags) versionInfo {
+	info := versionInfo{
+		Version:     libaiac.Version,
+		Commit:      libaiac.Commit,
+		BuildDate:   libaiac.BuildDate,
+		GoVersion:   runtime.Version(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		ConfigPaths: configPaths(cli),
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		// Binaries installed with "go install" have a module version
+		if info.Version == "development" && build.Main.Version != "" &&
+			build.Main.Version != "(devel)" {
+			info.Version = build.Main.Version
+		}
+
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+
+	if len(info.ConfigPaths) == 0 && envConfigured() {
+		info.ConfigPaths = []string{envConfigSource}
+	}
+
+	if info.ConfigPaths == nil {
+		info.ConfigPaths = []string{}
+	}
+
+	return info
+}
+
+// printVersion prints information about the build of aiac (see buildInfo), as
+// a JSON object with the --json flag.
+func printVersion(cli flags) error {
+	info := buildInfo(cli)
+
+	if cli.JSON {
+		return printJSON(info)
+	}
+
+	commit := info.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+
+	date := info.BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+
+	fmt.Fprintf(os.Stdout, "aiac version %s\n", info.Version)
+	fmt.Fprintf(os.Stdout, "Commit:     %s\n", commit)
+	fmt.Fprintf(os.Stdout, "Built:      %s\n", date)
+	fmt.Fprintf(os.Stdout, "Go version: %s\n", info.GoVersion)
+	fmt.Fprintf(os.Stdout, "Platform:   %s\n", info.Platform)
+
+	if len(info.ConfigPaths) == 0 {
+		fmt.Fprintf(os.Stdout, "Config:     none (create one at %s)\n", libaiac.UserConfigPath())
+	}
+
+	for i, path := range info.ConfigPaths {
+		label := ""
+		if i == 0 {
+			label = "Config:"
+		}
+
+		fmt.Fprintf(os.Stdout, "%-11s %s\n", label, path)
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+// whoamiResult is the identity of a backend, printed by the whoami command
+// with the --json flag.
+type whoamiResult struct {
+	Backend string `json:"backend"`
+	Type    string `json:"type,omitempty"`
+	types.Identity
+}
+
+// runWhoami prints who the selected backend is authenticated as with its
+// provider, to confirm which account or organization its credentials belong
+// to.
+func runWhoami(ctx context.Context, aiac *libaiac.Aiac, cli flags) error {
+	name, err := aiac.ResolveBackend(cli.Backend)
+	if err != nil {
+		return err
+	}
+
+	identity, err := aiac.Identity(ctx, name)
+	if err != nil {
+		if errors.Is(err, types.ErrUnsupported) {
+			return fmt.Errorf("backend does not report its identity: %w", err)
+		}
+		return err
+	}
+
+	result := whoamiResult{
+		Backend:  name,
+		Type:     string(aiac.Conf.Backends[name].Type),
+		Identity: identity,
+	}
+
+	if cli.JSON {
+		return printJSON(result)
+	}
+
+	if result.Type != "" {
+		fmt.Printf("Backend:      %s (%s)\n", result.Backend, result.Type)
+	} else {
+		fmt.Printf("Backend:      %s\n", result.Backend)
+	}
+
+	if identity.User != "" {
+		fmt.Printf("User:         %s\n", identity.User)
+	}
+
+	if identity.Organization != "" {
+		fmt.Printf("Organization: %s\n", identity.Organization)
+	}
+
+	if identity.Project != "" {
+		fmt.Printf("Project:      %s\n", identity.Project)
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
+)
+
+// Modes of writing output files to paths that already exist, selected with
+// the --write-mode flag.
+const (
+	writeModeError     = "error"
+	writeModeOverwrite = "overwrite"
+	writeModeAppend    = "append"
+	writeModeBackup    = "backup"
+)
+
+// backupSuffix is the suffix added to existing files renamed by the backup
+// write mode.
+const backupSuffix = ".bak"
+
+var errFileExists = errors.New("file already exists")
+
+// writeFile writes content, followed by a newline, to the file at path,
+// according to the write mode selected on the command line:
+//
+//   - "error" refuses to write to existing files, unless confirmed at a
+//     prompt in interactive mode;
+//   - "overwrite" replaces their content;
+//   - "append" adds to the end of their content;
+//   - "backup" renames them first, adding the ".bak" suffix to their name
+//     (replacing any previous backup).
+//
+// Files that do not exist are created with every mode. The file being revised
+// with the --refine flag, and the file whose changes were confirmed with the
+// --diff flag, are overwritten in the "error" mode, as replacing them is the
+// purpose of these flags. So is the file merged into with the --merge flag,
+// which is never appended to, as the merged content already includes it.
+// Files are written atomically: the content is written to a temporary file in
+// the same directory, which then replaces the file, so that a failed or
+// interrupted write never leaves a partially written file behind.
+func writeFile(cli flags, path, content string) error {
+	// exclusive is true if the file must not exist when it is written
+	exclusive, backup := false, false
+
+	switch cli.WriteMode {
+	case writeModeAppend:
+		// Merged files already include their existing content
+		if path == cli.Merge {
+			break
+		}
+
+		existing, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		content = string(existing) + content
+	case writeModeBackup:
+		backup = true
+	case writeModeError:
+		if path == cli.Refine || path == cli.Diff || path == cli.Merge {
+			break
+		}
+
+		_, err := os.Stat(path)
+		if err == nil && !confirmOverwrite(cli, path) {
+			return errFileExists
+		}
+
+		// Files created since they were checked are not overwritten
+		exclusive = err != nil
+	}
+
+	// Existing files keep their permissions
+	var mode fs.FileMode = 0o644 //nolint: gomnd
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	// The temporary file is removed unless it replaced the file
+	defer os.Remove(tmp.Name()) //nolint: errcheck
+
+	_, err = fmt.Fprintln(tmp, content)
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if exclusive {
+		return linkFile(tmp.Name(), path)
+	}
+
+	// Existing files are only backed up once their replacement is ready
+	if backup {
+		err = os.Rename(path, path+backupSuffix)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed backing up %s: %w", path, err)
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// linkFile moves the file at oldPath to newPath, unless a file exists at
+// newPath, in which case errFileExists is returned. On file systems that do
+// not support hard links, the file is renamed instead, replacing any file
+// created at newPath since it was checked.
+func linkFile(oldPath, newPath string) error {
+	err := os.Link(oldPath, newPath)
+	switch {
+	case errors.Is(err, fs.ErrExist):
+		return errFileExists
+	case err != nil:
+		return os.Rename(oldPath, newPath)
+	default:
+		return nil
+	}
+}
+
+// confirmOverwrite asks whether an existing file should be overwritten, in
+// interactive mode when standard input is a terminal. Returns false without
+// asking otherwise.
+func confirmOverwrite(cli flags, path string) bool {
+	if cli.Quiet || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+
+	input := promptui.Prompt{
+		Label:     fmt.Sprintf("%s already exists, overwrite it", path),
+		IsConfirm: true,
+	}
+
+	_, err := input.Run()
+
+	return err == nil
+}
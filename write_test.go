@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gofireflyio/aiac/v5/libaiac"
+	"github.com/gofireflyio/aiac/v5/libaiac/types"
+)
+
+func TestWriteFile(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		// existing is the content of the file before it is written, if not
+		// nil
+		existing *string
+		// exempt sets the flag exempting the file from the error mode, if
+		// not nil
+		exempt func(cli *flags, path string)
+		// wantErr is the error writing must fail with, if any
+		wantErr error
+		// want and wantBackup are the contents of the file and of its
+		// backup after writing, with the backup expected to be absent if
+		// wantBackup is nil
+		want       string
+		wantBackup *string
+	}{
+		{
+			name: "error mode creates new file",
+			mode: writeModeError,
+			want: "new\n",
+		},
+		{
+			name:     "error mode refuses existing file",
+			mode:     writeModeError,
+			existing: ptr("old\n"),
+			wantErr:  errFileExists,
+			want:     "old\n",
+		},
+		{
+			name:     "error mode overwrites refined file",
+			mode:     writeModeError,
+			existing: ptr("old\n"),
+			exempt:   func(cli *flags, path string) { cli.Refine = path },
+			want:     "new\n",
+		},
+		{
+			name:     "error mode overwrites diffed file",
+			mode:     writeModeError,
+			existing: ptr("old\n"),
+			exempt:   func(cli *flags, path string) { cli.Diff = path },
+			want:     "new\n",
+		},
+		{
+			name:     "error mode overwrites merged file",
+			mode:     writeModeError,
+			existing: ptr("old\n"),
+			exempt:   func(cli *flags, path string) { cli.Merge = path },
+			want:     "new\n",
+		},
+		{
+			name:     "overwrite mode replaces existing file",
+			mode:     writeModeOverwrite,
+			existing: ptr("old\n"),
+			want:     "new\n",
+		},
+		{
+			name: "append mode creates new file",
+			mode: writeModeAppend,
+			want: "new\n",
+		},
+		{
+			name:     "append mode appends to existing file",
+			mode:     writeModeAppend,
+			existing: ptr("old\n"),
+			want:     "old\nnew\n",
+		},
+		{
+			name:     "append mode replaces merged file",
+			mode:     writeModeAppend,
+			existing: ptr("old\n"),
+			exempt:   func(cli *flags, path string) { cli.Merge = path },
+			want:     "new\n",
+		},
+		{
+			name: "backup mode creates new file",
+			mode: writeModeBackup,
+			want: "new\n",
+		},
+		{
+			name:       "backup mode renames existing file",
+			mode:       writeModeBackup,
+			existing:   ptr("old\n"),
+			want:       "new\n",
+			wantBackup: ptr("old\n"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "main.tf")
+
+			if tt.existing != nil {
+				writeTestFile(t, path, *tt.existing)
+			}
+
+			cli := flags{WriteMode: tt.mode, Quiet: true}
+			if tt.exempt != nil {
+				tt.exempt(&cli, path)
+			}
+
+			err := writeFile(cli, path, "new")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			if got := readTestFile(t, path); got != tt.want {
+				t.Errorf("expected content %q, got %q", tt.want, got)
+			}
+
+			_, err = os.Stat(path + backupSuffix)
+			switch {
+			case tt.wantBackup == nil && err == nil:
+				t.Errorf("expected no backup, got %s", path+backupSuffix)
+			case tt.wantBackup != nil:
+				if got := readTestFile(t, path+backupSuffix); got != *tt.wantBackup {
+					t.Errorf("expected backup content %q, got %q", *tt.wantBackup, got)
+				}
+			}
+
+			assertNoTempFiles(t, dir)
+		})
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.tf")
+	writeTestFile(t, path, "old\n")
+
+	err := os.Chmod(path, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the original file open, to check that it is replaced by another
+	// file rather than rewritten in place
+	original, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer original.Close()
+
+	err = writeFile(flags{WriteMode: writeModeOverwrite}, path, "new")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := readTestFile(t, path); got != "new\n" {
+		t.Errorf("expected content %q, got %q", "new\n", got)
+	}
+
+	originalInfo, err := original.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if os.SameFile(originalInfo, info) {
+		t.Error("expected file to be replaced by a renamed file, got the same file")
+	}
+
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected permissions %s to be kept, got %s", os.FileMode(0o600), info.Mode().Perm())
+	}
+
+	assertNoTempFiles(t, dir)
+}
+
+func TestSaveOutput(t *testing.T) {
+	res := types.Response{
+		Code:     "resource \"aws_s3_bucket\" \"main\" {}",
+		Language: "hcl",
+		FullOutput: "Here is the module:\n\n" +
+			"```hcl title=\"main.tf\"\nresource \"aws_s3_bucket\" \"main\" {}\n```\n\n" +
+			"```hcl:variables.tf\nvariable \"name\" {}\n```\n",
+	}
+
+	tests := []struct {
+		name string
+		mode string
+		// outputDir selects saving every file to the directory, rather than
+		// the code to a single file
+		outputDir bool
+		// existing are the files in the directory before saving
+		existing map[string]string
+		wantErr  error
+		want     map[string]string
+	}{
+		{
+			name: "single file",
+			mode: writeModeError,
+			want: map[string]string{"main.tf": res.Code + "\n"},
+		},
+		{
+			name:     "single existing file",
+			mode:     writeModeError,
+			existing: map[string]string{"main.tf": "old\n"},
+			wantErr:  errFileExists,
+			want:     map[string]string{"main.tf": "old\n"},
+		},
+		{
+			name:     "single file appended",
+			mode:     writeModeAppend,
+			existing: map[string]string{"main.tf": "old\n"},
+			want:     map[string]string{"main.tf": "old\n" + res.Code + "\n"},
+		},
+		{
+			name:      "output directory",
+			mode:      writeModeError,
+			outputDir: true,
+			want: map[string]string{
+				"main.tf":      "resource \"aws_s3_bucket\" \"main\" {}\n",
+				"variables.tf": "variable \"name\" {}\n",
+			},
+		},
+		{
+			name:      "output directory with existing file",
+			mode:      writeModeError,
+			outputDir: true,
+			existing:  map[string]string{"main.tf": "old\n"},
+			wantErr:   errFileExists,
+			want:      map[string]string{"main.tf": "old\n"},
+		},
+		{
+			name:      "output directory backed up",
+			mode:      writeModeBackup,
+			outputDir: true,
+			existing:  map[string]string{"variables.tf": "old\n"},
+			want: map[string]string{
+				"main.tf":                     "resource \"aws_s3_bucket\" \"main\" {}\n",
+				"variables.tf":                "variable \"name\" {}\n",
+				"variables.tf" + backupSuffix: "old\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.existing {
+				writeTestFile(t, filepath.Join(dir, name), content)
+			}
+
+			cli := flags{WriteMode: tt.mode, Quiet: true}
+			if tt.outputDir {
+				cli.OutputDir = dir
+			} else {
+				cli.OutputFile = filepath.Join(dir, "main.tf")
+			}
+
+			_, err := saveOutput(libaiac.NewFromConf(libaiac.Config{}), cli, res, "", 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+
+			for name, want := range tt.want {
+				if got := readTestFile(t, filepath.Join(dir, name)); got != want {
+					t.Errorf("expected %s to contain %q, got %q", name, want, got)
+				}
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(entries) != len(tt.want) {
+				t.Errorf("expected %d files, got %d", len(tt.want), len(entries))
+			}
+		})
+	}
+}
+
+// ptr returns a pointer to the provided string.
+func ptr(s string) *string {
+	return &s
+}
+
+// writeTestFile writes content to the file at path, failing the test if it
+// cannot be written.
+func writeTestFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestFile returns the content of the file at path, failing the test if
+// it cannot be read.
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+// assertNoTempFiles fails the test if temporary files created while writing
+// are left in dir.
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+
+	matches, err := filepath.Glob(filepath.Join(dir, ".*.tmp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(matches) > 0 {
+		t.Errorf("expected no temporary files, got %v", matches)
+	}
+}